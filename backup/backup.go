@@ -14,6 +14,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/metrics"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
@@ -48,9 +49,11 @@ func DoSetup() {
 	initializeConnectionPool()
 
 	gplog.Info("Starting backup of database %s", MustGetFlagString(options.DBNAME))
-	opts, err := options.NewOptions(cmdFlags)
+	var err error
+	opts, err = options.NewOptions(cmdFlags)
 	gplog.FatalOnError(err)
 
+	expandFilterPatterns(opts)
 	validateFilterLists(opts)
 
 	err = opts.ExpandIncludesForPartitions(connectionPool, cmdFlags)
@@ -68,11 +71,17 @@ func DoSetup() {
 	}
 	globalTOC = &toc.TOC{}
 	globalTOC.InitializeMetadataEntryMap()
-	utils.InitializePipeThroughParameters(!MustGetFlagBool(options.NO_COMPRESSION), MustGetFlagInt(options.COMPRESSION_LEVEL))
+	utils.InitializePipeThroughParameters(!MustGetFlagBool(options.NO_COMPRESSION), MustGetFlagString(options.COMPRESSION_TYPE), MustGetFlagInt(options.COMPRESSION_LEVEL))
+	if !MustGetFlagBool(options.METADATA_ONLY) {
+		utils.CheckCompressionProgramExistsOnAllHosts(globalCluster, utils.GetPipeThroughProgram())
+	}
 	getQuotedRoleNames(connectionPool)
 
 	pluginConfigFlag := MustGetFlagString(options.PLUGIN_CONFIG)
 
+	encryptionKeySource, err = utils.ReadEncryptionKeySource(MustGetFlagString(options.ENCRYPTION_KEY_FILE), MustGetFlagString(options.ENCRYPTION_PASSPHRASE_FILE))
+	gplog.FatalOnError(err)
+
 	if pluginConfigFlag != "" {
 		pluginConfig, err = utils.ReadPluginConfig(pluginConfigFlag)
 		gplog.FatalOnError(err)
@@ -83,7 +92,10 @@ func DoSetup() {
 		gplog.Debug("Plugin config path: %s", pluginConfig.ConfigPath)
 	}
 
+	initializeRowFilters()
+	initializeMaskingRules()
 	initializeBackupReport(*opts)
+	initializeMetrics()
 
 	if pluginConfigFlag != "" {
 		backupReport.PluginVersion = pluginConfig.CheckPluginExistsOnAllHosts(globalCluster)
@@ -99,7 +111,10 @@ func DoBackup() {
 
 	pluginConfigFlag := MustGetFlagString(options.PLUGIN_CONFIG)
 	targetBackupTimestamp := ""
+	resumeTimestamp := MustGetFlagString(options.RESUME)
 	var targetBackupFPInfo filepath.FilePathInfo
+	var resumeFPInfo filepath.FilePathInfo
+	var targetBackupConfig *history.BackupConfig
 	if MustGetFlagBool(options.INCREMENTAL) {
 		targetBackupTimestamp = GetTargetBackupTimestamp()
 		targetBackupFPInfo = filepath.NewFilePathInfo(globalCluster, globalFPInfo.UserSpecifiedBackupDir,
@@ -111,28 +126,48 @@ func DoBackup() {
 			pluginConfig.MustRestoreFile(targetBackupFPInfo.GetTOCFilePath())
 			pluginConfig.MustRestoreFile(targetBackupFPInfo.GetPluginConfigPath())
 		}
+		targetBackupConfig = history.ReadConfigFile(targetBackupFPInfo.GetConfigFilePath())
+	} else if resumeTimestamp != "" {
+		resumeFPInfo = filepath.NewFilePathInfo(globalCluster, globalFPInfo.UserSpecifiedBackupDir,
+			resumeTimestamp, globalFPInfo.UserSpecifiedSegPrefix)
+		// A resumed backup must use the same encryption key as the backup it resumes
+		targetBackupConfig = readResumeBackupConfig(resumeFPInfo)
+		backupReport.ResumedFrom = resumeTimestamp
 	}
+	initializeEncryption(targetBackupConfig)
 
 	gplog.Info("Gathering table state information")
 	metadataTables, dataTables := RetrieveAndProcessTables()
+	validateRowFilters(dataTables)
+	validateMaskingRules(dataTables)
+	if len(rowFilters) > 0 || len(maskingRules) > 0 {
+		extPartitions, _ := GetExternalPartitionInfo(connectionPool)
+		ValidateFilteredPartitionTables(dataTables, extPartitions)
+	}
 	if !(MustGetFlagBool(options.METADATA_ONLY) || MustGetFlagBool(options.DATA_ONLY)) {
 		backupIncrementalMetadata()
 	}
 	CheckTablesContainData(dataTables)
 	metadataFilename := globalFPInfo.GetMetadataFilePath()
 	gplog.Info("Metadata will be written to %s", metadataFilename)
-	metadataFile := utils.NewFileWithByteCountFromFile(metadataFilename)
+	metadataFile := utils.NewEncryptedFileWithByteCountFromFile(metadataFilename)
 
 	backupSessionGUC(metadataFile)
 	if !MustGetFlagBool(options.DATA_ONLY) {
 		isFullBackup := len(MustGetFlagStringArray(options.INCLUDE_RELATION)) == 0
 		if isFullBackup && !MustGetFlagBool(options.WITHOUT_GLOBALS) {
+			sectionStart := operating.System.Now()
 			backupGlobals(metadataFile)
+			recordSection("globals", sectionStart)
 		}
 
 		isFilteredBackup := !isFullBackup
+		sectionStart := operating.System.Now()
 		backupPredata(metadataFile, metadataTables, isFilteredBackup)
+		recordSection("predata", sectionStart)
+		sectionStart = operating.System.Now()
 		backupPostdata(metadataFile)
+		recordSection("postdata", sectionStart)
 	}
 
 	/*
@@ -148,23 +183,43 @@ func DoBackup() {
 			gplog.Info("Basing incremental backup off of backup with timestamp = %s", targetBackupTimestamp)
 
 			targetBackupTOC := toc.NewTOC(targetBackupFPInfo.GetTOCFilePath())
-			targetBackupRestorePlan = history.ReadConfigFile(targetBackupFPInfo.GetConfigFilePath()).RestorePlan
+			targetBackupRestorePlan = targetBackupConfig.RestorePlan
+			if MustGetFlagBool(options.INCREMENTAL_HEAP) && !canCompareHeapEntries(targetBackupTOC, globalTOC) {
+				gplog.Info("Changes to heap tables since the last backup cannot be detected, so all heap tables will be backed up")
+			}
 			backupSetTables = FilterTablesForIncremental(targetBackupTOC, globalTOC, dataTables)
+			if MustGetFlagBool(options.INCREMENTAL_HEAP) {
+				backupSetTables = AddExpiredHeapTables(backupSetTables, dataTables, globalTOC, targetBackupRestorePlan,
+					globalFPInfo.Timestamp, MustGetFlagInt(options.INCREMENTAL_HEAP_MAX_AGE))
+			}
+		} else if resumeTimestamp != "" {
+			gplog.Info("Resuming backup with timestamp = %s", resumeTimestamp)
+
+			verifiedEntries := prepareResumedBackup(resumeFPInfo)
+			targetBackupRestorePlan = GetResumedRestorePlan(targetBackupConfig.RestorePlan, resumeTimestamp, verifiedEntries)
+			backedUpEntries := readRestorePlanDataEntries(targetBackupRestorePlan, resumeFPInfo, verifiedEntries)
+			backupSetTables = FilterTablesForResume(backedUpEntries, dataTables)
 		}
 
 		backupReport.RestorePlan = PopulateRestorePlan(backupSetTables, targetBackupRestorePlan, dataTables)
+		sectionStart := operating.System.Now()
 		backupData(backupSetTables)
+		recordSection("data", sectionStart)
 	}
 	if MustGetFlagBool(options.WITH_STATS) {
+		sectionStart := operating.System.Now()
 		backupStatistics(metadataTables)
+		recordSection("statistics", sectionStart)
 	}
 
+	metadataFile.Close()
+	addMetadataChecksumToTOC(metadataFilename)
 	globalTOC.WriteToFileAndMakeReadOnly(globalFPInfo.GetTOCFilePath())
+	backupReport.TOCChecksum = getFileChecksum(globalFPInfo.GetTOCFilePath())
 	for connNum := 0; connNum < connectionPool.NumConns; connNum++ {
 		// COMMIT TRANSACTION
 		connectionPool.MustCommit(connNum)
 	}
-	metadataFile.Close()
 	if pluginConfigFlag != "" {
 		pluginConfig.MustBackupFile(metadataFilename)
 		pluginConfig.MustBackupFile(globalFPInfo.GetTOCFilePath())
@@ -176,6 +231,37 @@ func DoBackup() {
 	}
 }
 
+func recordSection(name string, startTime time.Time) {
+	endTime := operating.System.Now()
+	backupReport.Sections = append(backupReport.Sections, report.NewSectionReport(name, startTime, endTime))
+	metricsRegistry.SetSectionDuration(name, endTime.Sub(startTime))
+}
+
+func initializeMetrics() {
+	port := MustGetFlagInt(options.METRICS_PORT)
+	if port == 0 && MustGetFlagString(options.METRICS_TEXTFILE) == "" {
+		return
+	}
+	metricsRegistry = metrics.NewRegistry("gpbackup", map[string]string{"database": MustGetFlagString(options.DBNAME)})
+	if port != 0 {
+		err := metricsRegistry.StartServer(port)
+		gplog.FatalOnError(err)
+	}
+}
+
+func finishMetrics(backupFailed bool) {
+	endTime := operating.System.Now()
+	startTime, err := time.ParseInLocation("20060102150405", globalFPInfo.Timestamp, operating.System.Local)
+	if err != nil {
+		startTime = endTime
+	}
+	// gpbackup stops at the first error
+	if backupFailed {
+		metricsRegistry.AddErrors(1)
+	}
+	metricsRegistry.Finish(!backupFailed, startTime, endTime, MustGetFlagString(options.METRICS_TEXTFILE))
+}
+
 func backupGlobals(metadataFile *utils.FileWithByteCount) {
 	gplog.Info("Writing global database metadata")
 
@@ -257,14 +343,18 @@ func backupData(tables []Table) {
 			}
 		}
 		utils.WriteOidListToSegments(oidList, globalCluster, globalFPInfo)
-		utils.CreateFirstSegmentPipeOnAllHosts(oidList[0], globalCluster, globalFPInfo)
-		compressStr := fmt.Sprintf(" --compression-level %d", MustGetFlagInt(options.COMPRESSION_LEVEL))
+		jobs := MustGetFlagInt(options.JOBS)
+		if jobs > len(oidList) {
+			jobs = len(oidList)
+		}
+		utils.CreateFirstSegmentPipesOnAllHosts(oidList[:jobs], globalCluster, globalFPInfo)
+		compressStr := fmt.Sprintf(" --compression-level %d --compression-type %s", MustGetFlagInt(options.COMPRESSION_LEVEL), MustGetFlagString(options.COMPRESSION_TYPE))
 		if MustGetFlagBool(options.NO_COMPRESSION) {
 			compressStr = " --compression-level 0"
 		}
 		// Do not pass through the --on-error-continue flag because it does not apply to gpbackup
 		utils.StartGpbackupHelpers(globalCluster, globalFPInfo, "--backup-agent",
-			MustGetFlagString(options.PLUGIN_CONFIG), compressStr, false, false)
+			MustGetFlagString(options.PLUGIN_CONFIG), compressStr, false, false, jobs)
 	}
 	gplog.Info("Writing data to file")
 	rowsCopiedMaps := backupDataForAllTables(tables)
@@ -272,6 +362,7 @@ func backupData(tables []Table) {
 	if MustGetFlagBool(options.SINGLE_DATA_FILE) && MustGetFlagString(options.PLUGIN_CONFIG) != "" {
 		pluginConfig.BackupSegmentTOCs(globalCluster, globalFPInfo)
 	}
+	AddDataChecksumsToTOC()
 
 	logCompletionMessage("Data backup")
 }
@@ -301,9 +392,10 @@ func backupStatistics(tables []Table) {
 	}
 	statisticsFilename := globalFPInfo.GetStatisticsFilePath()
 	gplog.Info("Writing query planner statistics to %s", statisticsFilename)
-	statisticsFile := utils.NewFileWithByteCountFromFile(statisticsFilename)
-	defer statisticsFile.Close()
+	statisticsFile := utils.NewEncryptedFileWithByteCountFromFile(statisticsFilename)
 	backupTableStatistics(statisticsFile, tables)
+	statisticsFile.Close()
+	addMetadataChecksumToTOC(statisticsFilename)
 
 	logCompletionMessage("Query planner statistics backup")
 }
@@ -356,6 +448,9 @@ func DoTeardown() {
 		if statErr != nil { // Even if this isn't os.IsNotExist, don't try to write a report file in case of further errors
 			return
 		}
+		if backupFailed {
+			writePartialTOC(false)
+		}
 		historyFilename := globalFPInfo.GetBackupHistoryFilePath()
 		reportFilename := globalFPInfo.GetBackupReportFilePath()
 		configFilename := globalFPInfo.GetConfigFilePath()
@@ -377,7 +472,19 @@ func DoTeardown() {
 			}
 			endtime, _ := time.ParseInLocation("20060102150405", backupReport.BackupConfig.EndTime, operating.System.Local)
 			backupReport.WriteBackupReportFile(reportFilename, globalFPInfo.Timestamp, endtime, objectCounts, errMsg)
+			reportFormat := MustGetFlagString(options.REPORT_FORMAT)
+			machineReportFilename := report.GetMachineReadableReportFilePath(reportFilename, reportFormat)
+			backupReport.Tables = GetTableReports(globalTOC, dataFileBytes)
+			machineReport := backupReport.NewMachineReadableBackupReport(globalFPInfo.Timestamp, endtime, objectCounts, errMsg)
+			report.WriteMachineReadableReportFile(machineReportFilename, reportFormat, machineReport)
 			report.EmailReport(globalCluster, globalFPInfo.Timestamp, reportFilename, "gpbackup")
+			report.SendNotifications(globalCluster, report.FindNotificationFile(MustGetFlagString(options.NOTIFICATION_CONFIG)), report.Notification{
+				Utility:        "gpbackup",
+				Timestamp:      globalFPInfo.Timestamp,
+				Status:         report.GetExitStatus(),
+				ReportFilePath: reportFilename,
+				Report:         machineReport,
+			})
 			if pluginConfig != nil {
 				err = pluginConfig.BackupFile(configFilename)
 				if err != nil {
@@ -389,6 +496,11 @@ func DoTeardown() {
 					gplog.Error(fmt.Sprintf("%v", err))
 					return
 				}
+				err = pluginConfig.BackupFile(machineReportFilename)
+				if err != nil {
+					gplog.Error(fmt.Sprintf("%v", err))
+					return
+				}
 			}
 		}
 		if pluginConfig != nil {
@@ -408,7 +520,12 @@ func DoCleanup(backupFailed bool) {
 	}()
 
 	gplog.Verbose("Beginning cleanup")
+	finishMetrics(backupFailed)
 	if globalFPInfo.Timestamp != "" {
+		if backupFailed && wasTerminated {
+			// DoTeardown does not get to write the TOC and config files if the backup was terminated
+			writePartialTOC(true)
+		}
 		if MustGetFlagBool(options.SINGLE_DATA_FILE) {
 			if backupFailed {
 				// Cleanup only if terminated or fataled
@@ -420,6 +537,9 @@ func DoCleanup(backupFailed bool) {
 			}
 			utils.CleanUpHelperFilesOnAllHosts(globalCluster, globalFPInfo)
 		}
+		if utils.IsEncryptionEnabled() && !MustGetFlagBool(options.METADATA_ONLY) {
+			utils.RemoveEncryptionKeyFromAllHosts(globalCluster, utils.GetEncryptionKeyFilePath())
+		}
 	}
 	err := backupLockFile.Unlock()
 	if err != nil && backupLockFile != "" {
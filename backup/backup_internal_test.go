@@ -2,6 +2,7 @@ package backup
 
 import (
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/options"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -22,4 +23,19 @@ var _ = Describe("backup internal tests", func() {
 			Expect(string(log.Contents())).To(ContainSubstring("Data backup complete"))
 		})
 	})
+	Describe("isObjectTypeExcluded", func() {
+		AfterEach(func() {
+			opts = nil
+		})
+		It("uses the normalized object types of the options", func() {
+			opts = &options.Options{ExcludedObjectTypes: []string{"RULE", "TEXT SEARCH PARSER"}}
+
+			Expect(isObjectTypeExcluded("RULE")).To(BeTrue())
+			Expect(isObjectTypeExcluded("TEXT SEARCH PARSER")).To(BeTrue())
+			Expect(isObjectTypeExcluded("TRIGGER")).To(BeFalse())
+		})
+		It("excludes no object types if no options were set", func() {
+			Expect(isObjectTypeExcluded("RULE")).To(BeFalse())
+		})
+	})
 })
@@ -9,18 +9,86 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"gopkg.in/cheggaaa/pb.v1"
+	"gopkg.in/yaml.v2"
 )
 
 var (
 	tableDelim = ","
+
+	/*
+	 * The tables being backed up and the rows copied for each completed table
+	 * are kept here so that, if the backup fails, the data entries for the
+	 * tables that were completed can still be written to the TOC.
+	 */
+	dataBackupTables         []Table
+	dataBackupRowsCopiedMaps []map[uint32]int64
+	rowsCopiedLock           sync.Mutex
+
+	// The number of bytes written to the data files of each table on all segments
+	dataFileBytes map[uint32]int64
+
+	segmentBytesMonitor = &SegmentBytesMonitor{}
 )
 
+// The shortest time between two reads of the bytes written on the segments
+const segmentBytesInterval = 15 * time.Second
+
+/*
+ * As tables finish, the bytes written on each segment so far are read from the
+ * segments in the background, at most once every segmentBytesInterval, so that
+ * the metrics endpoint shows the progress of each segment while the backup
+ * runs.  They are only read if the endpoint is served, as the textfile is only
+ * written once the final sizes are known.
+ */
+type SegmentBytesMonitor struct {
+	lastUpdate time.Time
+	updating   bool
+	lock       sync.Mutex
+	wait       sync.WaitGroup
+}
+
+func (monitor *SegmentBytesMonitor) TableCompleted() {
+	if MustGetFlagInt(options.METRICS_PORT) == 0 {
+		return
+	}
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	if monitor.updating || time.Since(monitor.lastUpdate) < segmentBytesInterval {
+		return
+	}
+	monitor.updating = true
+	monitor.wait.Add(1)
+	go func() {
+		defer monitor.wait.Done()
+		segmentBytes := utils.GetSegmentBytesWritten(globalCluster, globalFPInfo, MustGetFlagBool(options.SINGLE_DATA_FILE))
+		for contentID, numBytes := range segmentBytes {
+			metricsRegistry.SetSegmentBytes(contentID, numBytes)
+		}
+		monitor.lock.Lock()
+		monitor.updating = false
+		monitor.lastUpdate = time.Now()
+		monitor.lock.Unlock()
+	}()
+}
+
+/*
+ * Waits for a read in progress, so that it cannot overwrite the final sizes
+ * or read the checksum files as they are removed.
+ */
+func (monitor *SegmentBytesMonitor) Wait() {
+	monitor.wait.Wait()
+}
+
 func ConstructTableAttributesList(columnDefs []ColumnDefinition) string {
 	names := make([]string, 0)
 	for _, col := range columnDefs {
@@ -32,6 +100,29 @@ func ConstructTableAttributesList(columnDefs []ColumnDefinition) string {
 	return ""
 }
 
+/*
+ * Returns the rows and bytes backed up for each table with a data entry in the
+ * TOC.  Bytes are only known for backups with one data file per table, whose
+ * sizes are recorded along with their checksums.
+ */
+func GetTableReports(backupTOC *toc.TOC, tableBytes map[uint32]int64) []report.TableReport {
+	tables := make([]report.TableReport, 0)
+	if backupTOC == nil {
+		return tables
+	}
+	for _, entry := range backupTOC.DataEntries {
+		tables = append(tables, report.TableReport{
+			Schema:       entry.Schema,
+			Name:         entry.Name,
+			Oid:          entry.Oid,
+			Rows:         entry.RowsCopied,
+			BytesWritten: tableBytes[entry.Oid],
+			RowFilter:    entry.RowFilter,
+		})
+	}
+	return tables
+}
+
 func AddTableDataEntriesToTOC(tables []Table, rowsCopiedMaps []map[uint32]int64) {
 	for _, table := range tables {
 		if !table.SkipDataBackup() {
@@ -43,11 +134,108 @@ func AddTableDataEntriesToTOC(tables []Table, rowsCopiedMaps []map[uint32]int64)
 				}
 			}
 			attributes := ConstructTableAttributesList(table.ColumnDefs)
-			globalTOC.AddMasterDataEntry(table.Schema, table.Name, table.Oid, attributes, rowsCopied, table.PartitionLevelInfo.RootName)
+			dataEntry := globalTOC.AddMasterDataEntry(table.Schema, table.Name, table.Oid, attributes, rowsCopied, table.PartitionLevelInfo.RootName)
+			dataEntry.RowFilter = getRowFilter(table)
+		}
+	}
+}
+
+/*
+ * The checksum of each data file is computed on the segments as it is written,
+ * by the COPY command for each table or by gpbackup_helper for single-data-file
+ * backups, and gathered here into the master TOC.
+ */
+func AddDataChecksumsToTOC() {
+	segmentBytesMonitor.Wait()
+	if MustGetFlagBool(options.SINGLE_DATA_FILE) {
+		addSegmentTOCChecksumsToTOC()
+	} else {
+		addTableFileChecksumsToTOC()
+	}
+}
+
+func addTableFileChecksumsToTOC() {
+	checksums, sizes := utils.CollectDataFileChecksumsOnSegments(globalCluster, globalFPInfo)
+	for contentID, tableChecksums := range checksums {
+		for oid, checksum := range tableChecksums {
+			globalTOC.AddDataChecksum(contentID, oid, checksum)
+		}
+	}
+	dataFileBytes = make(map[uint32]int64)
+	for contentID, tableSizes := range sizes {
+		var segmentBytes int64
+		for oid, size := range tableSizes {
+			globalTOC.AddDataSize(contentID, oid, size)
+			dataFileBytes[oid] += size
+			segmentBytes += size
+		}
+		metricsRegistry.SetSegmentBytes(contentID, segmentBytes)
+	}
+}
+
+func addSegmentTOCChecksumsToTOC() {
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Reading data file checksums from segment TOC files", func(contentID int) string {
+		tocFile := globalFPInfo.GetSegmentTOCFilePath(contentID)
+		errorFile := fmt.Sprintf("%s_error", globalFPInfo.GetSegmentPipeFilePath(contentID))
+		return fmt.Sprintf(`while [[ ! -f "%s" && ! -f "%s" ]]; do sleep 1; done; cat "%s"`, tocFile, errorFile, tocFile)
+	}, cluster.ON_SEGMENTS)
+	globalCluster.CheckClusterError(remoteOutput, "Unable to read segment TOC files", func(contentID int) string {
+		return fmt.Sprintf("Unable to read segment TOC file on segment %d", contentID)
+	})
+
+	for contentID, stdout := range remoteOutput.Stdouts {
+		segmentTOC := toc.SegmentTOC{}
+		err := yaml.Unmarshal([]byte(stdout), &segmentTOC)
+		gplog.FatalOnError(err, fmt.Sprintf("Unable to parse segment TOC file on segment %d", contentID))
+		globalTOC.AddDataChecksum(contentID, 0, segmentTOC.DataFileChecksum)
+		var segmentBytes uint64
+		for oid, entry := range segmentTOC.DataEntries {
+			globalTOC.AddDataSize(contentID, uint32(oid), int64(entry.EndByte-entry.StartByte))
+			if entry.EndByte > segmentBytes {
+				segmentBytes = entry.EndByte
+			}
 		}
+		metricsRegistry.SetSegmentBytes(contentID, int64(segmentBytes))
 	}
 }
 
+/*
+ * Tables with a row filter or masked columns are copied out with a query that
+ * selects the matching rows and the masked values of their columns, in the
+ * order of ConstructTableAttributesList.  The rows of a partition table are
+ * read from its partitions, while ONLY keeps the rows of other tables from
+ * including those of the tables inheriting from them.
+ */
+func getCopySelectQuery(table Table) string {
+	rowFilter := getRowFilter(table)
+	tableRules := getMaskingRules(table)
+	if rowFilter == "" && len(tableRules) == 0 {
+		return ""
+	}
+	columns := make([]string, 0)
+	for _, column := range table.ColumnDefs {
+		if rule, ok := tableRules[column.Name]; ok {
+			expression, err := getMaskingExpression(column.Name, column.Type, rule)
+			gplog.FatalOnError(err)
+			columns = append(columns, fmt.Sprintf("%s AS %s", expression, column.Name))
+		} else {
+			columns = append(columns, column.Name)
+		}
+	}
+	if len(columns) == 0 {
+		columns = append(columns, "*")
+	}
+	only := "ONLY "
+	if level := table.PartitionLevelInfo.Level; level == "p" || level == "i" {
+		only = ""
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s%s", strings.Join(columns, ","), only, table.FQN())
+	if rowFilter != "" {
+		query += fmt.Sprintf(" WHERE %s", rowFilter)
+	}
+	return query
+}
+
 type BackupProgressCounters struct {
 	NumRegTables   int64
 	TotalRegTables int64
@@ -67,14 +255,21 @@ func CopyTableOut(connectionPool *dbconn.DBConn, table Table, destinationToWrite
 		 */
 		checkPipeExistsCommand = fmt.Sprintf("(test -p \"%s\" || (echo \"Pipe not found %s\">&2; exit 1)) && ", destinationToWrite, destinationToWrite)
 		customPipeThroughCommand = "cat -"
-	} else if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
-		sendToDestinationCommand = fmt.Sprintf("| %s backup_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+	} else {
+		customPipeThroughCommand = fmt.Sprintf("%s | %s", customPipeThroughCommand, utils.GetChecksumCommand(destinationToWrite))
+		if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
+			sendToDestinationCommand = fmt.Sprintf("| %s backup_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+		}
 	}
 
-	copyCommand := fmt.Sprintf("PROGRAM '%s%s %s %s'", checkPipeExistsCommand, customPipeThroughCommand, sendToDestinationCommand, destinationToWrite)
+	pipeline := fmt.Sprintf("%s%s %s %s", checkPipeExistsCommand, customPipeThroughCommand, sendToDestinationCommand, destinationToWrite)
+	copyCommand := fmt.Sprintf("PROGRAM '%s'", utils.EscapeSingleQuotes(utils.WrapWithPipefail(pipeline)))
 
 	query := fmt.Sprintf("COPY %s TO %s WITH CSV DELIMITER '%s' ON SEGMENT IGNORE EXTERNAL PARTITIONS;", table.FQN(), copyCommand, tableDelim)
-	gplog.Verbose(query)
+	if selectQuery := getCopySelectQuery(table); selectQuery != "" {
+		query = fmt.Sprintf("COPY (%s) TO %s WITH CSV DELIMITER '%s' ON SEGMENT;", selectQuery, copyCommand, tableDelim)
+	}
+	gplog.Verbose(redactMaskingKey(query))
 	result, err := connectionPool.Exec(query, connNum)
 	if err != nil {
 		return 0, err
@@ -107,8 +302,12 @@ func BackupSingleTableData(table Table, rowsCopiedMap map[uint32]int64, counters
 		if err != nil {
 			return err
 		}
+		rowsCopiedLock.Lock()
 		rowsCopiedMap[table.Oid] = rowsCopied
+		rowsCopiedLock.Unlock()
 		counters.ProgressBar.Increment()
+		metricsRegistry.TableCompleted(rowsCopied)
+		segmentBytesMonitor.TableCompleted()
 	}
 	return nil
 }
@@ -122,8 +321,16 @@ func backupDataForAllTables(tables []Table) []map[uint32]int64 {
 	}
 	counters := BackupProgressCounters{NumRegTables: 0, TotalRegTables: int64(len(tables)) - numExtOrForeignTables}
 	counters.ProgressBar = utils.NewProgressBar(int(counters.TotalRegTables), "Tables backed up: ", utils.PB_INFO)
+	metricsRegistry.SetTables(counters.TotalRegTables)
 	counters.ProgressBar.Start()
 	rowsCopiedMaps := make([]map[uint32]int64, connectionPool.NumConns)
+	for connNum := 0; connNum < connectionPool.NumConns; connNum++ {
+		rowsCopiedMaps[connNum] = make(map[uint32]int64)
+	}
+	rowsCopiedLock.Lock()
+	dataBackupTables = tables
+	dataBackupRowsCopiedMaps = rowsCopiedMaps
+	rowsCopiedLock.Unlock()
 	/*
 	 * We break when an interrupt is received and rely on
 	 * TerminateHangingCopySessions to kill any COPY statements
@@ -133,7 +340,6 @@ func backupDataForAllTables(tables []Table) []map[uint32]int64 {
 	var workerPool sync.WaitGroup
 	var copyErr error
 	for connNum := 0; connNum < connectionPool.NumConns; connNum++ {
-		rowsCopiedMaps[connNum] = make(map[uint32]int64)
 		workerPool.Add(1)
 		go func(whichConn int) {
 			defer workerPool.Done()
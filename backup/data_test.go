@@ -2,15 +2,20 @@ package backup_test
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"regexp"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 	"gopkg.in/cheggaaa/pb.v1"
 
 	. "github.com/onsi/ginkgo"
@@ -57,6 +62,21 @@ var _ = Describe("backup/data tests", func() {
 			expectedDataEntries := []toc.MasterDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)"}}
 			Expect(tocfile.DataEntries).To(Equal(expectedDataEntries))
 		})
+		It("records the row filter of a table in its entry", func() {
+			backup.SetRowFilters(map[string]string{"public.table": "a > 10"})
+			defer backup.SetRowFilters(nil)
+			otherTable := backup.Table{
+				Relation:        backup.Relation{Oid: 2, Schema: "public", Name: "other_table"},
+				TableDefinition: backup.TableDefinition{ColumnDefs: []backup.ColumnDefinition{{Oid: 1, Name: "b"}}},
+			}
+			tables := []backup.Table{table, otherTable}
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+			expectedDataEntries := []toc.MasterDataEntry{
+				{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)", RowFilter: "a > 10"},
+				{Schema: "public", Name: "other_table", Oid: 2, AttributeString: "(b)"},
+			}
+			Expect(tocfile.DataEntries).To(Equal(expectedDataEntries))
+		})
 		It("does not add an entry for an external table to the TOC", func() {
 			table.IsExternal = true
 			tables := []backup.Table{table}
@@ -71,11 +91,33 @@ var _ = Describe("backup/data tests", func() {
 			Expect(tocfile.DataEntries).To(BeNil())
 		})
 	})
+	Describe("GetTableReports", func() {
+		It("returns the rows and bytes backed up for each data entry in the TOC", func() {
+			tocfile := &toc.TOC{DataEntries: []toc.MasterDataEntry{
+				{Schema: "public", Name: "foo", Oid: 1, RowsCopied: 10},
+				{Schema: "public", Name: "bar", Oid: 2, RowsCopied: 20},
+			}}
+			tableBytes := map[uint32]int64{1: 100}
+
+			tables := backup.GetTableReports(tocfile, tableBytes)
+
+			Expect(tables).To(Equal([]report.TableReport{
+				{Schema: "public", Name: "foo", Oid: 1, Rows: 10, BytesWritten: 100},
+				{Schema: "public", Name: "bar", Oid: 2, Rows: 20},
+			}))
+		})
+	})
 	Describe("CopyTableOut", func() {
 		testTable := backup.Table{Relation: backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo"}}
+		BeforeEach(func() {
+			operating.System.Getenv = func(key string) string { return "/usr/local/gpdb" }
+		})
+		AfterEach(func() {
+			operating.System.Getenv = os.Getenv
+		})
 		It("will back up a table to its own file with compression", func() {
 			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "gzip", OutputCommand: "gzip -c -8", InputCommand: "gzip -d -c", Extension: ".gz"})
-			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'gzip -c -8 > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'bash -c ''set -o pipefail; gzip -c -8 | /usr/local/gpdb/bin/gpbackup_helper --checksum-file <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz.sha256 > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz''' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
 
@@ -88,7 +130,7 @@ var _ = Describe("backup/data tests", func() {
 			pluginConfig := utils.PluginConfig{ExecutablePath: "/tmp/fake-plugin.sh", ConfigPath: "/tmp/plugin_config"}
 			backup.SetPluginConfig(&pluginConfig)
 			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "gzip", OutputCommand: "gzip -c -8", InputCommand: "gzip -d -c", Extension: ".gz"})
-			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'gzip -c -8 | /tmp/fake-plugin.sh backup_data /tmp/plugin_config <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'bash -c ''set -o pipefail; gzip -c -8 | /usr/local/gpdb/bin/gpbackup_helper --checksum-file <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.sha256 | /tmp/fake-plugin.sh backup_data /tmp/plugin_config <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456''' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
@@ -98,7 +140,7 @@ var _ = Describe("backup/data tests", func() {
 		})
 		It("will back up a table to its own file without compression", func() {
 			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
-			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'bash -c ''set -o pipefail; cat - | /usr/local/gpdb/bin/gpbackup_helper --checksum-file <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.sha256 > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456''' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
 
@@ -111,7 +153,7 @@ var _ = Describe("backup/data tests", func() {
 			pluginConfig := utils.PluginConfig{ExecutablePath: "/tmp/fake-plugin.sh", ConfigPath: "/tmp/plugin_config"}
 			backup.SetPluginConfig(&pluginConfig)
 			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
-			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'cat - | /tmp/fake-plugin.sh backup_data /tmp/plugin_config <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'bash -c ''set -o pipefail; cat - | /usr/local/gpdb/bin/gpbackup_helper --checksum-file <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.sha256 | /tmp/fake-plugin.sh backup_data /tmp/plugin_config <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456''' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
@@ -121,7 +163,7 @@ var _ = Describe("backup/data tests", func() {
 		})
 		It("will back up a table to a single file", func() {
 			_ = cmdFlags.Set(options.SINGLE_DATA_FILE, "true")
-			execStr := regexp.QuoteMeta(`COPY public.foo TO PROGRAM '(test -p "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456" || (echo "Pipe not found <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456">&2; exit 1)) && cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;`)
+			execStr := regexp.QuoteMeta(`COPY public.foo TO PROGRAM 'bash -c ''set -o pipefail; (test -p "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456" || (echo "Pipe not found <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456">&2; exit 1)) && cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456''' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;`)
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
 
@@ -129,6 +171,340 @@ var _ = Describe("backup/data tests", func() {
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
+		It("will back up only the rows matching the row filter of a table", func() {
+			backup.SetRowFilters(map[string]string{"public.foo": "created > now() - interval '90 days'"})
+			defer backup.SetRowFilters(nil)
+			filteredTable := testTable
+			filteredTable.ColumnDefs = []backup.ColumnDefinition{{Name: "i"}, {Name: "created"}}
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			execStr := regexp.QuoteMeta("COPY (SELECT i,created FROM ONLY public.foo WHERE created > now() - interval '90 days') TO PROGRAM 'bash -c ''set -o pipefail; cat - | /usr/local/gpdb/bin/gpbackup_helper --checksum-file <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.sha256 > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456''' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+
+			_, err := backup.CopyTableOut(connectionPool, filteredTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will back up the masked values of columns with masking rules", func() {
+			backup.SetMaskingRules(map[string]string{"public.foo.email": "hash", "public.foo.card": "partial:4", "public.foo.name": "fixed:it's secret"})
+			defer backup.SetMaskingRules(nil)
+			backup.SetMaskingKey("the key's secret")
+			defer backup.SetMaskingKey("")
+			maskedTable := testTable
+			maskedTable.ColumnDefs = []backup.ColumnDefinition{{Name: "i", Type: "integer"}, {Name: "email", Type: "text"},
+				{Name: "card", Type: "character varying(16)"}, {Name: "name", Type: "text"}}
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			execStr := regexp.QuoteMeta("COPY (SELECT i,CAST(md5('the key''s secret' || email::text) AS text) AS email," +
+				"CAST(repeat('X', greatest(length(card::text) - 4, 0)) || substr(card::text, greatest(length(card::text) - 4 + 1, 1)) AS character varying(16)) AS card," +
+				"CAST('it''s secret' AS text) AS name FROM ONLY public.foo) TO PROGRAM")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+
+			_, err := backup.CopyTableOut(connectionPool, maskedTable, "<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_3456", defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(logfile.Contents())).To(ContainSubstring("md5('<masking key>' || email::text)"))
+			Expect(string(logfile.Contents())).ToNot(ContainSubstring("secret' || email"))
+		})
+		It("will apply the row filter of a partition table to its leaf partitions", func() {
+			backup.SetRowFilters(map[string]string{"public.foo": "i > 10"})
+			defer backup.SetRowFilters(nil)
+			leafTable := backup.Table{Relation: backup.Relation{SchemaOid: 2345, Oid: 3457, Schema: "public", Name: "foo_1_prt_1"},
+				TableDefinition: backup.TableDefinition{PartitionLevelInfo: backup.PartitionLevelInfo{Level: "l", RootName: "foo"}}}
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			execStr := regexp.QuoteMeta("COPY (SELECT * FROM ONLY public.foo_1_prt_1 WHERE i > 10) TO PROGRAM")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+
+			_, err := backup.CopyTableOut(connectionPool, leafTable, "<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_3457", defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+	})
+	Describe("ReadMaskingRuleFile", func() {
+		var ruleFile string
+		BeforeEach(func() {
+			file, err := ioutil.TempFile("", "masking_rules")
+			Expect(err).ToNot(HaveOccurred())
+			ruleFile = file.Name()
+			_ = file.Close()
+		})
+		AfterEach(func() {
+			_ = os.Remove(ruleFile)
+		})
+		It("reads the rule of each column, reading an unquoted null as the null rule", func() {
+			Expect(ioutil.WriteFile(ruleFile, []byte("public.customers.email: hash\npublic.customers.notes: null\npublic.customers.name: \"fixed:REDACTED\"\n"), 0644)).To(Succeed())
+
+			maskingRules, err := backup.ReadMaskingRuleFile(ruleFile)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(maskingRules).To(Equal(map[string]string{"public.customers.email": "hash", "public.customers.notes": "null", "public.customers.name": "fixed:REDACTED"}))
+		})
+		It("returns an error for a column name without a table", func() {
+			Expect(ioutil.WriteFile(ruleFile, []byte("customers.email: hash\n"), 0644)).To(Succeed())
+
+			_, err := backup.ReadMaskingRuleFile(ruleFile)
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("customers.email is not of the form schema.table.column"))
+		})
+		It("returns an error for a partial rule without a number of characters", func() {
+			Expect(ioutil.WriteFile(ruleFile, []byte("public.customers.card: \"partial:last\"\n"), 0644)).To(Succeed())
+
+			_, err := backup.ReadMaskingRuleFile(ruleFile)
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("The number of characters kept by partial:last must be a non-negative integer"))
+		})
+	})
+	Describe("ReadMaskingKeyFile", func() {
+		var keyFile string
+		BeforeEach(func() {
+			file, err := ioutil.TempFile("", "masking_key")
+			Expect(err).ToNot(HaveOccurred())
+			keyFile = file.Name()
+			_ = file.Close()
+		})
+		AfterEach(func() {
+			_ = os.Remove(keyFile)
+		})
+		It("returns an empty key if no file is given", func() {
+			key, err := backup.ReadMaskingKeyFile("")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key).To(Equal(""))
+		})
+		It("reads the key without its trailing newline", func() {
+			Expect(ioutil.WriteFile(keyFile, []byte("s3cr3t\n"), 0600)).To(Succeed())
+
+			key, err := backup.ReadMaskingKeyFile(keyFile)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key).To(Equal("s3cr3t"))
+		})
+		It("returns an error if the file is empty", func() {
+			_, err := backup.ReadMaskingKeyFile(keyFile)
+
+			Expect(err).To(MatchError(fmt.Sprintf("Masking key file %s is empty", keyFile)))
+		})
+	})
+	Describe("ValidateMaskingKey", func() {
+		It("requires a key if any column is masked with hash", func() {
+			err := backup.ValidateMaskingKey(map[string]string{"public.customers.email": "hash", "public.customers.notes": "null"}, "")
+
+			Expect(err).To(MatchError("--masking-key-file must be specified to mask columns with hash"))
+		})
+		It("accepts a hash rule with a key", func() {
+			err := backup.ValidateMaskingKey(map[string]string{"public.customers.email": "hash"}, "s3cr3t")
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("does not require a key for other rules", func() {
+			err := backup.ValidateMaskingKey(map[string]string{"public.customers.notes": "null", "public.customers.name": "fixed:REDACTED"}, "")
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Describe("ValidateMaskingRuleConstraints", func() {
+		var maskedTable backup.Table
+		BeforeEach(func() {
+			maskedTable = backup.Table{
+				Relation: backup.Relation{Schema: "public", Name: "customers"},
+				TableDefinition: backup.TableDefinition{ColumnDefs: []backup.ColumnDefinition{
+					{Name: "id", NotNull: true}, {Name: "email"}, {Name: "notes"}}},
+			}
+		})
+		AfterEach(func() {
+			backup.SetMaskingRules(nil)
+		})
+		It("accepts null and fixed rules on columns without constraints", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.email": "fixed:REDACTED", "public.customers.notes": "null"})
+
+			err := backup.ValidateMaskingRuleConstraints(maskedTable, map[string]bool{})
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("accepts a null rule on a unique column that is not NOT NULL", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.email": "null"})
+
+			err := backup.ValidateMaskingRuleConstraints(maskedTable, map[string]bool{"email": true})
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("accepts a hash rule on a primary key column", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.id": "hash"})
+
+			err := backup.ValidateMaskingRuleConstraints(maskedTable, map[string]bool{"id": true})
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("returns an error for a null rule on a NOT NULL column", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.id": "null"})
+
+			err := backup.ValidateMaskingRuleConstraints(maskedTable, map[string]bool{})
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("Cannot mask column id of table public.customers with null, as it is NOT NULL"))
+		})
+		It("returns an error for a fixed rule on a unique column", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.email": "fixed:REDACTED"})
+
+			err := backup.ValidateMaskingRuleConstraints(maskedTable, map[string]bool{"email": true})
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("Cannot mask column email of table public.customers with a fixed value, as it is part of a primary key or unique constraint"))
+		})
+		It("returns an error for a partial rule on a unique column", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.id": "partial:4"})
+
+			err := backup.ValidateMaskingRuleConstraints(maskedTable, map[string]bool{"id": true})
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("Cannot mask column id of table public.customers with partial:4, as it is part of a primary key or unique constraint"))
+		})
+		It("accepts a partial rule on a column without constraints", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.email": "partial:4"})
+
+			err := backup.ValidateMaskingRuleConstraints(maskedTable, map[string]bool{"id": true})
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Describe("ValidateMaskingRuleTypes", func() {
+		var maskedTable backup.Table
+		BeforeEach(func() {
+			maskedTable = backup.Table{
+				Relation: backup.Relation{Schema: "public", Name: "customers"},
+				TableDefinition: backup.TableDefinition{ColumnDefs: []backup.ColumnDefinition{
+					{Name: "id", Type: "integer"}, {Name: "email", Type: "character varying(255)"},
+					{Name: "code", Type: "character(8)"}, {Name: "notes", Type: "text"}}},
+			}
+		})
+		AfterEach(func() {
+			backup.SetMaskingRules(nil)
+		})
+		It("accepts hash and partial rules on columns of string types", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.email": "hash", "public.customers.notes": "hash", "public.customers.code": "partial:2"})
+
+			err := backup.ValidateMaskingRuleTypes(maskedTable)
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("accepts fixed, null, and expression rules on columns of other types", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.id": "id % 1000"})
+
+			err := backup.ValidateMaskingRuleTypes(maskedTable)
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("returns an error for a hash rule on a column that is not of a string type", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.id": "hash"})
+
+			err := backup.ValidateMaskingRuleTypes(maskedTable)
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("Cannot mask column id of table public.customers with hash, as its type integer is not a string type.  Use an SQL expression instead."))
+		})
+		It("returns an error for a partial rule on a column that is not of a string type", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.id": "partial:2"})
+
+			err := backup.ValidateMaskingRuleTypes(maskedTable)
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("with partial:2, as its type integer is not a string type"))
+		})
+		It("returns an error for a hash rule on a column too short to hold the hash", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.code": "hash"})
+
+			err := backup.ValidateMaskingRuleTypes(maskedTable)
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("Cannot mask column code of table public.customers with hash, as its type character(8) is too short to hold a keyed MD5 hash of 32 characters"))
+		})
+	})
+	Describe("ValidateFixedMaskingValues", func() {
+		var maskedTable backup.Table
+		BeforeEach(func() {
+			maskedTable = backup.Table{
+				Relation:        backup.Relation{Schema: "public", Name: "customers"},
+				TableDefinition: backup.TableDefinition{ColumnDefs: []backup.ColumnDefinition{{Name: "id", Type: "integer"}}},
+			}
+		})
+		AfterEach(func() {
+			backup.SetMaskingRules(nil)
+		})
+		It("casts each fixed value to the type of its column", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.id": "fixed:0"})
+			mock.ExpectExec(regexp.QuoteMeta("SELECT CAST('0' AS integer)")).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			backup.ValidateFixedMaskingValues(connectionPool, maskedTable)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("panics if a fixed value cannot be cast to the type of its column", func() {
+			backup.SetMaskingRules(map[string]string{"public.customers.id": "fixed:abc"})
+			mock.ExpectExec(regexp.QuoteMeta("SELECT CAST('abc' AS integer)")).WillReturnError(errors.New(`invalid input syntax for integer: "abc"`))
+
+			defer testhelper.ShouldPanicWithMessage("Cannot mask column id of table public.customers with fixed:abc, as the value cannot be cast to its type integer")
+			backup.ValidateFixedMaskingValues(connectionPool, maskedTable)
+		})
+	})
+	Describe("ReadRowFilterFile", func() {
+		var filterFile string
+		BeforeEach(func() {
+			file, err := ioutil.TempFile("", "row_filters")
+			Expect(err).ToNot(HaveOccurred())
+			filterFile = file.Name()
+			_ = file.Close()
+		})
+		AfterEach(func() {
+			_ = os.Remove(filterFile)
+		})
+		It("reads the filter of each table", func() {
+			Expect(ioutil.WriteFile(filterFile, []byte("sales.fact_orders: order_date >= current_date - 90\npublic.events: \"tenant_id IN (1, 2)\"\n"), 0644)).To(Succeed())
+
+			rowFilters, err := backup.ReadRowFilterFile(filterFile)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rowFilters).To(Equal(map[string]string{"sales.fact_orders": "order_date >= current_date - 90", "public.events": "tenant_id IN (1, 2)"}))
+		})
+		It("returns an error for a table name without a schema", func() {
+			Expect(ioutil.WriteFile(filterFile, []byte("fact_orders: order_date >= current_date - 90\n"), 0644)).To(Succeed())
+
+			_, err := backup.ReadRowFilterFile(filterFile)
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Invalid table name in row filter file"))
+		})
+		It("returns an error for an empty filter", func() {
+			Expect(ioutil.WriteFile(filterFile, []byte("public.events: \"\"\n"), 0644)).To(Succeed())
+
+			_, err := backup.ReadRowFilterFile(filterFile)
+
+			Expect(err).To(MatchError(fmt.Sprintf("The row filter of table public.events in row filter file %s is empty", filterFile)))
+		})
+	})
+	Describe("ValidateFilteredPartitionTables", func() {
+		partitionTable := backup.Table{Relation: backup.Relation{Oid: 1, Schema: "public", Name: "foo"},
+			TableDefinition: backup.TableDefinition{PartitionLevelInfo: backup.PartitionLevelInfo{Level: "p"}}}
+		extPartitions := []backup.PartitionInfo{{ParentRelationOid: 1, ParentSchema: "public", ParentRelationName: "foo", RelationOid: 2, IsExternal: true}}
+		AfterEach(func() {
+			backup.SetRowFilters(nil)
+		})
+		It("allows a row filter on a partition table without external partitions", func() {
+			backup.SetRowFilters(map[string]string{"public.foo": "i > 10"})
+
+			backup.ValidateFilteredPartitionTables([]backup.Table{partitionTable}, []backup.PartitionInfo{})
+		})
+		It("allows a partition table with external partitions without a row filter", func() {
+			backup.SetRowFilters(map[string]string{"public.bar": "i > 10"})
+
+			backup.ValidateFilteredPartitionTables([]backup.Table{partitionTable}, extPartitions)
+		})
+		It("panics for a row filter on a partition table with external partitions", func() {
+			backup.SetRowFilters(map[string]string{"public.foo": "i > 10"})
+			defer testhelper.ShouldPanicWithMessage("Cannot apply a row filter or masking rules to table public.foo, as it has external partitions.")
+
+			backup.ValidateFilteredPartitionTables([]backup.Table{partitionTable}, extPartitions)
+		})
 	})
 	Describe("BackupSingleTableData", func() {
 		var (
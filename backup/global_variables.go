@@ -7,6 +7,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/metrics"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
@@ -26,6 +27,7 @@ import (
 var (
 	backupReport         *report.Report
 	connectionPool       *dbconn.DBConn
+	encryptionKeySource  *utils.EncryptionKeySource
 	queryContext         context.Context
 	queryCancelFunc      context.CancelFunc
 	globalCluster        *cluster.Cluster
@@ -37,7 +39,14 @@ var (
 	wasTerminated        bool
 	backupLockFile       lockfile.Lockfile
 	filterRelationClause string
+	metricsRegistry      *metrics.Registry
+	opts                 *options.Options
 	quotedRoleNames      map[string]string
+	rowFilters           map[string]string
+	maskingRules         map[string]string
+	maskingKey           string
+	heapTableEntries     map[string]toc.HeapEntry
+	statsResetTimes      string
 	/*
 	 * Used for synchronizing DoCleanup.  In DoInit() we increment the group
 	 * and then wait for at least one DoCleanup to finish, either in DoTeardown
@@ -100,6 +109,18 @@ func SetQuotedRoleNames(quotedRoles map[string]string) {
 	quotedRoleNames = quotedRoles
 }
 
+func SetRowFilters(filters map[string]string) {
+	rowFilters = filters
+}
+
+func SetMaskingRules(rules map[string]string) {
+	maskingRules = rules
+}
+
+func SetMaskingKey(key string) {
+	maskingKey = key
+}
+
 // Util functions to enable ease of access to global flag values
 
 func MustGetFlagString(flagName string) string {
@@ -1,23 +1,26 @@
 package backup
 
 import (
-	"path"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/toc"
-	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/pkg/errors"
 )
 
 func FilterTablesForIncremental(lastBackupTOC, currentTOC *toc.TOC, tables []Table) []Table {
+	canCompareHeapTables := canCompareHeapEntries(lastBackupTOC, currentTOC)
 	var filteredTables []Table
 	for _, table := range tables {
 		currentAOEntry, isAOTable := currentTOC.IncrementalMetadata.AO[table.FQN()]
 		if !isAOTable {
-			filteredTables = append(filteredTables, table)
+			if !canCompareHeapTables || heapTableChanged(lastBackupTOC, currentTOC, table.FQN()) {
+				filteredTables = append(filteredTables, table)
+			}
 			continue
 		}
 		previousAOEntry := lastBackupTOC.IncrementalMetadata.AO[table.FQN()]
@@ -30,6 +33,75 @@ func FilterTablesForIncremental(lastBackupTOC, currentTOC *toc.TOC, tables []Tab
 	return filteredTables
 }
 
+/*
+ * The tuple counters of heap tables are only comparable if the statistics of
+ * the database have not been reset since the last backup, which also happens
+ * after a segment crashes.  Otherwise, every heap table is backed up.
+ */
+func canCompareHeapEntries(lastBackupTOC, currentTOC *toc.TOC) bool {
+	currentResetTimes := currentTOC.IncrementalMetadata.StatsResetTimes
+	return currentResetTimes != "" && currentResetTimes == lastBackupTOC.IncrementalMetadata.StatsResetTimes
+}
+
+/*
+ * A heap table without an entry in either TOC, such as a table created since
+ * the last backup or a table that is not a heap table, is always treated as
+ * changed.  So is a table without any tuple counters, as the statistics
+ * collector may have lost its counters for the table.
+ */
+func heapTableChanged(lastBackupTOC, currentTOC *toc.TOC, tableFQN string) bool {
+	previousHeapEntry, inLastBackup := lastBackupTOC.IncrementalMetadata.Heap[tableFQN]
+	currentHeapEntry, inCurrentBackup := currentTOC.IncrementalMetadata.Heap[tableFQN]
+	hasCounters := currentHeapEntry.TuplesInserted != 0 || currentHeapEntry.TuplesUpdated != 0 || currentHeapEntry.TuplesDeleted != 0
+	return !inLastBackup || !inCurrentBackup || !hasCounters || previousHeapEntry != currentHeapEntry
+}
+
+/*
+ * The tuple counters of the statistics collector can miss changes, such as
+ * those of a session that has not yet sent its counters, so a heap table that
+ * is skipped because its counters did not change is only carried forward for
+ * a limited time.  Once the backup holding its data is at least maxAgeDays
+ * older than the current backup, or if no backup in the restore plan holds its
+ * data, it is backed up again.
+ */
+func AddExpiredHeapTables(backupSetTables []Table, allTables []Table, currentTOC *toc.TOC,
+	restorePlan []history.RestorePlanEntry, backupTimestamp string, maxAgeDays int) []Table {
+	backupSetFQNs := make(map[string]bool, len(backupSetTables))
+	for _, table := range backupSetTables {
+		backupSetFQNs[table.FQN()] = true
+	}
+	lastBackupTimestamps := make(map[string]string)
+	for _, restorePlanEntry := range restorePlan {
+		for _, tableFQN := range restorePlanEntry.TableFQNs {
+			lastBackupTimestamps[tableFQN] = restorePlanEntry.Timestamp
+		}
+	}
+	backupTime, _ := time.ParseInLocation("20060102150405", backupTimestamp, operating.System.Local)
+	oldestCarriedTime := backupTime.AddDate(0, 0, -maxAgeDays)
+
+	filteredTables := make([]Table, 0, len(backupSetTables))
+	numExpired := 0
+	for _, table := range allTables {
+		tableFQN := table.FQN()
+		if backupSetFQNs[tableFQN] {
+			filteredTables = append(filteredTables, table)
+			continue
+		}
+		if _, isAOTable := currentTOC.IncrementalMetadata.AO[tableFQN]; isAOTable {
+			continue
+		}
+		lastBackupTime, err := time.ParseInLocation("20060102150405", lastBackupTimestamps[tableFQN], operating.System.Local)
+		if err != nil || !lastBackupTime.After(oldestCarriedTime) {
+			filteredTables = append(filteredTables, table)
+			numExpired++
+		}
+	}
+	if numExpired > 0 {
+		gplog.Info("Backing up %d unchanged heap tables whose data was last backed up at least %d days ago", numExpired, maxAgeDays)
+	}
+	return filteredTables
+}
+
 func GetTargetBackupTimestamp() string {
 	targetTimestamp := ""
 	if fromTimestamp := MustGetFlagString(options.FROM_TIMESTAMP); fromTimestamp != "" {
@@ -62,9 +134,9 @@ func GetLatestMatchingBackupTimestamp() string {
 	return latestTimestamp
 }
 
-func GetLatestMatchingBackupConfig(history *history.History, currentBackupConfig *history.BackupConfig) *history.BackupConfig {
-	for _, backupConfig := range history.BackupConfigs {
-		if matchesIncrementalFlags(&backupConfig, currentBackupConfig) && !backupConfig.Failed() {
+func GetLatestMatchingBackupConfig(backupHistory *history.History, currentBackupConfig *history.BackupConfig) *history.BackupConfig {
+	for _, backupConfig := range backupHistory.BackupConfigs {
+		if history.MatchesIncrementalFlags(&backupConfig, currentBackupConfig) && !backupConfig.Failed() && !backupConfig.Deleted() {
 			return &backupConfig
 		}
 	}
@@ -72,21 +144,6 @@ func GetLatestMatchingBackupConfig(history *history.History, currentBackupConfig
 	return nil
 }
 
-func matchesIncrementalFlags(backupConfig *history.BackupConfig, currentBackupConfig *history.BackupConfig) bool {
-	_, pluginBinaryName := path.Split(backupConfig.Plugin)
-	return backupConfig.BackupDir == MustGetFlagString(options.BACKUP_DIR) &&
-		backupConfig.DatabaseName == currentBackupConfig.DatabaseName &&
-		backupConfig.LeafPartitionData == MustGetFlagBool(options.LEAF_PARTITION_DATA) &&
-		pluginBinaryName == currentBackupConfig.Plugin &&
-		backupConfig.SingleDataFile == MustGetFlagBool(options.SINGLE_DATA_FILE) &&
-		backupConfig.Compressed == currentBackupConfig.Compressed &&
-		// Expanding of the include list happens before this now so we must compare again current backup config
-		utils.NewIncludeSet(backupConfig.IncludeRelations).Equals(utils.NewIncludeSet(currentBackupConfig.IncludeRelations)) &&
-		utils.NewIncludeSet(backupConfig.IncludeSchemas).Equals(utils.NewIncludeSet(MustGetFlagStringArray(options.INCLUDE_SCHEMA))) &&
-		utils.NewIncludeSet(backupConfig.ExcludeRelations).Equals(utils.NewIncludeSet(MustGetFlagStringArray(options.EXCLUDE_RELATION))) &&
-		utils.NewIncludeSet(backupConfig.ExcludeSchemas).Equals(utils.NewIncludeSet(MustGetFlagStringArray(options.EXCLUDE_SCHEMA)))
-}
-
 func PopulateRestorePlan(changedTables []Table,
 	restorePlan []history.RestorePlanEntry, allTables []Table) []history.RestorePlanEntry {
 	currBackupRestorePlanEntry := history.RestorePlanEntry{
@@ -76,8 +76,101 @@ var _ = Describe("backup/incremental tests", func() {
 		It("Should NOT include the unmodified AO table", func() {
 			Expect(filteredTables).To(Not(ContainElement(tblAOUnchanged)))
 		})
+		Context("Heap tables", func() {
+			heapEntry := toc.HeapEntry{Relfilenode: 16384, TuplesInserted: 10, Size: 32768, LastDDLTimestamp: "00000"}
+			changedHeapEntry := heapEntry
+			changedHeapEntry.TuplesUpdated = 1
+			truncatedHeapEntry := heapEntry
+			truncatedHeapEntry.Relfilenode = 16400
+			tblHeapChanged := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_changed"}}
+			tblHeapTruncated := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_truncated"}}
+			tblHeapUnchanged := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_unchanged"}}
+			tblHeapNew := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_new"}}
+			heapTables := []backup.Table{tblHeapChanged, tblHeapTruncated, tblHeapUnchanged, tblHeapNew}
+			var prevHeapTOC, currHeapTOC toc.TOC
+			BeforeEach(func() {
+				prevHeapTOC = toc.TOC{IncrementalMetadata: toc.IncrementalEntries{
+					Heap: map[string]toc.HeapEntry{
+						"public.heap_changed":   heapEntry,
+						"public.heap_truncated": heapEntry,
+						"public.heap_unchanged": heapEntry,
+					},
+					StatsResetTimes: "0:2020-01-01 00:00:00+00,1:2020-01-01 00:00:00+00",
+				}}
+				currHeapTOC = toc.TOC{IncrementalMetadata: toc.IncrementalEntries{
+					Heap: map[string]toc.HeapEntry{
+						"public.heap_changed":   changedHeapEntry,
+						"public.heap_truncated": truncatedHeapEntry,
+						"public.heap_unchanged": heapEntry,
+						"public.heap_new":       heapEntry,
+					},
+					StatsResetTimes: "0:2020-01-01 00:00:00+00,1:2020-01-01 00:00:00+00",
+				}}
+			})
+			It("includes only the heap tables that changed or are new since the last backup", func() {
+				filteredHeapTables := backup.FilterTablesForIncremental(&prevHeapTOC, &currHeapTOC, heapTables)
+
+				Expect(filteredHeapTables).To(Equal([]backup.Table{tblHeapChanged, tblHeapTruncated, tblHeapNew}))
+			})
+			It("includes every heap table if statistics were reset since the last backup", func() {
+				currHeapTOC.IncrementalMetadata.StatsResetTimes = "0:2020-01-01 00:00:00+00,1:2020-02-01 00:00:00+00"
+
+				filteredHeapTables := backup.FilterTablesForIncremental(&prevHeapTOC, &currHeapTOC, heapTables)
+
+				Expect(filteredHeapTables).To(Equal(heapTables))
+			})
+			It("includes a heap table without tuple counters", func() {
+				noCountersHeapEntry := toc.HeapEntry{Relfilenode: 16384, Size: 32768, LastDDLTimestamp: "00000"}
+				prevHeapTOC.IncrementalMetadata.Heap["public.heap_unchanged"] = noCountersHeapEntry
+				currHeapTOC.IncrementalMetadata.Heap["public.heap_unchanged"] = noCountersHeapEntry
+
+				filteredHeapTables := backup.FilterTablesForIncremental(&prevHeapTOC, &currHeapTOC, heapTables)
+
+				Expect(filteredHeapTables).To(Equal(heapTables))
+			})
+			It("includes every heap table if the last backup did not record heap table changes", func() {
+				prevHeapTOC.IncrementalMetadata = toc.IncrementalEntries{}
+
+				filteredHeapTables := backup.FilterTablesForIncremental(&prevHeapTOC, &currHeapTOC, heapTables)
+
+				Expect(filteredHeapTables).To(Equal(heapTables))
+			})
+			It("includes every heap table if changes could not be detected for the current backup", func() {
+				currHeapTOC.IncrementalMetadata.StatsResetTimes = ""
+
+				filteredHeapTables := backup.FilterTablesForIncremental(&prevHeapTOC, &currHeapTOC, heapTables)
+
+				Expect(filteredHeapTables).To(Equal(heapTables))
+			})
+		})
 	})
 
+	Describe("AddExpiredHeapTables", func() {
+		tblHeapChanged := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_changed"}}
+		tblHeapRecent := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_recent"}}
+		tblHeapExpired := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_expired"}}
+		tblHeapMissing := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_missing"}}
+		tblAOUnchanged := backup.Table{Relation: backup.Relation{Schema: "public", Name: "ao_unchanged"}}
+		allTables := []backup.Table{tblHeapChanged, tblHeapRecent, tblHeapExpired, tblHeapMissing, tblAOUnchanged}
+		currTOC := toc.TOC{IncrementalMetadata: toc.IncrementalEntries{
+			AO: map[string]toc.AOEntry{"public.ao_unchanged": {}},
+		}}
+		restorePlan := []history.RestorePlanEntry{
+			{Timestamp: "20200101000000", TableFQNs: []string{"public.heap_expired", "public.ao_unchanged"}},
+			{Timestamp: "20200105000000", TableFQNs: []string{"public.heap_recent"}},
+		}
+
+		It("adds the unchanged heap tables that were last backed up at least the maximum age ago or are not in the restore plan", func() {
+			filteredTables := backup.AddExpiredHeapTables([]backup.Table{tblHeapChanged}, allTables, &currTOC, restorePlan, "20200108000000", 7)
+
+			Expect(filteredTables).To(Equal([]backup.Table{tblHeapChanged, tblHeapExpired, tblHeapMissing}))
+		})
+		It("does not add heap tables that were last backed up less than the maximum age ago", func() {
+			filteredTables := backup.AddExpiredHeapTables([]backup.Table{tblHeapChanged}, allTables, &currTOC, restorePlan[:1], "20200107235959", 7)
+
+			Expect(filteredTables).To(Equal([]backup.Table{tblHeapChanged, tblHeapRecent, tblHeapMissing}))
+		})
+	})
 	Describe("GetLatestMatchingBackupConfig", func() {
 		contents := history.History{BackupConfigs: []history.BackupConfig{
 			{DatabaseName: "test2", Timestamp: "timestamp4", Status: history.BackupStatusFailed},
@@ -99,6 +192,28 @@ var _ = Describe("backup/incremental tests", func() {
 
 			structmatcher.ExpectStructsToMatch(contents.BackupConfigs[2], latestBackupHistoryEntry)
 		})
+		It("Should return the latest matching backup's timestamp that was not deleted", func() {
+			deletedContents := history.History{BackupConfigs: []history.BackupConfig{
+				{DatabaseName: "test1", Timestamp: "timestamp3", DateDeleted: "timestamp4"},
+				{DatabaseName: "test1", Timestamp: "timestamp1"},
+			}}
+			currentBackupConfig := history.BackupConfig{DatabaseName: "test1"}
+
+			latestBackupHistoryEntry := backup.GetLatestMatchingBackupConfig(&deletedContents, &currentBackupConfig)
+
+			structmatcher.ExpectStructsToMatch(deletedContents.BackupConfigs[1], latestBackupHistoryEntry)
+		})
+		It("Should return the latest matching backup's timestamp with the same encryption setting", func() {
+			encryptedContents := history.History{BackupConfigs: []history.BackupConfig{
+				{DatabaseName: "test1", Timestamp: "timestamp3"},
+				{DatabaseName: "test1", Timestamp: "timestamp1", Encrypted: true},
+			}}
+			currentBackupConfig := history.BackupConfig{DatabaseName: "test1", Encrypted: true}
+
+			latestBackupHistoryEntry := backup.GetLatestMatchingBackupConfig(&encryptedContents, &currentBackupConfig)
+
+			structmatcher.ExpectStructsToMatch(encryptedContents.BackupConfigs[1], latestBackupHistoryEntry)
+		})
 		It("should return nil with no matching Dbname", func() {
 			currentBackupConfig := history.BackupConfig{DatabaseName: "test3"}
 
@@ -0,0 +1,316 @@
+package backup
+
+/*
+ * This file contains functions related to masking the values of columns as
+ * their data is backed up, using --masking-rule-file.
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	MASK_HASH    = "hash"
+	MASK_NULL    = "null"
+	MASK_FIXED   = "fixed:"
+	MASK_PARTIAL = "partial:"
+)
+
+/*
+ * A masking rule file is a YAML map from columns, named as schema.table.column
+ * with unquoted names, to the rules with which their values are masked:
+ *
+ *   public.customers.email: hash
+ *   public.customers.notes: null
+ *   public.customers.name: "fixed:REDACTED"
+ *   public.customers.card_number: "partial:4"
+ *   public.customers.birth_date: "date_trunc('year', birth_date)::date"
+ *
+ * hash replaces a value with the MD5 hash of the secret in --masking-key-file
+ * followed by the value, so that equal values stay equal but the hashes cannot
+ * be matched against the hashes of guessed values without the secret, null
+ * with NULL, fixed with the given value, and partial with a string of X
+ * characters followed by the given number of its last characters.  Any other
+ * rule is an SQL expression, evaluated for each row, that may refer to the
+ * columns of the table.  The masked values are cast to the type of the column,
+ * and hash and partial can only be used on columns of string types.
+ */
+func ReadMaskingRuleFile(filename string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[string]*string)
+	err = yaml.UnmarshalStrict(contents, &rules)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse masking rule file %s", filename)
+	}
+	maskingRules := make(map[string]string, len(rules))
+	for column, rule := range rules {
+		if _, _, err := splitMaskedColumnName(column); err != nil {
+			return nil, errors.Wrapf(err, "Invalid column name in masking rule file %s", filename)
+		}
+		if rule == nil {
+			// An unquoted null is read by the YAML parser as a null value rather than a string
+			maskingRules[column] = MASK_NULL
+			continue
+		}
+		if _, err := getMaskingExpression("", "", *rule); err != nil {
+			return nil, errors.Wrapf(err, "Invalid masking rule for column %s in masking rule file %s", column, filename)
+		}
+		maskingRules[column] = *rule
+	}
+	return maskingRules, nil
+}
+
+// Splits schema.table.column into the table, as schema.table, and the column
+func splitMaskedColumnName(column string) (string, string, error) {
+	index := strings.LastIndex(column, ".")
+	if index == -1 || index == len(column)-1 {
+		return "", "", errors.Errorf("%s is not of the form schema.table.column", column)
+	}
+	if _, err := options.SeparateSchemaAndTable([]string{column[:index]}); err != nil {
+		return "", "", errors.Errorf("%s is not of the form schema.table.column", column)
+	}
+	return column[:index], column[index+1:], nil
+}
+
+func getMaskingExpression(quotedColumn string, columnType string, rule string) (string, error) {
+	var expression string
+	switch {
+	case strings.TrimSpace(rule) == "":
+		return "", errors.New("The masking rule is empty")
+	case rule == MASK_HASH:
+		expression = fmt.Sprintf("md5('%s' || %s::text)", utils.EscapeSingleQuotes(maskingKey), quotedColumn)
+	case rule == MASK_NULL:
+		expression = "NULL"
+	case strings.HasPrefix(rule, MASK_FIXED):
+		expression = fmt.Sprintf("'%s'", utils.EscapeSingleQuotes(strings.TrimPrefix(rule, MASK_FIXED)))
+	case strings.HasPrefix(rule, MASK_PARTIAL):
+		numChars, err := strconv.Atoi(strings.TrimPrefix(rule, MASK_PARTIAL))
+		if err != nil || numChars < 0 {
+			return "", errors.Errorf("The number of characters kept by %s must be a non-negative integer", rule)
+		}
+		expression = fmt.Sprintf("repeat('X', greatest(length(%[1]s::text) - %[2]d, 0)) || substr(%[1]s::text, greatest(length(%[1]s::text) - %[2]d + 1, 1))",
+			quotedColumn, numChars)
+	default:
+		expression = rule
+	}
+	return fmt.Sprintf("CAST(%s AS %s)", expression, columnType), nil
+}
+
+func initializeMaskingRules() {
+	filename := MustGetFlagString(options.MASKING_RULE_FILE)
+	if filename == "" {
+		return
+	}
+	var err error
+	maskingRules, err = ReadMaskingRuleFile(filename)
+	gplog.FatalOnError(err)
+	maskingKey, err = ReadMaskingKeyFile(MustGetFlagString(options.MASKING_KEY_FILE))
+	gplog.FatalOnError(err)
+	err = ValidateMaskingKey(maskingRules, maskingKey)
+	gplog.FatalOnError(err)
+	gplog.Verbose("Masking the values of %d column(s) using the rules in %s", len(maskingRules), filename)
+}
+
+/*
+ * The masking key is a secret, so it is kept out of the backup's config file,
+ * TOC, and report, and is replaced in the COPY commands that are logged.  A
+ * trailing newline is not part of the key.
+ */
+func ReadMaskingKeyFile(filename string) (string, error) {
+	if filename == "" {
+		return "", nil
+	}
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimRight(string(contents), "\r\n")
+	if key == "" {
+		return "", errors.Errorf("Masking key file %s is empty", filename)
+	}
+	return key, nil
+}
+
+func ValidateMaskingKey(rules map[string]string, key string) error {
+	if key != "" {
+		return nil
+	}
+	for _, rule := range rules {
+		if rule == MASK_HASH {
+			return errors.New("--masking-key-file must be specified to mask columns with hash")
+		}
+	}
+	return nil
+}
+
+// Replaces the masking key in a query that is logged
+func redactMaskingKey(query string) string {
+	if maskingKey == "" {
+		return query
+	}
+	return strings.Replace(query, fmt.Sprintf("'%s'", utils.EscapeSingleQuotes(maskingKey)), "'<masking key>'", -1)
+}
+
+/*
+ * Returns the rule for each masked column of a table, keyed by the quoted
+ * column name.  The rules of a partition table apply to its leaf partitions.
+ */
+func getMaskingRules(table Table) map[string]string {
+	tableRules := make(map[string]string)
+	if len(maskingRules) == 0 {
+		return tableRules
+	}
+	for _, fqn := range getUnquotedTableNames(table) {
+		for _, column := range table.ColumnDefs {
+			if _, ok := tableRules[column.Name]; ok {
+				continue
+			}
+			if rule, ok := maskingRules[fmt.Sprintf("%s.%s", fqn, utils.UnquoteIdent(column.Name))]; ok {
+				tableRules[column.Name] = rule
+			}
+		}
+	}
+	return tableRules
+}
+
+func validateMaskingRules(dataTables []Table) {
+	if len(maskingRules) == 0 {
+		return
+	}
+	maskedColumns := make(map[string]bool)
+	for _, table := range dataTables {
+		for _, fqn := range getUnquotedTableNames(table) {
+			for _, column := range table.ColumnDefs {
+				maskedColumns[fmt.Sprintf("%s.%s", fqn, utils.UnquoteIdent(column.Name))] = true
+			}
+		}
+	}
+	missingColumns := make([]string, 0)
+	for column := range maskingRules {
+		if !maskedColumns[column] {
+			missingColumns = append(missingColumns, column)
+		}
+	}
+	if len(missingColumns) > 0 {
+		sort.Strings(missingColumns)
+		gplog.Fatal(errors.Errorf("The following columns in the masking rule file are not columns of tables whose data is backed up: %s",
+			strings.Join(missingColumns, ", ")), "")
+	}
+
+	collidingMaskedTableOids := make([]uint32, 0)
+	for _, table := range dataTables {
+		for _, rule := range getMaskingRules(table) {
+			if rule == MASK_NULL || strings.HasPrefix(rule, MASK_FIXED) || strings.HasPrefix(rule, MASK_PARTIAL) {
+				collidingMaskedTableOids = append(collidingMaskedTableOids, table.Oid)
+				break
+			}
+		}
+	}
+	uniqueColumns := GetUniqueColumns(connectionPool, collidingMaskedTableOids)
+	for _, table := range dataTables {
+		err := ValidateMaskingRuleTypes(table)
+		gplog.FatalOnError(err)
+		err = ValidateMaskingRuleConstraints(table, uniqueColumns[table.Oid])
+		gplog.FatalOnError(err)
+		ValidateFixedMaskingValues(connectionPool, table)
+	}
+}
+
+// Matches the names format_type gives string types, with their length if any
+var stringTypeRegex = regexp.MustCompile(`^(?:text|character varying|character)(?:\((\d+)\))?$`)
+
+/*
+ * hash and partial turn a value into a string, so they can only be used on
+ * columns of string types, as casting the string to any other type would fail
+ * in the middle of the data backup.  The keyed MD5 hash is 32 characters long
+ * and is cut off when cast to a shorter varchar or char column, after which
+ * different values could have equal hashes.
+ */
+func ValidateMaskingRuleTypes(table Table) error {
+	tableRules := getMaskingRules(table)
+	for _, column := range table.ColumnDefs {
+		rule, ok := tableRules[column.Name]
+		if !ok || (rule != MASK_HASH && !strings.HasPrefix(rule, MASK_PARTIAL)) {
+			continue
+		}
+		match := stringTypeRegex.FindStringSubmatch(column.Type)
+		if match == nil {
+			return errors.Errorf("Cannot mask column %s of table %s with %s, as its type %s is not a string type.  Use an SQL expression instead.",
+				column.Name, table.FQN(), rule, column.Type)
+		}
+		if rule == MASK_HASH && match[1] != "" {
+			if length, _ := strconv.Atoi(match[1]); length < 32 {
+				return errors.Errorf("Cannot mask column %s of table %s with hash, as its type %s is too short to hold a keyed MD5 hash of 32 characters",
+					column.Name, table.FQN(), column.Type)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ * A fixed value that cannot be cast to the type of its column would only fail
+ * in the COPY of the table on the segments, so the cast is tried before any
+ * data is backed up.
+ */
+func ValidateFixedMaskingValues(connectionPool *dbconn.DBConn, table Table) {
+	tableRules := getMaskingRules(table)
+	for _, column := range table.ColumnDefs {
+		rule, ok := tableRules[column.Name]
+		if !ok || !strings.HasPrefix(rule, MASK_FIXED) {
+			continue
+		}
+		expression, _ := getMaskingExpression(column.Name, column.Type, rule)
+		_, err := connectionPool.Exec(fmt.Sprintf("SELECT %s", expression))
+		if err != nil {
+			gplog.Fatal(errors.Errorf("Cannot mask column %s of table %s with %s, as the value cannot be cast to its type %s: %v",
+				column.Name, table.FQN(), rule, column.Type, err), "")
+		}
+	}
+}
+
+/*
+ * The null and fixed rules give a column the same value in every row, so the
+ * restore of the masked data would fail on a column that is NOT NULL, or that
+ * is part of a primary key, unique constraint, or unique index, long after the
+ * backup succeeded.  Several NULLs do not violate a unique constraint, so null
+ * is allowed on unique columns that are not NOT NULL.  The partial rule keeps
+ * only the last characters of each value, so distinct values easily become
+ * equal, and is not allowed on unique columns either.
+ */
+func ValidateMaskingRuleConstraints(table Table, uniqueColumns map[string]bool) error {
+	tableRules := getMaskingRules(table)
+	for _, column := range table.ColumnDefs {
+		rule, ok := tableRules[column.Name]
+		if !ok {
+			continue
+		}
+		if rule == MASK_NULL && column.NotNull {
+			return errors.Errorf("Cannot mask column %s of table %s with null, as it is NOT NULL", column.Name, table.FQN())
+		}
+		if strings.HasPrefix(rule, MASK_FIXED) && uniqueColumns[column.Name] {
+			return errors.Errorf("Cannot mask column %s of table %s with a fixed value, as it is part of a primary key or unique constraint",
+				column.Name, table.FQN())
+		}
+		if strings.HasPrefix(rule, MASK_PARTIAL) && uniqueColumns[column.Name] {
+			return errors.Errorf("Cannot mask column %s of table %s with %s, as it is part of a primary key or unique constraint",
+				column.Name, table.FQN(), rule)
+		}
+	}
+	return nil
+}
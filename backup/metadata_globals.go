@@ -44,6 +44,9 @@ func PrintCreateDatabaseStatement(metadataFile *utils.FileWithByteCount, tocfile
 	metadataFile.MustPrintf(";")
 
 	entry := toc.MetadataEntry{Name: db.Name, ObjectType: "DATABASE"}
+	if db.Tablespace != "pg_default" {
+		entry.Tablespace = db.Tablespace
+	}
 	tocfile.AddMetadataEntry("global", entry, start, metadataFile.ByteCount)
 	PrintObjectMetadata(metadataFile, tocfile, dbMetadata[db.GetUniqueID()], db, "")
 }
@@ -24,7 +24,9 @@ func PrintCreateIndexStatements(metadataFile *utils.FileWithByteCount, toc *toc.
 			if index.Tablespace != "" {
 				start := metadataFile.ByteCount
 				metadataFile.MustPrintf("\nALTER INDEX %s SET TABLESPACE %s;", indexFQN, index.Tablespace)
-				toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
+				tablespaceEntry := entry
+				tablespaceEntry.Tablespace = index.Tablespace
+				toc.AddMetadataEntry(section, tablespaceEntry, start, metadataFile.ByteCount)
 			}
 			tableFQN := utils.MakeFQN(index.OwningSchema, index.OwningTable)
 			if index.IsClustered {
@@ -66,33 +66,46 @@ func PrintStatements(metadataFile *utils.FileWithByteCount, toc *toc.TOC,
 	}
 }
 
-func PrintObjectMetadata(metadataFile *utils.FileWithByteCount, toc *toc.TOC,
+/*
+ * Owner and privileges statements are marked in the TOC, so that gprestore can
+ * leave them out or map the roles they name without parsing other statements.
+ */
+func PrintStatementsWithClause(metadataFile *utils.FileWithByteCount, tocfile *toc.TOC,
+	obj toc.TOCObject, statements []string, clause string) {
+	for _, statement := range statements {
+		start := metadataFile.ByteCount
+		metadataFile.MustPrintf("\n\n%s\n", statement)
+		section, entry := obj.GetMetadataEntry()
+		entry.Clause = clause
+		tocfile.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
+	}
+}
+
+func PrintObjectMetadata(metadataFile *utils.FileWithByteCount, tocfile *toc.TOC,
 	metadata ObjectMetadata, obj toc.TOCObjectWithMetadata, owningTable string) {
 	_, entry := obj.GetMetadataEntry()
 	if entry.ObjectType == "DATABASE METADATA" {
 		entry.ObjectType = "DATABASE"
 	}
-	statements := make([]string, 0)
 	if comment := metadata.GetCommentStatement(obj.FQN(), entry.ObjectType, owningTable); comment != "" {
-		statements = append(statements, strings.TrimSpace(comment))
+		PrintStatements(metadataFile, tocfile, obj, []string{strings.TrimSpace(comment)})
 	}
 	if owner := metadata.GetOwnerStatement(obj.FQN(), entry.ObjectType); owner != "" {
 		if !(connectionPool.Version.Before("5") && entry.ObjectType == "LANGUAGE") {
 			// Languages have implicit owners in 4.3, but do not support ALTER OWNER
-			statements = append(statements, strings.TrimSpace(owner))
+			PrintStatementsWithClause(metadataFile, tocfile, obj, []string{strings.TrimSpace(owner)}, toc.CLAUSE_OWNER)
 		}
 	}
 	if privileges := metadata.GetPrivilegesStatements(obj.FQN(), entry.ObjectType); privileges != "" {
-		statements = append(statements, strings.TrimSpace(privileges))
+		PrintStatementsWithClause(metadataFile, tocfile, obj, []string{strings.TrimSpace(privileges)}, toc.CLAUSE_PRIVILEGES)
 	}
 	if securityLabel := metadata.GetSecurityLabelStatement(obj.FQN(), entry.ObjectType); securityLabel != "" {
-		statements = append(statements, strings.TrimSpace(securityLabel))
+		PrintStatements(metadataFile, tocfile, obj, []string{strings.TrimSpace(securityLabel)})
 	}
-	PrintStatements(metadataFile, toc, obj, statements)
 }
 
 // Only print grant statements for any functions that belong to extensions
-func printExtensionFunctionACLs(metadataFile *utils.FileWithByteCount, toc *toc.TOC,
+func printExtensionFunctionACLs(metadataFile *utils.FileWithByteCount, tocfile *toc.TOC,
 	metadataMap MetadataMap, funcInfoMap map[uint32]FunctionInfo) {
 	type objectInfo struct{
 		FunctionInfo
@@ -114,7 +127,7 @@ func printExtensionFunctionACLs(metadataFile *utils.FileWithByteCount, toc *toc.
 	for _, obj := range objects {
 		if privileges := obj.GetPrivilegesStatements(obj.FQN(), "FUNCTION"); privileges != "" {
 			statements = append(statements, strings.TrimSpace(privileges))
-			PrintStatements(metadataFile, toc, obj, statements)
+			PrintStatementsWithClause(metadataFile, tocfile, obj, statements, toc.CLAUSE_PRIVILEGES)
 		}
 	}
 }
@@ -481,7 +494,7 @@ func (obj ObjectMetadata) GetSecurityLabelStatement(objectName string, objectTyp
 	return securityLabelStr
 }
 
-func PrintDefaultPrivilegesStatements(metadataFile *utils.FileWithByteCount, toc *toc.TOC, privileges []DefaultPrivileges) {
+func PrintDefaultPrivilegesStatements(metadataFile *utils.FileWithByteCount, tocfile *toc.TOC, privileges []DefaultPrivileges) {
 	for _, priv := range privileges {
 		statements := make([]string, 0)
 		roleStr := ""
@@ -527,7 +540,8 @@ func PrintDefaultPrivilegesStatements(metadataFile *utils.FileWithByteCount, toc
 		start := metadataFile.ByteCount
 		metadataFile.MustPrintln("\n\n" + strings.Join(statements, "\n"))
 		section, entry := priv.GetMetadataEntry()
-		toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
+		entry.Clause = toc.CLAUSE_PRIVILEGES
+		tocfile.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
 	}
 }
 
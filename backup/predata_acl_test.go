@@ -9,6 +9,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
 	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/toc"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -134,6 +135,14 @@ GRANT ALL ON TABLE public.tablename TO anothertestrole;
 GRANT SELECT,INSERT,UPDATE,DELETE,TRUNCATE,REFERENCES ON TABLE public.tablename TO testrole;
 GRANT TRIGGER ON TABLE public.tablename TO PUBLIC;`)
 		})
+		It("marks the owner and privileges statements in the TOC", func() {
+			tableMetadata := backup.ObjectMetadata{Privileges: privileges, Owner: "testrole", Comment: "This is a table comment."}
+			backup.PrintObjectMetadata(backupfile, tocfile, tableMetadata, table, "")
+			Expect(tocfile.PredataEntries).To(HaveLen(3))
+			Expect(tocfile.PredataEntries[0].Clause).To(Equal(""))
+			Expect(tocfile.PredataEntries[1].Clause).To(Equal(toc.CLAUSE_OWNER))
+			Expect(tocfile.PredataEntries[2].Clause).To(Equal(toc.CLAUSE_PRIVILEGES))
+		})
 		It("prints SERVER for ALTER and FOREIGN SERVER for GRANT/REVOKE for a foreign server", func() {
 			server := backup.ForeignServer{Name: "foreignserver"}
 			serverPrivileges := testutils.DefaultACLForType("testrole", "FOREIGN SERVER")
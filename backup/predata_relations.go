@@ -91,6 +91,7 @@ func PrintCreateTableStatement(metadataFile *utils.FileWithByteCount, toc *toc.T
 		PrintRegularTableCreateStatement(metadataFile, nil, table)
 	}
 	section, entry := table.GetMetadataEntry()
+	entry.Tablespace = table.TablespaceName
 	toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
 	PrintPostCreateTableStatements(metadataFile, toc, table, tableMetadata)
 }
@@ -192,25 +193,26 @@ func printAlterColumnStatements(metadataFile *utils.FileWithByteCount, table Tab
  * This function prints additional statements that come after the CREATE TABLE
  * statement for both regular and external tables.
  */
-func PrintPostCreateTableStatements(metadataFile *utils.FileWithByteCount, toc *toc.TOC, table Table, tableMetadata ObjectMetadata) {
-	PrintObjectMetadata(metadataFile, toc, tableMetadata, table, "")
-	statements := make([]string, 0)
+func PrintPostCreateTableStatements(metadataFile *utils.FileWithByteCount, tocfile *toc.TOC, table Table, tableMetadata ObjectMetadata) {
+	PrintObjectMetadata(metadataFile, tocfile, tableMetadata, table, "")
 	for _, att := range table.ColumnDefs {
 		if att.Comment != "" {
 			escapedComment := utils.EscapeSingleQuotes(att.Comment)
-			statements = append(statements, fmt.Sprintf("COMMENT ON COLUMN %s.%s IS '%s';", table.FQN(), att.Name, escapedComment))
+			PrintStatements(metadataFile, tocfile, table, []string{fmt.Sprintf("COMMENT ON COLUMN %s.%s IS '%s';", table.FQN(), att.Name, escapedComment)})
 		}
 		if att.Privileges.Valid {
 			columnMetadata := ObjectMetadata{Privileges: getColumnACL(att.Privileges, att.Kind), Owner: tableMetadata.Owner}
 			columnPrivileges := columnMetadata.GetPrivilegesStatements(table.FQN(), "COLUMN", att.Name)
-			statements = append(statements, strings.TrimSpace(columnPrivileges))
+			PrintStatementsWithClause(metadataFile, tocfile, table, []string{strings.TrimSpace(columnPrivileges)}, toc.CLAUSE_PRIVILEGES)
 		}
 		if att.SecurityLabel != "" {
 			escapedLabel := utils.EscapeSingleQuotes(att.SecurityLabel)
-			statements = append(statements, fmt.Sprintf("SECURITY LABEL FOR %s ON COLUMN %s.%s IS '%s';", att.SecurityLabelProvider, table.FQN(), att.Name, escapedLabel))
+			PrintStatements(metadataFile, tocfile, table, []string{fmt.Sprintf("SECURITY LABEL FOR %s ON COLUMN %s.%s IS '%s';", att.SecurityLabelProvider, table.FQN(), att.Name, escapedLabel)})
 		}
 	}
 
+	statements := make([]string, 0)
+
 	// It seems that replica identity on foreign tables default to "n" and cannot be altered in postgres 9.4
 	if (table.ReplicaIdentity != "") && (table.ForeignDef == ForeignTableDefinition{}) {
 		switch table.ReplicaIdentity {
@@ -230,7 +232,7 @@ func PrintPostCreateTableStatements(metadataFile *utils.FileWithByteCount, toc *
 				utils.MakeFQN(alteredPartitionRelation.OldSchema, alteredPartitionRelation.Name), alteredPartitionRelation.NewSchema))
 	}
 
-	PrintStatements(metadataFile, toc, table, statements)
+	PrintStatements(metadataFile, tocfile, table, statements)
 }
 
 /*
@@ -315,6 +317,9 @@ func PrintCreateViewStatement(metadataFile *utils.FileWithByteCount, toc *toc.TO
 			view.FQN(), view.Options, tablespaceClause, view.Definition.String[:len(view.Definition.String)-1])
 	}
 	section, entry := view.GetMetadataEntry()
+	if view.IsMaterialized {
+		entry.Tablespace = view.Tablespace
+	}
 	toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
 	PrintObjectMetadata(metadataFile, toc, viewMetadata, view, "")
 }
@@ -117,3 +117,88 @@ func getLastDDLTimestamps(connectionPool *dbconn.DBConn) map[string]string {
 	}
 	return resultMap
 }
+
+/*
+ * Heap tables have no modcount, so with --incremental-heap changes to them are
+ * detected from the tuple counters of the statistics collector on the
+ * segments, together with the size of the tables, their relfilenodes, and
+ * their last DDL operations.  If the counters cannot be relied on, no heap
+ * entries are returned and every heap table is backed up.
+ *
+ * This is called before the transactions of the backup begin, so the filter
+ * clause of the backup, which is only known later, is not applied.
+ */
+func GetHeapIncrementalMetadata(connectionPool *dbconn.DBConn) (map[string]toc.HeapEntry, string) {
+	if connectionPool.Version.Before("6") {
+		gplog.Verbose("Statistics reset times are not available before GPDB 6, so all heap tables will be backed up")
+		return nil, ""
+	}
+	trackCounts := dbconn.MustSelectString(connectionPool, "SELECT setting AS string FROM pg_settings WHERE name = 'track_counts'")
+	if trackCounts != "on" {
+		gplog.Verbose("track_counts is not enabled, so all heap tables will be backed up")
+		return nil, ""
+	}
+	gplog.Verbose("Querying statistics reset times")
+	statsResetTimes := getStatsResetTimes(connectionPool)
+	if statsResetTimes == "" {
+		return nil, ""
+	}
+	gplog.Verbose("Querying heap table tuple counters")
+	return getHeapTableEntries(connectionPool), statsResetTimes
+}
+
+func getStatsResetTimes(connectionPool *dbconn.DBConn) string {
+	query := `
+	SELECT coalesce(string_agg(d.gp_segment_id || ':' || coalesce(pg_catalog.pg_stat_get_db_stat_reset_time(d.oid)::text, ''),
+			',' ORDER BY d.gp_segment_id), '') AS string
+	FROM gp_dist_random('pg_database') d
+	WHERE d.datname = current_database()`
+	return dbconn.MustSelectString(connectionPool, query)
+}
+
+func getHeapTableEntries(connectionPool *dbconn.DBConn) map[string]toc.HeapEntry {
+	heapClause := "c.relstorage = 'h'"
+	if connectionPool.Version.AtLeast("7") {
+		heapClause = "c.relam = (SELECT oid FROM pg_am WHERE amname = 'heap')"
+	}
+	query := fmt.Sprintf(`
+	SELECT quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS heaptablefqn,
+		c.relfilenode,
+		segstats.tuplesinserted,
+		segstats.tuplesupdated,
+		segstats.tuplesdeleted,
+		segstats.size,
+		coalesce(lastop.lastddltimestamp::text, '') AS lastddltimestamp
+	FROM pg_class c
+		JOIN pg_namespace n ON c.relnamespace = n.oid
+		JOIN ( SELECT sc.oid,
+				pg_catalog.sum(pg_catalog.pg_stat_get_tuples_inserted(sc.oid)) AS tuplesinserted,
+				pg_catalog.sum(pg_catalog.pg_stat_get_tuples_updated(sc.oid)) AS tuplesupdated,
+				pg_catalog.sum(pg_catalog.pg_stat_get_tuples_deleted(sc.oid)) AS tuplesdeleted,
+				coalesce(pg_catalog.sum(pg_catalog.pg_relation_size(sc.oid)), 0) AS size
+			FROM gp_dist_random('pg_class') sc
+			WHERE sc.relkind = 'r'
+			GROUP BY sc.oid
+		) segstats ON c.oid = segstats.oid
+		LEFT JOIN ( SELECT lo.objid,
+				MAX(lo.statime) AS lastddltimestamp
+			FROM pg_stat_last_operation lo
+			WHERE lo.staactionname IN ('CREATE', 'ALTER', 'TRUNCATE')
+			GROUP BY lo.objid
+		) lastop ON c.oid = lastop.objid
+	WHERE c.relkind = 'r'
+		AND %s
+		AND %s`, heapClause, systemSchemaFilterClause("n"))
+
+	var results []struct {
+		HeapTableFQN string
+		toc.HeapEntry
+	}
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	resultMap := make(map[string]toc.HeapEntry)
+	for _, result := range results {
+		resultMap[result.HeapTableFQN] = result.HeapEntry
+	}
+	return resultMap
+}
@@ -494,6 +494,42 @@ func GetTableInheritance(connectionPool *dbconn.DBConn, tables []Relation) map[u
 	return resultMap
 }
 
+/*
+ * Returns the columns of each of the given tables that are part of a primary
+ * key, unique constraint, or unique index, keyed by the quoted column name.
+ */
+func GetUniqueColumns(connectionPool *dbconn.DBConn, tableOids []uint32) map[uint32]map[string]bool {
+	resultMap := make(map[uint32]map[string]bool)
+	if len(tableOids) == 0 {
+		return resultMap
+	}
+	tableOidList := make([]string, len(tableOids))
+	for i, oid := range tableOids {
+		tableOidList[i] = fmt.Sprintf("%d", oid)
+	}
+	query := fmt.Sprintf(`
+	SELECT DISTINCT i.indrelid AS oid,
+		quote_ident(a.attname) AS name
+	FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+	WHERE i.indisunique
+	AND i.indrelid IN (%s)`, strings.Join(tableOidList, ","))
+
+	var results []struct {
+		Oid  uint32
+		Name string
+	}
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	for _, result := range results {
+		if resultMap[result.Oid] == nil {
+			resultMap[result.Oid] = make(map[string]bool)
+		}
+		resultMap[result.Oid][result.Name] = true
+	}
+	return resultMap
+}
+
 func selectAsOidToStringMap(connectionPool *dbconn.DBConn, query string) map[uint32]string {
 	var results []struct {
 		Oid   uint32
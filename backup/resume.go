@@ -0,0 +1,294 @@
+package backup
+
+/*
+ * This file contains functions related to resuming a backup that failed or
+ * was interrupted while backing up data.
+ *
+ * When a backup fails, the data entries of the tables whose data was already
+ * backed up are written to its TOC.  A later backup run with --resume verifies
+ * those data files against their checksums and backs up only the remaining
+ * tables under its own snapshot.  The restore plan of the resumed backup lists
+ * which tables are to be restored from the failed backup, in the same way as
+ * for incremental backups.
+ */
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Writes the TOC of a failed backup with data entries for the tables that
+ * were completed, so that the backup can be resumed.  If the backup was
+ * terminated, DoTeardown does not write the config file, so it is written
+ * here as well.  Errors are only logged, as this runs during cleanup.
+ */
+func writePartialTOC(writeConfig bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			gplog.Warn("Unable to write TOC file for resuming backup: %v", err)
+		}
+	}()
+
+	if globalTOC == nil || backupReport == nil || MustGetFlagBool(options.SINGLE_DATA_FILE) {
+		return
+	}
+	rowsCopiedLock.Lock()
+	defer rowsCopiedLock.Unlock()
+	if dataBackupTables == nil {
+		return
+	}
+	tocFilename := globalFPInfo.GetTOCFilePath()
+	if utils.FileExists(tocFilename) {
+		return
+	}
+
+	numTables := 0
+	completedTables := make([]Table, 0)
+	for _, table := range dataBackupTables {
+		if table.SkipDataBackup() {
+			continue
+		}
+		numTables++
+		for _, rowsCopiedMap := range dataBackupRowsCopiedMaps {
+			if _, ok := rowsCopiedMap[table.Oid]; ok {
+				completedTables = append(completedTables, table)
+				break
+			}
+		}
+	}
+	// If the data backup finished, the data entries are already in the TOC
+	if len(globalTOC.DataEntries) == 0 {
+		AddTableDataEntriesToTOC(completedTables, dataBackupRowsCopiedMaps)
+	}
+	globalTOC.WriteToFileAndMakeReadOnly(tocFilename)
+
+	configFilename := globalFPInfo.GetConfigFilePath()
+	if writeConfig && !utils.FileExists(configFilename) {
+		history.WriteConfigFile(&backupReport.BackupConfig, configFilename)
+	} else {
+		writeConfig = false
+	}
+	if pluginConfig != nil {
+		pluginConfig.MustBackupFile(tocFilename)
+		if writeConfig {
+			pluginConfig.MustBackupFile(configFilename)
+		}
+	}
+	gplog.Info("Data for %d of %d tables was backed up.  Run gpbackup with --resume %s to back up the remaining tables.",
+		len(globalTOC.DataEntries), numTables, globalFPInfo.Timestamp)
+}
+
+func readResumeBackupConfig(resumeFPInfo filepath.FilePathInfo) *history.BackupConfig {
+	if pluginConfig != nil {
+		// These files need to be downloaded from the remote system into the local filesystem
+		pluginConfig.MustRestoreFile(resumeFPInfo.GetConfigFilePath())
+		pluginConfig.MustRestoreFile(resumeFPInfo.GetTOCFilePath())
+	}
+	resumeConfig := history.ReadConfigFile(resumeFPInfo.GetConfigFilePath())
+
+	if !resumeConfig.Failed() {
+		gplog.Fatal(errors.Errorf("Backup with timestamp %s did not fail and cannot be resumed", resumeFPInfo.Timestamp), "")
+	}
+	if resumeConfig.MetadataOnly || resumeConfig.SingleDataFile || resumeConfig.Incremental {
+		gplog.Fatal(errors.Errorf("Backup with timestamp %s cannot be resumed.  Only full backups with one data file per table can be resumed.",
+			resumeFPInfo.Timestamp), "")
+	}
+	if !utils.FileExists(resumeFPInfo.GetTOCFilePath()) {
+		gplog.Fatal(errors.Errorf("Backup with timestamp %s failed before backing up any data and cannot be resumed", resumeFPInfo.Timestamp), "")
+	}
+	if !history.MatchesIncrementalFlags(resumeConfig, &backupReport.BackupConfig) {
+		gplog.Fatal(errors.Errorf("The flags of the backup with timestamp = %s do not match "+
+			"that of the current one. Please refer to the report to view the flags supplied for the "+
+			"failed backup.", resumeFPInfo.Timestamp), "")
+	}
+	return resumeConfig
+}
+
+/*
+ * Verifies the data files of the backup being resumed against the checksums
+ * written when they were backed up, removes the entries of any table whose
+ * data could not be verified from its TOC, and returns the remaining entries.
+ */
+func prepareResumedBackup(resumeFPInfo filepath.FilePathInfo) []toc.MasterDataEntry {
+	gplog.Info("Verifying data files of backup with timestamp %s", resumeFPInfo.Timestamp)
+	resumeTOC := toc.NewTOC(resumeFPInfo.GetTOCFilePath())
+	checksums, sizes := utils.CollectDataFileChecksumsOnSegments(globalCluster, resumeFPInfo)
+	for contentID, tableChecksums := range checksums {
+		for oid, checksum := range tableChecksums {
+			resumeTOC.AddDataChecksum(contentID, oid, checksum)
+		}
+	}
+	for contentID, tableSizes := range sizes {
+		for oid, size := range tableSizes {
+			resumeTOC.AddDataSize(contentID, oid, size)
+		}
+	}
+
+	verifiedEntries := make([]toc.MasterDataEntry, 0)
+	if len(resumeTOC.DataEntries) > 0 {
+		oidList := make([]string, 0, len(resumeTOC.DataEntries))
+		for _, entry := range resumeTOC.DataEntries {
+			oidList = append(oidList, fmt.Sprintf("%d", entry.Oid))
+		}
+		computedChecksums := utils.ComputeDataFileChecksumsOnSegments(globalCluster, resumeFPInfo, oidList, pluginConfig)
+		verifiedEntries = FilterVerifiedDataEntries(resumeTOC, computedChecksums)
+	}
+
+	verifiedOids := make(map[uint32]bool, len(verifiedEntries))
+	verifiedOidList := make([]string, 0, len(verifiedEntries))
+	for _, entry := range verifiedEntries {
+		verifiedOids[entry.Oid] = true
+		verifiedOidList = append(verifiedOidList, fmt.Sprintf("%d", entry.Oid))
+	}
+	for _, tableChecksums := range resumeTOC.DataChecksums {
+		for oid := range tableChecksums {
+			if !verifiedOids[oid] {
+				delete(tableChecksums, oid)
+			}
+		}
+	}
+	for _, tableSizes := range resumeTOC.DataSizes {
+		for oid := range tableSizes {
+			if !verifiedOids[oid] {
+				delete(tableSizes, oid)
+			}
+		}
+	}
+	resumeTOC.DataEntries = verifiedEntries
+	if pluginConfig == nil {
+		removeUnverifiedDataFiles(resumeFPInfo, verifiedOidList)
+	}
+
+	tocFilename := resumeFPInfo.GetTOCFilePath()
+	err := os.Remove(tocFilename)
+	gplog.FatalOnError(err)
+	resumeTOC.WriteToFileAndMakeReadOnly(tocFilename)
+	if pluginConfig != nil {
+		pluginConfig.MustBackupFile(tocFilename)
+	}
+	gplog.Info("Data for %d tables will be restored from backup with timestamp %s", len(verifiedEntries), resumeFPInfo.Timestamp)
+	return verifiedEntries
+}
+
+/*
+ * Returns the data entries of the TOC whose data files have the same checksum
+ * on every segment as the one recorded when they were backed up.
+ */
+func FilterVerifiedDataEntries(backupTOC *toc.TOC, checksums map[int]map[uint32]string) []toc.MasterDataEntry {
+	verifiedEntries := make([]toc.MasterDataEntry, 0)
+	for _, entry := range backupTOC.DataEntries {
+		verified := len(checksums) > 0
+		for contentID, tableChecksums := range checksums {
+			expectedChecksum, ok := backupTOC.DataChecksums[contentID][entry.Oid]
+			if !ok || tableChecksums[entry.Oid] != expectedChecksum {
+				verified = false
+				break
+			}
+		}
+		if verified {
+			verifiedEntries = append(verifiedEntries, entry)
+		} else {
+			gplog.Warn("Data for table %s could not be verified and will be backed up again", utils.MakeFQN(entry.Schema, entry.Name))
+		}
+	}
+	return verifiedEntries
+}
+
+func removeUnverifiedDataFiles(resumeFPInfo filepath.FilePathInfo, verifiedOidList []string) {
+	utils.WriteOidListToSegments(verifiedOidList, globalCluster, resumeFPInfo)
+	defer utils.CleanUpHelperFilesOnAllHosts(globalCluster, resumeFPInfo)
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Removing unverified data files", func(contentID int) string {
+		prefix := fmt.Sprintf("gpbackup_%d_%s_", contentID, resumeFPInfo.Timestamp)
+		return fmt.Sprintf(`cd %s && for f in %s*; do if [[ $f =~ ^%s([0-9]+)(\.|$) ]] && ! grep -qxF "${BASH_REMATCH[1]}" %s; then rm -f "$f"; fi; done`,
+			resumeFPInfo.GetDirForContent(contentID), prefix, prefix, resumeFPInfo.GetSegmentHelperFilePath(contentID, "oid"))
+	}, cluster.ON_SEGMENTS)
+	globalCluster.CheckClusterError(remoteOutput, "Unable to remove unverified data files", func(contentID int) string {
+		return fmt.Sprintf("Unable to remove unverified data files on segment %d", contentID)
+	})
+}
+
+/*
+ * The restore plan of the backup being resumed has a single entry for its own
+ * timestamp, or more if it was itself resumed from an earlier backup.  Its own
+ * entry is replaced by the tables whose data was verified.
+ */
+func GetResumedRestorePlan(resumeRestorePlan []history.RestorePlanEntry, resumeTimestamp string, verifiedEntries []toc.MasterDataEntry) []history.RestorePlanEntry {
+	restorePlan := make([]history.RestorePlanEntry, 0, len(resumeRestorePlan)+1)
+	for _, entry := range resumeRestorePlan {
+		if entry.Timestamp != resumeTimestamp {
+			restorePlan = append(restorePlan, entry)
+		}
+	}
+	tableFQNs := make([]string, 0, len(verifiedEntries))
+	for _, entry := range verifiedEntries {
+		tableFQNs = append(tableFQNs, utils.MakeFQN(entry.Schema, entry.Name))
+	}
+	sort.Strings(tableFQNs)
+	return append(restorePlan, history.RestorePlanEntry{Timestamp: resumeTimestamp, TableFQNs: tableFQNs})
+}
+
+/*
+ * Returns the data entries of the tables in the restore plan of the resumed
+ * backup, read from the TOC of the backup that has each table's data.  The
+ * entries of the resumed backup itself are the verified ones.
+ */
+func readRestorePlanDataEntries(restorePlan []history.RestorePlanEntry, resumeFPInfo filepath.FilePathInfo, verifiedEntries []toc.MasterDataEntry) []toc.MasterDataEntry {
+	dataEntries := make([]toc.MasterDataEntry, 0)
+	for _, planEntry := range restorePlan {
+		if planEntry.Timestamp == resumeFPInfo.Timestamp {
+			dataEntries = append(dataEntries, verifiedEntries...)
+			continue
+		}
+		planFPInfo := filepath.NewFilePathInfo(globalCluster, resumeFPInfo.UserSpecifiedBackupDir,
+			planEntry.Timestamp, resumeFPInfo.UserSpecifiedSegPrefix)
+		if pluginConfig != nil {
+			pluginConfig.MustRestoreFile(planFPInfo.GetTOCFilePath())
+		}
+		planTOC := toc.NewTOC(planFPInfo.GetTOCFilePath())
+		planTableFQNs := make(map[string]bool, len(planEntry.TableFQNs))
+		for _, tableFQN := range planEntry.TableFQNs {
+			planTableFQNs[tableFQN] = true
+		}
+		for _, entry := range planTOC.DataEntries {
+			if planTableFQNs[utils.MakeFQN(entry.Schema, entry.Name)] {
+				dataEntries = append(dataEntries, entry)
+			}
+		}
+	}
+	return dataEntries
+}
+
+/*
+ * Returns the tables whose data is not already in a backup in the restore
+ * plan and so must be backed up by the resumed backup.  A table is backed up
+ * again if it was dropped and recreated or its columns changed since its data
+ * was backed up, as the data already backed up no longer matches it.
+ */
+func FilterTablesForResume(backedUpEntries []toc.MasterDataEntry, tables []Table) []Table {
+	backedUpEntryMap := make(map[string]toc.MasterDataEntry, len(backedUpEntries))
+	for _, entry := range backedUpEntries {
+		backedUpEntryMap[utils.MakeFQN(entry.Schema, entry.Name)] = entry
+	}
+	filteredTables := make([]Table, 0)
+	for _, table := range tables {
+		entry, ok := backedUpEntryMap[table.FQN()]
+		if !ok {
+			filteredTables = append(filteredTables, table)
+		} else if entry.Oid != table.Oid || entry.AttributeString != ConstructTableAttributesList(table.ColumnDefs) {
+			gplog.Warn("Table %s has changed since its data was backed up and will be backed up again", table.FQN())
+			filteredTables = append(filteredTables, table)
+		}
+	}
+	return filteredTables
+}
@@ -0,0 +1,110 @@
+package backup_test
+
+import (
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/toc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("backup/resume tests", func() {
+	Describe("FilterVerifiedDataEntries", func() {
+		var backupTOC *toc.TOC
+		BeforeEach(func() {
+			backupTOC = &toc.TOC{}
+			backupTOC.AddMasterDataEntry("public", "t1", 1, "(a)", 10, "")
+			backupTOC.AddMasterDataEntry("public", "t2", 2, "(a)", 20, "")
+			backupTOC.AddMasterDataEntry("public", "t3", 3, "(a)", 30, "")
+			for _, contentID := range []int{0, 1} {
+				backupTOC.AddDataChecksum(contentID, 1, "aaaa")
+				backupTOC.AddDataChecksum(contentID, 2, "bbbb")
+			}
+		})
+		It("returns the entries whose checksums match on every segment", func() {
+			checksums := map[int]map[uint32]string{
+				0: {1: "aaaa", 2: "bbbb", 3: "cccc"},
+				1: {1: "aaaa", 2: "dddd", 3: "cccc"},
+			}
+
+			entries := backup.FilterVerifiedDataEntries(backupTOC, checksums)
+
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Name).To(Equal("t1"))
+			Expect(stdout).To(Say("Data for table public.t2 could not be verified and will be backed up again"))
+			Expect(stdout).To(Say("Data for table public.t3 could not be verified and will be backed up again"))
+		})
+		It("returns no entries if no checksums could be computed", func() {
+			entries := backup.FilterVerifiedDataEntries(backupTOC, map[int]map[uint32]string{})
+
+			Expect(entries).To(BeEmpty())
+		})
+	})
+	Describe("GetResumedRestorePlan", func() {
+		verifiedEntries := []toc.MasterDataEntry{
+			{Schema: "public", Name: "t2", Oid: 2},
+			{Schema: "public", Name: "t1", Oid: 1},
+		}
+		It("replaces the entry of the resumed backup with the verified tables", func() {
+			resumeRestorePlan := []history.RestorePlanEntry{
+				{Timestamp: "20190101010101", TableFQNs: []string{"public.t1", "public.t2", "public.t3"}},
+			}
+
+			restorePlan := backup.GetResumedRestorePlan(resumeRestorePlan, "20190101010101", verifiedEntries)
+
+			Expect(restorePlan).To(Equal([]history.RestorePlanEntry{
+				{Timestamp: "20190101010101", TableFQNs: []string{"public.t1", "public.t2"}},
+			}))
+		})
+		It("keeps the entries of earlier backups if the resumed backup was itself resumed", func() {
+			resumeRestorePlan := []history.RestorePlanEntry{
+				{Timestamp: "20190101010101", TableFQNs: []string{"public.t4"}},
+				{Timestamp: "20190102010101", TableFQNs: []string{"public.t1", "public.t2", "public.t3"}},
+			}
+
+			restorePlan := backup.GetResumedRestorePlan(resumeRestorePlan, "20190102010101", verifiedEntries)
+
+			Expect(restorePlan).To(Equal([]history.RestorePlanEntry{
+				{Timestamp: "20190101010101", TableFQNs: []string{"public.t4"}},
+				{Timestamp: "20190102010101", TableFQNs: []string{"public.t1", "public.t2"}},
+			}))
+		})
+	})
+	Describe("FilterTablesForResume", func() {
+		backedUpEntries := []toc.MasterDataEntry{
+			{Schema: "public", Name: "t1", Oid: 1, AttributeString: "(a,b)"},
+			{Schema: "public", Name: "t3", Oid: 3, AttributeString: "(a,b)"},
+			{Schema: "public", Name: "t4", Oid: 4, AttributeString: "(a,b)"},
+		}
+		columnDefs := []backup.ColumnDefinition{{Name: "a"}, {Name: "b"}}
+		It("returns only the tables whose data has not been backed up", func() {
+			tables := []backup.Table{
+				{Relation: backup.Relation{Oid: 1, Schema: "public", Name: "t1"}, TableDefinition: backup.TableDefinition{ColumnDefs: columnDefs}},
+				{Relation: backup.Relation{Oid: 2, Schema: "public", Name: "t2"}, TableDefinition: backup.TableDefinition{ColumnDefs: columnDefs}},
+				{Relation: backup.Relation{Oid: 4, Schema: "public", Name: "t4"}, TableDefinition: backup.TableDefinition{ColumnDefs: columnDefs}},
+			}
+
+			filteredTables := backup.FilterTablesForResume(backedUpEntries, tables)
+
+			Expect(filteredTables).To(HaveLen(1))
+			Expect(filteredTables[0].Name).To(Equal("t2"))
+		})
+		It("returns the tables that were recreated or whose columns changed since their data was backed up", func() {
+			tables := []backup.Table{
+				{Relation: backup.Relation{Oid: 1, Schema: "public", Name: "t1"}, TableDefinition: backup.TableDefinition{ColumnDefs: columnDefs}},
+				{Relation: backup.Relation{Oid: 5, Schema: "public", Name: "t3"}, TableDefinition: backup.TableDefinition{ColumnDefs: columnDefs}},
+				{Relation: backup.Relation{Oid: 4, Schema: "public", Name: "t4"}, TableDefinition: backup.TableDefinition{ColumnDefs: []backup.ColumnDefinition{{Name: "a"}}}},
+			}
+
+			filteredTables := backup.FilterTablesForResume(backedUpEntries, tables)
+
+			Expect(filteredTables).To(HaveLen(2))
+			Expect(filteredTables[0].Name).To(Equal("t3"))
+			Expect(filteredTables[1].Name).To(Equal("t4"))
+			Expect(stdout).To(Say("Table public.t3 has changed since its data was backed up and will be backed up again"))
+			Expect(stdout).To(Say("Table public.t4 has changed since its data was backed up and will be backed up again"))
+		})
+	})
+})
@@ -0,0 +1,128 @@
+package backup
+
+/*
+ * This file contains functions related to backing up only the rows of tables
+ * that match a WHERE clause, using --row-filter-file.
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+ * A row filter file is a YAML map from tables, named as in filter files, to
+ * the conditions of the WHERE clause with which their rows are selected:
+ *
+ *   sales.fact_orders: order_date >= current_date - 90
+ *   public.events: "tenant_id IN (1, 2)"
+ *
+ * A partition table's filter applies to its leaf partitions when they are
+ * backed up separately with --leaf-partition-data.
+ */
+func ReadRowFilterFile(filename string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	rowFilters := make(map[string]string)
+	err = yaml.UnmarshalStrict(contents, &rowFilters)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse row filter file %s", filename)
+	}
+	for fqn, rowFilter := range rowFilters {
+		if _, err := options.SeparateSchemaAndTable([]string{fqn}); err != nil {
+			return nil, errors.Wrapf(err, "Invalid table name in row filter file %s", filename)
+		}
+		if strings.TrimSpace(rowFilter) == "" {
+			return nil, errors.Errorf("The row filter of table %s in row filter file %s is empty", fqn, filename)
+		}
+	}
+	return rowFilters, nil
+}
+
+func initializeRowFilters() {
+	filename := MustGetFlagString(options.ROW_FILTER_FILE)
+	if filename == "" {
+		return
+	}
+	var err error
+	rowFilters, err = ReadRowFilterFile(filename)
+	gplog.FatalOnError(err)
+	gplog.Verbose("Backing up only the rows matching the filters in %s for %d table(s)", filename, len(rowFilters))
+}
+
+/*
+ * Returns the unquoted name of a table, and of the partition table a leaf
+ * partition belongs to, whose row filter and masking rules also apply to it.
+ */
+func getUnquotedTableNames(table Table) []string {
+	schema := utils.UnquoteIdent(table.Schema)
+	names := []string{fmt.Sprintf("%s.%s", schema, utils.UnquoteIdent(table.Name))}
+	if table.PartitionLevelInfo.RootName != "" {
+		names = append(names, fmt.Sprintf("%s.%s", schema, utils.UnquoteIdent(table.PartitionLevelInfo.RootName)))
+	}
+	return names
+}
+
+func getRowFilter(table Table) string {
+	if len(rowFilters) == 0 {
+		return ""
+	}
+	for _, fqn := range getUnquotedTableNames(table) {
+		if rowFilter, ok := rowFilters[fqn]; ok {
+			return rowFilter
+		}
+	}
+	return ""
+}
+
+func validateRowFilters(dataTables []Table) {
+	if len(rowFilters) == 0 {
+		return
+	}
+	filteredTables := make(map[string]bool)
+	for _, table := range dataTables {
+		for _, fqn := range getUnquotedTableNames(table) {
+			filteredTables[fqn] = true
+		}
+	}
+	missingTables := make([]string, 0)
+	for fqn := range rowFilters {
+		if !filteredTables[fqn] {
+			missingTables = append(missingTables, fqn)
+		}
+	}
+	if len(missingTables) > 0 {
+		sort.Strings(missingTables)
+		gplog.Fatal(errors.Errorf("The data of the following tables in the row filter file is not backed up: %s",
+			strings.Join(missingTables, ", ")), "")
+	}
+}
+
+/*
+ * The rows of a partition table with a row filter or masked columns are read
+ * with a query on the partition table, which cannot skip its external
+ * partitions as COPY does with IGNORE EXTERNAL PARTITIONS, so such tables
+ * cannot be filtered or masked unless their leaf partitions are backed up.
+ */
+func ValidateFilteredPartitionTables(dataTables []Table, extPartitions []PartitionInfo) {
+	hasExtPartitions := make(map[uint32]bool)
+	for _, partInfo := range extPartitions {
+		hasExtPartitions[partInfo.ParentRelationOid] = true
+	}
+	for _, table := range dataTables {
+		if hasExtPartitions[table.Oid] && (getRowFilter(table) != "" || len(getMaskingRules(table)) > 0) {
+			gplog.Fatal(errors.Errorf("Cannot apply a row filter or masking rules to table %s, as it has external partitions.  "+
+				"Use --leaf-partition-data to back up its other partitions with them instead.", table.FQN()), "")
+		}
+	}
+}
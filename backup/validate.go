@@ -2,12 +2,14 @@ package backup
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
@@ -23,6 +25,54 @@ func validateFilterLists(opts *options.Options) {
 	ValidateTablesExist(connectionPool, opts.GetExcludedTables(), true)
 	ValidateSchemasExist(connectionPool, opts.GetIncludedSchemas(), false)
 	ValidateSchemasExist(connectionPool, opts.GetExcludedSchemas(), true)
+	ValidateExcludedObjectTypes(opts.ExcludedObjectTypes)
+}
+
+/*
+ * Patterns match the names of user schemas and of tables that are not child
+ * partitions; the leaf partitions of matching tables are added to the include
+ * list along with those of any other included tables.
+ */
+func expandFilterPatterns(opts *options.Options) {
+	if !opts.HasFilterPatterns() {
+		return
+	}
+	gplog.Verbose("Expanding filter patterns against the database catalog")
+	schemaQuery := fmt.Sprintf(`
+	SELECT nspname AS string
+	FROM pg_namespace n
+	WHERE %s`, systemSchemaFilterClause("n"))
+	schemaNames := dbconn.MustSelectStringSlice(connectionPool, schemaQuery)
+	schemas := make(map[string]string, len(schemaNames))
+	for _, schema := range schemaNames {
+		schemas[schema] = schema
+	}
+
+	relationQuery := fmt.Sprintf(`
+	SELECT n.nspname || '.' || c.relname AS string
+	FROM pg_class c
+		JOIN pg_namespace n ON c.relnamespace = n.oid
+	WHERE %s
+		AND c.relkind IN ('r', 'f')
+		AND NOT EXISTS (SELECT 1 FROM pg_partition_rule r WHERE r.parchildrelid = c.oid)
+		AND %s`, systemSchemaFilterClause("n"), ExtensionFilterClause("c"))
+	relationNames := dbconn.MustSelectStringSlice(connectionPool, relationQuery)
+	relations := make(map[string]string, len(relationNames))
+	for _, relation := range relationNames {
+		relations[relation] = relation
+	}
+
+	err := opts.ExpandFilterPatterns(cmdFlags, schemas, relations)
+	gplog.FatalOnError(err)
+}
+
+func ValidateExcludedObjectTypes(objectTypes []string) {
+	for _, objectType := range objectTypes {
+		if !utils.Exists(excludableObjectTypes, objectType) {
+			gplog.Fatal(errors.Errorf("Object type %s cannot be excluded from a backup.  Valid object types are %s.",
+				objectType, strings.Join(excludableObjectTypes, ", ")), "")
+		}
+	}
 }
 
 func ValidateSchemasExist(connectionPool *dbconn.DBConn, schemaList []string, excludeSet bool) {
@@ -97,10 +147,25 @@ func validateFlagCombinations(flags *pflag.FlagSet) {
 	options.CheckExclusiveFlags(flags, options.INCLUDE_SCHEMA, options.INCLUDE_SCHEMA_FILE, options.INCLUDE_RELATION, options.INCLUDE_RELATION_FILE)
 	options.CheckExclusiveFlags(flags, options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE, options.INCLUDE_SCHEMA, options.INCLUDE_SCHEMA_FILE)
 	options.CheckExclusiveFlags(flags, options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE, options.EXCLUDE_RELATION, options.INCLUDE_RELATION, options.EXCLUDE_RELATION_FILE, options.INCLUDE_RELATION_FILE)
-	options.CheckExclusiveFlags(flags, options.JOBS, options.METADATA_ONLY, options.SINGLE_DATA_FILE)
+	options.CheckExclusiveFlags(flags, options.JOBS, options.METADATA_ONLY)
+	options.CheckExclusiveFlags(flags, options.METADATA_ONLY, options.SINGLE_DATA_FILE)
 	options.CheckExclusiveFlags(flags, options.METADATA_ONLY, options.LEAF_PARTITION_DATA)
 	options.CheckExclusiveFlags(flags, options.NO_COMPRESSION, options.COMPRESSION_LEVEL)
+	options.CheckExclusiveFlags(flags, options.NO_COMPRESSION, options.COMPRESSION_TYPE)
 	options.CheckExclusiveFlags(flags, options.PLUGIN_CONFIG, options.BACKUP_DIR)
+	options.CheckExclusiveFlags(flags, options.ENCRYPTION_KEY_FILE, options.ENCRYPTION_PASSPHRASE_FILE)
+	options.CheckExclusiveFlags(flags, options.INCREMENTAL_HEAP, options.METADATA_ONLY)
+	options.CheckExclusiveFlags(flags, options.RESUME, options.INCREMENTAL)
+	options.CheckExclusiveFlags(flags, options.RESUME, options.METADATA_ONLY)
+	// The data file of each segment in a single-data-file backup cannot be appended to
+	options.CheckExclusiveFlags(flags, options.RESUME, options.SINGLE_DATA_FILE)
+	// Tables carried forward by an incremental backup would keep the rows of another backup's filter
+	options.CheckExclusiveFlags(flags, options.ROW_FILTER_FILE, options.METADATA_ONLY, options.INCREMENTAL)
+	// Statistics would contain the most common values of the masked columns
+	options.CheckExclusiveFlags(flags, options.MASKING_RULE_FILE, options.METADATA_ONLY, options.INCREMENTAL, options.WITH_STATS)
+	if MustGetFlagString(options.MASKING_KEY_FILE) != "" && MustGetFlagString(options.MASKING_RULE_FILE) == "" {
+		gplog.Fatal(errors.Errorf("--masking-key-file must be specified with --masking-rule-file"), "")
+	}
 	if MustGetFlagString(options.FROM_TIMESTAMP) != "" && !MustGetFlagBool(options.INCREMENTAL) {
 		gplog.Fatal(errors.Errorf("--from-timestamp must be specified with --incremental"), "")
 	}
@@ -114,12 +179,32 @@ func validateFlagValues() {
 	gplog.FatalOnError(err)
 	err = utils.ValidateFullPath(MustGetFlagString(options.PLUGIN_CONFIG))
 	gplog.FatalOnError(err)
-	err = utils.ValidateCompressionLevel(MustGetFlagInt(options.COMPRESSION_LEVEL))
+	err = utils.ValidateCompressionTypeAndLevel(MustGetFlagString(options.COMPRESSION_TYPE), MustGetFlagInt(options.COMPRESSION_LEVEL))
+	gplog.FatalOnError(err)
+	err = report.ValidateReportFormat(MustGetFlagString(options.REPORT_FORMAT))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.METRICS_TEXTFILE))
 	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.NOTIFICATION_CONFIG))
+	gplog.FatalOnError(err)
+	if MustGetFlagString(options.NOTIFICATION_CONFIG) != "" {
+		_, err = report.ReadNotificationFile(MustGetFlagString(options.NOTIFICATION_CONFIG))
+		gplog.FatalOnError(err)
+	}
+	if port := MustGetFlagInt(options.METRICS_PORT); port < 0 || port > 65535 {
+		gplog.Fatal(errors.Errorf("Metrics port %d is invalid.  Valid ports are between 1 and 65535.", port), "")
+	}
+	if MustGetFlagInt(options.INCREMENTAL_HEAP_MAX_AGE) < 1 {
+		gplog.Fatal(errors.Errorf("--%s must be at least 1", options.INCREMENTAL_HEAP_MAX_AGE), "")
+	}
 	if MustGetFlagString(options.FROM_TIMESTAMP) != "" && !filepath.IsValidTimestamp(MustGetFlagString(options.FROM_TIMESTAMP)) {
 		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.",
 			MustGetFlagString(options.FROM_TIMESTAMP)), "")
 	}
+	if MustGetFlagString(options.RESUME) != "" && !filepath.IsValidTimestamp(MustGetFlagString(options.RESUME)) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.",
+			MustGetFlagString(options.RESUME)), "")
+	}
 }
 
 func validateFromTimestamp(fromTimestamp string) {
@@ -131,7 +216,7 @@ func validateFromTimestamp(fromTimestamp string) {
 	}
 	fromBackupConfig := history.ReadConfigFile(fromTimestampFPInfo.GetConfigFilePath())
 
-	if !matchesIncrementalFlags(fromBackupConfig, &backupReport.BackupConfig) {
+	if !history.MatchesIncrementalFlags(fromBackupConfig, &backupReport.BackupConfig) {
 		gplog.Fatal(errors.Errorf("The flags of the backup with timestamp = %s does not match "+
 			"that of the current one. Please refer to the report to view the flags supplied for the"+
 			"previous backup.", fromTimestampFPInfo.Timestamp), "")
@@ -57,6 +57,15 @@ var _ = Describe("backup/validate tests", func() {
 			testhelper.ExpectRegexp(logfile, "[WARNING]:-Excluded schema schema2 does not exist")
 		})
 	})
+	Describe("ValidateExcludedObjectTypes", func() {
+		It("passes if the object types can be excluded", func() {
+			backup.ValidateExcludedObjectTypes([]string{"CAST", "TEXT SEARCH PARSER", "RESOURCE GROUP"})
+		})
+		It("panics if an object type cannot be excluded", func() {
+			defer testhelper.ShouldPanicWithMessage("Object type TABLE cannot be excluded from a backup")
+			backup.ValidateExcludedObjectTypes([]string{"CAST", "TABLE"})
+		})
+	})
 	Describe("ValidateTablesExist", func() {
 		var tableRows, partitionTables, schemaAndTable, schemaAndTable2 *sqlmock.Rows
 		BeforeEach(func() {
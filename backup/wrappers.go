@@ -42,6 +42,13 @@ func initializeConnectionPool() {
 	connectionPool.MustConnect(MustGetFlagInt(options.JOBS))
 	utils.ValidateGPDBVersionCompatibility(connectionPool)
 	InitializeMetadataParams(connectionPool)
+	if MustGetFlagBool(options.INCREMENTAL_HEAP) && !MustGetFlagBool(options.METADATA_ONLY) {
+		/*
+		 * The tuple counters are read before the snapshot of the backup is
+		 * taken, so that they never include changes the backup does not.
+		 */
+		heapTableEntries, statsResetTimes = GetHeapIncrementalMetadata(connectionPool)
+	}
 	for connNum := 0; connNum < connectionPool.NumConns; connNum++ {
 		connectionPool.MustExec("SET application_name TO 'gpbackup'", connNum)
 		// BEGIN TRANSACTION
@@ -76,13 +83,20 @@ func SetSessionGUCs(connNum int) {
 }
 
 func NewBackupConfig(dbName string, dbVersion string, backupVersion string, plugin string, timestamp string, opts options.Options) *history.BackupConfig {
+	compressionType := ""
+	if !MustGetFlagBool(options.NO_COMPRESSION) {
+		compressionType = MustGetFlagString(options.COMPRESSION_TYPE)
+	}
 	backupConfig := history.BackupConfig{
 		BackupDir:             MustGetFlagString(options.BACKUP_DIR),
 		BackupVersion:         backupVersion,
 		Compressed:            !MustGetFlagBool(options.NO_COMPRESSION),
+		CompressionType:       compressionType,
 		DatabaseName:          dbName,
 		DatabaseVersion:       dbVersion,
 		DataOnly:              MustGetFlagBool(options.DATA_ONLY),
+		Encrypted:             MustGetFlagString(options.ENCRYPTION_KEY_FILE) != "" || MustGetFlagString(options.ENCRYPTION_PASSPHRASE_FILE) != "",
+		ExcludeObjectTypes:    opts.ExcludedObjectTypes,
 		ExcludeRelations:      MustGetFlagStringArray(options.EXCLUDE_RELATION),
 		ExcludeSchemaFiltered: len(MustGetFlagStringArray(options.EXCLUDE_SCHEMA)) > 0,
 		ExcludeSchemas:        MustGetFlagStringArray(options.EXCLUDE_SCHEMA),
@@ -92,6 +106,7 @@ func NewBackupConfig(dbName string, dbVersion string, backupVersion string, plug
 		IncludeSchemas:        MustGetFlagStringArray(options.INCLUDE_SCHEMA),
 		IncludeTableFiltered:  len(opts.GetOriginalIncludedTables()) > 0,
 		Incremental:           MustGetFlagBool(options.INCREMENTAL),
+		IncrementalHeap:       MustGetFlagBool(options.INCREMENTAL_HEAP),
 		LeafPartitionData:     MustGetFlagBool(options.LEAF_PARTITION_DATA),
 		MetadataOnly:          MustGetFlagBool(options.METADATA_ONLY),
 		Plugin:                plugin,
@@ -105,6 +120,50 @@ func NewBackupConfig(dbName string, dbVersion string, backupVersion string, plug
 	return &backupConfig
 }
 
+/*
+ * Incremental backups reuse the salt of the backup they are based on, so that
+ * every backup in a restore plan can be decrypted with the same key.
+ */
+func initializeEncryption(targetBackupConfig *history.BackupConfig) {
+	if encryptionKeySource == nil {
+		return
+	}
+	salt := ""
+	var err error
+	if targetBackupConfig != nil {
+		salt = targetBackupConfig.EncryptionSalt
+	} else if encryptionKeySource.UsesPassphrase() {
+		salt, err = utils.NewEncryptionSalt()
+		gplog.FatalOnError(err)
+	}
+	key, err := encryptionKeySource.DeriveKey(salt)
+	gplog.FatalOnError(err)
+	if targetBackupConfig != nil {
+		err = utils.ValidateEncryptionKeyFingerprint(key, targetBackupConfig.EncryptionKeyFingerprint)
+		if err != nil {
+			gplog.Fatal(errors.Errorf("The encryption key does not match the key used to encrypt the backup with timestamp %s", targetBackupConfig.Timestamp), "")
+		}
+	}
+	backupReport.EncryptionSalt = salt
+	backupReport.EncryptionKeyFingerprint = utils.GetEncryptionKeyFingerprint(key)
+
+	keyFilePath := utils.MakeEncryptionKeyFilePath(globalFPInfo.Timestamp)
+	utils.InitializeEncryption(key, keyFilePath)
+	if !MustGetFlagBool(options.METADATA_ONLY) {
+		utils.CopyEncryptionKeyToAllHosts(globalCluster, keyFilePath)
+	}
+}
+
+func getFileChecksum(filename string) string {
+	checksum, err := utils.GetFileChecksum(filename)
+	gplog.FatalOnError(err, fmt.Sprintf("Unable to compute checksum of %s", filename))
+	return checksum
+}
+
+func addMetadataChecksumToTOC(filename string) {
+	globalTOC.AddMetadataChecksum(filename, getFileChecksum(filename))
+}
+
 func initializeBackupReport(opts options.Options) {
 	escapedDBName := dbconn.MustSelectString(connectionPool, fmt.Sprintf("select quote_ident(datname) AS string FROM pg_database where datname='%s'", utils.EscapeSingleQuotes(connectionPool.DBName)))
 	plugin := ""
@@ -113,6 +172,8 @@ func initializeBackupReport(opts options.Options) {
 	}
 	config := NewBackupConfig(escapedDBName, connectionPool.Version.VersionString, version,
 		plugin, globalFPInfo.Timestamp, opts)
+	config.RowFilters = rowFilters
+	config.MaskingRules = maskingRules
 
 	isFilteredBackup := config.IncludeTableFiltered || config.IncludeSchemaFiltered ||
 		config.ExcludeTableFiltered || config.ExcludeSchemaFiltered
@@ -155,6 +216,23 @@ func createBackupDirectoriesOnAllHosts() {
  * Metadata retrieval wrapper functions
  */
 
+/*
+ * The object types that can be skipped with --exclude-object-type.  Each is
+ * retrieved or backed up by its own function below, which returns early when
+ * its type is excluded.  Excluding ROLE also skips role grants and role GUCs.
+ */
+var excludableObjectTypes = []string{"AGGREGATE", "CAST", "COLLATION", "CONVERSION", "DEFAULT PRIVILEGES", "EVENT TRIGGER",
+	"EXTENSION", "INDEX", "LANGUAGE", "OPERATOR", "OPERATOR CLASS", "OPERATOR FAMILY", "RESOURCE GROUP", "RESOURCE QUEUE",
+	"ROLE", "RULE", "TABLESPACE", "TEXT SEARCH CONFIGURATION", "TEXT SEARCH DICTIONARY", "TEXT SEARCH PARSER",
+	"TEXT SEARCH TEMPLATE", "TRIGGER"}
+
+func isObjectTypeExcluded(objectType string) bool {
+	if opts == nil {
+		return false
+	}
+	return !utils.NewExcludeSet(opts.ExcludedObjectTypes).MatchesFilter(objectType)
+}
+
 func RetrieveAndProcessTables() ([]Table, []Table) {
 	quotedIncludeRelations, err := options.QuoteTableNames(connectionPool, MustGetFlagStringArray(options.INCLUDE_RELATION))
 	gplog.FatalOnError(err)
@@ -263,6 +341,9 @@ func retrieveTSObjects(sortables *[]Sortable, metadataMap MetadataMap) {
 }
 
 func retrieveTSParsers(sortables *[]Sortable, metadataMap MetadataMap) {
+	if isObjectTypeExcluded("TEXT SEARCH PARSER") {
+		return
+	}
 	gplog.Verbose("Retrieving Text Search Parsers")
 	parsers := GetTextSearchParsers(connectionPool)
 	objectCounts["Text Search Parsers"] = len(parsers)
@@ -273,6 +354,9 @@ func retrieveTSParsers(sortables *[]Sortable, metadataMap MetadataMap) {
 }
 
 func retrieveTSTemplates(sortables *[]Sortable, metadataMap MetadataMap) {
+	if isObjectTypeExcluded("TEXT SEARCH TEMPLATE") {
+		return
+	}
 	gplog.Verbose("Retrieving TEXT SEARCH TEMPLATE information")
 	templates := GetTextSearchTemplates(connectionPool)
 	objectCounts["Text Search Templates"] = len(templates)
@@ -283,6 +367,9 @@ func retrieveTSTemplates(sortables *[]Sortable, metadataMap MetadataMap) {
 }
 
 func retrieveTSDictionaries(sortables *[]Sortable, metadataMap MetadataMap) {
+	if isObjectTypeExcluded("TEXT SEARCH DICTIONARY") {
+		return
+	}
 	gplog.Verbose("Retrieving TEXT SEARCH DICTIONARY information")
 	dictionaries := GetTextSearchDictionaries(connectionPool)
 	objectCounts["Text Search Dictionaries"] = len(dictionaries)
@@ -293,6 +380,9 @@ func retrieveTSDictionaries(sortables *[]Sortable, metadataMap MetadataMap) {
 }
 
 func retrieveTSConfigurations(sortables *[]Sortable, metadataMap MetadataMap) {
+	if isObjectTypeExcluded("TEXT SEARCH CONFIGURATION") {
+		return
+	}
 	gplog.Verbose("Retrieving TEXT SEARCH CONFIGURATION information")
 	configurations := GetTextSearchConfigurations(connectionPool)
 	objectCounts["Text Search Configurations"] = len(configurations)
@@ -308,6 +398,9 @@ func retrieveOperatorObjects(sortables *[]Sortable, metadataMap MetadataMap) {
 }
 
 func retrieveOperators(sortables *[]Sortable, metadataMap MetadataMap) {
+	if isObjectTypeExcluded("OPERATOR") {
+		return
+	}
 	gplog.Verbose("Retrieving OPERATOR information")
 	operators := GetOperators(connectionPool)
 	objectCounts["Operators"] = len(operators)
@@ -318,6 +411,9 @@ func retrieveOperators(sortables *[]Sortable, metadataMap MetadataMap) {
 }
 
 func retrieveOperatorClasses(sortables *[]Sortable, metadataMap MetadataMap) {
+	if isObjectTypeExcluded("OPERATOR CLASS") {
+		return
+	}
 	gplog.Verbose("Retrieving OPERATOR CLASS information")
 	operatorClasses := GetOperatorClasses(connectionPool)
 	objectCounts["Operator Classes"] = len(operatorClasses)
@@ -328,6 +424,9 @@ func retrieveOperatorClasses(sortables *[]Sortable, metadataMap MetadataMap) {
 }
 
 func retrieveAggregates(sortables *[]Sortable, metadataMap MetadataMap) {
+	if isObjectTypeExcluded("AGGREGATE") {
+		return
+	}
 	gplog.Verbose("Retrieving AGGREGATE information")
 	aggregates := GetAggregates(connectionPool)
 	objectCounts["Aggregates"] = len(aggregates)
@@ -338,6 +437,9 @@ func retrieveAggregates(sortables *[]Sortable, metadataMap MetadataMap) {
 }
 
 func retrieveCasts(sortables *[]Sortable, metadataMap MetadataMap) {
+	if isObjectTypeExcluded("CAST") {
+		return
+	}
 	gplog.Verbose("Retrieving CAST information")
 	casts := GetCasts(connectionPool)
 	objectCounts["Casts"] = len(casts)
@@ -396,6 +498,9 @@ func backupSessionGUC(metadataFile *utils.FileWithByteCount) {
  */
 
 func backupTablespaces(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("TABLESPACE") {
+		return
+	}
 	gplog.Verbose("Writing CREATE TABLESPACE statements to metadata file")
 	tablespaces := GetTablespaces(connectionPool)
 	objectCounts["Tablespaces"] = len(tablespaces)
@@ -419,6 +524,9 @@ func backupDatabaseGUCs(metadataFile *utils.FileWithByteCount) {
 }
 
 func backupResourceQueues(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("RESOURCE QUEUE") {
+		return
+	}
 	gplog.Verbose("Writing CREATE RESOURCE QUEUE statements to metadata file")
 	resQueues := GetResourceQueues(connectionPool)
 	objectCounts["Resource Queues"] = len(resQueues)
@@ -427,6 +535,9 @@ func backupResourceQueues(metadataFile *utils.FileWithByteCount) {
 }
 
 func backupResourceGroups(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("RESOURCE GROUP") {
+		return
+	}
 	if !connectionPool.Version.AtLeast("5") {
 		return
 	}
@@ -439,6 +550,9 @@ func backupResourceGroups(metadataFile *utils.FileWithByteCount) {
 }
 
 func backupRoles(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("ROLE") {
+		return
+	}
 	gplog.Verbose("Writing CREATE ROLE statements to metadata file")
 	roles := GetRoles(connectionPool)
 	objectCounts["Roles"] = len(roles)
@@ -447,12 +561,18 @@ func backupRoles(metadataFile *utils.FileWithByteCount) {
 }
 
 func backupRoleGUCs(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("ROLE") {
+		return
+	}
 	gplog.Verbose("Writing ROLE Configuration Parameter to meadata file")
 	roleGUCs := GetRoleGUCs(connectionPool)
 	PrintRoleGUCStatements(metadataFile, globalTOC, roleGUCs)
 }
 
 func backupRoleGrants(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("ROLE") {
+		return
+	}
 	gplog.Verbose("Writing GRANT ROLE statements to metadata file")
 	roleMembers := GetRoleMembers(connectionPool)
 	PrintRoleMembershipStatements(metadataFile, globalTOC, roleMembers)
@@ -472,6 +592,9 @@ func backupSchemas(metadataFile *utils.FileWithByteCount, partitionAlteredSchema
 
 func backupProceduralLanguages(metadataFile *utils.FileWithByteCount,
 	functions []Function, funcInfoMap map[uint32]FunctionInfo, functionMetadata MetadataMap) {
+	if isObjectTypeExcluded("LANGUAGE") {
+		return
+	}
 	gplog.Verbose("Writing CREATE PROCEDURAL LANGUAGE statements to metadata file")
 	procLangs := GetProceduralLanguages(connectionPool)
 	objectCounts["Procedural Languages"] = len(procLangs)
@@ -552,6 +675,9 @@ func backupDependentObjects(metadataFile *utils.FileWithByteCount, tables []Tabl
 }
 
 func backupConversions(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("CONVERSION") {
+		return
+	}
 	gplog.Verbose("Writing CREATE CONVERSION statements to metadata file")
 	conversions := GetConversions(connectionPool)
 	objectCounts["Conversions"] = len(conversions)
@@ -560,6 +686,9 @@ func backupConversions(metadataFile *utils.FileWithByteCount) {
 }
 
 func backupOperatorFamilies(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("OPERATOR FAMILY") {
+		return
+	}
 	if !connectionPool.Version.AtLeast("5") {
 		return
 	}
@@ -571,6 +700,9 @@ func backupOperatorFamilies(metadataFile *utils.FileWithByteCount) {
 }
 
 func backupCollations(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("COLLATION") {
+		return
+	}
 	if !connectionPool.Version.AtLeast("6") {
 		return
 	}
@@ -582,6 +714,9 @@ func backupCollations(metadataFile *utils.FileWithByteCount) {
 }
 
 func backupExtensions(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("EXTENSION") {
+		return
+	}
 	if !(len(MustGetFlagStringArray(options.INCLUDE_SCHEMA)) == 0 &&
 		connectionPool.Version.AtLeast("5")) {
 		return
@@ -604,6 +739,9 @@ func backupConstraints(metadataFile *utils.FileWithByteCount, constraints []Cons
  */
 
 func backupIndexes(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("INDEX") {
+		return
+	}
 	gplog.Verbose("Writing CREATE INDEX statements to metadata file")
 	indexes := GetIndexes(connectionPool)
 	objectCounts["Indexes"] = len(indexes)
@@ -612,6 +750,9 @@ func backupIndexes(metadataFile *utils.FileWithByteCount) {
 }
 
 func backupRules(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("RULE") {
+		return
+	}
 	gplog.Verbose("Writing CREATE RULE statements to metadata file")
 	rules := GetRules(connectionPool)
 	objectCounts["Rules"] = len(rules)
@@ -620,6 +761,9 @@ func backupRules(metadataFile *utils.FileWithByteCount) {
 }
 
 func backupTriggers(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("TRIGGER") {
+		return
+	}
 	gplog.Verbose("Writing CREATE TRIGGER statements to metadata file")
 	triggers := GetTriggers(connectionPool)
 	objectCounts["Triggers"] = len(triggers)
@@ -628,6 +772,9 @@ func backupTriggers(metadataFile *utils.FileWithByteCount) {
 }
 
 func backupEventTriggers(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("EVENT TRIGGER") {
+		return
+	}
 	gplog.Verbose("Writing CREATE EVENT TRIGGER statements to metadata file")
 	eventTriggers := GetEventTriggers(connectionPool)
 	objectCounts["Event Triggers"] = len(eventTriggers)
@@ -636,6 +783,9 @@ func backupEventTriggers(metadataFile *utils.FileWithByteCount) {
 }
 
 func backupDefaultPrivileges(metadataFile *utils.FileWithByteCount) {
+	if isObjectTypeExcluded("DEFAULT PRIVILEGES") {
+		return
+	}
 	gplog.Verbose("Writing ALTER DEFAULT PRIVILEGES statements to metadata file")
 	defaultPrivileges := GetDefaultPrivileges(connectionPool)
 	objectCounts["DEFAULT PRIVILEGES"] = len(defaultPrivileges)
@@ -657,4 +807,6 @@ func backupTableStatistics(statisticsFile *utils.FileWithByteCount, tables []Tab
 func backupIncrementalMetadata() {
 	aoTableEntries := GetAOIncrementalMetadata(connectionPool)
 	globalTOC.IncrementalMetadata.AO = aoTableEntries
+	globalTOC.IncrementalMetadata.Heap = heapTableEntries
+	globalTOC.IncrementalMetadata.StatsResetTimes = statsResetTimes
 }
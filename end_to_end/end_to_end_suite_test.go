@@ -837,6 +837,34 @@ var _ = Describe("backup and restore end to end tests", func() {
 			assertDataRestored(restoreConn, schema3TupleCounts)
 			assertRelationsCreatedInSchema(restoreConn, "schema2", 0)
 		})
+		It("runs gprestore with --redirect-mapping-file renaming schemas and tables", func() {
+			if useOldBackupVersion {
+				Skip("This test is not needed for old backup versions")
+			}
+			testhelper.AssertQueryRuns(backupConn,
+				"CREATE INDEX foo3_idx1 ON schema2.foo3(i)")
+			defer testhelper.AssertQueryRuns(backupConn,
+				"DROP INDEX schema2.foo3_idx1")
+			mapFile := path.Join(backupDir, "redirect-map.txt")
+			mapFileHandle := iohelper.MustOpenFileForWriting(mapFile)
+			utils.MustPrintln(mapFileHandle, "schema2 -> schema4\nschema2.foo3 -> schema4.foo3_renamed")
+			defer os.Remove(mapFile)
+			timestamp := gpbackup(gpbackupPath, backupHelperPath,
+				"--include-schema", "schema2")
+			gprestore(gprestorePath, restoreHelperPath, timestamp,
+				"--redirect-db", "restoredb",
+				"--redirect-mapping-file", mapFile)
+
+			assertDataRestored(restoreConn, map[string]int{
+				"schema4.foo2":         0,
+				"schema4.foo3_renamed": 100,
+				"schema4.ao1":          1000,
+			})
+			assertRelationsCreatedInSchema(restoreConn, "schema2", 0)
+			actualIndexCount := dbconn.MustSelectString(restoreConn,
+				`SELECT count(*) AS string FROM pg_indexes WHERE schemaname='schema4' AND tablename='foo3_renamed' AND indexname='foo3_idx1';`)
+			Expect(actualIndexCount).To(Equal("1"))
+		})
 	})
 	Describe("ACLs for extensions", func() {
 		It("runs gpbackup and gprestores any user defined ACLs on extensions", func() {
@@ -1060,6 +1088,217 @@ var _ = Describe("backup and restore end to end tests", func() {
 		assertDataRestored(restoreConn, publicSchemaTupleCounts)
 		assertDataRestored(restoreConn, schema2TupleCounts)
 	})
+	It("runs gpbackup and gprestore with zstd compression", func() {
+		timestamp := gpbackup(gpbackupPath, backupHelperPath,
+			"--compression-type", "zstd",
+			"--backup-dir", backupDir)
+		gprestore(gprestorePath, restoreHelperPath, timestamp,
+			"--redirect-db", "restoredb",
+			"--backup-dir", backupDir)
+		configFile, err := path.Glob(path.Join(backupDir, "*-1/backups/*",
+			timestamp, "*config.yaml"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configFile).To(HaveLen(1))
+
+		contents, err := ioutil.ReadFile(configFile[0])
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(contents)).To(ContainSubstring("compressiontype: zstd"))
+		assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+		assertDataRestored(restoreConn, publicSchemaTupleCounts)
+		assertDataRestored(restoreConn, schema2TupleCounts)
+	})
+	It("runs gpbackup and gprestore with zstd compression and single-data-file", func() {
+		timestamp := gpbackup(gpbackupPath, backupHelperPath,
+			"--compression-type", "zstd",
+			"--single-data-file",
+			"--backup-dir", backupDir)
+		gprestore(gprestorePath, restoreHelperPath, timestamp,
+			"--redirect-db", "restoredb",
+			"--backup-dir", backupDir)
+		configFile, err := path.Glob(path.Join(backupDir, "*-1/backups/*",
+			timestamp, "*config.yaml"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configFile).To(HaveLen(1))
+
+		contents, err := ioutil.ReadFile(configFile[0])
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(contents)).To(ContainSubstring("compressiontype: zstd"))
+		assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+		assertDataRestored(restoreConn, publicSchemaTupleCounts)
+		assertDataRestored(restoreConn, schema2TupleCounts)
+	})
+	It("runs gpbackup and gprestore with lz4 compression", func() {
+		timestamp := gpbackup(gpbackupPath, backupHelperPath,
+			"--compression-type", "lz4",
+			"--backup-dir", backupDir)
+		gprestore(gprestorePath, restoreHelperPath, timestamp,
+			"--redirect-db", "restoredb",
+			"--backup-dir", backupDir)
+		configFile, err := path.Glob(path.Join(backupDir, "*-1/backups/*",
+			timestamp, "*config.yaml"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configFile).To(HaveLen(1))
+
+		contents, err := ioutil.ReadFile(configFile[0])
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(contents)).To(ContainSubstring("compressiontype: lz4"))
+		assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+		assertDataRestored(restoreConn, publicSchemaTupleCounts)
+		assertDataRestored(restoreConn, schema2TupleCounts)
+	})
+	It("runs gpbackup and gprestore with lz4 compression and single-data-file", func() {
+		timestamp := gpbackup(gpbackupPath, backupHelperPath,
+			"--compression-type", "lz4",
+			"--single-data-file",
+			"--backup-dir", backupDir)
+		gprestore(gprestorePath, restoreHelperPath, timestamp,
+			"--redirect-db", "restoredb",
+			"--backup-dir", backupDir)
+		configFile, err := path.Glob(path.Join(backupDir, "*-1/backups/*",
+			timestamp, "*config.yaml"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configFile).To(HaveLen(1))
+
+		contents, err := ioutil.ReadFile(configFile[0])
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(contents)).To(ContainSubstring("compressiontype: lz4"))
+		assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+		assertDataRestored(restoreConn, publicSchemaTupleCounts)
+		assertDataRestored(restoreConn, schema2TupleCounts)
+	})
+	Describe("native encryption", func() {
+		var keyFile, passphraseFile string
+		BeforeEach(func() {
+			if useOldBackupVersion {
+				Skip("This test is not needed for old backup versions")
+			}
+			keyFile = path.Join(backupDir, "encryption.key")
+			Expect(ioutil.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)+"\n"), 0600)).To(Succeed())
+			passphraseFile = path.Join(backupDir, "encryption.passphrase")
+			Expect(ioutil.WriteFile(passphraseFile, []byte("correct horse battery staple\n"), 0600)).To(Succeed())
+		})
+		AfterEach(func() {
+			_ = os.Remove(keyFile)
+			_ = os.Remove(passphraseFile)
+		})
+		It("runs gpbackup and gprestore with an encryption key file", func() {
+			timestamp := gpbackup(gpbackupPath, backupHelperPath,
+				"--encryption-key-file", keyFile,
+				"--with-stats",
+				"--backup-dir", backupDir)
+			gprestore(gprestorePath, restoreHelperPath, timestamp,
+				"--encryption-key-file", keyFile,
+				"--redirect-db", "restoredb",
+				"--backup-dir", backupDir)
+			configFile, err := path.Glob(path.Join(backupDir, "*-1/backups/*",
+				timestamp, "*config.yaml"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configFile).To(HaveLen(1))
+
+			contents, err := ioutil.ReadFile(configFile[0])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring("encrypted: true"))
+			Expect(string(contents)).To(ContainSubstring("encryptionkeyfingerprint: "))
+
+			metadataFile, err := path.Glob(path.Join(backupDir, "*-1/backups/*",
+				timestamp, "*metadata.sql"))
+			Expect(err).ToNot(HaveOccurred())
+			metadata, err := ioutil.ReadFile(metadataFile[0])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(metadata)).To(HavePrefix("GPBKENC1"))
+
+			dataFiles, err := path.Glob(path.Join(backupDir, "*/backups/*",
+				timestamp, "*.gz.enc"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dataFiles).ToNot(BeEmpty())
+
+			assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+			assertDataRestored(restoreConn, publicSchemaTupleCounts)
+			assertDataRestored(restoreConn, schema2TupleCounts)
+		})
+		It("runs gpbackup and gprestore with an encryption passphrase and single-data-file", func() {
+			timestamp := gpbackup(gpbackupPath, backupHelperPath,
+				"--encryption-passphrase-file", passphraseFile,
+				"--single-data-file",
+				"--backup-dir", backupDir)
+			gprestore(gprestorePath, restoreHelperPath, timestamp,
+				"--encryption-passphrase-file", passphraseFile,
+				"--redirect-db", "restoredb",
+				"--backup-dir", backupDir)
+
+			assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+			assertDataRestored(restoreConn, publicSchemaTupleCounts)
+			assertDataRestored(restoreConn, schema2TupleCounts)
+		})
+		It("fails to restore an encrypted backup with the wrong key", func() {
+			timestamp := gpbackup(gpbackupPath, backupHelperPath,
+				"--encryption-key-file", keyFile,
+				"--backup-dir", backupDir)
+			Expect(ioutil.WriteFile(keyFile, []byte(strings.Repeat("cd", 32)), 0600)).To(Succeed())
+
+			gprestoreCmd := exec.Command(gprestorePath,
+				"--timestamp", timestamp,
+				"--encryption-key-file", keyFile,
+				"--redirect-db", "restoredb",
+				"--backup-dir", backupDir)
+			output, err := gprestoreCmd.CombinedOutput()
+			Expect(err).To(HaveOccurred())
+			Expect(string(output)).To(ContainSubstring("The encryption key does not match the key used to encrypt the backup"))
+		})
+	})
+	Describe("backup verification", func() {
+		BeforeEach(func() {
+			if useOldBackupVersion {
+				Skip("This test is not needed for old backup versions")
+			}
+		})
+		It("verifies the checksums of a backup with one data file per table", func() {
+			timestamp := gpbackup(gpbackupPath, backupHelperPath,
+				"--with-stats",
+				"--backup-dir", backupDir)
+			output := gprestore(gprestorePath, restoreHelperPath, timestamp,
+				"--verify",
+				"--backup-dir", backupDir)
+			Expect(string(output)).To(ContainSubstring("All checksums in backup set with timestamp"))
+
+			checksumFiles, err := path.Glob(path.Join(backupDir, "*/backups/*", timestamp, "*.sha256"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(checksumFiles).To(BeEmpty())
+		})
+		It("verifies the checksums of a single-data-file backup", func() {
+			timestamp := gpbackup(gpbackupPath, backupHelperPath,
+				"--single-data-file",
+				"--backup-dir", backupDir)
+			output := gprestore(gprestorePath, restoreHelperPath, timestamp,
+				"--verify",
+				"--backup-dir", backupDir)
+			Expect(string(output)).To(ContainSubstring("All checksums in backup set with timestamp"))
+		})
+		It("fails to verify a backup with a modified data file", func() {
+			timestamp := gpbackup(gpbackupPath, backupHelperPath,
+				"--backup-dir", backupDir)
+			dataFiles, err := path.Glob(path.Join(backupDir, "*/backups/*", timestamp, "gpbackup_0_*.gz"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dataFiles).ToNot(BeEmpty())
+			Expect(os.Chmod(dataFiles[0], 0644)).To(Succeed())
+			dataFile, err := os.OpenFile(dataFiles[0], os.O_APPEND|os.O_WRONLY, 0644)
+			Expect(err).ToNot(HaveOccurred())
+			_, _ = dataFile.WriteString("corrupt")
+			Expect(dataFile.Close()).To(Succeed())
+
+			gprestoreCmd := exec.Command(gprestorePath,
+				"--timestamp", timestamp,
+				"--verify",
+				"--backup-dir", backupDir)
+			output, err := gprestoreCmd.CombinedOutput()
+			Expect(err).To(HaveOccurred())
+			Expect(string(output)).To(ContainSubstring(fmt.Sprintf("Checksum mismatch for %s", dataFiles[0])))
+		})
+	})
 	It("runs gpbackup and gprestore with with-stats flag", func() {
 		// gpbackup before version 1.18.0 does not dump pg_class statistics correctly
 		skipIfOldBackupVersionBefore("1.18.0")
@@ -1148,6 +1387,121 @@ var _ = Describe("backup and restore end to end tests", func() {
 		assertDataRestored(restoreConn, schema2TupleCounts)
 		assertDataRestored(restoreConn, publicSchemaTupleCounts)
 	})
+	It("runs gpbackup and gprestore with jobs flag and single-data-file", func() {
+		skipIfOldBackupVersionBefore("1.3.0")
+		timestamp := gpbackup(gpbackupPath, backupHelperPath,
+			"--backup-dir", backupDir,
+			"--single-data-file",
+			"--jobs", "4")
+		gprestore(gprestorePath, restoreHelperPath, timestamp,
+			"--redirect-db", "restoredb",
+			"--backup-dir", backupDir,
+			"--jobs", "4")
+
+		assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+		assertDataRestored(restoreConn, schema2TupleCounts)
+		assertDataRestored(restoreConn, publicSchemaTupleCounts)
+	})
+	It("runs gprestore with --list and --use-list to restore only some entries", func() {
+		skipIfOldBackupVersionBefore("1.3.0")
+		timestamp := gpbackup(gpbackupPath, backupHelperPath,
+			"--backup-dir", backupDir)
+		listOutput := gprestore(gprestorePath, restoreHelperPath, timestamp,
+			"--backup-dir", backupDir,
+			"--list")
+		Expect(string(listOutput)).To(ContainSubstring("; data; TABLE DATA; public; foo; "))
+
+		// Comment out the data of public.foo
+		listLines := strings.Split(string(listOutput), "\n")
+		for i, line := range listLines {
+			if strings.HasSuffix(line, "; data; TABLE DATA; public; foo; ") {
+				listLines[i] = ";" + line
+			}
+		}
+		listFile := path.Join(backupDir, "restore_list")
+		Expect(ioutil.WriteFile(listFile, []byte(strings.Join(listLines, "\n")), 0644)).To(Succeed())
+		defer os.Remove(listFile)
+
+		gprestore(gprestorePath, restoreHelperPath, timestamp,
+			"--redirect-db", "restoredb",
+			"--backup-dir", backupDir,
+			"--use-list", listFile)
+
+		assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+		assertDataRestored(restoreConn, schema2TupleCounts)
+		assertDataRestored(restoreConn, map[string]int{"public.foo": 0, "public.holds": 50000, "public.sales": 13})
+	})
+	It("runs gprestore with --to-sql-file and restores by running the SQL file with psql", func() {
+		skipIfOldBackupVersionBefore("1.3.0")
+		timestamp := gpbackup(gpbackupPath, backupHelperPath,
+			"--backup-dir", backupDir)
+		sqlFile := path.Join(backupDir, "restore.sql")
+		defer os.Remove(sqlFile)
+		gprestore(gprestorePath, restoreHelperPath, timestamp,
+			"--redirect-db", "restoredb",
+			"--backup-dir", backupDir,
+			"--to-sql-file", sqlFile)
+		assertRelationsCreated(restoreConn, 0)
+
+		contents, err := ioutil.ReadFile(sqlFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(contents)).To(ContainSubstring(`\connect restoredb`))
+		Expect(string(contents)).To(ContainSubstring("COPY public.foo"))
+
+		mustRunCommand(exec.Command("psql", "-d", "postgres", "-f", sqlFile))
+
+		assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+		assertDataRestored(restoreConn, schema2TupleCounts)
+		assertDataRestored(restoreConn, publicSchemaTupleCounts)
+	})
+	It("runs gpbackup and gprestore with --exclude-object-type", func() {
+		if useOldBackupVersion {
+			Skip("This test is not needed for old backup versions")
+		}
+		testhelper.AssertQueryRuns(backupConn,
+			"CREATE INDEX foo3_idx1 ON schema2.foo3(i)")
+		defer testhelper.AssertQueryRuns(backupConn,
+			"DROP INDEX schema2.foo3_idx1")
+		testhelper.AssertQueryRuns(backupConn,
+			"CREATE RULE foo3_rule AS ON DELETE TO schema2.foo3 DO INSTEAD NOTHING")
+		defer testhelper.AssertQueryRuns(backupConn,
+			"DROP RULE foo3_rule ON schema2.foo3")
+		timestamp := gpbackup(gpbackupPath, backupHelperPath,
+			"--backup-dir", backupDir,
+			"--exclude-object-type", "rule")
+		gprestore(gprestorePath, restoreHelperPath, timestamp,
+			"--redirect-db", "restoredb",
+			"--backup-dir", backupDir,
+			"--exclude-object-type", "INDEX")
+
+		assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+		assertDataRestored(restoreConn, schema2TupleCounts)
+		actualIndexCount := dbconn.MustSelectString(restoreConn,
+			`SELECT count(*) AS string FROM pg_indexes WHERE schemaname='schema2' AND indexname='foo3_idx1';`)
+		Expect(actualIndexCount).To(Equal("0"))
+		actualRuleCount := dbconn.MustSelectString(restoreConn,
+			`SELECT count(*) AS string FROM pg_rules WHERE schemaname='schema2' AND rulename='foo3_rule';`)
+		Expect(actualRuleCount).To(Equal("0"))
+	})
+	It("runs gpbackup and gprestore with filter patterns", func() {
+		if useOldBackupVersion {
+			Skip("This test is not needed for old backup versions")
+		}
+		timestamp := gpbackup(gpbackupPath, backupHelperPath,
+			"--backup-dir", backupDir,
+			"--include-table", "public.sal*",
+			"--include-table", "schema2.foo?")
+		gprestore(gprestorePath, restoreHelperPath, timestamp,
+			"--redirect-db", "restoredb",
+			"--backup-dir", backupDir,
+			"--exclude-table", "^schema2\\.foo[0-9]$")
+
+		assertDataRestored(restoreConn, map[string]int{
+			"public.sales": 13})
+		actualTableCount := dbconn.MustSelectString(restoreConn,
+			`SELECT count(*) AS string FROM pg_tables WHERE schemaname='schema2';`)
+		Expect(actualTableCount).To(Equal("0"))
+	})
 	It("runs gpbackup with --version flag", func() {
 		if useOldBackupVersion {
 			Skip("This test is not needed for old backup versions")
@@ -105,6 +105,7 @@ var metadataFilenameMap = map[string]string{
 	"plugin_config":         "plugin_config.yaml",
 	"error_tables_metadata": "error_tables_metadata",
 	"error_tables_data":     "error_tables_data",
+	"state":                 "state",
 }
 
 func (backupFPInfo *FilePathInfo) GetBackupFilePath(filetype string) string {
@@ -148,6 +149,10 @@ func (backupFPInfo *FilePathInfo) GetErrorTablesDataFilePath(restoreTimestamp st
 	return backupFPInfo.GetRestoreFilePath(restoreTimestamp, "error_tables_data")
 }
 
+func (backupFPInfo *FilePathInfo) GetRestoreStateFilePath(restoreTimestamp string) string {
+	return backupFPInfo.GetRestoreFilePath(restoreTimestamp, "state")
+}
+
 func (backupFPInfo *FilePathInfo) GetConfigFilePath() string {
 	return backupFPInfo.GetBackupFilePath("config")
 }
@@ -20,6 +20,7 @@ require (
 	github.com/sergi/go-diff v1.1.0
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/pflag v1.0.5
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
 	golang.org/x/sys v0.0.0-20200223170610-d5e6a3e2c0ae
 	golang.org/x/tools v0.0.0-20200214225126-5916a50871fb
 	gopkg.in/cheggaaa/pb.v1 v1.0.28
@@ -0,0 +1,19 @@
+// +build gpbackup_dedup_plugin
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/greenplum-db/gpbackup/plugins/dedup"
+	"github.com/greenplum-db/gpbackup/plugins/pluginapi"
+)
+
+func main() {
+	err := pluginapi.Execute(dedup.PluginName, dedup.GetVersion(), dedup.NewPlugin, os.Args[1:], os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
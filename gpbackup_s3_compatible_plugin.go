@@ -0,0 +1,19 @@
+// +build gpbackup_s3_compatible_plugin
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/greenplum-db/gpbackup/plugins/pluginapi"
+	"github.com/greenplum-db/gpbackup/plugins/s3"
+)
+
+func main() {
+	err := pluginapi.Execute(s3.PluginName, s3.GetVersion(), s3.NewPlugin, os.Args[1:], os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -20,7 +20,13 @@ func main() {
 			defer DoTeardown()
 			DoValidation(cmd)
 			DoSetup()
-			DoRestore()
+			if options.MustGetFlagBool(cmd.Flags(), options.VERIFY) {
+				DoVerify()
+			} else if options.MustGetFlagBool(cmd.Flags(), options.LIST) {
+				DoList()
+			} else {
+				DoRestore()
+			}
 		}}
 	rootCmd.SetArgs(options.HandleSingleDashes(os.Args[1:]))
 	DoInit(rootCmd)
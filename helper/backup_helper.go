@@ -2,12 +2,16 @@ package helper
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	path "path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
@@ -20,13 +24,7 @@ import (
 
 func doBackupAgent() error {
 	var lastRead uint64
-	var (
-		finalWriter io.Writer
-		gzipWriter  *gzip.Writer
-		bufIoWriter *bufio.Writer
-		writeHandle io.WriteCloser
-		writeCmd    *exec.Cmd
-	)
+	var backupFile *backupDataFile
 	tocfile := &toc.SegmentTOC{}
 	tocfile.DataEntries = make(map[uint]toc.SegmentDataEntry)
 
@@ -34,6 +32,9 @@ func doBackupAgent() error {
 	if err != nil {
 		return err
 	}
+	if *jobs > 1 {
+		return doParallelBackupAgent(tocfile, oidList)
+	}
 
 	currentPipe = fmt.Sprintf("%s_%d", *pipeFile, oidList[0])
 	/*
@@ -60,22 +61,34 @@ func doBackupAgent() error {
 			return err
 		}
 		if i == 0 {
-			finalWriter, gzipWriter, bufIoWriter, writeHandle, writeCmd, err = getBackupPipeWriter(*compressionLevel)
+			backupFile, err = openBackupDataFile()
 			if err != nil {
 				return err
 			}
 		}
 
 		log(fmt.Sprintf("Backing up table with oid %d\n", oid))
-		numBytes, err := io.Copy(finalWriter, reader)
+		fileStart := backupFile.offset()
+		tableWriter, err := newTableCompressWriter(backupFile.writer)
+		if err != nil {
+			return err
+		}
+		tableChecksum := utils.NewChecksum()
+		numBytes, err := io.Copy(io.MultiWriter(tableWriter, tableChecksum), reader)
 		if err != nil {
 			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
 		}
+		err = tableWriter.Close()
+		if err != nil {
+			return err
+		}
 		log(fmt.Sprintf("Read %d bytes\n", numBytes))
 
 		lastProcessed := lastRead + uint64(numBytes)
-		tocfile.AddSegmentDataEntry(uint(oid), lastRead, lastProcessed)
+		tocfile.AddSegmentDataEntry(uint(oid), lastRead, lastProcessed, utils.FormatChecksum(tableChecksum))
+		tocfile.SetSegmentDataFileOffsets(uint(oid), fileStart, backupFile.offset())
 		lastRead = lastProcessed
+		writeBackupProgress(lastRead)
 
 		lastPipe = currentPipe
 		currentPipe = nextPipe
@@ -86,29 +99,227 @@ func doBackupAgent() error {
 		}
 	}
 
-	/*
-	 * The order for flushing and closing the writers below is very specific
-	 * to ensure all data is written to the file and file handles are not leaked.
-	 */
-	if gzipWriter != nil {
-		_ = gzipWriter.Close()
+	return finishBackupDataFile(backupFile, tocfile)
+}
+
+/*
+ * With --jobs, the helper reads the pipes of several tables at once, and writes
+ * the data of each table to the data file in oid order, so that the segment
+ * TOC and the data file are the same as if the tables had been read one at a
+ * time.  The table that is next in order is compressed straight into the data
+ * file.  Any other table is compressed into memory, up to
+ * maxBufferedTableBytes, and after that into a spill file in the backup
+ * directory, until every table before it has been written.  What it has
+ * written so far is then appended to the data file, and the rest of its data
+ * is compressed straight into the data file.  A table is only read once the
+ * table that many tables before it has been written, so at most one table per
+ * job is read at a time, and only the data read while an earlier table is
+ * still being read is written twice.
+ *
+ * gpbackup creates the pipes of the first tables, one per job, and the helper
+ * creates the pipe of the table that is that many tables ahead of each table
+ * before opening the table's pipe.  As gpbackup starts the COPY of a table
+ * only once the COPY of an earlier table has finished, its pipe always exists
+ * by then.
+ */
+const maxBufferedTableBytes = 16 * 1024 * 1024
+
+type tableOutput struct {
+	lock        sync.Mutex
+	buffer      bytes.Buffer
+	spillFile   *os.File
+	spillWriter *bufio.Writer
+	dataFile    io.Writer
+}
+
+func (output *tableOutput) Write(p []byte) (int, error) {
+	output.lock.Lock()
+	defer output.lock.Unlock()
+	if output.dataFile != nil {
+		return output.dataFile.Write(p)
 	}
-	_ = bufIoWriter.Flush()
-	_ = writeHandle.Close()
-	if *pluginConfigFile != "" {
-		/*
-		 * When using a plugin, the agent may take longer to finish than the
-		 * main gpbackup process. We either write the TOC file if the agent finishes
-		 * successfully or write an error file if it has an error after the COPYs have
-		 * finished. We then wait on the gpbackup side until one of those files is
-		 * written to verify the agent completed.
-		 */
-		log("Uploading remaining data to plugin destination")
-		err := writeCmd.Wait()
+	if output.spillFile == nil && output.buffer.Len()+len(p) <= maxBufferedTableBytes {
+		return output.buffer.Write(p)
+	}
+	if output.spillFile == nil {
+		spillFile, err := ioutil.TempFile(spillDir, "table_")
 		if err != nil {
-			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
+			return 0, err
+		}
+		output.spillFile = spillFile
+		output.spillWriter = bufio.NewWriter(spillFile)
+	}
+	return output.spillWriter.Write(p)
+}
+
+// Appends the data written so far to the data file, and sends the rest of the data straight to it
+func (output *tableOutput) writeToDataFile(dataFile io.Writer) error {
+	output.lock.Lock()
+	defer output.lock.Unlock()
+	_, err := output.buffer.WriteTo(dataFile)
+	output.buffer = bytes.Buffer{}
+	if err != nil {
+		return err
+	}
+	if output.spillFile != nil {
+		err = output.spillWriter.Flush()
+		if err == nil {
+			_, err = output.spillFile.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			_, err = io.Copy(dataFile, bufio.NewReader(output.spillFile))
+		}
+		_ = output.spillFile.Close()
+		_ = os.Remove(output.spillFile.Name())
+		output.spillFile = nil
+		if err != nil {
+			return err
 		}
 	}
+	output.dataFile = dataFile
+	return nil
+}
+
+type tableResult struct {
+	numBytes int64
+	checksum string
+}
+
+func doParallelBackupAgent(tocfile *toc.SegmentTOC, oidList []int) error {
+	backupFile, err := openBackupDataFile()
+	if err != nil {
+		return err
+	}
+	// Tables are spilled to the backup directory, rather than the segment data directory
+	spillDir, err = ioutil.TempDir(path.Dir(*dataFile), "gpbackup_helper_")
+	if err != nil {
+		return err
+	}
+
+	tasks := make(chan int, len(oidList))
+	defer close(tasks)
+	for i := 0; i < *jobs && i < len(oidList); i++ {
+		tasks <- i
+	}
+	tableOutputs := make([]chan *tableOutput, len(oidList))
+	tableResults := make([]chan *tableResult, len(oidList))
+	for i := range oidList {
+		tableOutputs[i] = make(chan *tableOutput, 1)
+		tableResults[i] = make(chan *tableResult, 1)
+	}
+	errChan := make(chan error, *jobs)
+	for j := 0; j < *jobs; j++ {
+		go func() {
+			for i := range tasks {
+				if wasTerminated {
+					errChan <- errors.New("Terminated due to user request")
+					return
+				}
+				output := &tableOutput{}
+				tableOutputs[i] <- output
+				result, err := backupTableData(oidList, i, output)
+				if err != nil {
+					errChan <- err
+					return
+				}
+				tableResults[i] <- result
+			}
+		}()
+	}
+
+	var lastRead uint64
+	for i, oid := range oidList {
+		var output *tableOutput
+		select {
+		case output = <-tableOutputs[i]:
+		case err = <-errChan:
+			return err
+		}
+		fileStart := backupFile.offset()
+		err = output.writeToDataFile(backupFile.writer)
+		if err != nil {
+			return err
+		}
+		var result *tableResult
+		select {
+		case result = <-tableResults[i]:
+		case err = <-errChan:
+			return err
+		}
+
+		if i+*jobs < len(oidList) {
+			tasks <- i + *jobs
+		}
+		lastProcessed := lastRead + uint64(result.numBytes)
+		tocfile.AddSegmentDataEntry(uint(oid), lastRead, lastProcessed, result.checksum)
+		tocfile.SetSegmentDataFileOffsets(uint(oid), fileStart, backupFile.offset())
+		lastRead = lastProcessed
+		writeBackupProgress(lastRead)
+	}
+
+	return finishBackupDataFile(backupFile, tocfile)
+}
+
+/*
+ * The number of bytes read so far is written to a progress file after each
+ * table, which gpbackup reads to report the bytes written on each segment
+ * while the backup runs.  Failing to write it does not fail the backup.
+ */
+func writeBackupProgress(numBytes uint64) {
+	progressFile := fmt.Sprintf("%s_progress", *pipeFile)
+	err := ioutil.WriteFile(progressFile, []byte(fmt.Sprintf("%d\n", numBytes)), 0644)
+	if err != nil {
+		log("Unable to write progress file %s: %v", progressFile, err)
+	}
+}
+
+func backupTableData(oidList []int, index int, output io.Writer) (*tableResult, error) {
+	oid := oidList[index]
+	if index+*jobs < len(oidList) {
+		log(fmt.Sprintf("Creating pipe for oid %d\n", oidList[index+*jobs]))
+		err := createPipe(fmt.Sprintf("%s_%d", *pipeFile, oidList[index+*jobs]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pipe := fmt.Sprintf("%s_%d", *pipeFile, oid)
+	log(fmt.Sprintf("Opening pipe for oid %d\n", oid))
+	reader, readHandle, err := getBackupPipeReader(pipe)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = readHandle.Close()
+		_ = utils.RemoveFileIfExists(pipe)
+	}()
+
+	log(fmt.Sprintf("Backing up table with oid %d\n", oid))
+	tableWriter, err := newTableCompressWriter(output)
+	if err != nil {
+		return nil, err
+	}
+	tableChecksum := utils.NewChecksum()
+	numBytes, err := io.Copy(io.MultiWriter(tableWriter, tableChecksum), reader)
+	closeErr := tableWriter.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	log(fmt.Sprintf("Read %d bytes\n", numBytes))
+	return &tableResult{numBytes: numBytes, checksum: utils.FormatChecksum(tableChecksum)}, nil
+}
+
+func finishBackupDataFile(backupFile *backupDataFile, tocfile *toc.SegmentTOC) error {
+	err := backupFile.close()
+	if err != nil {
+		return err
+	}
+	tocfile.DataFileChecksum = utils.FormatChecksum(dataFileChecksum)
+	// The data file is encrypted as a single stream, so the offsets in it cannot be used to seek
+	tocfile.SeekableDataFile = backupFile.encryptWriter == nil
 	err = tocfile.WriteToFileAndMakeReadOnly(*tocFile)
 	if err != nil {
 		return err
@@ -129,31 +340,161 @@ func getBackupPipeReader(currentPipe string) (io.Reader, io.ReadCloser, error) {
 	return reader, readHandle, nil
 }
 
-func getBackupPipeWriter(compressLevel int) (io.Writer, *gzip.Writer, *bufio.Writer, io.WriteCloser, *exec.Cmd, error) {
-	var writeHandle io.WriteCloser
+/*
+ * The data of each table is written to the data file as a separate compressed
+ * stream, so that it can be decompressed without the data of the tables
+ * before it.  The concatenated streams are still a valid stream of each
+ * compression type, so the data file can also be decompressed as a whole.
+ */
+type backupDataFile struct {
+	writer        io.Writer
+	counter       *countingWriter
+	encryptWriter io.WriteCloser
+	bufIoWriter   *bufio.Writer
+	writeHandle   io.WriteCloser
+	writeCmd      *exec.Cmd
+}
+
+type countingWriter struct {
+	writer   io.Writer
+	numBytes uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.numBytes += uint64(n)
+	return n, err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func openBackupDataFile() (*backupDataFile, error) {
 	var err error
-	var writeCmd *exec.Cmd
+	backupFile := &backupDataFile{}
 	if *pluginConfigFile != "" {
-		writeCmd, writeHandle, err = startBackupPluginCommand()
+		backupFile.writeCmd, backupFile.writeHandle, err = startBackupPluginCommand()
 	} else {
-		writeHandle, err = os.Create(*dataFile)
+		backupFile.writeHandle, err = os.Create(*dataFile)
 	}
 	if err != nil {
-		return nil, nil, nil, nil, nil, err
+		return nil, err
+	}
+
+	// The checksum covers the data file as stored, after compression and encryption
+	dataFileChecksum = utils.NewChecksum()
+	backupFile.bufIoWriter = bufio.NewWriter(io.MultiWriter(backupFile.writeHandle, dataFileChecksum))
+	backupFile.counter = &countingWriter{writer: backupFile.bufIoWriter}
+	backupFile.writer = backupFile.counter
+	if *encryptionKeyFile != "" {
+		key, err := readEncryptionKey()
+		if err != nil {
+			return nil, err
+		}
+		backupFile.encryptWriter, err = utils.NewEncryptWriter(backupFile.counter, key)
+		if err != nil {
+			return nil, err
+		}
+		backupFile.writer = backupFile.encryptWriter
 	}
+	return backupFile, nil
+}
+
+// Returns the number of bytes written to the data file so far
+func (backupFile *backupDataFile) offset() uint64 {
+	return backupFile.counter.numBytes
+}
 
-	var finalWriter io.Writer
-	var gzipWriter *gzip.Writer
-	bufIoWriter := bufio.NewWriter(writeHandle)
-	finalWriter = bufIoWriter
-	if compressLevel > 0 {
-		gzipWriter, err = gzip.NewWriterLevel(bufIoWriter, compressLevel)
+func (backupFile *backupDataFile) close() error {
+	/*
+	 * The order for flushing and closing the writers below is very specific
+	 * to ensure all data is written to the file and file handles are not leaked.
+	 */
+	if backupFile.encryptWriter != nil {
+		err := backupFile.encryptWriter.Close()
+		if err != nil {
+			return err
+		}
+	}
+	_ = backupFile.bufIoWriter.Flush()
+	_ = backupFile.writeHandle.Close()
+	if *pluginConfigFile != "" {
+		/*
+		 * When using a plugin, the agent may take longer to finish than the
+		 * main gpbackup process. We either write the TOC file if the agent finishes
+		 * successfully or write an error file if it has an error after the COPYs have
+		 * finished. We then wait on the gpbackup side until one of those files is
+		 * written to verify the agent completed.
+		 */
+		log("Uploading remaining data to plugin destination")
+		err := backupFile.writeCmd.Wait()
 		if err != nil {
-			return nil, nil, nil, nil, nil, err
+			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
 		}
-		finalWriter = gzipWriter
 	}
-	return finalWriter, gzipWriter, bufIoWriter, writeHandle, writeCmd, nil
+	return nil
+}
+
+/*
+ * Returns a writer that compresses the data of one table to output.  Closing
+ * it ends the compressed stream without closing output.
+ */
+func newTableCompressWriter(output io.Writer) (io.WriteCloser, error) {
+	if *compressionLevel == 0 {
+		return nopWriteCloser{output}, nil
+	}
+	if *compressionType == "gzip" {
+		return gzip.NewWriterLevel(output, *compressionLevel)
+	}
+	return startCompressionCommand(output, utils.NewPipeThroughProgram(*compressionType, *compressionLevel).OutputCommand)
+}
+
+/*
+ * Compression types other than gzip have no implementation in the Go standard
+ * library, so the data is piped through the corresponding command line program
+ * instead.  Closing the returned writer waits until all compressed output has
+ * been written to the underlying writer.
+ */
+type commandWriter struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *bytes.Buffer
+}
+
+func (w *commandWriter) Write(p []byte) (int, error) {
+	return w.stdin.Write(p)
+}
+
+func (w *commandWriter) Close() error {
+	_ = w.stdin.Close()
+	err := w.cmd.Wait()
+	if err != nil {
+		return errors.Wrap(err, strings.TrimSpace(w.stderr.String()))
+	}
+	return nil
+}
+
+func startCompressionCommand(output io.Writer, cmdStr string) (*commandWriter, error) {
+	log(fmt.Sprintf("Compressing data with %s", cmdStr))
+	cmd := exec.Command("bash", "-c", cmdStr)
+	cmd.Stdout = output
+	// Each command has its own buffer, as several may run at once with --jobs
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &commandWriter{cmd: cmd, stdin: stdin, stderr: stderr}, nil
 }
 
 func startBackupPluginCommand() (*exec.Cmd, io.WriteCloser, error) {
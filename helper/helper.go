@@ -5,8 +5,12 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	path "path/filepath"
 	"runtime/debug"
 	"sort"
 	"strconv"
@@ -19,6 +23,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 )
 
 /*
@@ -26,37 +31,59 @@ import (
  */
 
 var (
-	CleanupGroup  *sync.WaitGroup
-	currentPipe   string
-	errBuf        bytes.Buffer
-	lastPipe      string
-	nextPipe      string
-	version       string
-	wasTerminated bool
-	writeHandle   *os.File
-	writer        *bufio.Writer
+	CleanupGroup     *sync.WaitGroup
+	currentPipe      string
+	dataFileChecksum hash.Hash
+	errBuf           bytes.Buffer
+	lastPipe         string
+	nextPipe         string
+	spillDir         string
+	version          string
+	wasTerminated    bool
+	writeHandle      *os.File
+	writer           *bufio.Writer
 )
 
 /*
  * Command-line flags
  */
 var (
-	backupAgent      *bool
-	compressionLevel *int
-	content          *int
-	dataFile         *string
-	oidFile          *string
-	onErrorContinue  *bool
-	pipeFile         *string
-	pluginConfigFile *string
-	printVersion     *bool
-	restoreAgent     *bool
-	tocFile          *string
-	isFiltered       *bool
+	backupAgent       *bool
+	checksumFile      *string
+	compressionLevel  *int
+	compressionType   *string
+	content           *int
+	dataFile          *string
+	decryptData       *bool
+	encryptData       *bool
+	encryptionKeyFile *string
+	jobs              *int
+	oidFile           *string
+	onErrorContinue   *bool
+	pipeFile          *string
+	pluginConfigFile  *string
+	printVersion      *bool
+	restoreAgent      *bool
+	tocFile           *string
+	verifyAgent       *bool
+	isFiltered        *bool
 )
 
+/*
+ * The exit code of the filter modes on an error, which is distinct from those
+ * of the agents so that a failing stage of a COPY PROGRAM pipeline can be told
+ * apart from the rest.
+ */
+const filterErrorExitCode = 3
+
 func DoHelper() {
 	var err error
+	InitializeGlobals()
+	if *encryptData || *decryptData || *checksumFile != "" {
+		doFilter()
+		return
+	}
+
 	defer func() {
 		if wasTerminated {
 			CleanupGroup.Wait()
@@ -65,8 +92,7 @@ func DoHelper() {
 		DoCleanup()
 		os.Exit(gplog.GetErrorCode())
 	}()
-
-	InitializeGlobals()
+	gplog.InitializeLogging("gpbackup_helper", "")
 	// Initialize signal handler
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
@@ -80,6 +106,14 @@ func DoHelper() {
 		}
 	}()
 
+	if *verifyAgent {
+		err = doVerifyAgent()
+		if err != nil {
+			gplog.Error(err.Error())
+		}
+		return
+	}
+
 	if *backupAgent {
 		err = doBackupAgent()
 	} else if *restoreAgent {
@@ -95,12 +129,17 @@ func DoHelper() {
 func InitializeGlobals() {
 	CleanupGroup = &sync.WaitGroup{}
 	CleanupGroup.Add(1)
-	gplog.InitializeLogging("gpbackup_helper", "")
 
 	backupAgent = flag.Bool("backup-agent", false, "Use gpbackup_helper as an agent for backup")
+	checksumFile = flag.String("checksum-file", "", "Copy data from stdin to stdout and write its checksum to this file")
 	content = flag.Int("content", -2, "Content ID of the corresponding segment")
-	compressionLevel = flag.Int("compression-level", 0, "The level of compression to use with gzip. O indicates no compression.")
+	compressionLevel = flag.Int("compression-level", 0, "The level of compression to use. O indicates no compression.")
+	compressionType = flag.String("compression-type", "gzip", "The type of compression to use. Valid values are gzip, zstd, and lz4.")
 	dataFile = flag.String("data-file", "", "Absolute path to the data file")
+	decryptData = flag.Bool("decrypt", false, "Decrypt data from stdin and write it to stdout")
+	encryptData = flag.Bool("encrypt", false, "Encrypt data from stdin and write it to stdout")
+	encryptionKeyFile = flag.String("encryption-key-file", "", "Absolute path to the file containing the encryption key")
+	jobs = flag.Int("jobs", 1, "The number of tables whose data to back up or restore at once")
 	oidFile = flag.String("oid-file", "", "Absolute path to the file containing a list of oids to restore")
 	onErrorContinue = flag.Bool("on-error-continue", false, "Continue restore even when encountering an error")
 	pipeFile = flag.String("pipe-file", "", "Absolute path to the pipe file")
@@ -108,6 +147,7 @@ func InitializeGlobals() {
 	printVersion = flag.Bool("version", false, "Print version number and exit")
 	restoreAgent = flag.Bool("restore-agent", false, "Use gpbackup_helper as an agent for restore")
 	tocFile = flag.String("toc-file", "", "Absolute path to the table of contents file")
+	verifyAgent = flag.Bool("verify-agent", false, "Use gpbackup_helper as an agent to verify the checksums of a single data file")
 	isFiltered = flag.Bool("with-filters", false, "Used with table/schema filters")
 
 	if *onErrorContinue && !*restoreAgent {
@@ -132,6 +172,91 @@ func createPipe(pipe string) error {
 	return err
 }
 
+func readEncryptionKey() ([]byte, error) {
+	keySource, err := utils.ReadEncryptionKeySource(*encryptionKeyFile, "")
+	if err != nil {
+		return nil, err
+	}
+	if keySource == nil {
+		return nil, errors.New("An encryption key file must be specified")
+	}
+	return keySource.DeriveKey("")
+}
+
+/*
+ * The filter modes run as a stage of the COPY PROGRAM pipeline of a table on
+ * each segment, so unlike the agents they do not write a log file, handle
+ * signals, or clean up pipes.  Errors are written to stderr instead, where the
+ * COPY reports them.
+ */
+func doFilter() {
+	var err error
+	if *checksumFile != "" {
+		err = doChecksumFilter()
+	} else {
+		err = doEncryptionFilter()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gpbackup_helper: %v\n", err)
+		os.Exit(filterErrorExitCode)
+	}
+}
+
+/*
+ * With --encrypt or --decrypt, the helper filters stdin to stdout so that it
+ * can be used as a stage of the COPY PROGRAM pipelines for each table.
+ */
+func doEncryptionFilter() error {
+	key, err := readEncryptionKey()
+	if err != nil {
+		return err
+	}
+	output := bufio.NewWriter(os.Stdout)
+	if *encryptData {
+		encryptWriter, err := utils.NewEncryptWriter(output, key)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(encryptWriter, bufio.NewReader(os.Stdin))
+		if err != nil {
+			return err
+		}
+		err = encryptWriter.Close()
+		if err != nil {
+			return err
+		}
+	} else {
+		decryptReader, err := utils.NewDecryptReader(bufio.NewReader(os.Stdin), key)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(output, decryptReader)
+		if err != nil {
+			return err
+		}
+	}
+	return output.Flush()
+}
+
+/*
+ * With --checksum-file, the helper copies stdin to stdout unchanged and writes
+ * the checksum of the data to the given file once all of it has been read.
+ */
+func doChecksumFilter() error {
+	checksum := utils.NewChecksum()
+	output := bufio.NewWriter(io.MultiWriter(os.Stdout, checksum))
+	numBytes, err := io.Copy(output, bufio.NewReader(os.Stdin))
+	if err != nil {
+		return err
+	}
+	err = output.Flush()
+	if err != nil {
+		return err
+	}
+	// The size of the data file is recorded along with its checksum for the backup report
+	return ioutil.WriteFile(*checksumFile, []byte(fmt.Sprintf("%s %d\n", utils.FormatChecksum(checksum), numBytes)), 0644)
+}
+
 func getOidListFromFile() ([]int, error) {
 	oidStr, err := operating.System.ReadFile(*oidFile)
 	if err != nil {
@@ -165,7 +290,6 @@ func flushAndCloseRestoreWriter() error {
 	return nil
 }
 
-
 /*
  * Shared helper functions
  */
@@ -197,9 +321,33 @@ func DoCleanup() {
 	if err != nil {
 		log("Encountered error during cleanup: %v", err)
 	}
+	if *jobs > 1 {
+		removeTablePipes()
+	}
+	if spillDir != "" {
+		err = os.RemoveAll(spillDir)
+		if err != nil {
+			log("Encountered error during cleanup: %v", err)
+		}
+	}
 	log("Cleanup complete")
 }
 
+/*
+ * With --jobs, the pipes of several tables may exist at once, so all remaining
+ * pipes are removed.  The error file has the same prefix, so only names ending
+ * in an oid are matched.
+ */
+func removeTablePipes() {
+	pipes, _ := path.Glob(fmt.Sprintf("%s_[0-9]*", *pipeFile))
+	for _, pipe := range pipes {
+		err := utils.RemoveFileIfExists(pipe)
+		if err != nil {
+			log("Encountered error during cleanup: %v", err)
+		}
+	}
+}
+
 func log(s string, v ...interface{}) {
 	s = fmt.Sprintf("Segment %d: %s", *content, s)
 	gplog.Verbose(s, v...)
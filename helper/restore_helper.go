@@ -2,6 +2,7 @@ package helper
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
@@ -33,9 +35,21 @@ const (
  * NONSEEKABLE type applies for every other restore scenario
  */
 type RestoreReader struct {
-	bufReader  *bufio.Reader
-	seekReader io.ReadSeeker
-	readerType ReaderType
+	bufReader        *bufio.Reader
+	seekReader       io.ReadSeeker
+	readerType       ReaderType
+	decompressReader *commandReader
+}
+
+/*
+ * Waits for the decompression command, if there is one, so that a data file
+ * that fails to decompress is reported instead of restoring truncated data.
+ */
+func (r *RestoreReader) Close() error {
+	if r.decompressReader == nil {
+		return nil
+	}
+	return r.decompressReader.Close()
 }
 
 func (r *RestoreReader) positionReader(pos uint64) error {
@@ -74,7 +88,7 @@ func (r *RestoreReader) copyData(num int64) (int64, error) {
 	return bytesRead, err
 }
 
-func doRestoreAgent() error {
+func doRestoreAgent() (err error) {
 	segmentTOC := toc.NewSegmentTOC(*tocFile)
 	tocEntries := segmentTOC.DataEntries
 
@@ -89,12 +103,27 @@ func doRestoreAgent() error {
 	if err != nil {
 		return err
 	}
+	if *jobs > 1 && canReadTablesConcurrently(segmentTOC) {
+		return doParallelRestoreAgent(segmentTOC, oidList)
+	}
 
+	// The first pipe, created by gprestore, is removed on cleanup if the data file cannot be read
+	currentPipe = fmt.Sprintf("%s_%d", *pipeFile, oidList[0])
 	reader, err := getRestoreDataReader(segmentTOC, oidList)
 	if err != nil {
 		return err
 	}
 	log(fmt.Sprintf("Using reader type: %s", reader.readerType))
+	defer func() {
+		closeErr := reader.Close()
+		if closeErr == nil {
+			return
+		} else if err == nil {
+			err = closeErr
+		} else {
+			logError(fmt.Sprintf("Error encountered: %v", closeErr))
+		}
+	}()
 
 	for i, oid := range oidList {
 		if wasTerminated {
@@ -102,9 +131,10 @@ func doRestoreAgent() error {
 		}
 
 		currentPipe = fmt.Sprintf("%s_%d", *pipeFile, oidList[i])
-		if i < len(oidList)-1 {
-			nextPipe = fmt.Sprintf("%s_%d", *pipeFile, oidList[i+1])
-			log(fmt.Sprintf("Creating pipe for oid %d: %s", oidList[i+1], nextPipe))
+		// gprestore creates the pipes of the first tables, one per job
+		if i+*jobs < len(oidList) {
+			nextPipe = fmt.Sprintf("%s_%d", *pipeFile, oidList[i+*jobs])
+			log(fmt.Sprintf("Creating pipe for oid %d: %s", oidList[i+*jobs], nextPipe))
 			err := createPipe(nextPipe)
 			if err != nil {
 				// In the case this error is hit it means we have lost the
@@ -175,6 +205,194 @@ func doRestoreAgent() error {
 	return lastError
 }
 
+/*
+ * The data of each table can be read separately from a local data file that
+ * is not encrypted, if it is not compressed or if each table's data was
+ * compressed separately.  Otherwise, with --jobs the data file is still read
+ * once from start to end, with the pipes of the next tables already created
+ * so that gprestore can start their COPY commands.
+ */
+func canReadTablesConcurrently(segmentTOC *toc.SegmentTOC) bool {
+	if *pluginConfigFile != "" || utils.IsEncryptedFile(*dataFile) {
+		return false
+	}
+	return segmentTOC.SeekableDataFile || utils.GetCompressionTypeForFile(*dataFile) == ""
+}
+
+/*
+ * With --jobs, each job takes the next table in oid order, creates the pipe of
+ * the table that is that many tables ahead, and copies the table's data from
+ * its own position in the data file to its pipe.  gprestore creates the pipes
+ * of the first tables and starts the COPY of a table only once the COPY of an
+ * earlier table has finished, so its pipe always exists by then.
+ */
+func doParallelRestoreAgent(segmentTOC *toc.SegmentTOC, oidList []int) error {
+	dataFileHandle, err := os.Open(*dataFile)
+	if err != nil {
+		return err
+	}
+	defer dataFileHandle.Close()
+	log(fmt.Sprintf("Restoring %d tables at once", *jobs))
+
+	tasks := make(chan int, len(oidList))
+	for i := range oidList {
+		tasks <- i
+	}
+	close(tasks)
+	var workerPool sync.WaitGroup
+	var lastErrorLock sync.Mutex
+	var lastError error
+	errChan := make(chan error, *jobs)
+	for j := 0; j < *jobs; j++ {
+		workerPool.Add(1)
+		go func() {
+			defer workerPool.Done()
+			for i := range tasks {
+				if wasTerminated {
+					errChan <- errors.New("Terminated due to user request")
+					return
+				}
+				err := restoreTableFromDataFile(segmentTOC, dataFileHandle, oidList, i)
+				if err, ok := err.(fatalRestoreError); ok {
+					errChan <- err.error
+					return
+				}
+				if err != nil {
+					if !*onErrorContinue {
+						errChan <- err
+						return
+					}
+					logError(fmt.Sprintf("Error encountered: %v", err))
+					lastErrorLock.Lock()
+					lastError = err
+					lastErrorLock.Unlock()
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		workerPool.Wait()
+		close(done)
+	}()
+
+	select {
+	case err = <-errChan:
+		return err
+	case <-done:
+	}
+	return lastError
+}
+
+/*
+ * Errors that mean pipes can no longer be created or opened normally, so the
+ * helper quits even if --on-error-continue is given.
+ */
+type fatalRestoreError struct {
+	error
+}
+
+func restoreTableFromDataFile(segmentTOC *toc.SegmentTOC, dataFileHandle io.ReaderAt, oidList []int, index int) error {
+	oid := oidList[index]
+	if index+*jobs < len(oidList) {
+		pipe := fmt.Sprintf("%s_%d", *pipeFile, oidList[index+*jobs])
+		log(fmt.Sprintf("Creating pipe for oid %d: %s", oidList[index+*jobs], pipe))
+		err := createPipe(pipe)
+		if err != nil {
+			return fatalRestoreError{err}
+		}
+	}
+
+	pipe := fmt.Sprintf("%s_%d", *pipeFile, oid)
+	defer func() {
+		log(fmt.Sprintf("Removing pipe for oid %d: %s", oid, pipe))
+		_ = utils.RemoveFileIfExists(pipe)
+	}()
+	log(fmt.Sprintf("Opening pipe for oid %d: %s", oid, pipe))
+	pipeWriter, pipeHandle, err := getRestorePipeWriter(pipe)
+	if err != nil {
+		return fatalRestoreError{err}
+	}
+	defer pipeHandle.Close()
+
+	entry := segmentTOC.DataEntries[uint(oid)]
+	fileStart, fileEnd := segmentTOC.GetDataFileOffsets(uint(oid))
+	log(fmt.Sprintf("Data Reader - Start Byte: %d; End Byte: %d; File Start Byte: %d; File End Byte: %d", entry.StartByte, entry.EndByte, fileStart, fileEnd))
+	var tableReader io.Reader = io.NewSectionReader(dataFileHandle, int64(fileStart), int64(fileEnd-fileStart))
+	var decompressReader *commandReader
+	if compressionType := utils.GetCompressionTypeForFile(*dataFile); compressionType == "gzip" {
+		tableReader, err = gzip.NewReader(bufio.NewReader(tableReader))
+		if err != nil {
+			return err
+		}
+	} else if compressionType != "" {
+		decompressReader, err = startDecompressionCommand(tableReader, utils.NewPipeThroughProgram(compressionType, 0).InputCommand)
+		if err != nil {
+			return err
+		}
+		tableReader = decompressReader
+	}
+
+	log(fmt.Sprintf("Restoring table with oid %d", oid))
+	bytesRead, err := io.CopyN(pipeWriter, tableReader, int64(entry.EndByte-entry.StartByte))
+	if err == nil {
+		err = pipeWriter.Flush()
+	}
+	if decompressReader != nil {
+		closeErr := decompressReader.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log(fmt.Sprintf("Copied %d bytes into the pipe", bytesRead))
+	log(fmt.Sprintf("Closing pipe for oid %d: %s", oid, pipe))
+	return pipeHandle.Close()
+}
+
+/*
+ * As with commandWriter for backups, closing the reader waits for the
+ * decompression command to exit.
+ */
+type commandReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr *bytes.Buffer
+}
+
+func (r *commandReader) Read(p []byte) (int, error) {
+	return r.stdout.Read(p)
+}
+
+func (r *commandReader) Close() error {
+	// Draining the output lets the command exit if not all of it was read
+	_, _ = io.Copy(ioutil.Discard, r.stdout)
+	err := r.cmd.Wait()
+	if err != nil {
+		return errors.Wrap(err, strings.TrimSpace(r.stderr.String()))
+	}
+	return nil
+}
+
+func startDecompressionCommand(input io.Reader, cmdStr string) (*commandReader, error) {
+	log(fmt.Sprintf("Decompressing data with %s", cmdStr))
+	cmd := exec.Command("bash", "-c", cmdStr)
+	cmd.Stdin = input
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &commandReader{cmd: cmd, stdout: stdout, stderr: stderr}, nil
+}
+
 func getRestoreDataReader(toc *toc.SegmentTOC, oidList []int) (*RestoreReader, error) {
 	var readHandle io.Reader
 	var seekHandle io.ReadSeeker
@@ -192,7 +410,7 @@ func getRestoreDataReader(toc *toc.SegmentTOC, oidList []int) (*RestoreReader, e
 			restoreReader.readerType = NONSEEKABLE
 		}
 	} else {
-		if *isFiltered && !strings.HasSuffix(*dataFile, ".gz") {
+		if *isFiltered && utils.GetCompressionTypeForFile(*dataFile) == "" && !utils.IsEncryptedFile(*dataFile) {
 			// Seekable reader if backup is not compressed and filters are set
 			seekHandle, err = os.Open(*dataFile)
 			restoreReader.readerType = SEEKABLE
@@ -206,15 +424,33 @@ func getRestoreDataReader(toc *toc.SegmentTOC, oidList []int) (*RestoreReader, e
 		return nil, err
 	}
 
+	if utils.IsEncryptedFile(*dataFile) {
+		key, err := readEncryptionKey()
+		if err != nil {
+			return nil, err
+		}
+		readHandle, err = utils.NewDecryptReader(bufio.NewReader(readHandle), key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Set the underlying stream reader in restoreReader
 	if restoreReader.readerType == SEEKABLE {
 		restoreReader.seekReader = seekHandle
-	} else if strings.HasSuffix(*dataFile, ".gz") {
+	} else if compressionType := utils.GetCompressionTypeForFile(*dataFile); compressionType == "gzip" {
 		gzipReader, err := gzip.NewReader(readHandle)
 		if err != nil {
 			return nil, err
 		}
 		restoreReader.bufReader = bufio.NewReader(gzipReader)
+	} else if compressionType != "" {
+		decompressReader, err := startDecompressionCommand(readHandle, utils.NewPipeThroughProgram(compressionType, 0).InputCommand)
+		if err != nil {
+			return nil, err
+		}
+		restoreReader.bufReader = bufio.NewReader(decompressReader)
+		restoreReader.decompressReader = decompressReader
 	} else {
 		restoreReader.bufReader = bufio.NewReader(readHandle)
 	}
@@ -245,7 +481,7 @@ func startRestorePluginCommand(toc *toc.SegmentTOC, oidList []int) (io.Reader, b
 		return nil, false, err
 	}
 	cmdStr := ""
-	if pluginConfig.CanRestoreSubset() && *isFiltered && !strings.HasSuffix(*dataFile, ".gz") {
+	if pluginConfig.CanRestoreSubset() && *isFiltered && utils.GetCompressionTypeForFile(*dataFile) == "" && !utils.IsEncryptedFile(*dataFile) {
 		offsetsFile, _ := ioutil.TempFile("/tmp", "gprestore_offsets_")
 		defer func() {
 			offsetsFile.Close()
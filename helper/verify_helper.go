@@ -0,0 +1,133 @@
+package helper
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Verify specific functions
+ */
+
+/*
+ * With --verify-agent, the helper reads a single data file from disk or from
+ * the plugin, checks the checksum of each table's data against the segment
+ * TOC, and prints the checksum of the data file as stored so that gprestore
+ * can compare it against the master TOC.
+ */
+func doVerifyAgent() error {
+	segmentTOC := toc.NewSegmentTOC(*tocFile)
+
+	var readHandle io.Reader
+	var err error
+	if *pluginConfigFile != "" {
+		readHandle, _, err = startRestorePluginCommand(segmentTOC, nil)
+	} else {
+		var fileHandle *os.File
+		fileHandle, err = os.Open(*dataFile)
+		if err == nil {
+			defer fileHandle.Close()
+		}
+		readHandle = fileHandle
+	}
+	if err != nil {
+		return err
+	}
+
+	fileChecksum := utils.NewChecksum()
+	rawReader := bufio.NewReader(io.TeeReader(readHandle, fileChecksum))
+	var dataReader io.Reader = rawReader
+	if utils.IsEncryptedFile(*dataFile) {
+		key, err := readEncryptionKey()
+		if err != nil {
+			return err
+		}
+		dataReader, err = utils.NewDecryptReader(dataReader, key)
+		if err != nil {
+			return err
+		}
+	}
+	var decompressCmd *exec.Cmd
+	if compressionType := utils.GetCompressionTypeForFile(*dataFile); compressionType == "gzip" {
+		dataReader, err = gzip.NewReader(dataReader)
+		if err != nil {
+			return err
+		}
+	} else if compressionType != "" {
+		decompressCmd = exec.Command("bash", "-c", utils.NewPipeThroughProgram(compressionType, 0).InputCommand)
+		decompressCmd.Stdin = dataReader
+		decompressCmd.Stderr = &errBuf
+		dataReader, err = decompressCmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		err = decompressCmd.Start()
+		if err != nil {
+			return err
+		}
+	}
+
+	oids := make([]uint, 0, len(segmentTOC.DataEntries))
+	for oid := range segmentTOC.DataEntries {
+		oids = append(oids, oid)
+	}
+	sort.Slice(oids, func(i int, j int) bool {
+		return segmentTOC.DataEntries[oids[i]].StartByte < segmentTOC.DataEntries[oids[j]].StartByte
+	})
+
+	var lastByte uint64
+	mismatchedOids := make([]string, 0)
+	for _, oid := range oids {
+		entry := segmentTOC.DataEntries[oid]
+		_, err = io.CopyN(ioutil.Discard, dataReader, int64(entry.StartByte-lastByte))
+		if err != nil {
+			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
+		}
+		log(fmt.Sprintf("Verifying table with oid %d", oid))
+		tableChecksum := utils.NewChecksum()
+		_, err = io.CopyN(tableChecksum, dataReader, int64(entry.EndByte-entry.StartByte))
+		if err != nil {
+			return errors.Wrapf(err, "Unable to read data for table with oid %d: %s", oid, strings.Trim(errBuf.String(), "\x00"))
+		}
+		lastByte = entry.EndByte
+		if entry.Checksum != "" && utils.FormatChecksum(tableChecksum) != entry.Checksum {
+			mismatchedOids = append(mismatchedOids, fmt.Sprintf("%d", oid))
+		}
+	}
+
+	// Read the rest of the file so that the data file checksum covers all of it
+	_, err = io.Copy(ioutil.Discard, dataReader)
+	if err != nil {
+		return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
+	}
+	if decompressCmd != nil {
+		err = decompressCmd.Wait()
+		if err != nil {
+			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
+		}
+	}
+	_, err = io.Copy(ioutil.Discard, rawReader)
+	if err != nil {
+		return err
+	}
+	if errMsg := strings.Trim(errBuf.String(), "\x00"); len(errMsg) != 0 {
+		return errors.New(errMsg)
+	}
+
+	fmt.Println(utils.FormatChecksum(fileChecksum))
+	if len(mismatchedOids) > 0 {
+		return errors.Errorf("Checksum mismatch for data of table(s) with oid %s", strings.Join(mismatchedOids, ", "))
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package history
 import (
 	"io/ioutil"
 	"os"
+	"path"
 	"sort"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 )
 
@@ -18,45 +20,152 @@ type RestorePlanEntry struct {
 	TableFQNs []string
 }
 
+/*
+ * A copy of a backup made with gpbackup_manager replicate-backup, which can
+ * be restored using a config file for the given plugin.  The destination
+ * identifies the storage location of the replica, as the same plugin can store
+ * replicas in several locations.
+ */
+type Replica struct {
+	Plugin         string
+	Destination    string `yaml:",omitempty"`
+	PluginVersion  string
+	DateReplicated string
+}
+
 const (
 	BackupStatusSucceed = "Success"
 	BackupStatusFailed  = "Failure"
 )
 
 type BackupConfig struct {
-	BackupDir             string
-	BackupVersion         string
-	Compressed            bool
-	DatabaseName          string
-	DatabaseVersion       string
-	DataOnly              bool
-	DateDeleted           string
-	ExcludeRelations      []string
-	ExcludeSchemaFiltered bool
-	ExcludeSchemas        []string
-	ExcludeTableFiltered  bool
-	IncludeRelations      []string
-	IncludeSchemaFiltered bool
-	IncludeSchemas        []string
-	IncludeTableFiltered  bool
-	Incremental           bool
-	LeafPartitionData     bool
-	MetadataOnly          bool
-	Plugin                string
-	PluginVersion         string
-	RestorePlan           []RestorePlanEntry
-	SingleDataFile        bool
-	Timestamp             string
-	EndTime               string
-	WithoutGlobals        bool
-	WithStatistics        bool
-	Status                string
+	BackupDir                string
+	BackupVersion            string
+	Compressed               bool
+	CompressionType          string
+	ConsolidatedFrom         []string `yaml:",omitempty"`
+	DatabaseName             string
+	DatabaseVersion          string
+	DataOnly                 bool
+	DateDeleted              string
+	Encrypted                bool
+	EncryptionKeyFingerprint string
+	EncryptionSalt           string
+	ExcludeObjectTypes       []string `yaml:",omitempty"`
+	ExcludeRelations         []string
+	ExcludeSchemaFiltered    bool
+	ExcludeSchemas           []string
+	ExcludeTableFiltered     bool
+	IncludeRelations         []string
+	IncludeSchemaFiltered    bool
+	IncludeSchemas           []string
+	IncludeTableFiltered     bool
+	Incremental              bool
+	IncrementalHeap          bool
+	LeafPartitionData        bool
+	MaskingRules             map[string]string `yaml:",omitempty"`
+	MetadataOnly             bool
+	Plugin                   string
+	PluginVersion            string
+	Replicas                 []Replica `yaml:",omitempty"`
+	RestorePlan              []RestorePlanEntry
+	ResumedFrom              string
+	RowFilters               map[string]string `yaml:",omitempty"`
+	SingleDataFile           bool
+	Timestamp                string
+	TOCChecksum              string
+	EndTime                  string
+	WithoutGlobals           bool
+	WithStatistics           bool
+	Status                   string
 }
 
 func (backup *BackupConfig) Failed() bool {
 	return backup.Status == BackupStatusFailed
 }
 
+/*
+ * Returns "" for uncompressed backups.  Backups taken before the compression
+ * type was recorded were always compressed with gzip.
+ */
+func (backup *BackupConfig) GetCompressionType() string {
+	if !backup.Compressed {
+		return ""
+	}
+	if backup.CompressionType == "" {
+		return "gzip"
+	}
+	return backup.CompressionType
+}
+
+func (backup *BackupConfig) Deleted() bool {
+	return backup.DateDeleted != ""
+}
+
+/*
+ * Returns the version of the given plugin that wrote the replica of the backup
+ * in the given destination, if there is one, and otherwise the version of the
+ * plugin the backup was taken with.
+ */
+func (backup *BackupConfig) GetPluginVersion(plugin string, destination string) string {
+	if replica := backup.FindReplica(plugin, destination); replica != nil {
+		return replica.PluginVersion
+	}
+	return backup.PluginVersion
+}
+
+/*
+ * Replicas recorded without a destination were made before destinations were
+ * recorded, so it is unknown where they are and they never match.
+ */
+func (backup *BackupConfig) FindReplica(plugin string, destination string) *Replica {
+	for i := range backup.Replicas {
+		replica := &backup.Replicas[i]
+		if replica.Plugin == plugin && replica.Destination != "" && replica.Destination == destination {
+			return replica
+		}
+	}
+	return nil
+}
+
+/*
+ * Returns whether a backup was taken with the same flags as the current one,
+ * so that the current backup can be an incremental backup based on it.
+ */
+func MatchesIncrementalFlags(backupConfig *BackupConfig, currentBackupConfig *BackupConfig) bool {
+	_, pluginBinaryName := path.Split(backupConfig.Plugin)
+	_, currentPluginBinaryName := path.Split(currentBackupConfig.Plugin)
+	return backupConfig.BackupDir == currentBackupConfig.BackupDir &&
+		backupConfig.DatabaseName == currentBackupConfig.DatabaseName &&
+		backupConfig.LeafPartitionData == currentBackupConfig.LeafPartitionData &&
+		pluginBinaryName == currentPluginBinaryName &&
+		backupConfig.SingleDataFile == currentBackupConfig.SingleDataFile &&
+		backupConfig.Compressed == currentBackupConfig.Compressed &&
+		backupConfig.GetCompressionType() == currentBackupConfig.GetCompressionType() &&
+		backupConfig.Encrypted == currentBackupConfig.Encrypted &&
+		backupConfig.IncrementalHeap == currentBackupConfig.IncrementalHeap &&
+		// Filter patterns and the include list are expanded before this, so we must compare against the current backup config
+		utils.NewIncludeSet(backupConfig.IncludeRelations).Equals(utils.NewIncludeSet(currentBackupConfig.IncludeRelations)) &&
+		utils.NewIncludeSet(backupConfig.IncludeSchemas).Equals(utils.NewIncludeSet(currentBackupConfig.IncludeSchemas)) &&
+		utils.NewIncludeSet(backupConfig.ExcludeRelations).Equals(utils.NewIncludeSet(currentBackupConfig.ExcludeRelations)) &&
+		utils.NewIncludeSet(backupConfig.ExcludeSchemas).Equals(utils.NewIncludeSet(currentBackupConfig.ExcludeSchemas)) &&
+		stringMapsEqual(backupConfig.RowFilters, currentBackupConfig.RowFilters) &&
+		stringMapsEqual(backupConfig.MaskingRules, currentBackupConfig.MaskingRules)
+}
+
+// A nil map and an empty one are equal, as maps are omitted from the config file when empty
+func stringMapsEqual(map1 map[string]string, map2 map[string]string) bool {
+	if len(map1) != len(map2) {
+		return false
+	}
+	for key, value := range map1 {
+		if value2, ok := map2[key]; !ok || value2 != value {
+			return false
+		}
+	}
+	return true
+}
+
 func ReadConfigFile(filename string) *BackupConfig {
 	config := &BackupConfig{}
 	contents, err := ioutil.ReadFile(filename)
@@ -135,6 +244,59 @@ func (history *History) RewriteHistoryFile(historyFilePath string) error {
 	return err
 }
 
+func MarkBackupDeleted(historyFilePath string, timestamp string, dateDeleted string) error {
+	return updateBackupConfig(historyFilePath, timestamp, func(backupConfig *BackupConfig) {
+		backupConfig.DateDeleted = dateDeleted
+	})
+}
+
+func MarkBackupFailed(historyFilePath string, timestamp string) error {
+	return updateBackupConfig(historyFilePath, timestamp, func(backupConfig *BackupConfig) {
+		backupConfig.Status = BackupStatusFailed
+	})
+}
+
+/*
+ * An earlier replica in the same destination is replaced, as the files it
+ * refers to have been overwritten.
+ */
+func AddBackupReplica(historyFilePath string, timestamp string, replica Replica) error {
+	return updateBackupConfig(historyFilePath, timestamp, func(backupConfig *BackupConfig) {
+		if existing := backupConfig.FindReplica(replica.Plugin, replica.Destination); existing != nil {
+			*existing = replica
+		} else {
+			backupConfig.Replicas = append(backupConfig.Replicas, replica)
+		}
+	})
+}
+
+/*
+ * The history file is re-read while holding the lock so that entries written
+ * by a concurrent gpbackup are not lost when the file is rewritten.
+ */
+func updateBackupConfig(historyFilePath string, timestamp string, update func(*BackupConfig)) error {
+	lock := lockHistoryFile()
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	history, err := NewHistory(historyFilePath)
+	if err != nil {
+		return err
+	}
+	found := false
+	for i := range history.BackupConfigs {
+		if history.BackupConfigs[i].Timestamp == timestamp {
+			update(&history.BackupConfigs[i])
+			found = true
+		}
+	}
+	if !found {
+		return errors.Errorf("Backup with timestamp %s not found in history file %s", timestamp, historyFilePath)
+	}
+	return history.WriteToFileAndMakeReadOnly(historyFilePath)
+}
+
 func lockHistoryFile() lockfile.Lockfile {
 	lock, err := lockfile.New("/tmp/gpbackup_history.yaml.lck")
 	gplog.FatalOnError(err)
@@ -163,6 +325,39 @@ func (history *History) WriteToFileAndMakeReadOnly(filename string) error {
 	return utils.WriteToFileAndMakeReadOnly(filename, historyFileContents)
 }
 
+/*
+ * Unlike FindBackupConfig, this returns failed and deleted backups as well, and
+ * the returned pointer refers to the entry stored in the History.
+ */
+func (history *History) FindBackupConfigIncludingFailed(timestamp string) *BackupConfig {
+	for i := range history.BackupConfigs {
+		if history.BackupConfigs[i].Timestamp == timestamp {
+			return &history.BackupConfigs[i]
+		}
+	}
+	return nil
+}
+
+/*
+ * Returns the timestamps of all successful, undeleted backups whose restore
+ * plan reads data from the backup with the given timestamp.
+ */
+func (history *History) FindDependentBackups(timestamp string) []string {
+	dependents := make([]string, 0)
+	for _, backupConfig := range history.BackupConfigs {
+		if backupConfig.Timestamp == timestamp || backupConfig.Failed() || backupConfig.Deleted() {
+			continue
+		}
+		for _, entry := range backupConfig.RestorePlan {
+			if entry.Timestamp == timestamp {
+				dependents = append(dependents, backupConfig.Timestamp)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
 func (history *History) FindBackupConfig(timestamp string) *BackupConfig {
 	for _, backupConfig := range history.BackupConfigs {
 		if backupConfig.Timestamp == timestamp && !backupConfig.Failed() {
@@ -297,5 +297,129 @@ var _ = Describe("backup/history tests", func() {
 			foundConfig := resultHistory.FindBackupConfig("timestampFailed")
 			Expect(foundConfig).To(BeNil())
 		})
+		It("finds a failed backup config when including failed backups", func() {
+			foundConfig := resultHistory.FindBackupConfigIncludingFailed("timestampFailed")
+			Expect(foundConfig).To(Equal(&testConfigFailed))
+		})
+	})
+	Describe("FindDependentBackups", func() {
+		It("returns the successful, undeleted backups whose restore plan references the timestamp", func() {
+			testConfig1.RestorePlan = []history.RestorePlanEntry{{Timestamp: "timestamp1"}}
+			testConfig2.RestorePlan = []history.RestorePlanEntry{{Timestamp: "timestamp1"}, {Timestamp: "timestamp2"}}
+			testConfig3.RestorePlan = []history.RestorePlanEntry{{Timestamp: "timestamp1"}, {Timestamp: "timestamp3"}}
+			testConfig3.DateDeleted = "20190101010101"
+			testConfigFailed.RestorePlan = []history.RestorePlanEntry{{Timestamp: "timestamp1"}, {Timestamp: "timestampFailed"}}
+			testHistory := history.History{BackupConfigs: []history.BackupConfig{testConfigFailed, testConfig3, testConfig2, testConfig1}}
+
+			Expect(testHistory.FindDependentBackups("timestamp1")).To(Equal([]string{"timestamp2"}))
+			Expect(testHistory.FindDependentBackups("timestamp2")).To(BeEmpty())
+		})
+	})
+	Describe("MarkBackupDeleted", func() {
+		BeforeEach(func() {
+			err := history.WriteBackupHistory(historyFilePath, &testConfig1)
+			Expect(err).ToNot(HaveOccurred())
+			err = history.WriteBackupHistory(historyFilePath, &testConfig2)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("sets the deletion date of the backup in the history file", func() {
+			err := history.MarkBackupDeleted(historyFilePath, "timestamp1", "20190101010101")
+			Expect(err).ToNot(HaveOccurred())
+
+			resultHistory, err := history.NewHistory(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.BackupConfigs[1].Timestamp).To(Equal("timestamp1"))
+			Expect(resultHistory.BackupConfigs[1].DateDeleted).To(Equal("20190101010101"))
+			Expect(resultHistory.BackupConfigs[1].Deleted()).To(BeTrue())
+			Expect(resultHistory.BackupConfigs[0].Deleted()).To(BeFalse())
+		})
+		It("returns an error when the timestamp is not in the history file", func() {
+			err := history.MarkBackupDeleted(historyFilePath, "foo", "20190101010101")
+			Expect(err).To(MatchError("Backup with timestamp foo not found in history file /tmp/history_file.yaml"))
+		})
+	})
+	Describe("MarkBackupFailed", func() {
+		BeforeEach(func() {
+			err := history.WriteBackupHistory(historyFilePath, &testConfig1)
+			Expect(err).ToNot(HaveOccurred())
+			err = history.WriteBackupHistory(historyFilePath, &testConfig2)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("sets the status of the backup in the history file to failed", func() {
+			err := history.MarkBackupFailed(historyFilePath, "timestamp1")
+			Expect(err).ToNot(HaveOccurred())
+
+			resultHistory, err := history.NewHistory(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.BackupConfigs[1].Timestamp).To(Equal("timestamp1"))
+			Expect(resultHistory.BackupConfigs[1].Failed()).To(BeTrue())
+			Expect(resultHistory.BackupConfigs[0].Failed()).To(BeFalse())
+		})
+	})
+	Describe("AddBackupReplica", func() {
+		BeforeEach(func() {
+			err := history.WriteBackupHistory(historyFilePath, &testConfig1)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("records a replica of the backup, replacing an earlier replica in the same destination", func() {
+			err := history.AddBackupReplica(historyFilePath, "timestamp1", history.Replica{Plugin: "gpbackup_s3_plugin", Destination: "bucket1", PluginVersion: "1.0.0", DateReplicated: "20190101010101"})
+			Expect(err).ToNot(HaveOccurred())
+			err = history.AddBackupReplica(historyFilePath, "timestamp1", history.Replica{Plugin: "gpbackup_s3_plugin", Destination: "bucket2", PluginVersion: "1.0.0", DateReplicated: "20190101020202"})
+			Expect(err).ToNot(HaveOccurred())
+			err = history.AddBackupReplica(historyFilePath, "timestamp1", history.Replica{Plugin: "gpbackup_s3_plugin", Destination: "bucket1", PluginVersion: "1.1.0", DateReplicated: "20190102010101"})
+			Expect(err).ToNot(HaveOccurred())
+
+			resultHistory, err := history.NewHistory(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.BackupConfigs[0].Replicas).To(Equal([]history.Replica{
+				{Plugin: "gpbackup_s3_plugin", Destination: "bucket1", PluginVersion: "1.1.0", DateReplicated: "20190102010101"},
+				{Plugin: "gpbackup_s3_plugin", Destination: "bucket2", PluginVersion: "1.0.0", DateReplicated: "20190101020202"},
+			}))
+		})
+	})
+	Describe("GetPluginVersion", func() {
+		It("returns the version of the plugin the replica in the destination or the backup was written with", func() {
+			backupConfig := history.BackupConfig{Plugin: "gpbackup_s3_plugin", PluginVersion: "1.0.0",
+				Replicas: []history.Replica{{Plugin: "gpbackup_s3_plugin", Destination: "bucket2", PluginVersion: "1.1.0"},
+					{Plugin: "gpbackup_ddboost_plugin", Destination: "storage_unit", PluginVersion: "2.0.0"}}}
+
+			Expect(backupConfig.GetPluginVersion("gpbackup_s3_plugin", "bucket1")).To(Equal("1.0.0"))
+			Expect(backupConfig.GetPluginVersion("gpbackup_s3_plugin", "bucket2")).To(Equal("1.1.0"))
+			Expect(backupConfig.GetPluginVersion("gpbackup_ddboost_plugin", "storage_unit")).To(Equal("2.0.0"))
+			Expect(backupConfig.GetPluginVersion("other_plugin", "")).To(Equal("1.0.0"))
+		})
+		It("does not match replicas recorded without a destination", func() {
+			backupConfig := history.BackupConfig{Plugin: "gpbackup_ddboost_plugin", PluginVersion: "2.0.0",
+				Replicas: []history.Replica{{Plugin: "gpbackup_s3_plugin", PluginVersion: "1.1.0"}}}
+
+			Expect(backupConfig.GetPluginVersion("gpbackup_s3_plugin", "")).To(Equal("2.0.0"))
+		})
+	})
+	Describe("MatchesIncrementalFlags", func() {
+		It("matches backups with the same row filters", func() {
+			backupConfig := history.BackupConfig{RowFilters: map[string]string{"public.foo": "i > 1"}}
+			currentBackupConfig := history.BackupConfig{RowFilters: map[string]string{"public.foo": "i > 1"}}
+
+			Expect(history.MatchesIncrementalFlags(&backupConfig, &currentBackupConfig)).To(BeTrue())
+			Expect(history.MatchesIncrementalFlags(&history.BackupConfig{}, &history.BackupConfig{RowFilters: map[string]string{}})).To(BeTrue())
+		})
+		It("does not match backups with different row filters", func() {
+			backupConfig := history.BackupConfig{RowFilters: map[string]string{"public.foo": "i > 1"}}
+
+			Expect(history.MatchesIncrementalFlags(&backupConfig, &history.BackupConfig{})).To(BeFalse())
+			Expect(history.MatchesIncrementalFlags(&backupConfig, &history.BackupConfig{RowFilters: map[string]string{"public.foo": "i > 2"}})).To(BeFalse())
+			Expect(history.MatchesIncrementalFlags(&backupConfig, &history.BackupConfig{RowFilters: map[string]string{"public.bar": "i > 1"}})).To(BeFalse())
+		})
+		It("does not match backups with different masking rules", func() {
+			backupConfig := history.BackupConfig{MaskingRules: map[string]string{"public.foo.email": "hash"}}
+
+			Expect(history.MatchesIncrementalFlags(&backupConfig, &history.BackupConfig{MaskingRules: map[string]string{"public.foo.email": "hash"}})).To(BeTrue())
+			Expect(history.MatchesIncrementalFlags(&backupConfig, &history.BackupConfig{})).To(BeFalse())
+			Expect(history.MatchesIncrementalFlags(&backupConfig, &history.BackupConfig{MaskingRules: map[string]string{"public.foo.email": "null"}})).To(BeFalse())
+		})
+		It("does not match backups that differ in whether heap table changes were recorded", func() {
+			Expect(history.MatchesIncrementalFlags(&history.BackupConfig{IncrementalHeap: true}, &history.BackupConfig{IncrementalHeap: true})).To(BeTrue())
+			Expect(history.MatchesIncrementalFlags(&history.BackupConfig{}, &history.BackupConfig{IncrementalHeap: true})).To(BeFalse())
+		})
 	})
 })
@@ -14,6 +14,8 @@ import (
 	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -58,6 +60,13 @@ func gpbackupHelper(helperPath string, args ...string) *exec.Cmd {
 	return command
 }
 
+// Runs one of the filter modes of gpbackup_helper, which read stdin and write stdout
+func runHelperFilter(helperPath string, input []byte, args ...string) ([]byte, error) {
+	command := exec.Command(helperPath, args...)
+	command.Stdin = bytes.NewReader(input)
+	return command.Output()
+}
+
 func buildAndInstallBinaries() string {
 	_ = os.Chdir("..")
 	command := exec.Command("make", "build")
@@ -131,6 +140,48 @@ var _ = Describe("gpbackup_helper end to end integration tests", func() {
 			Expect(err).ToNot(HaveOccurred())
 			assertBackupArtifacts(true, true)
 		})
+		It("runs backup gpbackup_helper with --jobs without compression", func() {
+			createPipes(2)
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "0", "--data-file", dataFileFullPath, "--jobs", "2")
+			writeToPipes(defaultData)
+			err := helperCmd.Wait()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+			assertBackupArtifacts(false, false)
+		})
+		It("runs backup gpbackup_helper with --jobs with compression", func() {
+			createPipes(2)
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "1", "--data-file", dataFileFullPath+".gz", "--jobs", "2")
+			writeToPipes(defaultData)
+			err := helperCmd.Wait()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+			assertBackupArtifacts(true, false)
+		})
+		It("runs backup gpbackup_helper with --jobs with a table read in full before the table before it", func() {
+			createPipes(2)
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "0", "--data-file", dataFileFullPath, "--jobs", "2")
+			// The second table is larger than the data buffered in memory, so the rest of it is spilled to a file
+			largeData := strings.Repeat("a", 20*1024*1024) + "\n"
+			writeToPipe(2, largeData)
+			spillFiles, err := filepath.Glob(filepath.Join(testDir, "gpbackup_helper_*", "table_*"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(spillFiles).To(HaveLen(1))
+			writeToPipe(1, defaultData)
+			writeToPipe(3, defaultData)
+			err = helperCmd.Wait()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+
+			contents, err := ioutil.ReadFile(dataFileFullPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal(defaultData + largeData + defaultData))
+			assertSegmentTOC(dataFileFullPath, []string{defaultData, largeData, defaultData})
+			spillDirs, err := filepath.Glob(filepath.Join(testDir, "gpbackup_helper_*"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(spillDirs).To(BeEmpty())
+			assertNoErrors()
+		})
 		It("Generates error file when backup agent interrupted", func() {
 			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "0", "--data-file", dataFileFullPath)
 			time.Sleep(200 * time.Millisecond)
@@ -141,6 +192,208 @@ var _ = Describe("gpbackup_helper end to end integration tests", func() {
 			assertErrorsHandled()
 		})
 	})
+	for _, compressionType := range []string{"zstd", "lz4"} {
+		compressionType := compressionType
+		extension := utils.NewPipeThroughProgram(compressionType, 0).Extension
+		Context(fmt.Sprintf("%s compression tests", compressionType), func() {
+			BeforeEach(func() {
+				if _, err := exec.LookPath(compressionType); err != nil {
+					Skip(fmt.Sprintf("%s is not installed", compressionType))
+				}
+				f, _ := os.Create(oidFile)
+				_, _ = f.WriteString("1\n2\n3\n")
+			})
+			It(fmt.Sprintf("runs backup gpbackup_helper with %s compression", compressionType), func() {
+				helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-type", compressionType, "--compression-level", "1", "--data-file", dataFileFullPath+extension)
+				writeToPipes(defaultData)
+				err := helperCmd.Wait()
+				printHelperLogOnError(err)
+				Expect(err).ToNot(HaveOccurred())
+
+				// The data of each table is a separate stream, but the data file can still be decompressed as a whole
+				contents, err := ioutil.ReadFile(dataFileFullPath + extension)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(decompressData(contents, compressionType)).To(Equal(expectedData))
+				assertSegmentTOC(dataFileFullPath+extension, []string{defaultData, defaultData, defaultData})
+				assertNoErrors()
+			})
+			It(fmt.Sprintf("runs restore gpbackup_helper with %s compression", compressionType), func() {
+				helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-type", compressionType, "--compression-level", "1", "--data-file", dataFileFullPath+extension)
+				writeToPipes(defaultData)
+				err := helperCmd.Wait()
+				printHelperLogOnError(err)
+				Expect(err).ToNot(HaveOccurred())
+
+				createPipes(1)
+				helperCmd = gpbackupHelper(gpbackupHelperPath, "--restore-agent", "--data-file", dataFileFullPath+extension)
+				for _, i := range []int{1, 2, 3} {
+					contents, _ := ioutil.ReadFile(fmt.Sprintf("%s_%d", pipeFile, i))
+					Expect(string(contents)).To(Equal(defaultData))
+				}
+				err = helperCmd.Wait()
+				printHelperLogOnError(err)
+				Expect(err).ToNot(HaveOccurred())
+				assertNoErrors()
+			})
+			It(fmt.Sprintf("runs restore gpbackup_helper with %s compression with --jobs reading tables out of order", compressionType), func() {
+				helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-type", compressionType, "--compression-level", "1", "--data-file", dataFileFullPath+extension)
+				writeToPipes(defaultData)
+				err := helperCmd.Wait()
+				printHelperLogOnError(err)
+				Expect(err).ToNot(HaveOccurred())
+
+				createPipes(1, 2)
+				helperCmd = gpbackupHelper(gpbackupHelperPath, "--restore-agent", "--data-file", dataFileFullPath+extension, "--jobs", "2")
+				for _, i := range []int{2, 1, 3} {
+					contents, _ := ioutil.ReadFile(fmt.Sprintf("%s_%d", pipeFile, i))
+					Expect(string(contents)).To(Equal(defaultData))
+				}
+				err = helperCmd.Wait()
+				printHelperLogOnError(err)
+				Expect(err).ToNot(HaveOccurred())
+				assertNoErrors()
+			})
+		})
+	}
+	Context("checksum tests", func() {
+		verifyDataFile := func(dataFile string) ([]byte, error) {
+			return exec.Command(gpbackupHelperPath, "--verify-agent", "--toc-file", tocFile, "--data-file", dataFile, "--content", "1").Output()
+		}
+		BeforeEach(func() {
+			f, _ := os.Create(oidFile)
+			_, _ = f.WriteString("1\n2\n3\n")
+		})
+		It("copies data unchanged with --checksum-file and writes its checksum and size", func() {
+			checksumFile := filepath.Join(testDir, "test_data.sha256")
+			output, err := runHelperFilter(gpbackupHelperPath, []byte(expectedData), "--checksum-file", checksumFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(output)).To(Equal(expectedData))
+
+			contents, err := ioutil.ReadFile(checksumFile)
+			Expect(err).ToNot(HaveOccurred())
+			checksum, _ := utils.GetChecksum(strings.NewReader(expectedData))
+			Expect(string(contents)).To(Equal(fmt.Sprintf("%s %d\n", checksum, len(expectedData))))
+		})
+		It("exits with its own exit code and reports the error on stderr if --checksum-file cannot be written", func() {
+			checksumFile := filepath.Join(testDir, "no_such_dir", "test_data.sha256")
+			_, err := runHelperFilter(gpbackupHelperPath, []byte(expectedData), "--checksum-file", checksumFile)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*exec.ExitError).ExitCode()).To(Equal(3))
+			Expect(string(err.(*exec.ExitError).Stderr)).To(ContainSubstring(fmt.Sprintf("gpbackup_helper: open %s: no such file or directory", checksumFile)))
+		})
+		It("prints the checksum of a single data file with --verify-agent", func() {
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "1", "--data-file", dataFileFullPath+".gz")
+			writeToPipes(defaultData)
+			err := helperCmd.Wait()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+
+			output, err := verifyDataFile(dataFileFullPath + ".gz")
+			Expect(err).ToNot(HaveOccurred())
+			contents, err := ioutil.ReadFile(dataFileFullPath + ".gz")
+			Expect(err).ToNot(HaveOccurred())
+			checksum, _ := utils.GetChecksum(bytes.NewReader(contents))
+			Expect(string(output)).To(Equal(checksum + "\n"))
+		})
+		It("detects the table whose data is corrupted in a single data file with --verify-agent", func() {
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "0", "--data-file", dataFileFullPath)
+			writeToPipes(defaultData)
+			err := helperCmd.Wait()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+
+			// Change the first byte of the data of the table with oid 2
+			contents, err := ioutil.ReadFile(dataFileFullPath)
+			Expect(err).ToNot(HaveOccurred())
+			contents[len(defaultData)] = 'H'
+			Expect(ioutil.WriteFile(dataFileFullPath, contents, 0644)).To(Succeed())
+
+			_, err = verifyDataFile(dataFileFullPath)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*exec.ExitError).ExitCode()).ToNot(Equal(0))
+			Expect(string(err.(*exec.ExitError).Stderr)).To(ContainSubstring("Checksum mismatch for data of table(s) with oid 2"))
+		})
+	})
+	Context("encryption tests", func() {
+		var keyFile, otherKeyFile string
+		BeforeEach(func() {
+			keyFile = filepath.Join(testDir, "encryption_key")
+			otherKeyFile = filepath.Join(testDir, "other_encryption_key")
+			Expect(ioutil.WriteFile(keyFile, []byte(strings.Repeat("42", utils.EncryptionKeyLength)+"\n"), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(otherKeyFile, []byte(strings.Repeat("24", utils.EncryptionKeyLength)+"\n"), 0600)).To(Succeed())
+		})
+		It("decrypts with --decrypt what was encrypted with --encrypt", func() {
+			encrypted, err := runHelperFilter(gpbackupHelperPath, []byte(expectedData), "--encrypt", "--encryption-key-file", keyFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(encrypted)).ToNot(ContainSubstring(defaultData))
+
+			decrypted, err := runHelperFilter(gpbackupHelperPath, encrypted, "--decrypt", "--encryption-key-file", keyFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(decrypted)).To(Equal(expectedData))
+		})
+		It("exits with a non-zero exit code if --decrypt is given the wrong key", func() {
+			encrypted, err := runHelperFilter(gpbackupHelperPath, []byte(expectedData), "--encrypt", "--encryption-key-file", keyFile)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = runHelperFilter(gpbackupHelperPath, encrypted, "--decrypt", "--encryption-key-file", otherKeyFile)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*exec.ExitError).ExitCode()).To(Equal(3))
+			Expect(string(err.(*exec.ExitError).Stderr)).To(HavePrefix("gpbackup_helper: "))
+		})
+		It("exits with a non-zero exit code if --decrypt is given data that is not encrypted", func() {
+			_, err := runHelperFilter(gpbackupHelperPath, []byte(expectedData), "--decrypt", "--encryption-key-file", keyFile)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*exec.ExitError).ExitCode()).To(Equal(3))
+			Expect(string(err.(*exec.ExitError).Stderr)).To(HavePrefix("gpbackup_helper: "))
+		})
+		It("runs backup and restore gpbackup_helper with encryption", func() {
+			f, _ := os.Create(oidFile)
+			_, _ = f.WriteString("1\n2\n3\n")
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "1", "--data-file", dataFileFullPath+".gz.enc", "--encryption-key-file", keyFile)
+			writeToPipes(defaultData)
+			err := helperCmd.Wait()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+
+			contents, err := ioutil.ReadFile(dataFileFullPath + ".gz.enc")
+			Expect(err).ToNot(HaveOccurred())
+			decrypted, err := runHelperFilter(gpbackupHelperPath, contents, "--decrypt", "--encryption-key-file", keyFile)
+			Expect(err).ToNot(HaveOccurred())
+			r, err := gzip.NewReader(bytes.NewReader(decrypted))
+			Expect(err).ToNot(HaveOccurred())
+			decompressed, _ := ioutil.ReadAll(r)
+			Expect(string(decompressed)).To(Equal(expectedData))
+			// The offsets of an encrypted data file cannot be used to seek
+			Expect(toc.NewSegmentTOC(tocFile).SeekableDataFile).To(BeFalse())
+
+			createPipes(1)
+			helperCmd = gpbackupHelper(gpbackupHelperPath, "--restore-agent", "--data-file", dataFileFullPath+".gz.enc", "--encryption-key-file", keyFile)
+			for _, i := range []int{1, 2, 3} {
+				contents, _ := ioutil.ReadFile(fmt.Sprintf("%s_%d", pipeFile, i))
+				Expect(string(contents)).To(Equal(defaultData))
+			}
+			err = helperCmd.Wait()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+			assertNoErrors()
+		})
+		It("fails restore gpbackup_helper with the wrong key", func() {
+			f, _ := os.Create(oidFile)
+			_, _ = f.WriteString("1\n2\n3\n")
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "1", "--data-file", dataFileFullPath+".gz.enc", "--encryption-key-file", keyFile)
+			writeToPipes(defaultData)
+			err := helperCmd.Wait()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+
+			createPipes(1)
+			helperCmd = gpbackupHelper(gpbackupHelperPath, "--restore-agent", "--data-file", dataFileFullPath+".gz.enc", "--encryption-key-file", otherKeyFile)
+			err = helperCmd.Wait()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*exec.ExitError).ExitCode()).ToNot(Equal(0))
+			assertErrorsHandled()
+		})
+	})
 	Context("restore tests", func() {
 		It("runs restore gpbackup_helper without compression", func() {
 			setupRestoreFiles(false, false)
@@ -190,6 +443,37 @@ var _ = Describe("gpbackup_helper end to end integration tests", func() {
 			Expect(err).ToNot(HaveOccurred())
 			assertNoErrors()
 		})
+		It("runs restore gpbackup_helper with --jobs reading tables out of order without compression", func() {
+			tableData := []string{"data of table 1\n", "data of table 2\n", "data of table 3\n"}
+			dataFile := setupSeekableRestoreFiles(false, tableData)
+			createPipes(3)
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--restore-agent", "--data-file", dataFile, "--jobs", "2")
+			// The table with oid 1 is only read once the table with oid 3 has been restored
+			for _, i := range []int{3, 1} {
+				contents, err := ioutil.ReadFile(fmt.Sprintf("%s_%d", pipeFile, i))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(contents)).To(Equal(tableData[i-1]))
+			}
+			err := helperCmd.Wait()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+			assertNoErrors()
+		})
+		It("runs restore gpbackup_helper with --jobs reading tables out of order with compression", func() {
+			tableData := []string{"data of table 1\n", "data of table 2\n", "data of table 3\n"}
+			dataFile := setupSeekableRestoreFiles(true, tableData)
+			createPipes(3)
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--restore-agent", "--data-file", dataFile, "--jobs", "2")
+			for _, i := range []int{3, 1} {
+				contents, err := ioutil.ReadFile(fmt.Sprintf("%s_%d", pipeFile, i))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(contents)).To(Equal(tableData[i-1]))
+			}
+			err := helperCmd.Wait()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+			assertNoErrors()
+		})
 		It("Generates error file when restore agent interrupted", func() {
 			setupRestoreFiles(true, false)
 			helperCmd := gpbackupHelper(gpbackupHelperPath, "--restore-agent", "--data-file", dataFileFullPath+".gz")
@@ -293,6 +577,41 @@ func setupRestoreFiles(withCompression bool, withPlugin bool) {
 	_, _ = f.WriteString(expectedTOC)
 }
 
+/*
+ * Writes the data of each table, with oids starting at 1, to the data file as
+ * a separate stream, along with a segment TOC that records where each table's
+ * data starts and ends in the data file.  Only the tables with oids 1 and 3
+ * are restored.
+ */
+func setupSeekableRestoreFiles(withCompression bool, tableData []string) string {
+	dataFile := dataFileFullPath
+	if withCompression {
+		dataFile += ".gz"
+	}
+	f, _ := os.Create(oidFile)
+	_, _ = f.WriteString("1\n3\n")
+
+	segmentTOC := &toc.SegmentTOC{DataEntries: make(map[uint]toc.SegmentDataEntry), SeekableDataFile: true}
+	var contents bytes.Buffer
+	var startByte uint64
+	for i, data := range tableData {
+		fileStartByte := uint64(contents.Len())
+		if withCompression {
+			gzipf := gzip.NewWriter(&contents)
+			_, _ = gzipf.Write([]byte(data))
+			_ = gzipf.Close()
+		} else {
+			contents.WriteString(data)
+		}
+		segmentTOC.AddSegmentDataEntry(uint(i+1), startByte, startByte+uint64(len(data)), "")
+		segmentTOC.SetSegmentDataFileOffsets(uint(i+1), fileStartByte, uint64(contents.Len()))
+		startByte += uint64(len(data))
+	}
+	Expect(ioutil.WriteFile(dataFile, contents.Bytes(), 0644)).To(Succeed())
+	Expect(segmentTOC.WriteToFileAndMakeReadOnly(tocFile)).To(Succeed())
+	return dataFile
+}
+
 func assertNoErrors() {
 	Expect(errorFile).To(Not(BeARegularFile()))
 	pipes, err := filepath.Glob(pipeFile + "_[1-9]*")
@@ -325,12 +644,60 @@ func assertBackupArtifacts(withCompression bool, withPlugin bool) {
 	}
 	Expect(string(contents)).To(Equal(expectedData))
 
-	contents, err = ioutil.ReadFile(tocFile)
-	Expect(err).ToNot(HaveOccurred())
-	Expect(string(contents)).To(Equal(expectedTOC))
+	if withCompression {
+		dataFile += ".gz"
+	}
+	assertSegmentTOC(dataFile, []string{defaultData, defaultData, defaultData})
 	assertNoErrors()
 }
 
+/*
+ * Checks the segment TOC entry of each table, with oids starting at 1, against
+ * its data, and that the data file offsets of each table hold only its data,
+ * compressed separately if the data file is compressed.
+ */
+func assertSegmentTOC(dataFile string, tableData []string) {
+	segmentTOC := toc.NewSegmentTOC(tocFile)
+	Expect(segmentTOC.DataEntries).To(HaveLen(len(tableData)))
+	Expect(segmentTOC.SeekableDataFile).To(BeTrue())
+	contents, err := ioutil.ReadFile(dataFile)
+	Expect(err).ToNot(HaveOccurred())
+	dataFileChecksum, _ := utils.GetChecksum(bytes.NewReader(contents))
+	Expect(segmentTOC.DataFileChecksum).To(Equal(dataFileChecksum))
+
+	var startByte uint64
+	for i, data := range tableData {
+		entry := segmentTOC.DataEntries[uint(i+1)]
+		Expect(entry.StartByte).To(Equal(startByte))
+		Expect(entry.EndByte).To(Equal(startByte + uint64(len(data))))
+		checksum, _ := utils.GetChecksum(strings.NewReader(data))
+		Expect(entry.Checksum).To(Equal(checksum))
+
+		tableContents := contents[entry.FileStartByte:entry.FileEndByte]
+		Expect(decompressData(tableContents, utils.GetCompressionTypeForFile(dataFile))).To(Equal(data))
+		startByte = entry.EndByte
+	}
+}
+
+func decompressData(contents []byte, compressionType string) string {
+	switch compressionType {
+	case "":
+		return string(contents)
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(contents))
+		Expect(err).ToNot(HaveOccurred())
+		decompressed, err := ioutil.ReadAll(r)
+		Expect(err).ToNot(HaveOccurred())
+		return string(decompressed)
+	default:
+		command := exec.Command("bash", "-c", utils.NewPipeThroughProgram(compressionType, 0).InputCommand)
+		command.Stdin = bytes.NewReader(contents)
+		decompressed, err := command.Output()
+		Expect(err).ToNot(HaveOccurred())
+		return string(decompressed)
+	}
+}
+
 func printHelperLogOnError(helperErr error) {
 	if helperErr != nil {
 		homeDir := os.Getenv("HOME")
@@ -343,18 +710,32 @@ func printHelperLogOnError(helperErr error) {
 
 func writeToPipes(data string) {
 	for i := 1; i <= 3; i++ {
-		currentPipe := fmt.Sprintf("%s_%d", pipeFile, i)
-		_, err := os.Stat(currentPipe)
-		if err != nil {
-			Fail(fmt.Sprintf("%v", err))
-		}
-		f, _ := os.Create("/tmp/tmpdata.txt")
-		_, _ = f.WriteString(data)
-		output, err := exec.Command("bash", "-c", fmt.Sprintf("cat %s > %s", "/tmp/tmpdata.txt", currentPipe)).CombinedOutput()
-		_ = f.Close()
-		_ = os.Remove("/tmp/tmpdata.txt")
+		writeToPipe(i, data)
+	}
+}
+
+func writeToPipe(oid int, data string) {
+	currentPipe := fmt.Sprintf("%s_%d", pipeFile, oid)
+	_, err := os.Stat(currentPipe)
+	if err != nil {
+		Fail(fmt.Sprintf("%v", err))
+	}
+	f, _ := os.Create("/tmp/tmpdata.txt")
+	_, _ = f.WriteString(data)
+	output, err := exec.Command("bash", "-c", fmt.Sprintf("cat %s > %s", "/tmp/tmpdata.txt", currentPipe)).CombinedOutput()
+	_ = f.Close()
+	_ = os.Remove("/tmp/tmpdata.txt")
+	if err != nil {
+		fmt.Printf("%s", output)
+		Fail(fmt.Sprintf("%v", err))
+	}
+}
+
+// gpbackup and gprestore create the pipes of the first tables, one per job
+func createPipes(oids ...int) {
+	for _, oid := range oids {
+		err := syscall.Mkfifo(fmt.Sprintf("%s_%d", pipeFile, oid), 0777)
 		if err != nil {
-			fmt.Printf("%s", output)
 			Fail(fmt.Sprintf("%v", err))
 		}
 	}
@@ -43,6 +43,43 @@ var _ = Describe("backup integration tests", func() {
 		testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf(dropTableSQL, aoCOTableFQN))
 		testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf(dropTableSQL, aoPartParentTableFQN))
 	})
+	Describe("GetHeapIncrementalMetadata", func() {
+		var heapTableFQN = "public.heap_foo"
+		BeforeEach(func() {
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf("CREATE TABLE %s (i int)", heapTableFQN))
+		})
+		AfterEach(func() {
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf(dropTableSQL, heapTableFQN))
+		})
+		It("records heap tables but not AO tables", func() {
+			heapIncrementalMetadata, statsResetTimes := backup.GetHeapIncrementalMetadata(connectionPool)
+
+			Expect(statsResetTimes).To(Not(BeEmpty()))
+			Expect(heapIncrementalMetadata).To(HaveKey(heapTableFQN))
+			Expect(heapIncrementalMetadata).To(Not(HaveKey(aoTableFQN)))
+			Expect(heapIncrementalMetadata[heapTableFQN].LastDDLTimestamp).To(Not(BeEmpty()))
+		})
+		It("detects inserted rows", func() {
+			initialHeapIncrementalMetadata, _ := backup.GetHeapIncrementalMetadata(connectionPool)
+
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf(insertSQL, heapTableFQN))
+
+			// The statistics collector receives tuple counters shortly after a transaction commits
+			Eventually(func() toc.HeapEntry {
+				heapIncrementalMetadata, _ := backup.GetHeapIncrementalMetadata(connectionPool)
+				return heapIncrementalMetadata[heapTableFQN]
+			}, "5s", "100ms").Should(Not(Equal(initialHeapIncrementalMetadata[heapTableFQN])))
+		})
+		It("detects a truncated table", func() {
+			initialHeapIncrementalMetadata, _ := backup.GetHeapIncrementalMetadata(connectionPool)
+
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf("TRUNCATE TABLE %s", heapTableFQN))
+
+			heapIncrementalMetadata, _ := backup.GetHeapIncrementalMetadata(connectionPool)
+			Expect(heapIncrementalMetadata[heapTableFQN].Relfilenode).
+				To(Not(Equal(initialHeapIncrementalMetadata[heapTableFQN].Relfilenode)))
+		})
+	})
 	Describe("GetAOIncrementalMetadata", func() {
 		Context("AO, AO_CO and AO partition tables are only just created", func() {
 			var aoIncrementalMetadata map[string]toc.AOEntry
@@ -617,6 +617,24 @@ SET SUBPARTITION TEMPLATE
 		})
 	})
 
+	Describe("GetUniqueColumns", func() {
+		It("returns the columns of primary keys, unique constraints, and unique indexes", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.some_table (i int PRIMARY KEY, j int, k int, l int, UNIQUE (i, j)) DISTRIBUTED BY (i)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.some_table")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE UNIQUE INDEX some_index ON public.some_table (i, k)")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE INDEX other_index ON public.some_table (l)")
+			oid := testutils.OidFromObjectName(connectionPool, "public", "some_table", backup.TYPE_RELATION)
+
+			result := backup.GetUniqueColumns(connectionPool, []uint32{oid})
+
+			Expect(result).To(HaveLen(1))
+			Expect(result[oid]).To(Equal(map[string]bool{"i": true, "j": true, "k": true}))
+		})
+		It("returns an empty map when no tables are given", func() {
+			result := backup.GetUniqueColumns(connectionPool, []uint32{})
+			Expect(result).To(BeEmpty())
+		})
+	})
 	Describe("GetUnloggedTables", func() {
 		It("Returns a map when an UNLOGGED table exists", func() {
 			testutils.SkipIfBefore6(connectionPool)
@@ -0,0 +1,298 @@
+package manager
+
+/*
+ * This file contains functions for consolidating a backup and the backups in
+ * its restore plan into a new, self-contained backup.
+ *
+ * The consolidated backup has the metadata of the given backup and the data
+ * files of every table in its restore plan, copied or hard-linked from the
+ * backups they were taken in, so its restore plan has a single entry and the
+ * earlier backups can be deleted.  The database is not accessed.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+func DoConsolidateBackup(timestamp string) {
+	if !filepath.IsValidTimestamp(timestamp) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", timestamp), "")
+	}
+	backupHistory := readHistory()
+	backupConfig := backupHistory.FindBackupConfigIncludingFailed(timestamp)
+	err := ValidateBackupCanBeConsolidated(backupHistory, backupConfig, timestamp)
+	gplog.FatalOnError(err)
+	initializeEncryptionForConsolidation(backupConfig)
+
+	newTimestamp := history.CurrentTimestamp()
+	if backupHistory.FindBackupConfigIncludingFailed(newTimestamp) != nil {
+		gplog.Fatal(errors.Errorf("A backup with timestamp %s already exists.  Please try again.", newTimestamp), "")
+	}
+	gplog.Info("Consolidating backup %s and the %d backups in its restore plan into backup %s",
+		timestamp, len(backupConfig.RestorePlan)-1, newTimestamp)
+
+	tocs := make(map[string]*toc.TOC, len(backupConfig.RestorePlan))
+	for _, entry := range backupConfig.RestorePlan {
+		fpInfo := GetFPInfoForBackup(backupHistory.FindBackupConfigIncludingFailed(entry.Timestamp))
+		tocs[entry.Timestamp] = toc.NewTOC(fpInfo.GetTOCFilePath())
+	}
+	consolidatedTOC, dataFiles, err := ConsolidateTOCs(backupConfig, tocs, newTimestamp)
+	gplog.FatalOnError(err)
+
+	newFPInfo := filepath.NewFilePathInfo(globalCluster, backupConfig.BackupDir, newTimestamp, segPrefix)
+	defer func() {
+		if err := recover(); err != nil {
+			gplog.Warn("Removing the incomplete consolidated backup %s", newTimestamp)
+			DeleteBackupDirectories(globalCluster, newFPInfo)
+			panic(err)
+		}
+	}()
+	CreateBackupDirectories(globalCluster, newFPInfo)
+	CopyDataFilesOnSegments(globalCluster, newFPInfo, dataFiles, getDataFileExtension(backupConfig), MustGetFlagBool(options.HARD_LINK))
+	copyMasterFiles(GetFPInfoForBackup(backupConfig), newFPInfo)
+
+	tocFilename := newFPInfo.GetTOCFilePath()
+	consolidatedTOC.WriteToFileAndMakeReadOnly(tocFilename)
+	tocChecksum, err := utils.GetFileChecksum(tocFilename)
+	gplog.FatalOnError(err)
+
+	consolidatedConfig := GetConsolidatedBackupConfig(backupConfig, consolidatedTOC, newTimestamp, tocChecksum)
+	consolidatedConfig.EndTime = history.CurrentTimestamp()
+	history.WriteConfigFile(consolidatedConfig, newFPInfo.GetConfigFilePath())
+	err = history.WriteBackupHistory(historyFilePath, consolidatedConfig)
+	gplog.FatalOnError(err)
+	gplog.Info("Backup %s consolidated successfully into backup %s", timestamp, newTimestamp)
+}
+
+/*
+ * Only backups with one data file per table in backup directories can be
+ * consolidated, as the data files of plugin backups are not stored locally
+ * and the data files of single-data-file backups cannot be split.
+ */
+func ValidateBackupCanBeConsolidated(backupHistory *history.History, backupConfig *history.BackupConfig, timestamp string) error {
+	if backupConfig == nil {
+		return errors.Errorf("Backup with timestamp %s not found in history file %s", timestamp, historyFilePath)
+	}
+	if backupConfig.Deleted() {
+		return errors.Errorf("Backup %s was deleted on %s", timestamp, backupConfig.DateDeleted)
+	}
+	if backupConfig.Failed() {
+		return errors.Errorf("Backup %s failed and cannot be consolidated", timestamp)
+	}
+	if backupConfig.MetadataOnly {
+		return errors.Errorf("Backup %s is a metadata-only backup and cannot be consolidated", timestamp)
+	}
+	if backupConfig.Plugin != "" {
+		return errors.Errorf("Backup %s was taken using plugin %s.  Only backups in backup directories can be consolidated.",
+			timestamp, backupConfig.Plugin)
+	}
+	if backupConfig.SingleDataFile {
+		return errors.Errorf("Backup %s is a single-data-file backup.  Only backups with one data file per table can be consolidated.", timestamp)
+	}
+	if len(backupConfig.RestorePlan) < 2 {
+		return errors.Errorf("The restore plan of backup %s does not include any other backups, so it is already self-contained", timestamp)
+	}
+	for _, entry := range backupConfig.RestorePlan {
+		if entry.Timestamp == timestamp {
+			continue
+		}
+		planConfig := backupHistory.FindBackupConfigIncludingFailed(entry.Timestamp)
+		if planConfig == nil {
+			return errors.Errorf("Backup %s in the restore plan of backup %s not found in history file %s", entry.Timestamp, timestamp, historyFilePath)
+		}
+		if planConfig.Deleted() {
+			return errors.Errorf("Backup %s in the restore plan of backup %s was deleted on %s", entry.Timestamp, timestamp, planConfig.DateDeleted)
+		}
+		if planConfig.Failed() && backupConfig.ResumedFrom != entry.Timestamp {
+			return errors.Errorf("Backup %s in the restore plan of backup %s failed", entry.Timestamp, timestamp)
+		}
+		if planConfig.BackupDir != backupConfig.BackupDir || planConfig.Plugin != "" || planConfig.SingleDataFile ||
+			planConfig.GetCompressionType() != backupConfig.GetCompressionType() ||
+			planConfig.EncryptionKeyFingerprint != backupConfig.EncryptionKeyFingerprint {
+			return errors.Errorf("Backup %s in the restore plan of backup %s was not taken with the same backup directory, compression, and encryption",
+				entry.Timestamp, timestamp)
+		}
+	}
+	return nil
+}
+
+/*
+ * The TOC and config of an encrypted backup are decrypted and encrypted again
+ * on the master, while data files are copied as they are, so the key is not
+ * needed on the segments.
+ */
+func initializeEncryptionForConsolidation(backupConfig *history.BackupConfig) {
+	keySource, err := utils.ReadEncryptionKeySource(MustGetFlagString(options.ENCRYPTION_KEY_FILE), MustGetFlagString(options.ENCRYPTION_PASSPHRASE_FILE))
+	gplog.FatalOnError(err)
+	if !backupConfig.Encrypted {
+		if keySource != nil {
+			gplog.Warn("Backup with timestamp %s is not encrypted; the encryption key will be ignored", backupConfig.Timestamp)
+		}
+		return
+	}
+	if keySource == nil {
+		gplog.Fatal(errors.Errorf("Backup with timestamp %s is encrypted.  Please specify --%s or --%s.",
+			backupConfig.Timestamp, options.ENCRYPTION_KEY_FILE, options.ENCRYPTION_PASSPHRASE_FILE), "")
+	}
+	key, err := keySource.DeriveKey(backupConfig.EncryptionSalt)
+	gplog.FatalOnError(err)
+	err = utils.ValidateEncryptionKeyFingerprint(key, backupConfig.EncryptionKeyFingerprint)
+	gplog.FatalOnError(err)
+	utils.SetEncryptionKey(key)
+}
+
+/*
+ * Returns the TOC of the consolidated backup, which is the TOC of the given
+ * backup with the data entries and checksums of each table taken from the TOC
+ * of the backup its restore plan restores it from, along with the timestamp
+ * and oid of each data file to be copied, as "<timestamp> <oid>".
+ */
+func ConsolidateTOCs(backupConfig *history.BackupConfig, tocs map[string]*toc.TOC, newTimestamp string) (*toc.TOC, []string, error) {
+	backupTOC := tocs[backupConfig.Timestamp]
+	consolidatedTOC := *backupTOC
+	consolidatedTOC.DataEntries = make([]toc.MasterDataEntry, 0)
+	consolidatedTOC.DataChecksums = nil
+	consolidatedTOC.DataSizes = nil
+	consolidatedTOC.MetadataChecksums = nil
+	for filename, checksum := range backupTOC.MetadataChecksums {
+		consolidatedTOC.AddMetadataChecksum(strings.Replace(filename, backupConfig.Timestamp, newTimestamp, 1), checksum)
+	}
+
+	dataFiles := make([]string, 0)
+	oidTables := make(map[uint32]string)
+	for _, planEntry := range backupConfig.RestorePlan {
+		planTOC, ok := tocs[planEntry.Timestamp]
+		if !ok {
+			return nil, nil, errors.Errorf("The TOC of backup %s was not read", planEntry.Timestamp)
+		}
+		dataEntries := make(map[string]toc.MasterDataEntry, len(planTOC.DataEntries))
+		for _, dataEntry := range planTOC.DataEntries {
+			dataEntries[utils.MakeFQN(dataEntry.Schema, dataEntry.Name)] = dataEntry
+		}
+		for _, tableFQN := range planEntry.TableFQNs {
+			dataEntry, ok := dataEntries[tableFQN]
+			if !ok {
+				return nil, nil, errors.Errorf("Table %s is not in the TOC of backup %s", tableFQN, planEntry.Timestamp)
+			}
+			if otherTableFQN, ok := oidTables[dataEntry.Oid]; ok {
+				return nil, nil, errors.Errorf("Tables %s and %s in the restore plan have the same oid %d", otherTableFQN, tableFQN, dataEntry.Oid)
+			}
+			oidTables[dataEntry.Oid] = tableFQN
+			consolidatedTOC.DataEntries = append(consolidatedTOC.DataEntries, dataEntry)
+			for contentID, tableChecksums := range planTOC.DataChecksums {
+				if checksum, ok := tableChecksums[dataEntry.Oid]; ok {
+					consolidatedTOC.AddDataChecksum(contentID, dataEntry.Oid, checksum)
+				}
+			}
+			for contentID, tableSizes := range planTOC.DataSizes {
+				if size, ok := tableSizes[dataEntry.Oid]; ok {
+					consolidatedTOC.AddDataSize(contentID, dataEntry.Oid, size)
+				}
+			}
+			dataFiles = append(dataFiles, fmt.Sprintf("%s %d", planEntry.Timestamp, dataEntry.Oid))
+		}
+	}
+	return &consolidatedTOC, dataFiles, nil
+}
+
+/*
+ * The consolidated backup starts at its own timestamp, the time of the
+ * consolidation, and has not been replicated, as the replicas of the backup it
+ * is made from hold that backup's files rather than its own.
+ */
+func GetConsolidatedBackupConfig(backupConfig *history.BackupConfig, consolidatedTOC *toc.TOC, newTimestamp string, tocChecksum string) *history.BackupConfig {
+	consolidatedConfig := *backupConfig
+	consolidatedConfig.Timestamp = newTimestamp
+	consolidatedConfig.EndTime = ""
+	consolidatedConfig.Replicas = nil
+	consolidatedConfig.Incremental = false
+	consolidatedConfig.ResumedFrom = ""
+	consolidatedConfig.DateDeleted = ""
+	consolidatedConfig.Status = history.BackupStatusSucceed
+	consolidatedConfig.TOCChecksum = tocChecksum
+	consolidatedConfig.ConsolidatedFrom = make([]string, 0, len(backupConfig.RestorePlan))
+	for _, entry := range backupConfig.RestorePlan {
+		consolidatedConfig.ConsolidatedFrom = append(consolidatedConfig.ConsolidatedFrom, entry.Timestamp)
+	}
+	tableFQNs := make([]string, 0, len(consolidatedTOC.DataEntries))
+	for _, dataEntry := range consolidatedTOC.DataEntries {
+		tableFQNs = append(tableFQNs, utils.MakeFQN(dataEntry.Schema, dataEntry.Name))
+	}
+	sort.Strings(tableFQNs)
+	consolidatedConfig.RestorePlan = []history.RestorePlanEntry{{Timestamp: newTimestamp, TableFQNs: tableFQNs}}
+	return &consolidatedConfig
+}
+
+func getDataFileExtension(backupConfig *history.BackupConfig) string {
+	extension := ""
+	if backupConfig.Compressed {
+		extension = utils.NewPipeThroughProgram(backupConfig.GetCompressionType(), 0).Extension
+	}
+	if backupConfig.Encrypted {
+		extension += utils.EncryptionExtension
+	}
+	return extension
+}
+
+func CreateBackupDirectories(c *cluster.Cluster, fpInfo filepath.FilePathInfo) {
+	remoteOutput := c.GenerateAndExecuteCommand("Creating backup directories on all hosts",
+		func(contentID int) string {
+			return fmt.Sprintf("mkdir -p %s", fpInfo.GetDirForContent(contentID))
+		}, cluster.ON_SEGMENTS_AND_MASTER)
+	c.CheckClusterError(remoteOutput, "Unable to create backup directories", func(contentID int) string {
+		return fmt.Sprintf("Unable to create backup directory %s on host %s", fpInfo.GetDirForContent(contentID), c.GetHostForContent(contentID))
+	})
+}
+
+/*
+ * The list of data files is copied to each segment, and each file is copied
+ * or hard-linked from the directory of the backup it was taken in, which is
+ * next to the directory of the consolidated backup.
+ */
+func CopyDataFilesOnSegments(c *cluster.Cluster, fpInfo filepath.FilePathInfo, dataFiles []string, extension string, hardLink bool) {
+	utils.WriteOidListToSegments(dataFiles, c, fpInfo)
+	defer utils.CleanUpHelperFilesOnAllHosts(c, fpInfo)
+
+	copyCommand := "cp -p"
+	if hardLink {
+		copyCommand = "ln"
+	}
+	remoteOutput := c.GenerateAndExecuteCommand("Copying data files", func(contentID int) string {
+		backupsDir := path.Dir(path.Dir(fpInfo.GetDirForContent(contentID)))
+		source := fmt.Sprintf("%s/${ts:0:8}/${ts}/gpbackup_%d_${ts}_${oid}%s", backupsDir, contentID, extension)
+		destination := path.Join(fpInfo.GetDirForContent(contentID), fmt.Sprintf("gpbackup_%d_%s_${oid}%s", contentID, fpInfo.Timestamp, extension))
+		return fmt.Sprintf(`while read ts oid; do %s %s %s || exit 1; done < %s`,
+			copyCommand, source, destination, fpInfo.GetSegmentHelperFilePath(contentID, "oid"))
+	}, cluster.ON_SEGMENTS)
+	c.CheckClusterError(remoteOutput, "Unable to copy data files", func(contentID int) string {
+		return fmt.Sprintf("Unable to copy data files on segment %d on host %s", contentID, c.GetHostForContent(contentID))
+	})
+}
+
+/*
+ * The metadata and statistics files of the backup are copied unchanged, as
+ * they do not refer to its timestamp.
+ */
+func copyMasterFiles(fpInfo filepath.FilePathInfo, newFPInfo filepath.FilePathInfo) {
+	for _, filetype := range []string{"metadata", "statistics"} {
+		filename := fpInfo.GetBackupFilePath(filetype)
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			continue
+		}
+		err := utils.CopyFile(filename, newFPInfo.GetBackupFilePath(filetype))
+		gplog.FatalOnError(err, fmt.Sprintf("Unable to copy %s", filename))
+	}
+}
@@ -0,0 +1,185 @@
+package manager_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/toc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/consolidate tests", func() {
+	var (
+		backupHistory *history.History
+		incremental   *history.BackupConfig
+	)
+	BeforeEach(func() {
+		manager.SetHistoryFilePath("/tmp/history_file.yaml")
+		backupHistory = &history.History{BackupConfigs: []history.BackupConfig{
+			{Timestamp: "20190103010101", Incremental: true, Status: history.BackupStatusSucceed, Compressed: true,
+				RestorePlan: []history.RestorePlanEntry{
+					{Timestamp: "20190101010101", TableFQNs: []string{"public.ao1"}},
+					{Timestamp: "20190102010101", TableFQNs: []string{"public.heap1"}},
+					{Timestamp: "20190103010101", TableFQNs: []string{"public.ao2"}},
+				}},
+			{Timestamp: "20190102010101", Incremental: true, Status: history.BackupStatusSucceed, Compressed: true,
+				RestorePlan: []history.RestorePlanEntry{
+					{Timestamp: "20190101010101", TableFQNs: []string{"public.ao1", "public.ao2"}},
+					{Timestamp: "20190102010101", TableFQNs: []string{"public.heap1"}},
+				}},
+			{Timestamp: "20190101010101", Status: history.BackupStatusSucceed, Compressed: true,
+				RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190101010101", TableFQNs: []string{"public.ao1", "public.ao2", "public.heap1"}}}},
+		}}
+		incremental = backupHistory.FindBackupConfigIncludingFailed("20190103010101")
+	})
+	Describe("ValidateBackupCanBeConsolidated", func() {
+		It("allows consolidating an incremental backup", func() {
+			err := manager.ValidateBackupCanBeConsolidated(backupHistory, incremental, "20190103010101")
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("returns an error for a backup that is already self-contained", func() {
+			fullBackup := backupHistory.FindBackupConfigIncludingFailed("20190101010101")
+			err := manager.ValidateBackupCanBeConsolidated(backupHistory, fullBackup, "20190101010101")
+			Expect(err).To(MatchError("The restore plan of backup 20190101010101 does not include any other backups, so it is already self-contained"))
+		})
+		It("returns an error for a plugin backup", func() {
+			incremental.Plugin = "gpbackup_s3_plugin"
+			err := manager.ValidateBackupCanBeConsolidated(backupHistory, incremental, "20190103010101")
+			Expect(err).To(MatchError("Backup 20190103010101 was taken using plugin gpbackup_s3_plugin.  Only backups in backup directories can be consolidated."))
+		})
+		It("returns an error if a backup in the restore plan was deleted", func() {
+			backupHistory.FindBackupConfigIncludingFailed("20190101010101").DateDeleted = "20190104010101"
+			err := manager.ValidateBackupCanBeConsolidated(backupHistory, incremental, "20190103010101")
+			Expect(err).To(MatchError("Backup 20190101010101 in the restore plan of backup 20190103010101 was deleted on 20190104010101"))
+		})
+		It("returns an error if a backup in the restore plan was compressed differently", func() {
+			backupHistory.FindBackupConfigIncludingFailed("20190102010101").CompressionType = "zstd"
+			err := manager.ValidateBackupCanBeConsolidated(backupHistory, incremental, "20190103010101")
+			Expect(err).To(MatchError("Backup 20190102010101 in the restore plan of backup 20190103010101 was not taken with the same backup directory, compression, and encryption"))
+		})
+	})
+	Describe("ConsolidateTOCs", func() {
+		var tocs map[string]*toc.TOC
+		BeforeEach(func() {
+			tocs = map[string]*toc.TOC{
+				"20190101010101": {
+					DataEntries: []toc.MasterDataEntry{
+						{Schema: "public", Name: "ao1", Oid: 1},
+						{Schema: "public", Name: "ao2", Oid: 2},
+						{Schema: "public", Name: "heap1", Oid: 3},
+					},
+					DataChecksums: map[int]map[uint32]string{0: {1: "ao1_0", 2: "ao2_0_old", 3: "heap1_0_old"}},
+				},
+				"20190102010101": {
+					DataEntries:   []toc.MasterDataEntry{{Schema: "public", Name: "heap1", Oid: 3}},
+					DataChecksums: map[int]map[uint32]string{0: {3: "heap1_0"}},
+				},
+				"20190103010101": {
+					DataEntries:       []toc.MasterDataEntry{{Schema: "public", Name: "ao2", Oid: 2, RowsCopied: 10}},
+					DataChecksums:     map[int]map[uint32]string{0: {2: "ao2_0"}},
+					MetadataChecksums: map[string]string{"gpbackup_20190103010101_metadata.sql": "metadata"},
+					IncrementalMetadata: toc.IncrementalEntries{
+						AO: map[string]toc.AOEntry{"public.ao2": {Modcount: 2}},
+					},
+				},
+			}
+		})
+		It("takes the data entry and checksums of each table from the backup the restore plan restores it from", func() {
+			consolidatedTOC, dataFiles, err := manager.ConsolidateTOCs(incremental, tocs, "20190105010101")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(consolidatedTOC.DataEntries).To(Equal([]toc.MasterDataEntry{
+				{Schema: "public", Name: "ao1", Oid: 1},
+				{Schema: "public", Name: "heap1", Oid: 3},
+				{Schema: "public", Name: "ao2", Oid: 2, RowsCopied: 10},
+			}))
+			Expect(consolidatedTOC.DataChecksums).To(Equal(map[int]map[uint32]string{0: {1: "ao1_0", 2: "ao2_0", 3: "heap1_0"}}))
+			Expect(dataFiles).To(Equal([]string{"20190101010101 1", "20190102010101 3", "20190103010101 2"}))
+		})
+		It("takes the data sizes of each table from the backup the restore plan restores it from", func() {
+			tocs["20190101010101"].DataSizes = map[int]map[uint32]int64{0: {1: 10, 2: 20, 3: 30}}
+			tocs["20190102010101"].DataSizes = map[int]map[uint32]int64{0: {3: 31}}
+
+			consolidatedTOC, _, err := manager.ConsolidateTOCs(incremental, tocs, "20190105010101")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(consolidatedTOC.DataSizes).To(Equal(map[int]map[uint32]int64{0: {1: 10, 3: 31}}))
+		})
+		It("keeps the metadata checksums and incremental metadata of the backup", func() {
+			consolidatedTOC, _, err := manager.ConsolidateTOCs(incremental, tocs, "20190105010101")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(consolidatedTOC.MetadataChecksums).To(Equal(map[string]string{"gpbackup_20190105010101_metadata.sql": "metadata"}))
+			Expect(consolidatedTOC.IncrementalMetadata).To(Equal(tocs["20190103010101"].IncrementalMetadata))
+			Expect(tocs["20190103010101"].DataEntries).To(HaveLen(1))
+		})
+		It("returns an error if a table in the restore plan is not in the TOC of its backup", func() {
+			tocs["20190102010101"].DataEntries = []toc.MasterDataEntry{}
+
+			_, _, err := manager.ConsolidateTOCs(incremental, tocs, "20190105010101")
+			Expect(err).To(MatchError("Table public.heap1 is not in the TOC of backup 20190102010101"))
+		})
+	})
+	Describe("GetConsolidatedBackupConfig", func() {
+		It("makes a full backup config with a single restore plan entry", func() {
+			consolidatedTOC := &toc.TOC{DataEntries: []toc.MasterDataEntry{
+				{Schema: "public", Name: "heap1", Oid: 3},
+				{Schema: "public", Name: "ao1", Oid: 1},
+			}}
+
+			config := manager.GetConsolidatedBackupConfig(incremental, consolidatedTOC, "20190105010101", "checksum")
+
+			Expect(config.Timestamp).To(Equal("20190105010101"))
+			Expect(config.Incremental).To(BeFalse())
+			Expect(config.Compressed).To(BeTrue())
+			Expect(config.TOCChecksum).To(Equal("checksum"))
+			Expect(config.ConsolidatedFrom).To(Equal([]string{"20190101010101", "20190102010101", "20190103010101"}))
+			Expect(config.RestorePlan).To(Equal([]history.RestorePlanEntry{
+				{Timestamp: "20190105010101", TableFQNs: []string{"public.ao1", "public.heap1"}},
+			}))
+			Expect(incremental.Timestamp).To(Equal("20190103010101"))
+		})
+		It("does not carry over the replicas or end time of the backup it is made from", func() {
+			incremental.EndTime = "20190103020202"
+			incremental.Replicas = []history.Replica{{Plugin: "gpbackup_s3_plugin", Destination: "bucket1", PluginVersion: "1.0.0"}}
+
+			config := manager.GetConsolidatedBackupConfig(incremental, &toc.TOC{}, "20190105010101", "checksum")
+
+			Expect(config.Timestamp).To(Equal("20190105010101"))
+			Expect(config.EndTime).To(BeEmpty())
+			Expect(config.Replicas).To(BeNil())
+			Expect(incremental.Replicas).To(HaveLen(1))
+		})
+	})
+	Describe("CopyDataFilesOnSegments", func() {
+		var testCluster *cluster.Cluster
+		var executor testutils.TestExecutorMultiple
+		var fpInfo filepath.FilePathInfo
+
+		BeforeEach(func() {
+			testCluster = testutils.SetDefaultSegmentConfiguration()
+			executor = testutils.TestExecutorMultiple{
+				ClusterOutputs: []*cluster.RemoteOutput{{}},
+			}
+			testCluster.Executor = &executor
+			fpInfo = filepath.NewFilePathInfo(testCluster, "/backup_dir", "20190105010101", "gpseg")
+		})
+		It("copies each data file from the backup it was taken in", func() {
+			manager.CopyDataFilesOnSegments(testCluster, fpInfo, []string{"20190101010101 1"}, ".gz", false)
+
+			Expect(executor.NumRemoteExecutions).To(Equal(3))
+			Expect(executor.ClusterCommands[1][0]).To(ContainElement(MatchRegexp(
+				`^while read ts oid; do cp -p /backup_dir/gpseg0/backups/\$\{ts:0:8\}/\$\{ts\}/gpbackup_0_\$\{ts\}_\$\{oid\}\.gz ` +
+					`/backup_dir/gpseg0/backups/20190105/20190105010101/gpbackup_0_20190105010101_\$\{oid\}\.gz \|\| exit 1; done < `)))
+		})
+		It("hard-links data files if requested", func() {
+			manager.CopyDataFilesOnSegments(testCluster, fpInfo, []string{"20190101010101 1"}, "", true)
+
+			Expect(executor.ClusterCommands[1][1]).To(ContainElement(MatchRegexp(`^while read ts oid; do ln /backup_dir/gpseg1/backups/`)))
+		})
+	})
+})
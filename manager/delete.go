@@ -0,0 +1,113 @@
+package manager
+
+/*
+ * This file contains functions for deleting a backup from the master, the
+ * segments, and (if applicable) the plugin storage location.
+ */
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+func DoDeleteBackup(timestamp string) {
+	if !filepath.IsValidTimestamp(timestamp) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", timestamp), "")
+	}
+	pluginConfigFile := MustGetFlagString(options.PLUGIN_CONFIG)
+	err := utils.ValidateFullPath(pluginConfigFile)
+	gplog.FatalOnError(err)
+
+	backupHistory := readHistory()
+	backupConfig := backupHistory.FindBackupConfigIncludingFailed(timestamp)
+	err = ValidateBackupCanBeDeleted(backupHistory, backupConfig, timestamp, pluginConfigFile)
+	gplog.FatalOnError(err)
+
+	deleteBackup(backupConfig, pluginConfigFile)
+}
+
+/*
+ * The in-memory history entry is marked as deleted as well, so that callers
+ * deleting several backups in turn see the effect of earlier deletions.
+ */
+func deleteBackup(backupConfig *history.BackupConfig, pluginConfigFile string) {
+	gplog.Info("Deleting backup %s", backupConfig.Timestamp)
+	if backupConfig.Plugin != "" {
+		deleteBackupUsingPlugin(backupConfig, pluginConfigFile)
+	}
+	DeleteBackupDirectories(globalCluster, GetFPInfoForBackup(backupConfig))
+
+	dateDeleted := history.CurrentTimestamp()
+	err := history.MarkBackupDeleted(historyFilePath, backupConfig.Timestamp, dateDeleted)
+	gplog.FatalOnError(err)
+	backupConfig.DateDeleted = dateDeleted
+	gplog.Info("Backup %s deleted successfully", backupConfig.Timestamp)
+}
+
+/*
+ * A backup that is referenced by the restore plan of a later incremental
+ * backup cannot be deleted on its own, as that would make the incremental
+ * backup impossible to restore.
+ */
+func ValidateBackupCanBeDeleted(backupHistory *history.History, backupConfig *history.BackupConfig, timestamp string, pluginConfigFile string) error {
+	if backupConfig == nil {
+		return errors.Errorf("Backup with timestamp %s not found in history file %s", timestamp, historyFilePath)
+	}
+	if backupConfig.Deleted() {
+		return errors.Errorf("Backup %s was already deleted on %s", timestamp, backupConfig.DateDeleted)
+	}
+	if dependents := backupHistory.FindDependentBackups(timestamp); len(dependents) > 0 {
+		return errors.Errorf("Backup %s cannot be deleted, as the following incremental backups depend on it: %s",
+			timestamp, strings.Join(dependents, ", "))
+	}
+	if backupConfig.Plugin != "" && pluginConfigFile == "" {
+		return errors.Errorf("Backup %s was taken using plugin %s.  The --%s flag is required to delete it.",
+			timestamp, backupConfig.Plugin, options.PLUGIN_CONFIG)
+	}
+	if backupConfig.Plugin == "" && pluginConfigFile != "" {
+		return errors.Errorf("Backup %s was not taken using a plugin.  The --%s flag cannot be used to delete it.",
+			timestamp, options.PLUGIN_CONFIG)
+	}
+	return nil
+}
+
+func setupPlugin(pluginConfigFile string) {
+	if pluginConfig != nil {
+		return
+	}
+	var err error
+	pluginConfig, err = utils.ReadPluginConfig(pluginConfigFile)
+	gplog.FatalOnError(err)
+	pluginConfig.CheckPluginExistsOnAllHosts(globalCluster)
+	pluginName, err = pluginConfig.GetPluginName(globalCluster)
+	gplog.FatalOnError(err)
+	pluginConfig.CopyPluginConfigToAllHosts(globalCluster)
+}
+
+func deleteBackupUsingPlugin(backupConfig *history.BackupConfig, pluginConfigFile string) {
+	setupPlugin(pluginConfigFile)
+	if pluginName != backupConfig.Plugin {
+		gplog.Fatal(errors.Errorf("Backup %s was taken using plugin %s, but the plugin config file specifies plugin %s",
+			backupConfig.Timestamp, backupConfig.Plugin, pluginName), "")
+	}
+	err := pluginConfig.DeleteBackup(backupConfig.Timestamp)
+	gplog.FatalOnError(err)
+}
+
+func DeleteBackupDirectories(c *cluster.Cluster, fpInfo filepath.FilePathInfo) {
+	remoteOutput := c.GenerateAndExecuteCommand("Deleting backup directories on all hosts",
+		func(contentID int) string {
+			return fmt.Sprintf("rm -rf %s", fpInfo.GetDirForContent(contentID))
+		}, cluster.ON_SEGMENTS_AND_MASTER)
+	c.CheckClusterError(remoteOutput, "Unable to delete backup directories", func(contentID int) string {
+		return fmt.Sprintf("Unable to delete backup directory %s on host %s", fpInfo.GetDirForContent(contentID), c.GetHostForContent(contentID))
+	})
+}
@@ -0,0 +1,95 @@
+package manager_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/delete tests", func() {
+	Describe("ValidateBackupCanBeDeleted", func() {
+		var backupHistory *history.History
+
+		BeforeEach(func() {
+			manager.SetHistoryFilePath("/tmp/history_file.yaml")
+			backupHistory = &history.History{BackupConfigs: []history.BackupConfig{
+				{Timestamp: "20190103010101", Incremental: true, Status: history.BackupStatusSucceed,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190101010101"}, {Timestamp: "20190103010101"}}},
+				{Timestamp: "20190102010101", Plugin: "gpbackup_s3_plugin", Status: history.BackupStatusSucceed,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190102010101"}}},
+				{Timestamp: "20190101010101", Status: history.BackupStatusSucceed,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190101010101"}}},
+				{Timestamp: "20181231010101", Status: history.BackupStatusSucceed, DateDeleted: "20190101120000"},
+			}}
+		})
+		It("allows deleting a backup that no other backup depends on", func() {
+			backupConfig := backupHistory.FindBackupConfigIncludingFailed("20190103010101")
+			err := manager.ValidateBackupCanBeDeleted(backupHistory, backupConfig, "20190103010101", "")
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("returns an error if the backup is not in the history file", func() {
+			err := manager.ValidateBackupCanBeDeleted(backupHistory, nil, "20170101010101", "")
+			Expect(err).To(MatchError("Backup with timestamp 20170101010101 not found in history file /tmp/history_file.yaml"))
+		})
+		It("returns an error if the backup was already deleted", func() {
+			backupConfig := backupHistory.FindBackupConfigIncludingFailed("20181231010101")
+			err := manager.ValidateBackupCanBeDeleted(backupHistory, backupConfig, "20181231010101", "")
+			Expect(err).To(MatchError("Backup 20181231010101 was already deleted on 20190101120000"))
+		})
+		It("returns an error if an incremental backup depends on the backup", func() {
+			backupConfig := backupHistory.FindBackupConfigIncludingFailed("20190101010101")
+			err := manager.ValidateBackupCanBeDeleted(backupHistory, backupConfig, "20190101010101", "")
+			Expect(err).To(MatchError("Backup 20190101010101 cannot be deleted, as the following incremental backups depend on it: 20190103010101"))
+		})
+		It("returns an error if a plugin backup is deleted without a plugin config", func() {
+			backupConfig := backupHistory.FindBackupConfigIncludingFailed("20190102010101")
+			err := manager.ValidateBackupCanBeDeleted(backupHistory, backupConfig, "20190102010101", "")
+			Expect(err).To(MatchError("Backup 20190102010101 was taken using plugin gpbackup_s3_plugin.  The --plugin-config flag is required to delete it."))
+		})
+		It("returns an error if a plugin config is passed for a backup that did not use a plugin", func() {
+			backupConfig := backupHistory.FindBackupConfigIncludingFailed("20190103010101")
+			err := manager.ValidateBackupCanBeDeleted(backupHistory, backupConfig, "20190103010101", "/tmp/plugin_config.yaml")
+			Expect(err).To(MatchError("Backup 20190103010101 was not taken using a plugin.  The --plugin-config flag cannot be used to delete it."))
+		})
+	})
+	Describe("DeleteBackupDirectories", func() {
+		var testCluster *cluster.Cluster
+		var executor testutils.TestExecutorMultiple
+		var fpInfo filepath.FilePathInfo
+
+		BeforeEach(func() {
+			testCluster = testutils.SetDefaultSegmentConfiguration()
+			executor = testutils.TestExecutorMultiple{
+				ClusterOutputs: []*cluster.RemoteOutput{{}},
+			}
+			testCluster.Executor = &executor
+			fpInfo = filepath.NewFilePathInfo(testCluster, "/backup_dir", "20190101010101", "gpseg")
+		})
+		It("removes the backup directory on the master and every segment", func() {
+			manager.DeleteBackupDirectories(testCluster, fpInfo)
+
+			Expect(executor.NumRemoteExecutions).To(Equal(1))
+			commands := executor.ClusterCommands[0]
+			Expect(commands[-1]).To(ContainElement("rm -rf /backup_dir/gpseg-1/backups/20190101/20190101010101"))
+			Expect(commands[0]).To(ContainElement("rm -rf /backup_dir/gpseg0/backups/20190101/20190101010101"))
+			Expect(commands[1]).To(ContainElement("rm -rf /backup_dir/gpseg1/backups/20190101/20190101010101"))
+		})
+		It("panics if a directory cannot be removed", func() {
+			executor.ClusterOutputs[0] = &cluster.RemoteOutput{
+				NumErrors: 1,
+				Errors:    map[int]error{0: errors.New("permission denied")},
+				Stderrs:   map[int]string{0: "permission denied"},
+				CmdStrs:   map[int]string{0: "rm -rf /backup_dir/gpseg0/backups/20190101/20190101010101"},
+			}
+			defer testhelper.ShouldPanicWithMessage("Unable to delete backup directories")
+			manager.DeleteBackupDirectories(testCluster, fpInfo)
+		})
+	})
+})
@@ -0,0 +1,92 @@
+package manager
+
+import (
+	"sync"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/pflag"
+)
+
+/*
+ * This file contains global variables and setter functions for those variables
+ * used in testing.
+ */
+
+/*
+ * Non-flag variables
+ */
+
+var (
+	connectionPool  *dbconn.DBConn
+	globalCluster   *cluster.Cluster
+	historyFilePath string
+	pluginConfig    *utils.PluginConfig
+	pluginName      string
+	segPrefix       string
+	version         string
+	wasTerminated   bool
+	/*
+	 * Used for synchronizing DoCleanup.  In DoInit() we increment the group
+	 * and then wait for at least one DoCleanup to finish, either in DoTeardown
+	 * or the signal handler.
+	 */
+	CleanupGroup *sync.WaitGroup
+)
+
+/*
+ * Command-line flags
+ */
+var cmdFlags *pflag.FlagSet
+
+/*
+ * Setter functions
+ */
+
+func SetCmdFlags(flagSet *pflag.FlagSet) {
+	cmdFlags = flagSet
+}
+
+func SetConnection(conn *dbconn.DBConn) {
+	connectionPool = conn
+}
+
+func SetCluster(cluster *cluster.Cluster) {
+	globalCluster = cluster
+}
+
+func SetHistoryFilePath(filePath string) {
+	historyFilePath = filePath
+}
+
+func SetPluginConfig(config *utils.PluginConfig) {
+	pluginConfig = config
+}
+
+func SetSegPrefix(prefix string) {
+	segPrefix = prefix
+}
+
+// Util functions to enable ease of access to global flag values
+
+func MustGetFlagString(flagName string) string {
+	return options.MustGetFlagString(cmdFlags, flagName)
+}
+
+func MustGetFlagInt(flagName string) int {
+	return options.MustGetFlagInt(cmdFlags, flagName)
+}
+
+func MustGetFlagBool(flagName string) bool {
+	return options.MustGetFlagBool(cmdFlags, flagName)
+}
+
+func GetVersion() string {
+	return version
+}
+
+func SetVersion(v string) {
+	version = v
+}
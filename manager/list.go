@@ -0,0 +1,251 @@
+package manager
+
+/*
+ * This file contains functions for listing and describing backups recorded in
+ * the backup history file.
+ */
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+const BackupStatusDeleted = "Deleted"
+
+func DoListBackups() {
+	backupHistory := readHistory()
+	fpInfoMap := make(map[string]filepath.FilePathInfo, 0)
+	for i := range backupHistory.BackupConfigs {
+		backupConfig := &backupHistory.BackupConfigs[i]
+		if backupConfig.Plugin == "" && !backupConfig.Deleted() {
+			fpInfoMap[backupConfig.Timestamp] = GetFPInfoForBackup(backupConfig)
+		}
+	}
+	sizes := GetBackupSizes(globalCluster, fpInfoMap)
+	PrintBackupList(os.Stdout, backupHistory.BackupConfigs, sizes)
+}
+
+func GetBackupType(backupConfig *history.BackupConfig) string {
+	switch {
+	case backupConfig.MetadataOnly:
+		return "metadata-only"
+	case backupConfig.DataOnly:
+		return "data-only"
+	case backupConfig.Incremental:
+		return "incremental"
+	default:
+		return "full"
+	}
+}
+
+func GetBackupStatus(backupConfig *history.BackupConfig) string {
+	if backupConfig.Deleted() {
+		return BackupStatusDeleted
+	}
+	if backupConfig.Status == "" {
+		// Backups taken before the status field was recorded can only have succeeded
+		return history.BackupStatusSucceed
+	}
+	return backupConfig.Status
+}
+
+/*
+ * Backup directories are measured with a single du call per segment, and the
+ * per-directory sizes are then summed per timestamp. Directories that no
+ * longer exist are silently ignored.
+ */
+func GetBackupSizes(c *cluster.Cluster, fpInfoMap map[string]filepath.FilePathInfo) map[string]int64 {
+	sizes := make(map[string]int64, 0)
+	if len(fpInfoMap) == 0 {
+		return sizes
+	}
+	timestamps := make([]string, 0)
+	for timestamp := range fpInfoMap {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Strings(timestamps)
+
+	remoteOutput := c.GenerateAndExecuteCommand("Calculating backup sizes on all hosts",
+		func(contentID int) string {
+			dirs := make([]string, 0)
+			for _, timestamp := range timestamps {
+				fpInfo := fpInfoMap[timestamp]
+				dirs = append(dirs, fpInfo.GetDirForContent(contentID))
+			}
+			return fmt.Sprintf("du -sk %s 2>/dev/null; true", strings.Join(dirs, " "))
+		}, cluster.ON_SEGMENTS_AND_MASTER)
+	c.CheckClusterError(remoteOutput, "Unable to calculate backup sizes", func(contentID int) string {
+		return fmt.Sprintf("Unable to calculate backup sizes for segment %d on host %s", contentID, c.GetHostForContent(contentID))
+	}, true)
+
+	for contentID, stdout := range remoteOutput.Stdouts {
+		dirToTimestamp := make(map[string]string, 0)
+		for timestamp, fpInfo := range fpInfoMap {
+			dirToTimestamp[fpInfo.GetDirForContent(contentID)] = timestamp
+		}
+		for _, line := range strings.Split(stdout, "\n") {
+			fields := strings.SplitN(strings.TrimSpace(line), "\t", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			timestamp, ok := dirToTimestamp[fields[1]]
+			if !ok {
+				continue
+			}
+			size, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				gplog.Verbose("Unable to parse size of directory %s: %s", fields[1], fields[0])
+				continue
+			}
+			sizes[timestamp] += size
+		}
+	}
+	return sizes
+}
+
+func FormatBackupSize(sizeInKB int64) string {
+	units := []string{"KB", "MB", "GB", "TB"}
+	size := float64(sizeInKB)
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", sizeInKB, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
+
+func PrintBackupList(writer io.Writer, backupConfigs []history.BackupConfig, sizes map[string]int64) {
+	tabWriter := tabwriter.NewWriter(writer, 0, 0, 3, ' ', 0)
+	utils.MustPrintf(tabWriter, "TIMESTAMP\tDATABASE\tTYPE\tSTATUS\tPLUGIN\tSIZE\tDATE DELETED\n")
+	for i := range backupConfigs {
+		backupConfig := &backupConfigs[i]
+		plugin := backupConfig.Plugin
+		if plugin == "" {
+			plugin = "-"
+		}
+		size := "N/A"
+		if backupSize, ok := sizes[backupConfig.Timestamp]; ok {
+			size = FormatBackupSize(backupSize)
+		}
+		dateDeleted := backupConfig.DateDeleted
+		if dateDeleted == "" {
+			dateDeleted = "-"
+		}
+		utils.MustPrintf(tabWriter, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", backupConfig.Timestamp,
+			backupConfig.DatabaseName, GetBackupType(backupConfig), GetBackupStatus(backupConfig),
+			plugin, size, dateDeleted)
+	}
+	_ = tabWriter.Flush()
+}
+
+func DoDescribeBackup(timestamp string) {
+	if !filepath.IsValidTimestamp(timestamp) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", timestamp), "")
+	}
+	backupConfig := readHistory().FindBackupConfigIncludingFailed(timestamp)
+	if backupConfig == nil {
+		gplog.Fatal(errors.Errorf("Backup with timestamp %s not found in history file %s", timestamp, historyFilePath), "")
+	}
+	PrintBackupDescription(os.Stdout, backupConfig)
+	for _, entry := range backupConfig.RestorePlan {
+		gplog.Verbose("Tables restored from backup %s: %s", entry.Timestamp, strings.Join(entry.TableFQNs, ", "))
+	}
+}
+
+func GetBackupDescription(backupConfig *history.BackupConfig) []report.LineInfo {
+	backupDir := backupConfig.BackupDir
+	if backupDir == "" {
+		backupDir = "<master and segment data directories>"
+	}
+	plugin := backupConfig.Plugin
+	if plugin != "" && backupConfig.PluginVersion != "" {
+		plugin = fmt.Sprintf("%s %s", plugin, backupConfig.PluginVersion)
+	}
+
+	compression := "None"
+	if backupConfig.Compressed {
+		compression = backupConfig.GetCompressionType()
+	}
+	encryption := "None"
+	if backupConfig.Encrypted {
+		encryption = fmt.Sprintf("AES-256-GCM (key fingerprint %s)", backupConfig.EncryptionKeyFingerprint)
+	}
+
+	description := []report.LineInfo{
+		{Key: "timestamp key:", Value: backupConfig.Timestamp},
+		{Key: "end time:", Value: backupConfig.EndTime},
+		{Key: "status:", Value: GetBackupStatus(backupConfig)},
+		{Key: "date deleted:", Value: backupConfig.DateDeleted},
+		{Key: "gpdb version:", Value: backupConfig.DatabaseVersion},
+		{Key: "gpbackup version:", Value: backupConfig.BackupVersion},
+		{Key: "database name:", Value: backupConfig.DatabaseName},
+		{Key: "backup type:", Value: GetBackupType(backupConfig)},
+		{Key: "resumed from:", Value: backupConfig.ResumedFrom},
+		{Key: "consolidated from:", Value: strings.Join(backupConfig.ConsolidatedFrom, ", ")},
+		{Key: "backup directory:", Value: backupDir},
+		{Key: "plugin:", Value: plugin},
+		{Key: "compression:", Value: compression},
+		{Key: "encryption:", Value: encryption},
+		{Key: "single data file:", Value: strconv.FormatBool(backupConfig.SingleDataFile)},
+		{Key: "leaf partition data:", Value: strconv.FormatBool(backupConfig.LeafPartitionData)},
+		{Key: "with statistics:", Value: strconv.FormatBool(backupConfig.WithStatistics)},
+		{Key: "without globals:", Value: strconv.FormatBool(backupConfig.WithoutGlobals)},
+		{Key: "include schemas:", Value: strings.Join(backupConfig.IncludeSchemas, ", ")},
+		{Key: "exclude schemas:", Value: strings.Join(backupConfig.ExcludeSchemas, ", ")},
+		{Key: "include tables:", Value: strings.Join(backupConfig.IncludeRelations, ", ")},
+		{Key: "exclude tables:", Value: strings.Join(backupConfig.ExcludeRelations, ", ")},
+		{Key: "exclude types:", Value: strings.Join(backupConfig.ExcludeObjectTypes, ", ")},
+	}
+
+	if len(backupConfig.RestorePlan) > 0 {
+		description = append(description, report.LineInfo{}, report.LineInfo{Key: "restore plan:"})
+		for _, entry := range backupConfig.RestorePlan {
+			description = append(description, report.LineInfo{Key: fmt.Sprintf("  %s:", entry.Timestamp),
+				Value: fmt.Sprintf("%d tables", len(entry.TableFQNs))})
+		}
+	}
+	if len(backupConfig.Replicas) > 0 {
+		description = append(description, report.LineInfo{}, report.LineInfo{Key: "replicas:"})
+		for _, replica := range backupConfig.Replicas {
+			plugin := replica.Plugin
+			if replica.PluginVersion != "" {
+				plugin = fmt.Sprintf("%s %s", plugin, replica.PluginVersion)
+			}
+			description = append(description, report.LineInfo{Key: fmt.Sprintf("  %s:", replica.DateReplicated), Value: plugin})
+		}
+	}
+	return description
+}
+
+func PrintBackupDescription(writer io.Writer, backupConfig *history.BackupConfig) {
+	description := GetBackupDescription(backupConfig)
+	maxSize := 0
+	for _, lineInfo := range description {
+		if len(lineInfo.Key) > maxSize {
+			maxSize = len(lineInfo.Key)
+		}
+	}
+	for _, lineInfo := range description {
+		if lineInfo.Key == "" {
+			utils.MustPrintf(writer, "\n")
+		} else {
+			utils.MustPrintf(writer, "%-*s%s\n", maxSize+3, lineInfo.Key, lineInfo.Value)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package manager_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/testutils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/list tests", func() {
+	Describe("GetBackupType", func() {
+		It("returns the type of each kind of backup", func() {
+			Expect(manager.GetBackupType(&history.BackupConfig{})).To(Equal("full"))
+			Expect(manager.GetBackupType(&history.BackupConfig{Incremental: true})).To(Equal("incremental"))
+			Expect(manager.GetBackupType(&history.BackupConfig{MetadataOnly: true})).To(Equal("metadata-only"))
+			Expect(manager.GetBackupType(&history.BackupConfig{DataOnly: true})).To(Equal("data-only"))
+		})
+	})
+	Describe("GetBackupStatus", func() {
+		It("returns the recorded status of a backup", func() {
+			Expect(manager.GetBackupStatus(&history.BackupConfig{Status: history.BackupStatusFailed})).To(Equal(history.BackupStatusFailed))
+		})
+		It("treats a backup without a recorded status as successful", func() {
+			Expect(manager.GetBackupStatus(&history.BackupConfig{})).To(Equal(history.BackupStatusSucceed))
+		})
+		It("reports a deleted backup as deleted", func() {
+			Expect(manager.GetBackupStatus(&history.BackupConfig{Status: history.BackupStatusSucceed, DateDeleted: "20190101010101"})).To(Equal(manager.BackupStatusDeleted))
+		})
+	})
+	Describe("FormatBackupSize", func() {
+		It("formats sizes in the largest appropriate unit", func() {
+			Expect(manager.FormatBackupSize(12)).To(Equal("12 KB"))
+			Expect(manager.FormatBackupSize(1536)).To(Equal("1.5 MB"))
+			Expect(manager.FormatBackupSize(3 * 1024 * 1024)).To(Equal("3.0 GB"))
+			Expect(manager.FormatBackupSize(2 * 1024 * 1024 * 1024 * 1024)).To(Equal("2048.0 TB"))
+		})
+	})
+	Describe("GetBackupSizes", func() {
+		var testCluster *cluster.Cluster
+		var executor testutils.TestExecutorMultiple
+		var fpInfoMap map[string]filepath.FilePathInfo
+
+		BeforeEach(func() {
+			testCluster = testutils.SetDefaultSegmentConfiguration()
+			executor = testutils.TestExecutorMultiple{
+				ClusterOutputs: []*cluster.RemoteOutput{{
+					Stdouts: map[int]string{
+						-1: "4\tgpseg-1/backups/20190101/20190101010101\n8\tgpseg-1/backups/20190102/20190102010101\n",
+						0:  "100\tgpseg0/backups/20190101/20190101010101\n",
+						1:  "200\tgpseg1/backups/20190101/20190101010101\nnot a du line\n",
+					},
+				}},
+			}
+			testCluster.Executor = &executor
+			fpInfoMap = map[string]filepath.FilePathInfo{
+				"20190101010101": filepath.NewFilePathInfo(testCluster, "", "20190101010101", "gpseg"),
+				"20190102010101": filepath.NewFilePathInfo(testCluster, "", "20190102010101", "gpseg"),
+			}
+		})
+		It("measures all backup directories with one command per segment", func() {
+			_ = manager.GetBackupSizes(testCluster, fpInfoMap)
+
+			Expect(executor.NumRemoteExecutions).To(Equal(1))
+			Expect(executor.ClusterCommands[0][0]).To(ContainElement("du -sk gpseg0/backups/20190101/20190101010101 gpseg0/backups/20190102/20190102010101 2>/dev/null; true"))
+		})
+		It("sums the sizes of each backup across all segments", func() {
+			sizes := manager.GetBackupSizes(testCluster, fpInfoMap)
+
+			Expect(sizes).To(Equal(map[string]int64{"20190101010101": 304, "20190102010101": 8}))
+		})
+		It("does not run a command when there are no backups to measure", func() {
+			sizes := manager.GetBackupSizes(testCluster, map[string]filepath.FilePathInfo{})
+
+			Expect(sizes).To(BeEmpty())
+			Expect(executor.NumRemoteExecutions).To(Equal(0))
+		})
+	})
+	Describe("PrintBackupList", func() {
+		It("prints one row per backup", func() {
+			backupConfigs := []history.BackupConfig{
+				{Timestamp: "20190102010101", DatabaseName: "testdb", Incremental: true, Status: history.BackupStatusSucceed},
+				{Timestamp: "20190101010101", DatabaseName: "testdb", Plugin: "gpbackup_s3_plugin", Status: history.BackupStatusFailed},
+				{Timestamp: "20181231010101", DatabaseName: "otherdb", Status: history.BackupStatusSucceed, DateDeleted: "20190101120000"},
+			}
+			manager.PrintBackupList(buffer, backupConfigs, map[string]int64{"20190102010101": 2048})
+
+			Expect(string(buffer.Contents())).To(Equal(`TIMESTAMP        DATABASE   TYPE          STATUS    PLUGIN               SIZE     DATE DELETED
+20190102010101   testdb     incremental   Success   -                    2.0 MB   -
+20190101010101   testdb     full          Failure   gpbackup_s3_plugin   N/A      -
+20181231010101   otherdb    full          Deleted   -                    N/A      20190101120000
+`))
+		})
+	})
+	Describe("PrintBackupDescription", func() {
+		It("prints the backup configuration and restore plan", func() {
+			backupConfig := history.BackupConfig{
+				Timestamp: "20190102010101", EndTime: "20190102010202", DatabaseName: "testdb",
+				DatabaseVersion: "6.0.0", BackupVersion: "1.15.0", Incremental: true, Compressed: true,
+				Plugin: "gpbackup_s3_plugin", PluginVersion: "1.2.3", Status: history.BackupStatusSucceed,
+				IncludeSchemas: []string{"public", "foo"},
+				RestorePlan: []history.RestorePlanEntry{
+					{Timestamp: "20190101010101", TableFQNs: []string{"public.t1"}},
+					{Timestamp: "20190102010101", TableFQNs: []string{"public.t2", "foo.t3"}},
+				},
+			}
+			manager.PrintBackupDescription(buffer, &backupConfig)
+
+			contents := string(buffer.Contents())
+			Expect(contents).To(ContainSubstring("timestamp key:         20190102010101\n"))
+			Expect(contents).To(ContainSubstring("backup type:           incremental\n"))
+			Expect(contents).To(ContainSubstring("backup directory:      <master and segment data directories>\n"))
+			Expect(contents).To(ContainSubstring("plugin:                gpbackup_s3_plugin 1.2.3\n"))
+			Expect(contents).To(ContainSubstring("compression:           gzip\n"))
+			Expect(contents).To(ContainSubstring("encryption:            None\n"))
+			Expect(contents).To(ContainSubstring("include schemas:       public, foo\n"))
+			Expect(contents).To(ContainSubstring("\nrestore plan:          \n  20190101010101:      1 tables\n  20190102010101:      2 tables\n"))
+		})
+		It("prints the key fingerprint of an encrypted backup", func() {
+			backupConfig := history.BackupConfig{Timestamp: "20190102010101", Encrypted: true, EncryptionKeyFingerprint: "0123456789abcdef"}
+			manager.PrintBackupDescription(buffer, &backupConfig)
+
+			Expect(string(buffer.Contents())).To(ContainSubstring("encryption:            AES-256-GCM (key fingerprint 0123456789abcdef)\n"))
+		})
+		It("prints the replicas of the backup", func() {
+			backupConfig := history.BackupConfig{Timestamp: "20190102010101",
+				Replicas: []history.Replica{{Plugin: "gpbackup_s3_plugin", PluginVersion: "1.2.3", DateReplicated: "20190103010101"}}}
+			manager.PrintBackupDescription(buffer, &backupConfig)
+
+			Expect(string(buffer.Contents())).To(ContainSubstring("\nreplicas:              \n  20190103010101:      gpbackup_s3_plugin 1.2.3\n"))
+		})
+		It("prints the object types excluded from the backup", func() {
+			backupConfig := history.BackupConfig{Timestamp: "20190102010101", ExcludeObjectTypes: []string{"CAST", "TRIGGER"}}
+			manager.PrintBackupDescription(buffer, &backupConfig)
+
+			Expect(string(buffer.Contents())).To(ContainSubstring("exclude types:         CAST, TRIGGER\n"))
+		})
+		It("prints the timestamp of the backup that a resumed backup was resumed from", func() {
+			backupConfig := history.BackupConfig{Timestamp: "20190102010101", ResumedFrom: "20190101010101"}
+			manager.PrintBackupDescription(buffer, &backupConfig)
+
+			Expect(string(buffer.Contents())).To(ContainSubstring("resumed from:          20190101010101\n"))
+		})
+	})
+})
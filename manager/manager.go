@@ -0,0 +1,187 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// This function handles setup that can be done before parsing flags.
+func DoInit(cmd *cobra.Command) {
+	CleanupGroup = &sync.WaitGroup{}
+	CleanupGroup.Add(1)
+	gplog.InitializeLogging("gpbackup_manager", "")
+	options.SetManagerFlagDefaults(cmd.PersistentFlags())
+
+	listCmd := &cobra.Command{
+		Use:   "list-backups",
+		Short: "List all backups in the backup history file along with their status and size",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			DoSetup(cmd)
+			DoListBackups()
+		}}
+	describeCmd := &cobra.Command{
+		Use:   "describe-backup <timestamp>",
+		Short: "Display the configuration and restore plan of a single backup",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			DoSetup(cmd)
+			DoDescribeBackup(args[0])
+		}}
+	deleteCmd := &cobra.Command{
+		Use:   "delete-backup <timestamp>",
+		Short: "Delete the files of a backup on the master and all segments and mark it as deleted",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			DoSetup(cmd)
+			DoDeleteBackup(args[0])
+		}}
+	options.SetManagerDeleteFlagDefaults(deleteCmd.Flags())
+	consolidateCmd := &cobra.Command{
+		Use:   "consolidate-backup <timestamp>",
+		Short: "Merge a backup and the backups in its restore plan into a new, self-contained backup",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			DoSetup(cmd)
+			DoConsolidateBackup(args[0])
+		}}
+	options.SetManagerConsolidateFlagDefaults(consolidateCmd.Flags())
+	replicateCmd := &cobra.Command{
+		Use:   "replicate-backup <timestamp>",
+		Short: "Copy a backup and the backups in its restore plan to the storage location of a plugin",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			DoSetup(cmd)
+			DoReplicateBackup(args[0])
+		}}
+	options.SetManagerReplicateFlagDefaults(replicateCmd.Flags())
+	validateChainCmd := &cobra.Command{
+		Use:   "validate-chain [timestamp]",
+		Short: "Check that the backups in the restore plan of a backup, or of every backup, are complete and compatible",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			DoSetup(cmd)
+			DoValidateChain(args)
+		}}
+	options.SetManagerValidateChainFlagDefaults(validateChainCmd.Flags())
+	pruneCmd := &cobra.Command{
+		Use:   "prune-backups",
+		Short: "Delete the backups that have expired according to a retention policy",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			DoSetup(cmd)
+			DoPruneBackups()
+		}}
+	options.SetManagerPruneFlagDefaults(pruneCmd.Flags())
+
+	cmd.AddCommand(listCmd, describeCmd, deleteCmd, consolidateCmd, replicateCmd, validateChainCmd, pruneCmd)
+	utils.InitializeSignalHandler(DoCleanup, "gpbackup_manager process", &wasTerminated)
+}
+
+// This function handles setup that must be done after parsing flags.
+func DoSetup(cmd *cobra.Command) {
+	SetCmdFlags(cmd.Flags())
+	options.CheckExclusiveFlags(cmdFlags, options.DEBUG, options.QUIET, options.VERBOSE)
+	SetLoggerVerbosity()
+	gplog.Verbose("Manager Command: %s", os.Args)
+
+	connectionPool = dbconn.NewDBConnFromEnvironment("postgres")
+	connectionPool.MustConnect(1)
+	utils.ValidateGPDBVersionCompatibility(connectionPool)
+
+	segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
+	globalCluster = cluster.NewCluster(segConfig)
+	segPrefix = filepath.GetSegPrefix(connectionPool)
+
+	fpInfo := filepath.NewFilePathInfo(globalCluster, "", "", "")
+	historyFilePath = fpInfo.GetBackupHistoryFilePath()
+	if !iohelper.FileExistsAndIsReadable(historyFilePath) {
+		gplog.Fatal(errors.Errorf("Backup history file %s does not exist or is not readable", historyFilePath), "")
+	}
+}
+
+func SetLoggerVerbosity() {
+	if MustGetFlagBool(options.QUIET) {
+		gplog.SetVerbosity(gplog.LOGERROR)
+	} else if MustGetFlagBool(options.DEBUG) {
+		gplog.SetVerbosity(gplog.LOGDEBUG)
+	} else if MustGetFlagBool(options.VERBOSE) {
+		gplog.SetVerbosity(gplog.LOGVERBOSE)
+	}
+}
+
+func readHistory() *history.History {
+	backupHistory, err := history.NewHistory(historyFilePath)
+	gplog.FatalOnError(err)
+	return backupHistory
+}
+
+func GetFPInfoForBackup(backupConfig *history.BackupConfig) filepath.FilePathInfo {
+	return filepath.NewFilePathInfo(globalCluster, backupConfig.BackupDir, backupConfig.Timestamp, segPrefix)
+}
+
+func DoTeardown() {
+	defer func() {
+		DoCleanup(false)
+		os.Exit(gplog.GetErrorCode())
+	}()
+
+	errStr := ""
+	if err := recover(); err != nil {
+		// Check if gplog.Fatal did not cause the panic
+		if gplog.GetErrorCode() != 2 {
+			gplog.Error(fmt.Sprintf("%v: %s", err, debug.Stack()))
+			gplog.SetErrorCode(2)
+		} else {
+			errStr = fmt.Sprintf("%v", err)
+		}
+	}
+	if wasTerminated {
+		/*
+		 * Don't print an error if the command was canceled, as the signal handler
+		 * will take care of cleanup and return codes.  Just wait until the signal
+		 * handler's DoCleanup completes so the main goroutine doesn't exit while
+		 * cleanup is still in progress.
+		 */
+		CleanupGroup.Wait()
+		return
+	}
+	if errStr != "" {
+		fmt.Println(errStr)
+	}
+}
+
+func DoCleanup(commandFailed bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			gplog.Warn("Encountered error during cleanup: %v", err)
+		}
+		gplog.Verbose("Cleanup complete")
+		CleanupGroup.Done()
+	}()
+
+	gplog.Verbose("Beginning cleanup")
+	if connectionPool != nil {
+		connectionPool.Close()
+	}
+}
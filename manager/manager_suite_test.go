@@ -0,0 +1,34 @@
+package manager_test
+
+import (
+	"testing"
+
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/spf13/pflag"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+)
+
+var (
+	stdout  *Buffer
+	logfile *Buffer
+	buffer  *Buffer
+)
+
+func TestManager(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "manager tests")
+}
+
+var cmdFlags *pflag.FlagSet
+
+var _ = BeforeEach(func() {
+	_, _, stdout, _, logfile = testutils.SetupTestEnvironment()
+	buffer = NewBuffer()
+
+	cmdFlags = pflag.NewFlagSet("gpbackup_manager", pflag.ExitOnError)
+	manager.SetCmdFlags(cmdFlags)
+})
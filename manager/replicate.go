@@ -0,0 +1,330 @@
+package manager
+
+/*
+ * This file contains functions for replicating a backup, along with the
+ * backups in its restore plan, from a backup directory or the storage
+ * location of one plugin to the storage location of another plugin.
+ *
+ * Plugin backups are always stored under the default backup directories in
+ * the master and segment data directories, so the files of a backup taken to
+ * a user-specified backup directory are passed to the plugin under those
+ * paths instead.  The config file of the replica names the new plugin, and
+ * the replica is recorded in the history file, so that the backup can be
+ * restored using gprestore --plugin-config.
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+type ReplicatedFile struct {
+	Path     string
+	Required bool
+}
+
+func DoReplicateBackup(timestamp string) {
+	if !filepath.IsValidTimestamp(timestamp) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", timestamp), "")
+	}
+	destPluginConfigFile := MustGetFlagString(options.PLUGIN_CONFIG)
+	if destPluginConfigFile == "" {
+		gplog.Fatal(errors.Errorf("The --%s flag is required to replicate a backup.", options.PLUGIN_CONFIG), "")
+	}
+	sourcePluginConfigFile := MustGetFlagString(options.SOURCE_PLUGIN_CONFIG)
+	for _, pluginConfigFile := range []string{destPluginConfigFile, sourcePluginConfigFile} {
+		err := utils.ValidateFullPath(pluginConfigFile)
+		gplog.FatalOnError(err)
+	}
+	keySource, err := utils.ReadEncryptionKeySource(MustGetFlagString(options.ENCRYPTION_KEY_FILE), MustGetFlagString(options.ENCRYPTION_PASSPHRASE_FILE))
+	gplog.FatalOnError(err)
+
+	backupHistory := readHistory()
+	backupConfig := backupHistory.FindBackupConfigIncludingFailed(timestamp)
+	err = ValidateBackupCanBeReplicated(backupHistory, backupConfig, timestamp, sourcePluginConfigFile)
+	gplog.FatalOnError(err)
+
+	fpInfo := filepath.NewFilePathInfo(globalCluster, "", timestamp, segPrefix)
+	destPlugin := readReplicationPluginConfig(destPluginConfigFile, "")
+	destPluginVersion := destPlugin.CheckPluginExistsOnAllHosts(globalCluster)
+	destPluginName := path.Base(destPlugin.ExecutablePath)
+	destination := destPlugin.GetDestination()
+	destPlugin.CopyPluginConfigToAllHosts(globalCluster)
+	destPlugin.SetupPluginForBackup(globalCluster, fpInfo)
+	defer func() {
+		destPlugin.CleanupPluginForBackup(globalCluster, fpInfo)
+		destPlugin.DeletePluginConfigWhenEncrypting(globalCluster)
+	}()
+
+	var sourcePlugin *utils.PluginConfig
+	if sourcePluginConfigFile != "" {
+		sourcePlugin = readReplicationPluginConfig(sourcePluginConfigFile, "source_")
+		sourcePlugin.CheckPluginExistsOnAllHosts(globalCluster)
+		sourcePlugin.SetBackupPluginVersion(timestamp, backupConfig.PluginVersion)
+		sourcePlugin.CopyPluginConfigToAllHosts(globalCluster)
+		sourcePlugin.SetupPluginForRestore(globalCluster, fpInfo)
+		defer func() {
+			sourcePlugin.CleanupPluginForRestore(globalCluster, fpInfo)
+			sourcePlugin.DeletePluginConfigWhenEncrypting(globalCluster)
+		}()
+	}
+
+	for _, planTimestamp := range GetBackupsToReplicate(backupHistory, backupConfig, destPluginName, destination) {
+		planConfig := backupHistory.FindBackupConfigIncludingFailed(planTimestamp)
+		replicateBackup(planConfig, sourcePlugin, destPlugin, destPluginConfigFile, destPluginName, destPluginVersion, keySource)
+		err = history.AddBackupReplica(historyFilePath, planTimestamp, history.Replica{
+			Plugin:         destPluginName,
+			Destination:    destination,
+			PluginVersion:  destPluginVersion,
+			DateReplicated: history.CurrentTimestamp(),
+		})
+		gplog.FatalOnError(err)
+		gplog.Info("Backup %s replicated successfully using plugin %s", planTimestamp, destPluginName)
+	}
+}
+
+/*
+ * A backup in a backup directory can only be replicated if it is not in the
+ * default backup directories, as that is where the files of its replica are
+ * passed to the plugin from.
+ */
+func ValidateBackupCanBeReplicated(backupHistory *history.History, backupConfig *history.BackupConfig, timestamp string, sourcePluginConfigFile string) error {
+	if backupConfig == nil {
+		return errors.Errorf("Backup with timestamp %s not found in history file %s", timestamp, historyFilePath)
+	}
+	if backupConfig.Deleted() {
+		return errors.Errorf("Backup %s was deleted on %s", timestamp, backupConfig.DateDeleted)
+	}
+	if backupConfig.Failed() {
+		return errors.Errorf("Backup %s failed and cannot be replicated", timestamp)
+	}
+	if backupConfig.Plugin != "" && sourcePluginConfigFile == "" {
+		return errors.Errorf("Backup %s was taken using plugin %s.  The --%s flag is required to replicate it.",
+			timestamp, backupConfig.Plugin, options.SOURCE_PLUGIN_CONFIG)
+	}
+	if backupConfig.Plugin == "" && sourcePluginConfigFile != "" {
+		return errors.Errorf("Backup %s was not taken using a plugin.  The --%s flag cannot be used to replicate it.",
+			timestamp, options.SOURCE_PLUGIN_CONFIG)
+	}
+	if backupConfig.Plugin == "" && backupConfig.BackupDir == "" {
+		return errors.Errorf("Backup %s is in the default backup directories, where the files of its replica would be written.  Only backups taken with --%s or a plugin can be replicated.",
+			timestamp, options.BACKUP_DIR)
+	}
+	if problems := ValidateRestorePlan(backupHistory, backupConfig); len(problems) > 0 {
+		return errors.Errorf("Backup %s cannot be replicated, as its restore plan is broken: %s", timestamp, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+/*
+ * Returns the timestamps of the backups in the restore plan of the backup
+ * that have not already been replicated to the destination of the plugin, as
+ * the replica of an incremental backup can only be restored if the backups it
+ * depends on were replicated to the same place.  The backup itself is always
+ * replicated.
+ */
+func GetBackupsToReplicate(backupHistory *history.History, backupConfig *history.BackupConfig, pluginName string, destination string) []string {
+	timestamps := make([]string, 0, len(backupConfig.RestorePlan))
+	for _, entry := range backupConfig.RestorePlan {
+		if entry.Timestamp != backupConfig.Timestamp {
+			planConfig := backupHistory.FindBackupConfigIncludingFailed(entry.Timestamp)
+			if planConfig != nil && planConfig.FindReplica(pluginName, destination) != nil {
+				continue
+			}
+		}
+		timestamps = append(timestamps, entry.Timestamp)
+	}
+	return timestamps
+}
+
+/*
+ * The source and destination plugin config files may have the same name, so
+ * each is copied to the hosts under a name of its own.
+ */
+func readReplicationPluginConfig(pluginConfigFile string, prefix string) *utils.PluginConfig {
+	plugin, err := utils.ReadPluginConfig(pluginConfigFile)
+	gplog.FatalOnError(err)
+	configFilename := path.Base(plugin.ConfigPath)
+	configDirname := path.Dir(plugin.ConfigPath)
+	plugin.ConfigPath = path.Join(configDirname, fmt.Sprintf("%s_%s%s", history.CurrentTimestamp(), prefix, configFilename))
+	return plugin
+}
+
+/*
+ * The config file is passed to the plugin last, as gpbackup does, so that a
+ * replica that was interrupted cannot be mistaken for a complete one.
+ */
+func replicateBackup(backupConfig *history.BackupConfig, sourcePlugin *utils.PluginConfig, destPlugin *utils.PluginConfig,
+	destPluginConfigFile string, destPluginName string, destPluginVersion string, keySource *utils.EncryptionKeySource) {
+	gplog.Info("Replicating backup %s using plugin %s", backupConfig.Timestamp, destPluginName)
+	err := setEncryptionKeyForBackup(backupConfig, keySource)
+	if err != nil {
+		gplog.Fatal(errors.Errorf("Backup %s: %v", backupConfig.Timestamp, err), "")
+	}
+	defer utils.SetEncryptionKey(nil)
+
+	sourceFPInfo := GetFPInfoForBackup(backupConfig)
+	destFPInfo := filepath.NewFilePathInfo(globalCluster, "", backupConfig.Timestamp, segPrefix)
+	for _, file := range GetMasterFilesToReplicate(backupConfig, destFPInfo) {
+		if replicateMasterFile(sourceFPInfo, destFPInfo, file, sourcePlugin) {
+			destPlugin.MustBackupFile(file.Path)
+		}
+	}
+
+	if !backupConfig.MetadataOnly {
+		tocFilename := destFPInfo.GetTOCFilePath()
+		tocfile, err := readTOC(tocFilename)
+		gplog.FatalOnError(err, fmt.Sprintf("Unable to read TOC file %s", tocFilename))
+		segmentFiles := GetSegmentFilesToReplicate(backupConfig, sourceFPInfo, destFPInfo, tocfile)
+		if len(segmentFiles) > 0 {
+			ReplicateFilesOnSegments(globalCluster, destFPInfo, segmentFiles, sourcePlugin, destPlugin)
+		}
+	}
+
+	pluginConfigFilename := destFPInfo.GetPluginConfigPath()
+	_ = os.Remove(pluginConfigFilename)
+	err = utils.CopyFile(destPluginConfigFile, pluginConfigFilename)
+	gplog.FatalOnError(err)
+	destPlugin.MustBackupFile(pluginConfigFilename)
+
+	configFilename := destFPInfo.GetConfigFilePath()
+	replicateMasterFile(sourceFPInfo, destFPInfo, ReplicatedFile{Path: configFilename, Required: true}, sourcePlugin)
+	configContents, err := ioutil.ReadFile(configFilename)
+	gplog.FatalOnError(err)
+	replicaConfig := GetReplicaConfig(history.ReadConfigFile(configFilename), destPluginName, destPluginVersion)
+	history.WriteConfigFile(replicaConfig, configFilename)
+	destPlugin.MustBackupFile(configFilename)
+	if sourcePlugin != nil {
+		// The config file in the master data directory is that of the backup, not of its replica
+		err = utils.WriteToFileAndMakeReadOnly(configFilename, configContents)
+		gplog.FatalOnError(err)
+	}
+}
+
+/*
+ * Returns the master files to pass to the plugin, other than the config file
+ * and plugin config file, under their paths in the default backup directory.
+ * The reports are only passed if they exist.
+ */
+func GetMasterFilesToReplicate(backupConfig *history.BackupConfig, destFPInfo filepath.FilePathInfo) []ReplicatedFile {
+	files := []ReplicatedFile{{Path: destFPInfo.GetTOCFilePath(), Required: true}}
+	if !backupConfig.DataOnly {
+		files = append(files, ReplicatedFile{Path: destFPInfo.GetMetadataFilePath(), Required: true})
+	}
+	if backupConfig.WithStatistics {
+		files = append(files, ReplicatedFile{Path: destFPInfo.GetStatisticsFilePath(), Required: true})
+	}
+	reportFilename := destFPInfo.GetBackupReportFilePath()
+	files = append(files, ReplicatedFile{Path: reportFilename})
+	for _, format := range []string{"json", "yaml"} {
+		files = append(files, ReplicatedFile{Path: report.GetMachineReadableReportFilePath(reportFilename, format)})
+	}
+	return files
+}
+
+/*
+ * Places a master file at its path in the default backup directory, by
+ * copying it from the backup directory of the backup or by restoring it using
+ * the source plugin, and returns whether the file exists.
+ */
+func replicateMasterFile(sourceFPInfo filepath.FilePathInfo, destFPInfo filepath.FilePathInfo, file ReplicatedFile, sourcePlugin *utils.PluginConfig) bool {
+	var err error
+	if sourcePlugin != nil {
+		err = sourcePlugin.RestoreFile(file.Path)
+	} else {
+		sourcePath := strings.Replace(file.Path, destFPInfo.GetDirForContent(-1), sourceFPInfo.GetDirForContent(-1), 1)
+		if _, err = os.Stat(sourcePath); err == nil {
+			err = operating.System.MkdirAll(path.Dir(file.Path), 0755)
+			if err == nil {
+				_ = os.Remove(file.Path)
+				err = utils.CopyFile(sourcePath, file.Path)
+			}
+		}
+	}
+	if err != nil {
+		if file.Required {
+			gplog.Fatal(err, fmt.Sprintf("Unable to replicate %s", file.Path))
+		}
+		gplog.Verbose("Skipping %s, which could not be found: %v", file.Path, err)
+		return false
+	}
+	return true
+}
+
+func GetReplicaConfig(backupConfig *history.BackupConfig, pluginName string, pluginVersion string) *history.BackupConfig {
+	replicaConfig := *backupConfig
+	replicaConfig.BackupDir = ""
+	replicaConfig.Plugin = pluginName
+	replicaConfig.PluginVersion = pluginVersion
+	replicaConfig.Replicas = nil
+	return &replicaConfig
+}
+
+/*
+ * Returns a line for each segment file to replicate, with the kind of file and
+ * the templates of its source path and its path in the default backup
+ * directory, as used in COPY commands.  Data files are streamed to the plugin
+ * with backup_data, while segment TOCs are passed to it with backup_file.
+ */
+func GetSegmentFilesToReplicate(backupConfig *history.BackupConfig, sourceFPInfo filepath.FilePathInfo, destFPInfo filepath.FilePathInfo, tocfile *toc.TOC) []string {
+	extension := getDataFileExtension(backupConfig)
+	if backupConfig.SingleDataFile {
+		sourceFile := sourceFPInfo.GetTableBackupFilePathForCopyCommand(0, extension, true)
+		destFile := destFPInfo.GetTableBackupFilePathForCopyCommand(0, extension, true)
+		return []string{
+			fmt.Sprintf("data %s %s", sourceFile, destFile),
+			fmt.Sprintf("file %s %s", getSegmentTOCFileTemplate(sourceFile, extension), getSegmentTOCFileTemplate(destFile, extension)),
+		}
+	}
+	lines := make([]string, 0, len(tocfile.DataEntries))
+	for _, dataEntry := range tocfile.DataEntries {
+		lines = append(lines, fmt.Sprintf("data %s %s",
+			sourceFPInfo.GetTableBackupFilePathForCopyCommand(dataEntry.Oid, extension, false),
+			destFPInfo.GetTableBackupFilePathForCopyCommand(dataEntry.Oid, extension, false)))
+	}
+	return lines
+}
+
+/*
+ * The list of files is copied to each segment, which passes each file to the
+ * destination plugin, reading it from the backup directory or restoring it
+ * using the source plugin.  Segment TOCs must exist on disk to be passed to a
+ * plugin, so they are copied to the default backup directory first.
+ */
+func ReplicateFilesOnSegments(c *cluster.Cluster, fpInfo filepath.FilePathInfo, segmentFiles []string, sourcePlugin *utils.PluginConfig, destPlugin *utils.PluginConfig) {
+	utils.WriteOidListToSegments(segmentFiles, c, fpInfo)
+	defer utils.CleanUpHelperFilesOnAllHosts(c, fpInfo)
+
+	backupFile := fmt.Sprintf(`%s backup_file %s "$d" < /dev/null`, destPlugin.ExecutablePath, destPlugin.ConfigPath)
+	backupData := fmt.Sprintf(`%s backup_data %s "$d"`, destPlugin.ExecutablePath, destPlugin.ConfigPath)
+	replicateFile := fmt.Sprintf(`rm -f "$d" && cp "$s" "$d" && %s`, backupFile)
+	replicateData := fmt.Sprintf(`%s < "$s"`, backupData)
+	if sourcePlugin != nil {
+		replicateFile = fmt.Sprintf(`%s restore_file %s "$d" < /dev/null && %s`, sourcePlugin.ExecutablePath, sourcePlugin.ConfigPath, backupFile)
+		replicateData = fmt.Sprintf(`%s restore_data %s "$d" < /dev/null | %s`, sourcePlugin.ExecutablePath, sourcePlugin.ConfigPath, backupData)
+	}
+	remoteOutput := c.GenerateAndExecuteCommand("Replicating segment files", func(contentID int) string {
+		return fmt.Sprintf(`set -o pipefail; source %s/greenplum_path.sh && while read -r kind f g; do %s; %s; `+
+			`mkdir -p "$(dirname "$d")" && if [[ $kind == file ]]; then %s; else %s; fi || exit 1; done < %s`,
+			operating.System.Getenv("GPHOME"), substituteCopyFormatStrings(fpInfo, contentID, "f", "s"),
+			substituteCopyFormatStrings(fpInfo, contentID, "g", "d"), replicateFile, replicateData,
+			fpInfo.GetSegmentHelperFilePath(contentID, "oid"))
+	}, cluster.ON_SEGMENTS)
+	c.CheckClusterError(remoteOutput, "Unable to replicate segment files", func(contentID int) string {
+		return fmt.Sprintf("Unable to replicate segment files on segment %d on host %s", contentID, c.GetHostForContent(contentID))
+	})
+}
@@ -0,0 +1,172 @@
+package manager_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/replicate tests", func() {
+	var (
+		backupHistory *history.History
+		incremental   *history.BackupConfig
+		sourceFPInfo  filepath.FilePathInfo
+		destFPInfo    filepath.FilePathInfo
+	)
+	BeforeEach(func() {
+		manager.SetHistoryFilePath("/tmp/history_file.yaml")
+		backupHistory = &history.History{BackupConfigs: []history.BackupConfig{
+			{Timestamp: "20190102010101", BackupDir: "/backup_dir", Incremental: true, Status: history.BackupStatusSucceed,
+				RestorePlan: []history.RestorePlanEntry{
+					{Timestamp: "20190101010101", TableFQNs: []string{"public.ao1"}},
+					{Timestamp: "20190102010101", TableFQNs: []string{"public.heap1"}},
+				}},
+			{Timestamp: "20190101010101", BackupDir: "/backup_dir", Status: history.BackupStatusSucceed,
+				RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190101010101", TableFQNs: []string{"public.ao1", "public.heap1"}}}},
+		}}
+		incremental = backupHistory.FindBackupConfigIncludingFailed("20190102010101")
+		testCluster := testutils.SetDefaultSegmentConfiguration()
+		sourceFPInfo = filepath.NewFilePathInfo(testCluster, "/backup_dir", "20190102010101", "gpseg")
+		destFPInfo = filepath.NewFilePathInfo(testCluster, "", "20190102010101", "gpseg")
+	})
+	Describe("ValidateBackupCanBeReplicated", func() {
+		It("allows replicating a backup in a backup directory", func() {
+			err := manager.ValidateBackupCanBeReplicated(backupHistory, incremental, "20190102010101", "")
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("returns an error for a backup in the default backup directories", func() {
+			incremental.BackupDir = ""
+			err := manager.ValidateBackupCanBeReplicated(backupHistory, incremental, "20190102010101", "")
+			Expect(err).To(MatchError("Backup 20190102010101 is in the default backup directories, where the files of its replica would be written.  Only backups taken with --backup-dir or a plugin can be replicated."))
+		})
+		It("requires the source plugin config for a plugin backup", func() {
+			incremental.Plugin = "gpbackup_s3_plugin"
+			err := manager.ValidateBackupCanBeReplicated(backupHistory, incremental, "20190102010101", "")
+			Expect(err).To(MatchError("Backup 20190102010101 was taken using plugin gpbackup_s3_plugin.  The --source-plugin-config flag is required to replicate it."))
+		})
+		It("returns an error if the restore plan of the backup is broken", func() {
+			backupHistory.FindBackupConfigIncludingFailed("20190101010101").DateDeleted = "20190103010101"
+			err := manager.ValidateBackupCanBeReplicated(backupHistory, incremental, "20190102010101", "")
+			Expect(err).To(MatchError("Backup 20190102010101 cannot be replicated, as its restore plan is broken: backup 20190101010101 in the restore plan was deleted on 20190103010101"))
+		})
+	})
+	Describe("GetBackupsToReplicate", func() {
+		It("returns every backup in the restore plan", func() {
+			Expect(manager.GetBackupsToReplicate(backupHistory, incremental, "gpbackup_s3_plugin", "bucket1")).To(Equal([]string{"20190101010101", "20190102010101"}))
+		})
+		It("skips backups in the restore plan that were already replicated to the destination", func() {
+			backupHistory.FindBackupConfigIncludingFailed("20190101010101").Replicas = []history.Replica{{Plugin: "gpbackup_s3_plugin", Destination: "bucket1"}}
+			incremental.Replicas = []history.Replica{{Plugin: "gpbackup_s3_plugin", Destination: "bucket1"}}
+
+			Expect(manager.GetBackupsToReplicate(backupHistory, incremental, "gpbackup_s3_plugin", "bucket1")).To(Equal([]string{"20190102010101"}))
+			Expect(manager.GetBackupsToReplicate(backupHistory, incremental, "gpbackup_ddboost_plugin", "bucket1")).To(HaveLen(2))
+		})
+		It("replicates the whole restore plan to a second destination of the same plugin", func() {
+			bucket1 := &utils.PluginConfig{ExecutablePath: "/usr/local/bin/gpbackup_s3_plugin", Options: map[string]string{"bucket": "bucket1", "folder": "gpdb"}}
+			bucket2 := &utils.PluginConfig{ExecutablePath: "/usr/local/bin/gpbackup_s3_plugin", Options: map[string]string{"bucket": "bucket2", "folder": "gpdb"}}
+			backupHistory.FindBackupConfigIncludingFailed("20190101010101").Replicas = []history.Replica{{Plugin: "gpbackup_s3_plugin", Destination: bucket1.GetDestination()}}
+			incremental.Replicas = []history.Replica{{Plugin: "gpbackup_s3_plugin", Destination: bucket1.GetDestination()}}
+
+			Expect(manager.GetBackupsToReplicate(backupHistory, incremental, "gpbackup_s3_plugin", bucket1.GetDestination())).To(Equal([]string{"20190102010101"}))
+			Expect(manager.GetBackupsToReplicate(backupHistory, incremental, "gpbackup_s3_plugin", bucket2.GetDestination())).To(Equal([]string{"20190101010101", "20190102010101"}))
+		})
+		It("replicates backups whose replicas were recorded without a destination again", func() {
+			backupHistory.FindBackupConfigIncludingFailed("20190101010101").Replicas = []history.Replica{{Plugin: "gpbackup_s3_plugin"}}
+
+			Expect(manager.GetBackupsToReplicate(backupHistory, incremental, "gpbackup_s3_plugin", "bucket1")).To(HaveLen(2))
+		})
+	})
+	Describe("GetMasterFilesToReplicate", func() {
+		It("returns the master files in the default backup directory", func() {
+			incremental.WithStatistics = true
+			files := manager.GetMasterFilesToReplicate(incremental, destFPInfo)
+
+			Expect(files).To(Equal([]manager.ReplicatedFile{
+				{Path: destFPInfo.GetTOCFilePath(), Required: true},
+				{Path: destFPInfo.GetMetadataFilePath(), Required: true},
+				{Path: destFPInfo.GetStatisticsFilePath(), Required: true},
+				{Path: destFPInfo.GetBackupReportFilePath()},
+				{Path: destFPInfo.GetBackupReportFilePath() + ".json"},
+				{Path: destFPInfo.GetBackupReportFilePath() + ".yaml"},
+			}))
+		})
+		It("does not require a metadata file for a data-only backup", func() {
+			incremental.DataOnly = true
+			files := manager.GetMasterFilesToReplicate(incremental, destFPInfo)
+
+			Expect(files).ToNot(ContainElement(manager.ReplicatedFile{Path: destFPInfo.GetMetadataFilePath(), Required: true}))
+		})
+	})
+	Describe("GetReplicaConfig", func() {
+		It("makes a config for the replica in the plugin storage location", func() {
+			incremental.Replicas = []history.Replica{{Plugin: "gpbackup_ddboost_plugin"}}
+			replicaConfig := manager.GetReplicaConfig(incremental, "gpbackup_s3_plugin", "gpbackup_s3_plugin version 1.2.3")
+
+			Expect(replicaConfig.BackupDir).To(Equal(""))
+			Expect(replicaConfig.Plugin).To(Equal("gpbackup_s3_plugin"))
+			Expect(replicaConfig.PluginVersion).To(Equal("gpbackup_s3_plugin version 1.2.3"))
+			Expect(replicaConfig.Replicas).To(BeNil())
+			Expect(replicaConfig.RestorePlan).To(Equal(incremental.RestorePlan))
+			Expect(incremental.BackupDir).To(Equal("/backup_dir"))
+		})
+	})
+	Describe("GetSegmentFilesToReplicate", func() {
+		tocfile := &toc.TOC{DataEntries: []toc.MasterDataEntry{{Schema: "public", Name: "heap1", Oid: 3}}}
+		It("returns the data file of each table", func() {
+			incremental.Compressed = true
+			lines := manager.GetSegmentFilesToReplicate(incremental, sourceFPInfo, destFPInfo, tocfile)
+
+			Expect(lines).To(Equal([]string{"data /backup_dir/gpseg<SEGID>/backups/20190102/20190102010101/gpbackup_<SEGID>_20190102010101_3.gz " +
+				"<SEG_DATA_DIR>/backups/20190102/20190102010101/gpbackup_<SEGID>_20190102010101_3.gz"}))
+		})
+		It("returns the data file and segment TOC of a single-data-file backup", func() {
+			incremental.SingleDataFile = true
+			lines := manager.GetSegmentFilesToReplicate(incremental, sourceFPInfo, destFPInfo, tocfile)
+
+			Expect(lines).To(Equal([]string{
+				"data /backup_dir/gpseg<SEGID>/backups/20190102/20190102010101/gpbackup_<SEGID>_20190102010101 " +
+					"<SEG_DATA_DIR>/backups/20190102/20190102010101/gpbackup_<SEGID>_20190102010101",
+				"file /backup_dir/gpseg<SEGID>/backups/20190102/20190102010101/gpbackup_<SEGID>_20190102010101_toc.yaml " +
+					"<SEG_DATA_DIR>/backups/20190102/20190102010101/gpbackup_<SEGID>_20190102010101_toc.yaml",
+			}))
+		})
+	})
+	Describe("ReplicateFilesOnSegments", func() {
+		var testCluster *cluster.Cluster
+		var executor testutils.TestExecutorMultiple
+		var destPlugin *utils.PluginConfig
+		BeforeEach(func() {
+			testCluster = testutils.SetDefaultSegmentConfiguration()
+			executor = testutils.TestExecutorMultiple{
+				ClusterOutputs: []*cluster.RemoteOutput{{}, {}, {}},
+			}
+			testCluster.Executor = &executor
+			destPlugin = &utils.PluginConfig{ExecutablePath: "/tmp/dest_plugin.sh", ConfigPath: "/tmp/dest_config.yaml"}
+		})
+		It("streams data files from the backup directory to the plugin", func() {
+			manager.ReplicateFilesOnSegments(testCluster, destFPInfo, []string{"data a b"}, nil, destPlugin)
+
+			Expect(executor.NumRemoteExecutions).To(Equal(3))
+			Expect(executor.ClusterCommands[1][0]).To(ContainElement(And(
+				ContainSubstring(`while read -r kind f g; do s=${f//<SEGID>/0}; s=${s//<SEG_DATA_DIR>/`),
+				ContainSubstring(`if [[ $kind == file ]]; then rm -f "$d" && cp "$s" "$d" && /tmp/dest_plugin.sh backup_file /tmp/dest_config.yaml "$d" < /dev/null; `+
+					`else /tmp/dest_plugin.sh backup_data /tmp/dest_config.yaml "$d" < "$s"; fi || exit 1; done < `),
+			)))
+		})
+		It("restores the files using the source plugin", func() {
+			sourcePlugin := &utils.PluginConfig{ExecutablePath: "/tmp/source_plugin.sh", ConfigPath: "/tmp/source_config.yaml"}
+			manager.ReplicateFilesOnSegments(testCluster, destFPInfo, []string{"data a b"}, sourcePlugin, destPlugin)
+
+			Expect(executor.ClusterCommands[1][1]).To(ContainElement(ContainSubstring(
+				`then /tmp/source_plugin.sh restore_file /tmp/source_config.yaml "$d" < /dev/null && /tmp/dest_plugin.sh backup_file /tmp/dest_config.yaml "$d" < /dev/null; ` +
+					`else /tmp/source_plugin.sh restore_data /tmp/source_config.yaml "$d" < /dev/null | /tmp/dest_plugin.sh backup_data /tmp/dest_config.yaml "$d"; fi`)))
+		})
+	})
+})
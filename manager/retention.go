@@ -0,0 +1,289 @@
+package manager
+
+/*
+ * This file contains functions for applying a retention policy to the backups
+ * recorded in the backup history file.
+ */
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+type RetentionPolicy struct {
+	KeepLastFull int
+	KeepDays     int
+	KeepWeekly   int
+	KeepMonthly  int
+}
+
+func (policy RetentionPolicy) IsEmpty() bool {
+	return policy.KeepLastFull == 0 && policy.KeepDays == 0 && policy.KeepWeekly == 0 && policy.KeepMonthly == 0
+}
+
+type RetentionDecision struct {
+	BackupConfig *history.BackupConfig
+	Keep         bool
+	Reasons      []string
+}
+
+func (decision *RetentionDecision) keep(reason string) {
+	decision.Keep = true
+	decision.Reasons = append(decision.Reasons, reason)
+}
+
+func DoPruneBackups() {
+	policy := RetentionPolicy{
+		KeepLastFull: MustGetFlagInt(options.KEEP_LAST_FULL),
+		KeepDays:     MustGetFlagInt(options.KEEP_DAYS),
+		KeepWeekly:   MustGetFlagInt(options.KEEP_WEEKLY),
+		KeepMonthly:  MustGetFlagInt(options.KEEP_MONTHLY),
+	}
+	err := ValidateRetentionPolicy(policy)
+	gplog.FatalOnError(err)
+	pluginConfigFile := MustGetFlagString(options.PLUGIN_CONFIG)
+	err = utils.ValidateFullPath(pluginConfigFile)
+	gplog.FatalOnError(err)
+
+	backupHistory := readHistory()
+	decisions := EvaluateRetentionPolicy(backupHistory, policy, MustGetFlagString(options.DBNAME), operating.System.Now())
+	PrintRetentionDecisions(os.Stdout, decisions)
+
+	expired := make([]*history.BackupConfig, 0)
+	for _, decision := range decisions {
+		if !decision.Keep {
+			expired = append(expired, decision.BackupConfig)
+		}
+	}
+	if MustGetFlagBool(options.DRY_RUN) {
+		gplog.Info("Dry run: %d of %d backups would be deleted", len(expired), len(decisions))
+		return
+	}
+	if len(expired) == 0 {
+		gplog.Info("No backups have expired")
+		return
+	}
+	configuredPluginName := ""
+	for _, backupConfig := range expired {
+		if backupConfig.Plugin != "" && pluginConfigFile != "" {
+			setupPlugin(pluginConfigFile)
+			configuredPluginName = pluginName
+			break
+		}
+	}
+	err = ValidateExpiredBackupPlugins(expired, pluginConfigFile, configuredPluginName)
+	gplog.FatalOnError(err)
+
+	/*
+	 * The history is sorted newest first, so expired incremental backups are
+	 * always deleted before the backups their restore plans depend on.
+	 */
+	for _, backupConfig := range expired {
+		backupPluginConfigFile := ""
+		if backupConfig.Plugin != "" {
+			backupPluginConfigFile = pluginConfigFile
+		}
+		err = ValidateBackupCanBeDeleted(backupHistory, backupConfig, backupConfig.Timestamp, backupPluginConfigFile)
+		gplog.FatalOnError(err)
+		deleteBackup(backupConfig, backupPluginConfigFile)
+	}
+	gplog.Info("Deleted %d expired backups", len(expired))
+}
+
+/*
+ * Every expired backup is checked before any of them is deleted, so that a
+ * backup taken using another plugin does not stop the prune partway through.
+ */
+func ValidateExpiredBackupPlugins(expired []*history.BackupConfig, pluginConfigFile string, configuredPluginName string) error {
+	for _, backupConfig := range expired {
+		if backupConfig.Plugin == "" {
+			continue
+		}
+		if pluginConfigFile == "" {
+			return errors.Errorf("Expired backup %s was taken using plugin %s.  The --%s flag is required to delete it.",
+				backupConfig.Timestamp, backupConfig.Plugin, options.PLUGIN_CONFIG)
+		}
+		if backupConfig.Plugin != configuredPluginName {
+			return errors.Errorf("Expired backup %s was taken using plugin %s, but the plugin config file specifies plugin %s",
+				backupConfig.Timestamp, backupConfig.Plugin, configuredPluginName)
+		}
+	}
+	return nil
+}
+
+func ValidateRetentionPolicy(policy RetentionPolicy) error {
+	if policy.IsEmpty() {
+		return errors.Errorf("At least one of the following flags must be specified: --%s, --%s, --%s, --%s",
+			options.KEEP_LAST_FULL, options.KEEP_DAYS, options.KEEP_WEEKLY, options.KEEP_MONTHLY)
+	}
+	if policy.KeepLastFull < 0 || policy.KeepDays < 0 || policy.KeepWeekly < 0 || policy.KeepMonthly < 0 {
+		return errors.New("Retention policy values must not be negative")
+	}
+	return nil
+}
+
+/*
+ * A backup is kept if any part of the policy keeps it, and every backup that
+ * the restore plan of a kept backup reads from is kept as well, so that no
+ * surviving incremental backup is left without its base backups.  Policies are
+ * evaluated separately for each database, and deleted backups are ignored.
+ */
+func EvaluateRetentionPolicy(backupHistory *history.History, policy RetentionPolicy, dbName string, now time.Time) []*RetentionDecision {
+	decisions := make([]*RetentionDecision, 0)
+	decisionMap := make(map[string]*RetentionDecision, 0)
+	for i := range backupHistory.BackupConfigs {
+		backupConfig := &backupHistory.BackupConfigs[i]
+		if backupConfig.Deleted() || (dbName != "" && backupConfig.DatabaseName != dbName) {
+			continue
+		}
+		decision := &RetentionDecision{BackupConfig: backupConfig}
+		decisions = append(decisions, decision)
+		decisionMap[backupConfig.Timestamp] = decision
+	}
+
+	fullBackupCount := make(map[string]int, 0)
+	retainedFullBackups := make(map[string]bool, 0)
+	retainedWeeks := make(map[string]map[string]bool, 0)
+	retainedMonths := make(map[string]map[string]bool, 0)
+	oldestKeptTime := now.AddDate(0, 0, -policy.KeepDays)
+	for _, decision := range decisions {
+		backupConfig := decision.BackupConfig
+		backupTime, err := time.ParseInLocation("20060102150405", backupConfig.Timestamp, operating.System.Local)
+		if err != nil {
+			decision.keep("unable to parse timestamp")
+			continue
+		}
+		if policy.KeepDays > 0 && backupTime.After(oldestKeptTime) {
+			decision.keep(fmt.Sprintf("newer than %d days", policy.KeepDays))
+		}
+		if backupConfig.Failed() {
+			continue
+		}
+		dbName := backupConfig.DatabaseName
+		if policy.KeepLastFull > 0 && isFullBackup(backupConfig) && fullBackupCount[dbName] < policy.KeepLastFull {
+			fullBackupCount[dbName]++
+			retainedFullBackups[backupConfig.Timestamp] = true
+			decision.keep(fmt.Sprintf("one of the last %d full backups", policy.KeepLastFull))
+		}
+		year, week := backupTime.ISOWeek()
+		weekKey := fmt.Sprintf("%d-W%02d", year, week)
+		if retainNewestInPeriod(retainedWeeks, dbName, weekKey, policy.KeepWeekly) {
+			decision.keep(fmt.Sprintf("newest backup of week %s", weekKey))
+		}
+		monthKey := backupTime.Format("2006-01")
+		if retainNewestInPeriod(retainedMonths, dbName, monthKey, policy.KeepMonthly) {
+			decision.keep(fmt.Sprintf("newest backup of month %s", monthKey))
+		}
+	}
+
+	for _, decision := range decisions {
+		backupConfig := decision.BackupConfig
+		if !backupConfig.Incremental || backupConfig.Failed() {
+			continue
+		}
+		for _, entry := range backupConfig.RestorePlan {
+			if retainedFullBackups[entry.Timestamp] {
+				decision.keep(fmt.Sprintf("incremental backup of retained full backup %s", entry.Timestamp))
+				break
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, decision := range decisions {
+			if !decision.Keep {
+				continue
+			}
+			for _, entry := range decision.BackupConfig.RestorePlan {
+				dependency, ok := decisionMap[entry.Timestamp]
+				if ok && !dependency.Keep {
+					dependency.keep(fmt.Sprintf("required by restore plan of %s", decision.BackupConfig.Timestamp))
+					changed = true
+				}
+			}
+		}
+	}
+
+	for _, decision := range decisions {
+		if !decision.Keep {
+			decision.Reasons = getExpiredReasons(decision.BackupConfig, policy)
+		}
+	}
+	return decisions
+}
+
+/*
+ * Metadata-only backups hold no table data, so they do not count towards the
+ * last full backups.  Data-only backups hold the data of every table and do
+ * count, as the metadata they lack can be restored from any other backup.
+ */
+func isFullBackup(backupConfig *history.BackupConfig) bool {
+	return !backupConfig.Incremental && !backupConfig.MetadataOnly
+}
+
+func retainNewestInPeriod(retainedPeriods map[string]map[string]bool, dbName string, periodKey string, numPeriods int) bool {
+	if numPeriods == 0 {
+		return false
+	}
+	if retainedPeriods[dbName] == nil {
+		retainedPeriods[dbName] = make(map[string]bool, 0)
+	}
+	if retainedPeriods[dbName][periodKey] || len(retainedPeriods[dbName]) >= numPeriods {
+		return false
+	}
+	retainedPeriods[dbName][periodKey] = true
+	return true
+}
+
+func getExpiredReasons(backupConfig *history.BackupConfig, policy RetentionPolicy) []string {
+	reasons := make([]string, 0)
+	if backupConfig.Failed() {
+		reasons = append(reasons, "backup failed")
+	}
+	if policy.KeepDays > 0 {
+		reasons = append(reasons, fmt.Sprintf("older than %d days", policy.KeepDays))
+	}
+	if policy.KeepLastFull > 0 && !backupConfig.Failed() {
+		if backupConfig.Incremental {
+			reasons = append(reasons, "full backup of incremental set not retained")
+		} else if backupConfig.MetadataOnly {
+			reasons = append(reasons, "metadata-only backups are not counted as full backups")
+		} else {
+			reasons = append(reasons, fmt.Sprintf("not one of the last %d full backups", policy.KeepLastFull))
+		}
+	}
+	if policy.KeepWeekly > 0 && !backupConfig.Failed() {
+		reasons = append(reasons, fmt.Sprintf("not the newest backup of one of the last %d weeks", policy.KeepWeekly))
+	}
+	if policy.KeepMonthly > 0 && !backupConfig.Failed() {
+		reasons = append(reasons, fmt.Sprintf("not the newest backup of one of the last %d months", policy.KeepMonthly))
+	}
+	return reasons
+}
+
+func PrintRetentionDecisions(writer io.Writer, decisions []*RetentionDecision) {
+	tabWriter := tabwriter.NewWriter(writer, 0, 0, 3, ' ', 0)
+	utils.MustPrintf(tabWriter, "TIMESTAMP\tDATABASE\tTYPE\tACTION\tREASON\n")
+	for _, decision := range decisions {
+		action := "delete"
+		if decision.Keep {
+			action = "keep"
+		}
+		backupConfig := decision.BackupConfig
+		utils.MustPrintf(tabWriter, "%s\t%s\t%s\t%s\t%s\n", backupConfig.Timestamp, backupConfig.DatabaseName,
+			GetBackupType(backupConfig), action, strings.Join(decision.Reasons, "; "))
+	}
+	_ = tabWriter.Flush()
+}
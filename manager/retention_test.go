@@ -0,0 +1,174 @@
+package manager_test
+
+import (
+	"time"
+
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/manager"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/retention tests", func() {
+	Describe("ValidateRetentionPolicy", func() {
+		It("requires at least one retention rule", func() {
+			err := manager.ValidateRetentionPolicy(manager.RetentionPolicy{})
+			Expect(err).To(MatchError("At least one of the following flags must be specified: --keep-last-full, --keep-days, --keep-weekly, --keep-monthly"))
+		})
+		It("rejects negative values", func() {
+			err := manager.ValidateRetentionPolicy(manager.RetentionPolicy{KeepDays: -1})
+			Expect(err).To(MatchError("Retention policy values must not be negative"))
+		})
+		It("accepts a policy with a single rule", func() {
+			err := manager.ValidateRetentionPolicy(manager.RetentionPolicy{KeepWeekly: 4})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Describe("EvaluateRetentionPolicy", func() {
+		var backupHistory *history.History
+		now := time.Date(2019, time.March, 20, 12, 0, 0, 0, time.Local)
+
+		getResults := func(decisions []*manager.RetentionDecision) map[string]bool {
+			results := make(map[string]bool, 0)
+			for _, decision := range decisions {
+				results[decision.BackupConfig.Timestamp] = decision.Keep
+			}
+			return results
+		}
+		getReasons := func(decisions []*manager.RetentionDecision, timestamp string) []string {
+			for _, decision := range decisions {
+				if decision.BackupConfig.Timestamp == timestamp {
+					return decision.Reasons
+				}
+			}
+			return nil
+		}
+
+		BeforeEach(func() {
+			// Two incremental sets in testdb, newest first as in the history file
+			backupHistory = &history.History{BackupConfigs: []history.BackupConfig{
+				{Timestamp: "20190319010101", DatabaseName: "testdb", Incremental: true, Status: history.BackupStatusSucceed,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190317010101"}, {Timestamp: "20190318010101"}, {Timestamp: "20190319010101"}}},
+				{Timestamp: "20190318010101", DatabaseName: "testdb", Incremental: true, Status: history.BackupStatusSucceed,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190317010101"}, {Timestamp: "20190318010101"}}},
+				{Timestamp: "20190317010101", DatabaseName: "testdb", Status: history.BackupStatusSucceed,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190317010101"}}},
+				{Timestamp: "20190310010101", DatabaseName: "testdb", Status: history.BackupStatusFailed},
+				{Timestamp: "20190203010101", DatabaseName: "testdb", Incremental: true, Status: history.BackupStatusSucceed,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190201010101"}, {Timestamp: "20190203010101"}}},
+				{Timestamp: "20190201010101", DatabaseName: "testdb", Status: history.BackupStatusSucceed,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190201010101"}}},
+				{Timestamp: "20190115010101", DatabaseName: "otherdb", Status: history.BackupStatusSucceed,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190115010101"}}},
+				{Timestamp: "20190101010101", DatabaseName: "testdb", Status: history.BackupStatusSucceed, DateDeleted: "20190102010101"},
+			}}
+		})
+		It("keeps the last full backups along with their incremental backups", func() {
+			decisions := manager.EvaluateRetentionPolicy(backupHistory, manager.RetentionPolicy{KeepLastFull: 1}, "", now)
+
+			Expect(getResults(decisions)).To(Equal(map[string]bool{
+				"20190319010101": true, "20190318010101": true, "20190317010101": true, "20190310010101": false,
+				"20190203010101": false, "20190201010101": false, "20190115010101": true,
+			}))
+			Expect(getReasons(decisions, "20190318010101")).To(Equal([]string{"incremental backup of retained full backup 20190317010101"}))
+			Expect(getReasons(decisions, "20190201010101")).To(Equal([]string{"not one of the last 1 full backups"}))
+			Expect(getReasons(decisions, "20190310010101")).To(Equal([]string{"backup failed"}))
+		})
+		It("keeps backups newer than the given number of days, including failed backups", func() {
+			decisions := manager.EvaluateRetentionPolicy(backupHistory, manager.RetentionPolicy{KeepDays: 12}, "", now)
+
+			Expect(getResults(decisions)).To(Equal(map[string]bool{
+				"20190319010101": true, "20190318010101": true, "20190317010101": true, "20190310010101": true,
+				"20190203010101": false, "20190201010101": false, "20190115010101": false,
+			}))
+			Expect(getReasons(decisions, "20190203010101")).To(Equal([]string{"older than 12 days"}))
+		})
+		It("keeps backups referenced by the restore plan of a kept incremental backup", func() {
+			decisions := manager.EvaluateRetentionPolicy(backupHistory, manager.RetentionPolicy{KeepDays: 2}, "", now)
+
+			Expect(getResults(decisions)).To(Equal(map[string]bool{
+				"20190319010101": true, "20190318010101": true, "20190317010101": true, "20190310010101": false,
+				"20190203010101": false, "20190201010101": false, "20190115010101": false,
+			}))
+			Expect(getReasons(decisions, "20190317010101")).To(Equal([]string{"required by restore plan of 20190319010101"}))
+		})
+		It("keeps the newest successful backup of each week and month", func() {
+			decisions := manager.EvaluateRetentionPolicy(backupHistory, manager.RetentionPolicy{KeepWeekly: 2, KeepMonthly: 2}, "", now)
+
+			Expect(getResults(decisions)).To(Equal(map[string]bool{
+				"20190319010101": true, "20190318010101": true, "20190317010101": true, "20190310010101": false,
+				"20190203010101": true, "20190201010101": true, "20190115010101": true,
+			}))
+			Expect(getReasons(decisions, "20190319010101")).To(Equal([]string{"newest backup of week 2019-W12", "newest backup of month 2019-03"}))
+			Expect(getReasons(decisions, "20190317010101")).To(Equal([]string{"newest backup of week 2019-W11"}))
+			Expect(getReasons(decisions, "20190203010101")).To(Equal([]string{"newest backup of month 2019-02"}))
+			Expect(getReasons(decisions, "20190201010101")).To(Equal([]string{"required by restore plan of 20190203010101"}))
+		})
+		It("does not count metadata-only backups as full backups", func() {
+			backupHistory.BackupConfigs = append([]history.BackupConfig{
+				{Timestamp: "20190320010101", DatabaseName: "testdb", MetadataOnly: true, Status: history.BackupStatusSucceed,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190320010101"}}},
+			}, backupHistory.BackupConfigs...)
+			decisions := manager.EvaluateRetentionPolicy(backupHistory, manager.RetentionPolicy{KeepLastFull: 1}, "testdb", now)
+
+			Expect(getResults(decisions)).To(Equal(map[string]bool{
+				"20190320010101": false, "20190319010101": true, "20190318010101": true, "20190317010101": true,
+				"20190310010101": false, "20190203010101": false, "20190201010101": false,
+			}))
+			Expect(getReasons(decisions, "20190320010101")).To(Equal([]string{"metadata-only backups are not counted as full backups"}))
+		})
+		It("counts data-only backups as full backups", func() {
+			backupHistory.BackupConfigs = append([]history.BackupConfig{
+				{Timestamp: "20190320010101", DatabaseName: "testdb", DataOnly: true, Status: history.BackupStatusSucceed,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190320010101"}}},
+			}, backupHistory.BackupConfigs...)
+			decisions := manager.EvaluateRetentionPolicy(backupHistory, manager.RetentionPolicy{KeepLastFull: 1}, "testdb", now)
+
+			Expect(getResults(decisions)).To(Equal(map[string]bool{
+				"20190320010101": true, "20190319010101": false, "20190318010101": false, "20190317010101": false,
+				"20190310010101": false, "20190203010101": false, "20190201010101": false,
+			}))
+		})
+		It("only evaluates backups of the given database", func() {
+			decisions := manager.EvaluateRetentionPolicy(backupHistory, manager.RetentionPolicy{KeepLastFull: 1}, "otherdb", now)
+
+			Expect(getResults(decisions)).To(Equal(map[string]bool{"20190115010101": true}))
+		})
+	})
+	Describe("ValidateExpiredBackupPlugins", func() {
+		expired := []*history.BackupConfig{
+			{Timestamp: "20190319010101"},
+			{Timestamp: "20190318010101", Plugin: "gpbackup_s3_plugin"},
+			{Timestamp: "20190317010101", Plugin: "gpbackup_ddboost_plugin"},
+		}
+		It("accepts backups taken without a plugin", func() {
+			err := manager.ValidateExpiredBackupPlugins(expired[:1], "", "")
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("requires a plugin config for backups taken using a plugin", func() {
+			err := manager.ValidateExpiredBackupPlugins(expired, "", "")
+			Expect(err).To(MatchError("Expired backup 20190318010101 was taken using plugin gpbackup_s3_plugin.  The --plugin-config flag is required to delete it."))
+		})
+		It("rejects backups taken using a plugin other than the configured one", func() {
+			err := manager.ValidateExpiredBackupPlugins(expired, "/tmp/plugin_config.yaml", "gpbackup_s3_plugin")
+			Expect(err).To(MatchError("Expired backup 20190317010101 was taken using plugin gpbackup_ddboost_plugin, but the plugin config file specifies plugin gpbackup_s3_plugin"))
+		})
+	})
+	Describe("PrintRetentionDecisions", func() {
+		It("prints the action and reasons for each backup", func() {
+			decisions := []*manager.RetentionDecision{
+				{BackupConfig: &history.BackupConfig{Timestamp: "20190319010101", DatabaseName: "testdb", Incremental: true},
+					Keep: true, Reasons: []string{"newer than 7 days"}},
+				{BackupConfig: &history.BackupConfig{Timestamp: "20190201010101", DatabaseName: "testdb"},
+					Keep: false, Reasons: []string{"older than 7 days", "not one of the last 1 full backups"}},
+			}
+			manager.PrintRetentionDecisions(buffer, decisions)
+
+			Expect(string(buffer.Contents())).To(Equal(`TIMESTAMP        DATABASE   TYPE          ACTION   REASON
+20190319010101   testdb     incremental   keep     newer than 7 days
+20190201010101   testdb     full          delete   older than 7 days; not one of the last 1 full backups
+`))
+		})
+	})
+})
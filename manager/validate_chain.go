@@ -0,0 +1,519 @@
+package manager
+
+/*
+ * This file contains functions for validating the restore plans of backups,
+ * so that a backup that cannot be restored because a backup in its restore
+ * plan is missing, incompatible, or incomplete is found before it is needed.
+ *
+ * Each backup in a restore plan must still be in the history file, must have
+ * been taken with the same flags as the backup being validated, and must have
+ * its config file, its TOC, and the data file of each table the restore plan
+ * reads from it on every segment, either on disk or through the plugin.
+ *
+ * Files that cannot be checked, because the plugin config or key a backup
+ * needs was not given or the plugin could not read them, are reported apart
+ * from files that were found to be missing or corrupt, and only the latter
+ * make --repair mark a backup as failed.
+ */
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+ * The result of checking the files of a single backup.  Problems that make
+ * every table in the backup unrestorable, such as a missing TOC, are kept
+ * separately from problems with the data files of individual tables, as each
+ * restore plan only reads some of the tables in a backup.  Files that could
+ * not be checked are kept in the same way.
+ */
+type BackupFileCheck struct {
+	Problems       []string
+	TableProblems  map[string][]string
+	Unchecked      []string
+	TableUnchecked map[string][]string
+}
+
+func newBackupFileCheck() *BackupFileCheck {
+	return &BackupFileCheck{
+		Problems:       make([]string, 0),
+		TableProblems:  make(map[string][]string),
+		Unchecked:      make([]string, 0),
+		TableUnchecked: make(map[string][]string),
+	}
+}
+
+func DoValidateChain(args []string) {
+	timestamp := ""
+	if len(args) > 0 {
+		timestamp = args[0]
+		if !filepath.IsValidTimestamp(timestamp) {
+			gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", timestamp), "")
+		}
+	}
+	pluginConfigFile := MustGetFlagString(options.PLUGIN_CONFIG)
+	err := utils.ValidateFullPath(pluginConfigFile)
+	gplog.FatalOnError(err)
+	keySource, err := utils.ReadEncryptionKeySource(MustGetFlagString(options.ENCRYPTION_KEY_FILE), MustGetFlagString(options.ENCRYPTION_PASSPHRASE_FILE))
+	gplog.FatalOnError(err)
+
+	backupHistory := readHistory()
+	backupConfigs, err := GetBackupsToValidate(backupHistory, timestamp)
+	gplog.FatalOnError(err)
+	if len(backupConfigs) == 0 {
+		gplog.Info("No backups to validate")
+		return
+	}
+
+	tablesToCheck := GetTablesToCheck(backupHistory, backupConfigs)
+	planTimestamps := make([]string, 0, len(tablesToCheck))
+	for planTimestamp := range tablesToCheck {
+		planTimestamps = append(planTimestamps, planTimestamp)
+	}
+	sort.Strings(planTimestamps)
+	fileChecks := make(map[string]*BackupFileCheck, len(planTimestamps))
+	for _, planTimestamp := range planTimestamps {
+		planConfig := backupHistory.FindBackupConfigIncludingFailed(planTimestamp)
+		fileChecks[planTimestamp] = CheckBackupFiles(planConfig, tablesToCheck[planTimestamp], pluginConfigFile, keySource)
+	}
+
+	brokenBackups := make([]string, 0)
+	uncheckedBackups := make([]string, 0)
+	for _, backupConfig := range backupConfigs {
+		problems := ValidateRestorePlan(backupHistory, backupConfig)
+		problems = append(problems, GetFileProblems(backupConfig, fileChecks)...)
+		unchecked := GetUncheckedFiles(backupConfig, fileChecks)
+		fpInfo := GetFPInfoForBackup(backupConfig)
+		if !backupConfig.DataOnly {
+			problem, checked := checkMasterFile(backupConfig, fpInfo.GetMetadataFilePath(), pluginConfigFile)
+			if !checked {
+				unchecked = append(unchecked, problem)
+			} else if problem != "" {
+				problems = append(problems, problem)
+			}
+		}
+		if len(problems) == 0 && len(unchecked) == 0 {
+			gplog.Info("Backup %s: restore plan of %d backups is intact", backupConfig.Timestamp, len(backupConfig.RestorePlan))
+			continue
+		}
+		for _, problem := range problems {
+			gplog.Error("Backup %s: %s", backupConfig.Timestamp, problem)
+		}
+		for _, reason := range unchecked {
+			gplog.Warn("Backup %s: cannot check %s", backupConfig.Timestamp, reason)
+		}
+		if len(problems) > 0 {
+			brokenBackups = append(brokenBackups, backupConfig.Timestamp)
+		} else {
+			uncheckedBackups = append(uncheckedBackups, backupConfig.Timestamp)
+		}
+	}
+
+	if len(uncheckedBackups) > 0 {
+		gplog.Warn("%d of %d backups could not be fully validated, as the plugin config or key they need was not given or the plugin could not read their files.  They are not marked as failed.",
+			len(uncheckedBackups), len(backupConfigs))
+	}
+	if len(brokenBackups) == 0 {
+		if len(uncheckedBackups) == 0 {
+			gplog.Info("All %d backups validated successfully", len(backupConfigs))
+		}
+		return
+	}
+	if !MustGetFlagBool(options.REPAIR) {
+		gplog.Info("%d of %d backups cannot be restored.  Use --%s to mark them as failed so that later incremental backups are not based on them.",
+			len(brokenBackups), len(backupConfigs), options.REPAIR)
+		return
+	}
+	for _, brokenTimestamp := range brokenBackups {
+		err = history.MarkBackupFailed(historyFilePath, brokenTimestamp)
+		gplog.FatalOnError(err)
+		gplog.Info("Marked backup %s as failed", brokenTimestamp)
+	}
+}
+
+/*
+ * With no timestamp, every successful backup that has not been deleted is
+ * validated, as any of them may be the base of a later incremental backup.
+ */
+func GetBackupsToValidate(backupHistory *history.History, timestamp string) ([]*history.BackupConfig, error) {
+	if timestamp != "" {
+		backupConfig := backupHistory.FindBackupConfigIncludingFailed(timestamp)
+		if backupConfig == nil {
+			return nil, errors.Errorf("Backup with timestamp %s not found in history file %s", timestamp, historyFilePath)
+		}
+		if backupConfig.Deleted() {
+			return nil, errors.Errorf("Backup %s was deleted on %s", timestamp, backupConfig.DateDeleted)
+		}
+		if backupConfig.Failed() {
+			return nil, errors.Errorf("Backup %s failed and cannot be restored", timestamp)
+		}
+		return []*history.BackupConfig{backupConfig}, nil
+	}
+	backupConfigs := make([]*history.BackupConfig, 0)
+	for i := range backupHistory.BackupConfigs {
+		backupConfig := &backupHistory.BackupConfigs[i]
+		if !backupConfig.Failed() && !backupConfig.Deleted() {
+			backupConfigs = append(backupConfigs, backupConfig)
+		}
+	}
+	return backupConfigs, nil
+}
+
+/*
+ * Returns the problems with the restore plan of a backup that can be found
+ * using the history file alone.  A failed backup is only allowed in the
+ * restore plan of the backup that resumed it.
+ */
+func ValidateRestorePlan(backupHistory *history.History, backupConfig *history.BackupConfig) []string {
+	problems := make([]string, 0)
+	if len(backupConfig.RestorePlan) == 0 {
+		return append(problems, "the restore plan is empty")
+	}
+	if backupConfig.RestorePlan[len(backupConfig.RestorePlan)-1].Timestamp != backupConfig.Timestamp {
+		problems = append(problems, "the restore plan does not end with the backup itself")
+	}
+	for _, entry := range backupConfig.RestorePlan {
+		if entry.Timestamp == backupConfig.Timestamp {
+			continue
+		}
+		planConfig := backupHistory.FindBackupConfigIncludingFailed(entry.Timestamp)
+		if planConfig == nil {
+			problems = append(problems, fmt.Sprintf("backup %s in the restore plan is not in the history file", entry.Timestamp))
+			continue
+		}
+		if planConfig.Deleted() {
+			problems = append(problems, fmt.Sprintf("backup %s in the restore plan was deleted on %s", entry.Timestamp, planConfig.DateDeleted))
+			continue
+		}
+		if planConfig.Failed() && backupConfig.ResumedFrom != entry.Timestamp {
+			problems = append(problems, fmt.Sprintf("backup %s in the restore plan failed", entry.Timestamp))
+		}
+		if !history.MatchesIncrementalFlags(planConfig, backupConfig) {
+			problems = append(problems, fmt.Sprintf("backup %s in the restore plan was taken with different flags", entry.Timestamp))
+		}
+	}
+	return problems
+}
+
+/*
+ * Returns the tables each backup must have data files for, across the
+ * restore plans of all of the given backups, so that the files of a backup
+ * that is in several restore plans are only checked once.  Backups that are
+ * not in the history file or have been deleted are reported by
+ * ValidateRestorePlan and are not checked.
+ */
+func GetTablesToCheck(backupHistory *history.History, backupConfigs []*history.BackupConfig) map[string][]string {
+	tableSets := make(map[string]map[string]bool)
+	for _, backupConfig := range backupConfigs {
+		for _, entry := range backupConfig.RestorePlan {
+			planConfig := backupHistory.FindBackupConfigIncludingFailed(entry.Timestamp)
+			if planConfig == nil || planConfig.Deleted() {
+				continue
+			}
+			if _, ok := tableSets[entry.Timestamp]; !ok {
+				tableSets[entry.Timestamp] = make(map[string]bool)
+			}
+			for _, tableFQN := range entry.TableFQNs {
+				tableSets[entry.Timestamp][tableFQN] = true
+			}
+		}
+	}
+	tablesToCheck := make(map[string][]string, len(tableSets))
+	for planTimestamp, tableSet := range tableSets {
+		tableFQNs := make([]string, 0, len(tableSet))
+		for tableFQN := range tableSet {
+			tableFQNs = append(tableFQNs, tableFQN)
+		}
+		sort.Strings(tableFQNs)
+		tablesToCheck[planTimestamp] = tableFQNs
+	}
+	return tablesToCheck
+}
+
+/*
+ * Returns the problems with the files of the backups in the restore plan of a
+ * backup, limited to the tables the restore plan reads from each of them.
+ */
+func GetFileProblems(backupConfig *history.BackupConfig, fileChecks map[string]*BackupFileCheck) []string {
+	return getRestorePlanFileResults(backupConfig, fileChecks, func(fileCheck *BackupFileCheck) ([]string, map[string][]string) {
+		return fileCheck.Problems, fileCheck.TableProblems
+	})
+}
+
+/*
+ * Returns the files of the backups in the restore plan of a backup that could
+ * not be checked, limited in the same way as GetFileProblems.
+ */
+func GetUncheckedFiles(backupConfig *history.BackupConfig, fileChecks map[string]*BackupFileCheck) []string {
+	return getRestorePlanFileResults(backupConfig, fileChecks, func(fileCheck *BackupFileCheck) ([]string, map[string][]string) {
+		return fileCheck.Unchecked, fileCheck.TableUnchecked
+	})
+}
+
+func getRestorePlanFileResults(backupConfig *history.BackupConfig, fileChecks map[string]*BackupFileCheck, getResults func(*BackupFileCheck) ([]string, map[string][]string)) []string {
+	results := make([]string, 0)
+	for _, entry := range backupConfig.RestorePlan {
+		fileCheck, ok := fileChecks[entry.Timestamp]
+		if !ok {
+			continue
+		}
+		backupResults, tableResults := getResults(fileCheck)
+		for _, result := range backupResults {
+			results = append(results, fmt.Sprintf("backup %s in the restore plan: %s", entry.Timestamp, result))
+		}
+		for _, tableFQN := range entry.TableFQNs {
+			for _, result := range tableResults[tableFQN] {
+				results = append(results, fmt.Sprintf("backup %s in the restore plan: table %s: %s", entry.Timestamp, tableFQN, result))
+			}
+		}
+	}
+	return results
+}
+
+/*
+ * A plugin failing to restore a file cannot be told apart from the file being
+ * missing, so files the plugin cannot read are reported as unchecked rather
+ * than as problems.
+ */
+func CheckBackupFiles(backupConfig *history.BackupConfig, tableFQNs []string, pluginConfigFile string, keySource *utils.EncryptionKeySource) *BackupFileCheck {
+	gplog.Verbose("Checking the files of backup %s", backupConfig.Timestamp)
+	fileCheck := newBackupFileCheck()
+	if backupConfig.Plugin != "" {
+		if pluginConfigFile == "" {
+			fileCheck.Unchecked = append(fileCheck.Unchecked, fmt.Sprintf("files taken using plugin %s, as --%s was not specified",
+				backupConfig.Plugin, options.PLUGIN_CONFIG))
+			return fileCheck
+		}
+		setupPlugin(pluginConfigFile)
+		if pluginName != backupConfig.Plugin {
+			fileCheck.Unchecked = append(fileCheck.Unchecked, fmt.Sprintf("files taken using plugin %s, as the plugin config file specifies plugin %s",
+				backupConfig.Plugin, pluginName))
+			return fileCheck
+		}
+	}
+	if err := setEncryptionKeyForBackup(backupConfig, keySource); err != nil {
+		fileCheck.Unchecked = append(fileCheck.Unchecked, fmt.Sprintf("encrypted files: %v", err))
+		return fileCheck
+	}
+	defer utils.SetEncryptionKey(nil)
+
+	fpInfo := GetFPInfoForBackup(backupConfig)
+	if problem, checked := checkMasterFile(backupConfig, fpInfo.GetConfigFilePath(), pluginConfigFile); !checked {
+		fileCheck.Unchecked = append(fileCheck.Unchecked, problem)
+	} else if problem != "" {
+		fileCheck.Problems = append(fileCheck.Problems, problem)
+	}
+	tocFilename := fpInfo.GetTOCFilePath()
+	if problem, checked := checkMasterFile(backupConfig, tocFilename, pluginConfigFile); !checked {
+		fileCheck.Unchecked = append(fileCheck.Unchecked, problem)
+		return fileCheck
+	} else if problem != "" {
+		fileCheck.Problems = append(fileCheck.Problems, problem)
+		return fileCheck
+	}
+	tocfile, err := readTOC(tocFilename)
+	if err != nil {
+		fileCheck.Problems = append(fileCheck.Problems, fmt.Sprintf("cannot read TOC file %s: %v", tocFilename, err))
+		return fileCheck
+	}
+	if backupConfig.MetadataOnly || len(tableFQNs) == 0 {
+		return fileCheck
+	}
+
+	var plugin *utils.PluginConfig
+	if backupConfig.Plugin != "" {
+		plugin = pluginConfig
+	}
+	dataFiles, fileTables, missingTables := GetDataFilesToCheck(backupConfig, fpInfo, tocfile, tableFQNs)
+	for _, tableFQN := range missingTables {
+		fileCheck.TableProblems[tableFQN] = append(fileCheck.TableProblems[tableFQN], "not in the TOC")
+	}
+	if len(dataFiles) == 0 {
+		return fileCheck
+	}
+	missingFiles := FindMissingFilesOnSegments(globalCluster, fpInfo, dataFiles, plugin)
+	for _, dataFile := range dataFiles {
+		contentIDs, ok := missingFiles[dataFile]
+		if !ok {
+			continue
+		}
+		backupResults, tableResults := &fileCheck.Problems, fileCheck.TableProblems
+		result := fmt.Sprintf("file %s is missing on segments %s", dataFile, formatContentIDs(contentIDs))
+		if plugin != nil {
+			backupResults, tableResults = &fileCheck.Unchecked, fileCheck.TableUnchecked
+			result = fmt.Sprintf("file %s, as plugin %s could not read it on segments %s", dataFile, backupConfig.Plugin, formatContentIDs(contentIDs))
+		}
+		if tableFQN, ok := fileTables[dataFile]; ok {
+			tableResults[tableFQN] = append(tableResults[tableFQN], result)
+		} else {
+			*backupResults = append(*backupResults, result)
+		}
+	}
+	return fileCheck
+}
+
+/*
+ * The master files of a plugin backup are restored using the plugin if they
+ * are no longer in the backup directory, as gprestore would do.  Returns
+ * false if the file could not be checked, along with the reason.
+ */
+func checkMasterFile(backupConfig *history.BackupConfig, filename string, pluginConfigFile string) (string, bool) {
+	if _, err := os.Stat(filename); err == nil {
+		return "", true
+	}
+	if backupConfig.Plugin == "" {
+		return fmt.Sprintf("file %s is missing", filename), true
+	}
+	if pluginConfigFile == "" || pluginName != backupConfig.Plugin {
+		return fmt.Sprintf("file %s, as it is not in the backup directory and plugin %s was not set up", filename, backupConfig.Plugin), false
+	}
+	if err := pluginConfig.RestoreFile(filename); err != nil {
+		return fmt.Sprintf("file %s, as it is not in the backup directory and could not be restored using plugin %s", filename, backupConfig.Plugin), false
+	}
+	return "", true
+}
+
+func setEncryptionKeyForBackup(backupConfig *history.BackupConfig, keySource *utils.EncryptionKeySource) error {
+	if !backupConfig.Encrypted {
+		utils.SetEncryptionKey(nil)
+		return nil
+	}
+	if keySource == nil {
+		return errors.Errorf("encrypted, but neither --%s nor --%s was specified", options.ENCRYPTION_KEY_FILE, options.ENCRYPTION_PASSPHRASE_FILE)
+	}
+	key, err := keySource.DeriveKey(backupConfig.EncryptionSalt)
+	if err != nil {
+		return err
+	}
+	err = utils.ValidateEncryptionKeyFingerprint(key, backupConfig.EncryptionKeyFingerprint)
+	if err != nil {
+		return err
+	}
+	utils.SetEncryptionKey(key)
+	return nil
+}
+
+func readTOC(filename string) (*toc.TOC, error) {
+	contents, err := utils.ReadFileWithDecryption(filename)
+	if err != nil {
+		return nil, err
+	}
+	tocfile := &toc.TOC{}
+	err = yaml.Unmarshal(contents, tocfile)
+	if err != nil {
+		return nil, err
+	}
+	return tocfile, nil
+}
+
+/*
+ * Returns the templates of the paths of the data files to check on each
+ * segment, as used in COPY commands, the table each data file belongs to, and
+ * the tables that are not in the TOC.  A single-data-file backup has one data
+ * file and a segment TOC per segment, which belong to every table.
+ */
+func GetDataFilesToCheck(backupConfig *history.BackupConfig, fpInfo filepath.FilePathInfo, tocfile *toc.TOC, tableFQNs []string) ([]string, map[string]string, []string) {
+	extension := getDataFileExtension(backupConfig)
+	if backupConfig.SingleDataFile {
+		dataFile := fpInfo.GetTableBackupFilePathForCopyCommand(0, extension, true)
+		segmentTOCFile := getSegmentTOCFileTemplate(dataFile, extension)
+		return []string{dataFile, segmentTOCFile}, map[string]string{}, []string{}
+	}
+
+	dataEntries := make(map[string]toc.MasterDataEntry, len(tocfile.DataEntries))
+	for _, dataEntry := range tocfile.DataEntries {
+		dataEntries[utils.MakeFQN(dataEntry.Schema, dataEntry.Name)] = dataEntry
+	}
+	dataFiles := make([]string, 0, len(tableFQNs))
+	fileTables := make(map[string]string, len(tableFQNs))
+	missingTables := make([]string, 0)
+	for _, tableFQN := range tableFQNs {
+		dataEntry, ok := dataEntries[tableFQN]
+		if !ok {
+			missingTables = append(missingTables, tableFQN)
+			continue
+		}
+		dataFile := fpInfo.GetTableBackupFilePathForCopyCommand(dataEntry.Oid, extension, false)
+		dataFiles = append(dataFiles, dataFile)
+		fileTables[dataFile] = tableFQN
+	}
+	return dataFiles, fileTables, missingTables
+}
+
+/*
+ * The list of path templates is copied to each segment, which prints each
+ * template whose file does not exist.  With a plugin, a file exists if the
+ * plugin can start streaming it; the stream is cut off after the first byte,
+ * so the plugin exiting due to SIGPIPE is not an error.
+ */
+func FindMissingFilesOnSegments(c *cluster.Cluster, fpInfo filepath.FilePathInfo, dataFiles []string, plugin *utils.PluginConfig) map[string][]int {
+	utils.WriteOidListToSegments(dataFiles, c, fpInfo)
+	defer utils.CleanUpHelperFilesOnAllHosts(c, fpInfo)
+
+	remoteOutput := c.GenerateAndExecuteCommand("Checking data files", func(contentID int) string {
+		substitute := substituteCopyFormatStrings(fpInfo, contentID, "f", "p")
+		check := `test -e "$p"`
+		command := ""
+		if plugin != nil {
+			check = fmt.Sprintf(`%s restore_data %s "$p" < /dev/null 2> /dev/null | head -c 1 > /dev/null; rc=${PIPESTATUS[0]}; [[ $rc -eq 0 || $rc -eq 141 ]]`,
+				plugin.ExecutablePath, plugin.ConfigPath)
+			command = fmt.Sprintf("source %s/greenplum_path.sh && ", operating.System.Getenv("GPHOME"))
+		}
+		return command + fmt.Sprintf(`while read -r f; do %s; %s || echo "$f"; done < %s`,
+			substitute, check, fpInfo.GetSegmentHelperFilePath(contentID, "oid"))
+	}, cluster.ON_SEGMENTS)
+	c.CheckClusterError(remoteOutput, "Unable to check data files", func(contentID int) string {
+		return fmt.Sprintf("Unable to check data files on segment %d on host %s", contentID, c.GetHostForContent(contentID))
+	})
+
+	missingFiles := make(map[string][]int)
+	contentIDs := make([]int, 0, len(remoteOutput.Stdouts))
+	for contentID := range remoteOutput.Stdouts {
+		contentIDs = append(contentIDs, contentID)
+	}
+	sort.Ints(contentIDs)
+	for _, contentID := range contentIDs {
+		for _, line := range strings.Split(strings.TrimSpace(remoteOutput.Stdouts[contentID]), "\n") {
+			if line != "" {
+				missingFiles[line] = append(missingFiles[line], contentID)
+			}
+		}
+	}
+	return missingFiles
+}
+
+/*
+ * Returns a bash statement that sets the variable to the path template in
+ * fromVariable with the segment data directory and content ID of the given
+ * segment substituted, as replaceCopyFormatStringsInPath does in Go.
+ */
+func substituteCopyFormatStrings(fpInfo filepath.FilePathInfo, contentID int, fromVariable string, variable string) string {
+	return fmt.Sprintf(`%[2]s=${%[1]s//<SEGID>/%[3]d}; %[2]s=${%[2]s//<SEG_DATA_DIR>/%[4]s}`,
+		fromVariable, variable, contentID, fpInfo.SegDirMap[contentID])
+}
+
+func getSegmentTOCFileTemplate(dataFile string, extension string) string {
+	return strings.TrimSuffix(dataFile, extension) + "_toc.yaml"
+}
+
+func formatContentIDs(contentIDs []int) string {
+	contentIDStrs := make([]string, len(contentIDs))
+	for i, contentID := range contentIDs {
+		contentIDStrs[i] = fmt.Sprintf("%d", contentID)
+	}
+	return strings.Join(contentIDStrs, ", ")
+}
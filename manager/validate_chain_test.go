@@ -0,0 +1,266 @@
+package manager_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("manager/validate_chain tests", func() {
+	var (
+		backupHistory *history.History
+		incremental   *history.BackupConfig
+	)
+	BeforeEach(func() {
+		manager.SetHistoryFilePath("/tmp/history_file.yaml")
+		backupHistory = &history.History{BackupConfigs: []history.BackupConfig{
+			{Timestamp: "20190103010101", DatabaseName: "testdb", Incremental: true, Status: history.BackupStatusSucceed,
+				RestorePlan: []history.RestorePlanEntry{
+					{Timestamp: "20190101010101", TableFQNs: []string{"public.ao1"}},
+					{Timestamp: "20190102010101", TableFQNs: []string{"public.ao2"}},
+					{Timestamp: "20190103010101", TableFQNs: []string{"public.heap1"}},
+				}},
+			{Timestamp: "20190102010101", DatabaseName: "testdb", Incremental: true, Status: history.BackupStatusSucceed,
+				RestorePlan: []history.RestorePlanEntry{
+					{Timestamp: "20190101010101", TableFQNs: []string{"public.ao1", "public.heap1"}},
+					{Timestamp: "20190102010101", TableFQNs: []string{"public.ao2"}},
+				}},
+			{Timestamp: "20190101010101", DatabaseName: "testdb", Status: history.BackupStatusSucceed,
+				RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190101010101", TableFQNs: []string{"public.ao1", "public.ao2", "public.heap1"}}}},
+		}}
+		incremental = backupHistory.FindBackupConfigIncludingFailed("20190103010101")
+	})
+	Describe("GetBackupsToValidate", func() {
+		It("returns every successful backup that has not been deleted if no timestamp is given", func() {
+			backupHistory.FindBackupConfigIncludingFailed("20190102010101").Status = history.BackupStatusFailed
+			backupHistory.FindBackupConfigIncludingFailed("20190101010101").DateDeleted = "20190104010101"
+
+			backupConfigs, err := manager.GetBackupsToValidate(backupHistory, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backupConfigs).To(Equal([]*history.BackupConfig{incremental}))
+		})
+		It("returns the backup with the given timestamp", func() {
+			backupConfigs, err := manager.GetBackupsToValidate(backupHistory, "20190102010101")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backupConfigs).To(HaveLen(1))
+			Expect(backupConfigs[0].Timestamp).To(Equal("20190102010101"))
+		})
+		It("returns an error if the backup with the given timestamp failed", func() {
+			incremental.Status = history.BackupStatusFailed
+			_, err := manager.GetBackupsToValidate(backupHistory, "20190103010101")
+			Expect(err).To(MatchError("Backup 20190103010101 failed and cannot be restored"))
+		})
+	})
+	Describe("ValidateRestorePlan", func() {
+		It("returns no problems for an intact restore plan", func() {
+			Expect(manager.ValidateRestorePlan(backupHistory, incremental)).To(BeEmpty())
+		})
+		It("reports backups in the restore plan that are missing, deleted, or failed", func() {
+			backupHistory.BackupConfigs = backupHistory.BackupConfigs[:2]
+			backupHistory.FindBackupConfigIncludingFailed("20190102010101").Status = history.BackupStatusFailed
+
+			Expect(manager.ValidateRestorePlan(backupHistory, incremental)).To(Equal([]string{
+				"backup 20190101010101 in the restore plan is not in the history file",
+				"backup 20190102010101 in the restore plan failed",
+			}))
+
+			backupHistory.FindBackupConfigIncludingFailed("20190102010101").DateDeleted = "20190104010101"
+			Expect(manager.ValidateRestorePlan(backupHistory, incremental)).To(ContainElement(
+				"backup 20190102010101 in the restore plan was deleted on 20190104010101"))
+		})
+		It("allows the failed backup that the backup resumed", func() {
+			backupHistory.FindBackupConfigIncludingFailed("20190102010101").Status = history.BackupStatusFailed
+			incremental.ResumedFrom = "20190102010101"
+
+			Expect(manager.ValidateRestorePlan(backupHistory, incremental)).To(BeEmpty())
+		})
+		It("reports backups in the restore plan that were taken with different flags", func() {
+			backupHistory.FindBackupConfigIncludingFailed("20190101010101").Compressed = true
+
+			Expect(manager.ValidateRestorePlan(backupHistory, incremental)).To(Equal([]string{
+				"backup 20190101010101 in the restore plan was taken with different flags",
+			}))
+		})
+	})
+	Describe("GetTablesToCheck", func() {
+		It("combines the tables each backup is restored from across all restore plans", func() {
+			backupConfigs := []*history.BackupConfig{incremental, backupHistory.FindBackupConfigIncludingFailed("20190102010101")}
+
+			Expect(manager.GetTablesToCheck(backupHistory, backupConfigs)).To(Equal(map[string][]string{
+				"20190101010101": {"public.ao1", "public.heap1"},
+				"20190102010101": {"public.ao2"},
+				"20190103010101": {"public.heap1"},
+			}))
+		})
+		It("leaves out backups that have been deleted", func() {
+			backupHistory.FindBackupConfigIncludingFailed("20190101010101").DateDeleted = "20190104010101"
+
+			Expect(manager.GetTablesToCheck(backupHistory, []*history.BackupConfig{incremental})).ToNot(HaveKey("20190101010101"))
+		})
+	})
+	Describe("GetFileProblems", func() {
+		It("only reports problems with the tables the restore plan reads from each backup", func() {
+			fileChecks := map[string]*manager.BackupFileCheck{
+				"20190101010101": {TableProblems: map[string][]string{
+					"public.ao1":   {"file gpbackup_<SEGID>_20190101010101_1 is missing on segments 0"},
+					"public.heap1": {"file gpbackup_<SEGID>_20190101010101_3 is missing on segments 1"},
+				}},
+				"20190102010101": {Problems: []string{"file /tmp/gpbackup_20190102010101_toc.yaml is missing"}},
+			}
+
+			Expect(manager.GetFileProblems(incremental, fileChecks)).To(Equal([]string{
+				"backup 20190101010101 in the restore plan: table public.ao1: file gpbackup_<SEGID>_20190101010101_1 is missing on segments 0",
+				"backup 20190102010101 in the restore plan: file /tmp/gpbackup_20190102010101_toc.yaml is missing",
+			}))
+		})
+	})
+	Describe("GetUncheckedFiles", func() {
+		It("only reports files the restore plan reads from each backup that could not be checked", func() {
+			fileChecks := map[string]*manager.BackupFileCheck{
+				"20190101010101": {
+					Problems: []string{"file /tmp/gpbackup_20190101010101_config.yaml is missing"},
+					TableUnchecked: map[string][]string{
+						"public.ao1": {"file gpbackup_<SEGID>_20190101010101_1, as plugin my_plugin could not read it on segments 0"},
+						"public.ao2": {"file gpbackup_<SEGID>_20190101010101_2, as plugin my_plugin could not read it on segments 0"},
+					}},
+				"20190102010101": {Unchecked: []string{"files taken using plugin my_plugin, as --plugin-config was not specified"}},
+			}
+
+			Expect(manager.GetUncheckedFiles(incremental, fileChecks)).To(Equal([]string{
+				"backup 20190101010101 in the restore plan: table public.ao1: file gpbackup_<SEGID>_20190101010101_1, as plugin my_plugin could not read it on segments 0",
+				"backup 20190102010101 in the restore plan: files taken using plugin my_plugin, as --plugin-config was not specified",
+			}))
+		})
+	})
+	Describe("CheckBackupFiles", func() {
+		It("does not report a plugin backup as broken if no plugin config is given", func() {
+			backupConfig := &history.BackupConfig{Timestamp: "20190101010101", Plugin: "my_plugin"}
+
+			fileCheck := manager.CheckBackupFiles(backupConfig, []string{"public.ao1"}, "", nil)
+			Expect(fileCheck.Problems).To(BeEmpty())
+			Expect(fileCheck.TableProblems).To(BeEmpty())
+			Expect(fileCheck.Unchecked).To(Equal([]string{"files taken using plugin my_plugin, as --plugin-config was not specified"}))
+		})
+		It("does not report an encrypted backup as broken if no key is given", func() {
+			backupConfig := &history.BackupConfig{Timestamp: "20190101010101", Encrypted: true}
+
+			fileCheck := manager.CheckBackupFiles(backupConfig, []string{"public.ao1"}, "", nil)
+			Expect(fileCheck.Problems).To(BeEmpty())
+			Expect(fileCheck.TableProblems).To(BeEmpty())
+			Expect(fileCheck.Unchecked).To(Equal([]string{"encrypted files: encrypted, but neither --encryption-key-file nor --encryption-passphrase-file was specified"}))
+		})
+	})
+	Describe("DoValidateChain", func() {
+		var backupDir string
+		BeforeEach(func() {
+			var err error
+			backupDir, err = ioutil.TempDir("", "validate_chain")
+			Expect(err).ToNot(HaveOccurred())
+			manager.SetHistoryFilePath(path.Join(backupDir, "gpbackup_history.yaml"))
+			manager.SetCluster(testutils.SetDefaultSegmentConfiguration())
+			manager.SetSegPrefix("gpseg")
+			options.SetManagerValidateChainFlagDefaults(cmdFlags)
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(backupDir)
+		})
+		It("does not mark plugin or encrypted backups as failed with --repair if their plugin config or key is not given", func() {
+			backupHistory = &history.History{BackupConfigs: []history.BackupConfig{
+				{Timestamp: "20190102010101", DatabaseName: "testdb", Status: history.BackupStatusSucceed, BackupDir: backupDir, Encrypted: true,
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190102010101", TableFQNs: []string{"public.ao1"}}}},
+				{Timestamp: "20190101010101", DatabaseName: "testdb", Status: history.BackupStatusSucceed, Plugin: "my_plugin",
+					RestorePlan: []history.RestorePlanEntry{{Timestamp: "20190101010101", TableFQNs: []string{"public.ao1"}}}},
+			}}
+			Expect(backupHistory.WriteToFileAndMakeReadOnly(path.Join(backupDir, "gpbackup_history.yaml"))).To(Succeed())
+			metadataDir := path.Join(backupDir, "gpseg-1", "backups", "20190102", "20190102010101")
+			Expect(os.MkdirAll(metadataDir, 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(path.Join(metadataDir, "gpbackup_20190102010101_metadata.sql"), []byte{}, 0600)).To(Succeed())
+			Expect(cmdFlags.Set(options.REPAIR, "true")).To(Succeed())
+
+			manager.DoValidateChain([]string{})
+
+			resultHistory, err := history.NewHistory(path.Join(backupDir, "gpbackup_history.yaml"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.BackupConfigs[0].Failed()).To(BeFalse())
+			Expect(resultHistory.BackupConfigs[1].Failed()).To(BeFalse())
+			Expect(logfile).To(Say("2 of 2 backups could not be fully validated"))
+			Expect(logfile).ToNot(Say("Marked backup"))
+		})
+	})
+	Describe("GetDataFilesToCheck", func() {
+		var fpInfo filepath.FilePathInfo
+		var tocfile *toc.TOC
+		BeforeEach(func() {
+			fpInfo = filepath.NewFilePathInfo(testutils.SetDefaultSegmentConfiguration(), "/backup_dir", "20190101010101", "gpseg")
+			tocfile = &toc.TOC{DataEntries: []toc.MasterDataEntry{
+				{Schema: "public", Name: "ao1", Oid: 1},
+				{Schema: "public", Name: "heap1", Oid: 3},
+			}}
+		})
+		It("returns the data file of each table and the tables that are not in the TOC", func() {
+			backupConfig := &history.BackupConfig{Timestamp: "20190101010101", Compressed: true}
+
+			dataFiles, fileTables, missingTables := manager.GetDataFilesToCheck(backupConfig, fpInfo, tocfile, []string{"public.ao1", "public.ao2"})
+			Expect(dataFiles).To(Equal([]string{"/backup_dir/gpseg<SEGID>/backups/20190101/20190101010101/gpbackup_<SEGID>_20190101010101_1.gz"}))
+			Expect(fileTables).To(Equal(map[string]string{
+				"/backup_dir/gpseg<SEGID>/backups/20190101/20190101010101/gpbackup_<SEGID>_20190101010101_1.gz": "public.ao1",
+			}))
+			Expect(missingTables).To(Equal([]string{"public.ao2"}))
+		})
+		It("returns the data file and segment TOC of a single-data-file backup", func() {
+			backupConfig := &history.BackupConfig{Timestamp: "20190101010101", SingleDataFile: true, Compressed: true, Encrypted: true}
+
+			dataFiles, fileTables, missingTables := manager.GetDataFilesToCheck(backupConfig, fpInfo, tocfile, []string{"public.ao1", "public.ao2"})
+			Expect(dataFiles).To(Equal([]string{
+				"/backup_dir/gpseg<SEGID>/backups/20190101/20190101010101/gpbackup_<SEGID>_20190101010101.gz" + utils.EncryptionExtension,
+				"/backup_dir/gpseg<SEGID>/backups/20190101/20190101010101/gpbackup_<SEGID>_20190101010101_toc.yaml",
+			}))
+			Expect(fileTables).To(BeEmpty())
+			Expect(missingTables).To(BeEmpty())
+		})
+	})
+	Describe("FindMissingFilesOnSegments", func() {
+		var testCluster *cluster.Cluster
+		var executor testutils.TestExecutorMultiple
+		var fpInfo filepath.FilePathInfo
+		BeforeEach(func() {
+			testCluster = testutils.SetDefaultSegmentConfiguration()
+			executor = testutils.TestExecutorMultiple{
+				ClusterOutputs: []*cluster.RemoteOutput{
+					{},
+					{Stdouts: map[int]string{0: "file_a\nfile_b\n", 1: "file_b\n"}},
+					{},
+				},
+			}
+			testCluster.Executor = &executor
+			fpInfo = filepath.NewFilePathInfo(testCluster, "/backup_dir", "20190101010101", "gpseg")
+		})
+		It("returns the segments each file is missing on", func() {
+			missingFiles := manager.FindMissingFilesOnSegments(testCluster, fpInfo, []string{"file_a", "file_b", "file_c"}, nil)
+
+			Expect(missingFiles).To(Equal(map[string][]int{"file_a": {0}, "file_b": {0, 1}}))
+			Expect(executor.ClusterCommands[1][0]).To(ContainElement(MatchRegexp(
+				`^while read -r f; do p=\$\{f//<SEGID>/0\}; p=\$\{p//<SEG_DATA_DIR>/[^}]*\}; test -e "\$p" \|\| echo "\$f"; done < `)))
+		})
+		It("checks whether the plugin can restore each file", func() {
+			plugin := &utils.PluginConfig{ExecutablePath: "/tmp/fake_plugin.sh", ConfigPath: "/tmp/plugin_config.yaml"}
+			manager.FindMissingFilesOnSegments(testCluster, fpInfo, []string{"file_a"}, plugin)
+
+			Expect(executor.ClusterCommands[1][1]).To(ContainElement(ContainSubstring(
+				`/tmp/fake_plugin.sh restore_data /tmp/plugin_config.yaml "$p" < /dev/null 2> /dev/null | head -c 1 > /dev/null; rc=${PIPESTATUS[0]}; [[ $rc -eq 0 || $rc -eq 141 ]] || echo "$f"`)))
+		})
+	})
+})
@@ -0,0 +1,267 @@
+package metrics
+
+/*
+ * This file contains structs and functions related to exposing the progress of
+ * a backup or restore as Prometheus metrics, either on an HTTP endpoint while
+ * it runs or as a node exporter textfile when it finishes.
+ *
+ * All methods can be called on a nil *Registry, in which case they do nothing,
+ * so callers do not need to check whether metrics were requested.
+ */
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/pkg/errors"
+)
+
+const (
+	COUNTER = "counter"
+	GAUGE   = "gauge"
+)
+
+type metric struct {
+	help       string
+	metricType string
+	values     map[string]float64
+}
+
+type Registry struct {
+	prefix  string
+	labels  map[string]string
+	metrics map[string]*metric
+	server  *http.Server
+	lock    sync.Mutex
+}
+
+/*
+ * Each metric name is prefixed with the name of the program, and every sample
+ * has the given constant labels (e.g. the database being backed up).
+ */
+func NewRegistry(program string, constLabels map[string]string) *Registry {
+	return &Registry{
+		prefix:  program,
+		labels:  constLabels,
+		metrics: make(map[string]*metric),
+	}
+}
+
+func (r *Registry) AddCounter(name string, help string, delta float64, labels ...string) {
+	r.update(name, help, COUNTER, labels, func(value float64) float64 { return value + delta })
+}
+
+func (r *Registry) SetGauge(name string, help string, value float64, labels ...string) {
+	r.update(name, help, GAUGE, labels, func(float64) float64 { return value })
+}
+
+func (r *Registry) SetTables(numTables int64) {
+	r.SetGauge("tables", "Number of tables whose data is to be copied", float64(numTables))
+}
+
+func (r *Registry) TableCompleted(rowsCopied int64) {
+	r.AddCounter("tables_completed_total", "Number of tables whose data has been copied", 1)
+	r.AddCounter("rows_copied_total", "Number of rows copied", float64(rowsCopied))
+}
+
+func (r *Registry) AddErrors(numErrors int64) {
+	r.AddCounter("errors_total", "Number of errors encountered", float64(numErrors))
+}
+
+func (r *Registry) SetSectionDuration(section string, duration time.Duration) {
+	r.SetGauge("section_duration_seconds", "Time taken by each section", duration.Seconds(), "section", section)
+}
+
+func (r *Registry) SetSegmentBytes(contentID int, numBytes int64) {
+	r.SetGauge("segment_bytes_written", "Number of bytes of data files written on each segment", float64(numBytes), "segment", fmt.Sprintf("%d", contentID))
+}
+
+func (r *Registry) SetSegmentBytesRead(contentID int, numBytes int64) {
+	r.SetGauge("segment_bytes_read", "Number of bytes of data files read on each segment", float64(numBytes), "segment", fmt.Sprintf("%d", contentID))
+}
+
+/*
+ * Records the outcome of the run, writes the textfile if one was requested,
+ * and stops the HTTP server.  Errors are only logged, as this runs during
+ * cleanup.
+ */
+func (r *Registry) Finish(succeeded bool, startTime time.Time, endTime time.Time, textfile string) {
+	if r == nil {
+		return
+	}
+	success := 0.0
+	if succeeded {
+		success = 1
+	}
+	r.SetGauge("success", "Whether the run completed successfully", success)
+	// Ensure the error count is exported even if no errors occurred
+	r.AddErrors(0)
+	r.SetGauge("start_time_seconds", "Start time of the run since the Unix epoch", float64(startTime.Unix()))
+	r.SetGauge("end_time_seconds", "End time of the run since the Unix epoch", float64(endTime.Unix()))
+	r.SetGauge("duration_seconds", "Time taken by the run", endTime.Sub(startTime).Seconds())
+	if textfile != "" {
+		err := r.WriteTextfile(textfile)
+		if err != nil {
+			gplog.Warn("Unable to write metrics to %s: %v", textfile, err)
+		}
+	}
+	r.Stop()
+}
+
+/*
+ * Labels are passed as alternating names and values, e.g. "segment", "0".
+ */
+func (r *Registry) update(name string, help string, metricType string, labels []string, updateFunc func(float64) float64) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	fullName := fmt.Sprintf("%s_%s", r.prefix, name)
+	m, ok := r.metrics[fullName]
+	if !ok {
+		m = &metric{help: help, metricType: metricType, values: make(map[string]float64)}
+		r.metrics[fullName] = m
+	}
+	labelStr := r.formatLabels(labels)
+	m.values[labelStr] = updateFunc(m.values[labelStr])
+}
+
+func (r *Registry) formatLabels(labels []string) string {
+	allLabels := make(map[string]string, len(r.labels)+len(labels)/2)
+	for name, value := range r.labels {
+		allLabels[name] = value
+	}
+	for i := 0; i+1 < len(labels); i += 2 {
+		allLabels[labels[i]] = labels[i+1]
+	}
+	if len(allLabels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(allLabels))
+	for name := range allLabels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(allLabels[name]))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ","))
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
+
+/*
+ * Writes all metrics in the Prometheus text exposition format, sorted by name
+ * and labels so that the output is stable.
+ */
+func (r *Registry) WriteMetrics(writer io.Writer) error {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := r.metrics[name]
+		_, err := fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", name, m.help, name, m.metricType)
+		if err != nil {
+			return err
+		}
+		labelStrs := make([]string, 0, len(m.values))
+		for labelStr := range m.values {
+			labelStrs = append(labelStrs, labelStr)
+		}
+		sort.Strings(labelStrs)
+		for _, labelStr := range labelStrs {
+			_, err = fmt.Fprintf(writer, "%s%s %s\n", name, labelStr, strconv.FormatFloat(m.values[labelStr], 'f', -1, 64))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ * Serves the metrics on http://<host>:<port>/metrics until Stop is called.
+ */
+func (r *Registry) StartServer(port int) error {
+	if r == nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return errors.Wrapf(err, "Unable to serve metrics on port %d", port)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = r.WriteMetrics(writer)
+	})
+	r.server = &http.Server{Handler: mux}
+	go func() {
+		err := r.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			gplog.Warn("Metrics server on port %d stopped: %v", port, err)
+		}
+	}()
+	gplog.Verbose("Serving metrics on port %d", port)
+	return nil
+}
+
+func (r *Registry) Stop() {
+	if r == nil || r.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = r.server.Shutdown(ctx)
+	r.server = nil
+}
+
+/*
+ * The node exporter may read the textfile at any time, so the metrics are
+ * written to a temporary file that is then renamed over it.
+ */
+func (r *Registry) WriteTextfile(filename string) error {
+	if r == nil {
+		return nil
+	}
+	tempFilename := fmt.Sprintf("%s.%d.tmp", filename, os.Getpid())
+	file, err := os.OpenFile(tempFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = r.WriteMetrics(file)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFilename, filename)
+	}
+	if err != nil {
+		_ = os.Remove(tempFilename)
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package metrics_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Metrics Suite")
+}
+
+var _ = BeforeSuite(func() {
+	_, _, _ = testhelper.SetupTestLogger()
+})
+
+var _ = Describe("metrics tests", func() {
+	var registry *metrics.Registry
+	BeforeEach(func() {
+		registry = metrics.NewRegistry("gpbackup", map[string]string{"database": "testdb"})
+	})
+	Describe("WriteMetrics", func() {
+		It("writes counters and gauges in the Prometheus text format", func() {
+			registry.SetTables(2)
+			registry.TableCompleted(10)
+			registry.TableCompleted(5)
+			registry.SetSegmentBytes(1, 200)
+			registry.SetSegmentBytes(0, 100)
+			registry.SetSectionDuration("data", 1500*time.Millisecond)
+
+			buffer := &bytes.Buffer{}
+			Expect(registry.WriteMetrics(buffer)).To(Succeed())
+
+			Expect(buffer.String()).To(Equal(`# HELP gpbackup_rows_copied_total Number of rows copied
+# TYPE gpbackup_rows_copied_total counter
+gpbackup_rows_copied_total{database="testdb"} 15
+# HELP gpbackup_section_duration_seconds Time taken by each section
+# TYPE gpbackup_section_duration_seconds gauge
+gpbackup_section_duration_seconds{database="testdb",section="data"} 1.5
+# HELP gpbackup_segment_bytes_written Number of bytes of data files written on each segment
+# TYPE gpbackup_segment_bytes_written gauge
+gpbackup_segment_bytes_written{database="testdb",segment="0"} 100
+gpbackup_segment_bytes_written{database="testdb",segment="1"} 200
+# HELP gpbackup_tables Number of tables whose data is to be copied
+# TYPE gpbackup_tables gauge
+gpbackup_tables{database="testdb"} 2
+# HELP gpbackup_tables_completed_total Number of tables whose data has been copied
+# TYPE gpbackup_tables_completed_total counter
+gpbackup_tables_completed_total{database="testdb"} 2
+`))
+		})
+		It("writes the bytes read on each segment", func() {
+			registry = metrics.NewRegistry("gprestore", map[string]string{"database": "testdb"})
+			registry.SetSegmentBytesRead(0, 100)
+
+			buffer := &bytes.Buffer{}
+			Expect(registry.WriteMetrics(buffer)).To(Succeed())
+
+			Expect(buffer.String()).To(Equal(`# HELP gprestore_segment_bytes_read Number of bytes of data files read on each segment
+# TYPE gprestore_segment_bytes_read gauge
+gprestore_segment_bytes_read{database="testdb",segment="0"} 100
+`))
+		})
+		It("escapes label values", func() {
+			registry = metrics.NewRegistry("gprestore", map[string]string{"database": `my "db"\`})
+			registry.AddErrors(1)
+
+			buffer := &bytes.Buffer{}
+			Expect(registry.WriteMetrics(buffer)).To(Succeed())
+
+			Expect(buffer.String()).To(ContainSubstring(`gprestore_errors_total{database="my \"db\"\\"} 1`))
+		})
+		It("does nothing for a nil registry", func() {
+			var nilRegistry *metrics.Registry
+			nilRegistry.TableCompleted(10)
+
+			buffer := &bytes.Buffer{}
+			Expect(nilRegistry.WriteMetrics(buffer)).To(Succeed())
+			Expect(buffer.Len()).To(Equal(0))
+		})
+	})
+	Describe("Finish", func() {
+		It("records the outcome of the run and writes the textfile", func() {
+			tempDir, err := ioutil.TempDir("", "metrics")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tempDir)
+			textfile := filepath.Join(tempDir, "gpbackup.prom")
+			startTime := time.Unix(1483232461, 0)
+
+			registry.Finish(true, startTime, startTime.Add(time.Minute), textfile)
+
+			contents, err := ioutil.ReadFile(textfile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring(`gpbackup_success{database="testdb"} 1`))
+			Expect(string(contents)).To(ContainSubstring(`gpbackup_errors_total{database="testdb"} 0`))
+			Expect(string(contents)).To(ContainSubstring(`gpbackup_start_time_seconds{database="testdb"} 1483232461`))
+			Expect(string(contents)).To(ContainSubstring(`gpbackup_duration_seconds{database="testdb"} 60`))
+			files, _ := ioutil.ReadDir(tempDir)
+			Expect(files).To(HaveLen(1))
+		})
+	})
+})
@@ -14,46 +14,82 @@ import (
 )
 
 const (
-	BACKUP_DIR            = "backup-dir"
-	COMPRESSION_LEVEL     = "compression-level"
-	DATA_ONLY             = "data-only"
-	DBNAME                = "dbname"
-	DEBUG                 = "debug"
-	EXCLUDE_RELATION      = "exclude-table"
-	EXCLUDE_RELATION_FILE = "exclude-table-file"
-	EXCLUDE_SCHEMA        = "exclude-schema"
-	EXCLUDE_SCHEMA_FILE   = "exclude-schema-file"
-	FROM_TIMESTAMP        = "from-timestamp"
-	INCLUDE_RELATION      = "include-table"
-	INCLUDE_RELATION_FILE = "include-table-file"
-	INCLUDE_SCHEMA        = "include-schema"
-	INCLUDE_SCHEMA_FILE   = "include-schema-file"
-	INCREMENTAL           = "incremental"
-	JOBS                  = "jobs"
-	LEAF_PARTITION_DATA   = "leaf-partition-data"
-	METADATA_ONLY         = "metadata-only"
-	NO_COMPRESSION        = "no-compression"
-	PLUGIN_CONFIG         = "plugin-config"
-	QUIET                 = "quiet"
-	SINGLE_DATA_FILE      = "single-data-file"
-	VERBOSE               = "verbose"
-	WITH_STATS            = "with-stats"
-	CREATE_DB             = "create-db"
-	ON_ERROR_CONTINUE     = "on-error-continue"
-	REDIRECT_DB           = "redirect-db"
-	TIMESTAMP             = "timestamp"
-	WITH_GLOBALS          = "with-globals"
-	REDIRECT_SCHEMA       = "redirect-schema"
-	TRUNCATE_TABLE        = "truncate-table"
-	WITHOUT_GLOBALS       = "without-globals"
+	BACKUP_DIR                 = "backup-dir"
+	COMPRESSION_LEVEL          = "compression-level"
+	COMPRESSION_TYPE           = "compression-type"
+	DATA_ONLY                  = "data-only"
+	DBNAME                     = "dbname"
+	DEBUG                      = "debug"
+	DRY_RUN                    = "dry-run"
+	ENCRYPTION_KEY_FILE        = "encryption-key-file"
+	ENCRYPTION_PASSPHRASE_FILE = "encryption-passphrase-file"
+	EXCLUDE_OBJECT_TYPE        = "exclude-object-type"
+	EXCLUDE_RELATION           = "exclude-table"
+	EXCLUDE_RELATION_FILE      = "exclude-table-file"
+	EXCLUDE_SCHEMA             = "exclude-schema"
+	EXCLUDE_SCHEMA_FILE        = "exclude-schema-file"
+	FROM_TIMESTAMP             = "from-timestamp"
+	HARD_LINK                  = "hard-link"
+	INCLUDE_OBJECT_TYPE        = "include-object-type"
+	INCLUDE_RELATION           = "include-table"
+	INCLUDE_RELATION_FILE      = "include-table-file"
+	INCLUDE_SCHEMA             = "include-schema"
+	INCLUDE_SCHEMA_FILE        = "include-schema-file"
+	INCREMENTAL                = "incremental"
+	INCREMENTAL_HEAP           = "incremental-heap"
+	INCREMENTAL_HEAP_MAX_AGE   = "incremental-heap-max-age"
+	JOBS                       = "jobs"
+	KEEP_DAYS                  = "keep-days"
+	KEEP_LAST_FULL             = "keep-last-full"
+	KEEP_MONTHLY               = "keep-monthly"
+	KEEP_WEEKLY                = "keep-weekly"
+	LEAF_PARTITION_DATA        = "leaf-partition-data"
+	LIST                       = "list"
+	MASKING_KEY_FILE           = "masking-key-file"
+	MASKING_RULE_FILE          = "masking-rule-file"
+	METADATA_ONLY              = "metadata-only"
+	METRICS_PORT               = "metrics-port"
+	METRICS_TEXTFILE           = "metrics-textfile"
+	NO_COMPRESSION             = "no-compression"
+	NO_OWNER                   = "no-owner"
+	NO_PRIVILEGES              = "no-privileges"
+	NOTIFICATION_CONFIG        = "notification-config"
+	PLUGIN_CONFIG              = "plugin-config"
+	QUIET                      = "quiet"
+	REPAIR                     = "repair"
+	REPORT_FORMAT              = "report-format"
+	RESUME                     = "resume"
+	ROLE_MAPPING               = "role-mapping"
+	ROW_FILTER_FILE            = "row-filter-file"
+	SINGLE_DATA_FILE           = "single-data-file"
+	SOURCE_PLUGIN_CONFIG       = "source-plugin-config"
+	TABLESPACE_MAPPING         = "tablespace-mapping"
+	VERBOSE                    = "verbose"
+	VERIFY                     = "verify"
+	WITH_STATS                 = "with-stats"
+	CREATE_DB                  = "create-db"
+	ON_ERROR_CONTINUE          = "on-error-continue"
+	REDIRECT_DB                = "redirect-db"
+	TIMESTAMP                  = "timestamp"
+	TO_SQL_FILE                = "to-sql-file"
+	WITH_GLOBALS               = "with-globals"
+	REDIRECT_SCHEMA            = "redirect-schema"
+	REDIRECT_MAPPING_FILE      = "redirect-mapping-file"
+	TRUNCATE_TABLE             = "truncate-table"
+	USE_LIST                   = "use-list"
+	WITHOUT_GLOBALS            = "without-globals"
 )
 
 func SetBackupFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.String(BACKUP_DIR, "", "The absolute path of the directory to which all backup files will be written")
-	flagSet.Int(COMPRESSION_LEVEL, 1, "Level of compression to use during data backup. Valid values are between 1 and 9.")
+	flagSet.Int(COMPRESSION_LEVEL, 1, "Level of compression to use during data backup. Valid values are between 1 and 9 for gzip, 1 and 19 for zstd, and 1 and 12 for lz4.")
+	flagSet.String(COMPRESSION_TYPE, "gzip", "Type of compression to use during data backup. Valid values are gzip, zstd, and lz4. zstd and lz4 must be installed on all hosts.")
 	flagSet.Bool(DATA_ONLY, false, "Only back up data, do not back up metadata")
 	flagSet.String(DBNAME, "", "The database to be backed up")
 	flagSet.Bool(DEBUG, false, "Print verbose and debug log messages")
+	flagSet.String(ENCRYPTION_KEY_FILE, "", "A file containing a 32-byte key, raw or hex-encoded, with which to encrypt all backup files")
+	flagSet.String(ENCRYPTION_PASSPHRASE_FILE, "", "A file containing a passphrase from which to derive a key with which to encrypt all backup files")
+	flagSet.StringArray(EXCLUDE_OBJECT_TYPE, []string{}, "Do not back up objects of the specified type(s), such as CAST or TEXT SEARCH PARSER. --exclude-object-type can be specified multiple times.")
 	flagSet.StringArray(EXCLUDE_SCHEMA, []string{}, "Back up all metadata except objects in the specified schema(s). --exclude-schema can be specified multiple times.")
 	flagSet.String(EXCLUDE_SCHEMA_FILE, "", "A file containing a list of schemas to be excluded from the backup")
 	flagSet.StringArray(EXCLUDE_RELATION, []string{}, "Back up all metadata except the specified table(s). --exclude-table can be specified multiple times.")
@@ -64,14 +100,24 @@ func SetBackupFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.String(INCLUDE_SCHEMA_FILE, "", "A file containing a list of schema(s) to be included in the backup")
 	flagSet.StringArray(INCLUDE_RELATION, []string{}, "Back up only the specified table(s). --include-table can be specified multiple times.")
 	flagSet.String(INCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified tables to be included in the backup")
-	flagSet.Bool(INCREMENTAL, false, "Only back up data for AO tables that have been modified since the last backup")
+	flagSet.Bool(INCREMENTAL, false, "Only back up data for AO tables, and heap tables with --incremental-heap, that have been modified since the last backup")
+	flagSet.Bool(INCREMENTAL_HEAP, false, "Record the statistics collector's tuple counters of heap tables, so that incremental backups based on this backup skip heap tables whose counters have not changed. The backup an incremental backup is based on must also use this flag.")
+	flagSet.Int(INCREMENTAL_HEAP_MAX_AGE, 7, "With --incremental-heap, back up a heap table whose counters have not changed if its data was last backed up at least this many days ago")
 	flagSet.Int(JOBS, 1, "The number of parallel connections to use when backing up data")
 	flagSet.Bool(LEAF_PARTITION_DATA, false, "For partition tables, create one data file per leaf partition instead of one data file for the whole table")
+	flagSet.String(MASKING_KEY_FILE, "", "A file containing the secret with which the hash masking rule keys its hashes, so that they cannot be matched against the hashes of guessed values. Required if any column is masked with hash.")
+	flagSet.String(MASKING_RULE_FILE, "", "A YAML file mapping columns, as schema.table.column, to the rules with which their values are masked when their data is backed up")
 	flagSet.Bool(METADATA_ONLY, false, "Only back up metadata, do not back up data")
+	flagSet.Int(METRICS_PORT, 0, "Serve Prometheus metrics on the specified port at /metrics while the backup runs")
+	flagSet.String(METRICS_TEXTFILE, "", "Write Prometheus metrics to the specified file, for the node exporter textfile collector, when the backup finishes")
 	flagSet.Bool(NO_COMPRESSION, false, "Disable compression of data files")
+	flagSet.String(NOTIFICATION_CONFIG, "", "The YAML file configuring notifications to send when the backup finishes. Defaults to gp_notifications.yaml in $HOME or $GPHOME/bin, if either exists.")
 	flagSet.String(PLUGIN_CONFIG, "", "The configuration file to use for a plugin")
 	flagSet.Bool("version", false, "Print version number and exit")
 	flagSet.Bool(QUIET, false, "Suppress non-warning, non-error log messages")
+	flagSet.String(REPORT_FORMAT, "json", "Format of the machine-readable report written next to the report file. Valid values are json and yaml.")
+	flagSet.String(RESUME, "", "The timestamp of a failed backup to resume, backing up data only for the tables it did not complete. Cannot be used with --single-data-file, as a resumed backup cannot append tables to the data file of each segment.")
+	flagSet.String(ROW_FILTER_FILE, "", "A YAML file mapping fully-qualified tables to the conditions of a WHERE clause, so that only the matching rows of those tables are backed up")
 	flagSet.Bool(SINGLE_DATA_FILE, false, "Back up all data to a single file instead of one per table")
 	flagSet.Bool(VERBOSE, false, "Print verbose log messages")
 	flagSet.Bool(WITH_STATS, false, "Back up query plan statistics")
@@ -83,33 +129,91 @@ func SetRestoreFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.Bool(CREATE_DB, false, "Create the database before metadata restore")
 	flagSet.Bool(DATA_ONLY, false, "Only restore data, do not restore metadata")
 	flagSet.Bool(DEBUG, false, "Print verbose and debug log messages")
+	flagSet.String(ENCRYPTION_KEY_FILE, "", "A file containing the key with which the backup was encrypted")
+	flagSet.String(ENCRYPTION_PASSPHRASE_FILE, "", "A file containing the passphrase with which the backup was encrypted")
+	flagSet.StringArray(EXCLUDE_OBJECT_TYPE, []string{}, "Restore all metadata except objects of the specified type(s), such as TRIGGER. --exclude-object-type can be specified multiple times.")
 	flagSet.StringArray(EXCLUDE_SCHEMA, []string{}, "Restore all metadata except objects in the specified schema(s). --exclude-schema can be specified multiple times.")
 	flagSet.String(EXCLUDE_SCHEMA_FILE, "", "A file containing a list of schemas that will not be restored")
 	flagSet.StringArray(EXCLUDE_RELATION, []string{}, "Restore all metadata except the specified relation(s). --exclude-table can be specified multiple times.")
 	flagSet.String(EXCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified relation(s) that will not be restored")
 	flagSet.Bool("help", false, "Help for gprestore")
+	flagSet.StringArray(INCLUDE_OBJECT_TYPE, []string{}, "Restore only objects of the specified type(s), such as FUNCTION or VIEW. --include-object-type can be specified multiple times.")
 	flagSet.StringArray(INCLUDE_SCHEMA, []string{}, "Restore only the specified schema(s). --include-schema can be specified multiple times.")
 	flagSet.String(INCLUDE_SCHEMA_FILE, "", "A file containing a list of schemas that will be restored")
 	flagSet.StringArray(INCLUDE_RELATION, []string{}, "Restore only the specified relation(s). --include-table can be specified multiple times.")
 	flagSet.String(INCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified relation(s) that will be restored")
-	flagSet.Bool(INCREMENTAL, false, "BETA FEATURE: Only restore data for all heap tables and only AO tables that have been modified since the last backup")
+	flagSet.Bool(INCREMENTAL, false, "BETA FEATURE: Only restore data for tables that have been modified since the last backup")
+	flagSet.Bool(LIST, false, "Print the entries of the backup set instead of restoring it, in the format read by --use-list")
 	flagSet.Bool(METADATA_ONLY, false, "Only restore metadata, do not restore data")
+	flagSet.Int(METRICS_PORT, 0, "Serve Prometheus metrics on the specified port at /metrics while the restore runs")
+	flagSet.String(METRICS_TEXTFILE, "", "Write Prometheus metrics to the specified file, for the node exporter textfile collector, when the restore finishes")
 	flagSet.Int(JOBS, 1, "Number of parallel connections to use when restoring table data and post-data")
+	flagSet.Bool(NO_OWNER, false, "Do not restore the owners of objects, so that objects are owned by the user running the restore")
+	flagSet.Bool(NO_PRIVILEGES, false, "Do not restore the privileges or default privileges of objects")
+	flagSet.String(NOTIFICATION_CONFIG, "", "The YAML file configuring notifications to send when the restore finishes. Defaults to gp_notifications.yaml in $HOME or $GPHOME/bin, if either exists.")
 	flagSet.Bool(ON_ERROR_CONTINUE, false, "Log errors and continue restore, instead of exiting on first error")
 	flagSet.String(PLUGIN_CONFIG, "", "The configuration file to use for a plugin")
 	flagSet.Bool("version", false, "Print version number and exit")
 	flagSet.Bool(QUIET, false, "Suppress non-warning, non-error log messages")
 	flagSet.String(REDIRECT_DB, "", "Restore to the specified database instead of the database that was backed up")
 	flagSet.String(REDIRECT_SCHEMA, "", "Restore to the specified schema instead of the schema that was backed up")
+	flagSet.String(REDIRECT_MAPPING_FILE, "", "A file mapping schemas and tables to the schemas and names to restore them to, one \"old_name -> new_name\" mapping per line")
+	flagSet.String(REPORT_FORMAT, "json", "Format of the machine-readable report written next to the report file. Valid values are json and yaml.")
+	flagSet.String(ROLE_MAPPING, "", "A file mapping the owners and grantees of objects to the roles to restore them with, one \"old_role -> new_role\" mapping per line")
+	flagSet.Bool(RESUME, false, "Resume the most recent failed restore of this backup into the same database, skipping objects and tables that were already restored")
 	flagSet.Bool(WITH_GLOBALS, false, "Restore global metadata")
+	flagSet.String(TABLESPACE_MAPPING, "", "A file mapping the tablespaces of objects to the tablespaces to restore them to, one \"old_tablespace -> new_tablespace\" mapping per line")
 	flagSet.String(TIMESTAMP, "", "The timestamp to be restored, in the format YYYYMMDDHHMMSS")
+	flagSet.String(TO_SQL_FILE, "", "Write the statements and COPY commands of the restore to the specified SQL file instead of executing them")
 	flagSet.Bool(TRUNCATE_TABLE, false, "Removes data of the tables getting restored")
+	flagSet.String(USE_LIST, "", "A file in the format printed by --list. Only the entries in the file are restored. Metadata entries are restored in the order in which they are listed within each section, and table data in the order in which it was backed up.")
 	flagSet.Bool(VERBOSE, false, "Print verbose log messages")
+	flagSet.Bool(VERIFY, false, "Verify the checksums of all files in the backup set instead of restoring it")
 	flagSet.Bool(WITH_STATS, false, "Restore query plan statistics")
 	flagSet.Bool(LEAF_PARTITION_DATA, false, "For partition tables, create one data file per leaf partition instead of one data file for the whole table")
 	_ = flagSet.MarkHidden(LEAF_PARTITION_DATA)
 }
 
+func SetManagerFlagDefaults(flagSet *pflag.FlagSet) {
+	flagSet.Bool(DEBUG, false, "Print verbose and debug log messages")
+	flagSet.Bool(QUIET, false, "Suppress non-warning, non-error log messages")
+	flagSet.Bool(VERBOSE, false, "Print verbose log messages")
+}
+
+func SetManagerDeleteFlagDefaults(flagSet *pflag.FlagSet) {
+	flagSet.String(PLUGIN_CONFIG, "", "The configuration file to use for a plugin. Required when deleting a backup taken with a plugin.")
+}
+
+func SetManagerConsolidateFlagDefaults(flagSet *pflag.FlagSet) {
+	flagSet.String(ENCRYPTION_KEY_FILE, "", "A file containing the key with which the backup was encrypted")
+	flagSet.String(ENCRYPTION_PASSPHRASE_FILE, "", "A file containing the passphrase with which the backup was encrypted")
+	flagSet.Bool(HARD_LINK, false, "Hard-link the data files into the consolidated backup instead of copying them")
+}
+
+func SetManagerReplicateFlagDefaults(flagSet *pflag.FlagSet) {
+	flagSet.String(ENCRYPTION_KEY_FILE, "", "A file containing the key with which the backup was encrypted")
+	flagSet.String(ENCRYPTION_PASSPHRASE_FILE, "", "A file containing the passphrase with which the backup was encrypted")
+	flagSet.String(PLUGIN_CONFIG, "", "The configuration file of the plugin to replicate the backup to")
+	flagSet.String(SOURCE_PLUGIN_CONFIG, "", "The configuration file to use for a plugin. Required when replicating a backup taken with a plugin.")
+}
+
+func SetManagerValidateChainFlagDefaults(flagSet *pflag.FlagSet) {
+	flagSet.String(ENCRYPTION_KEY_FILE, "", "A file containing the key with which encrypted backups were encrypted")
+	flagSet.String(ENCRYPTION_PASSPHRASE_FILE, "", "A file containing the passphrase with which encrypted backups were encrypted")
+	flagSet.String(PLUGIN_CONFIG, "", "The configuration file to use for a plugin. Required when validating backups taken with a plugin.")
+	flagSet.Bool(REPAIR, false, "Mark backups whose restore plans are broken as failed, so that later incremental backups are not based on them")
+}
+
+func SetManagerPruneFlagDefaults(flagSet *pflag.FlagSet) {
+	flagSet.String(DBNAME, "", "Only apply the retention policy to backups of this database")
+	flagSet.Bool(DRY_RUN, false, "List the backups that would be deleted and why, without deleting them")
+	flagSet.Int(KEEP_DAYS, 0, "Keep all backups taken within this many days")
+	flagSet.Int(KEEP_LAST_FULL, 0, "Keep this many of the most recent full backups, along with their incremental backups")
+	flagSet.Int(KEEP_MONTHLY, 0, "Keep the most recent backup of each of this many months")
+	flagSet.Int(KEEP_WEEKLY, 0, "Keep the most recent backup of each of this many weeks")
+	flagSet.String(PLUGIN_CONFIG, "", "The configuration file to use for a plugin. Required when expired backups were taken with a plugin.")
+}
+
 /*
  * Functions for validating whether flags are set and in what combination
  */
@@ -2,9 +2,11 @@ package options
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/pkg/errors"
@@ -22,6 +24,8 @@ type Options struct {
 	IncludedSchemas           []string
 	originalIncludedRelations []string
 	RedirectSchema            string
+	IncludedObjectTypes       []string
+	ExcludedObjectTypes       []string
 }
 
 func NewOptions(initialFlags *pflag.FlagSet) (*Options, error) {
@@ -66,6 +70,16 @@ func NewOptions(initialFlags *pflag.FlagSet) (*Options, error) {
 		}
 	}
 
+	includedObjectTypes, err := getObjectTypes(initialFlags, INCLUDE_OBJECT_TYPE)
+	if err != nil {
+		return nil, err
+	}
+
+	excludedObjectTypes, err := getObjectTypes(initialFlags, EXCLUDE_OBJECT_TYPE)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Options{
 		IncludedRelations:         includedRelations,
 		ExcludedRelations:         excludedRelations,
@@ -74,9 +88,27 @@ func NewOptions(initialFlags *pflag.FlagSet) (*Options, error) {
 		isLeafPartitionData:       leafPartitionData,
 		originalIncludedRelations: includedRelations,
 		RedirectSchema:            redirectSchema,
+		IncludedObjectTypes:       includedObjectTypes,
+		ExcludedObjectTypes:       excludedObjectTypes,
 	}, nil
 }
 
+// Object types are matched against those in the TOC, which are upper case
+func getObjectTypes(initialFlags *pflag.FlagSet, objectTypeFlag string) ([]string, error) {
+	objectTypes := make([]string, 0)
+	if initialFlags.Lookup(objectTypeFlag) == nil {
+		return objectTypes, nil
+	}
+	flagValues, err := initialFlags.GetStringArray(objectTypeFlag)
+	if err != nil {
+		return nil, err
+	}
+	for _, objectType := range flagValues {
+		objectTypes = append(objectTypes, strings.ToUpper(strings.TrimSpace(objectType)))
+	}
+	return objectTypes, nil
+}
+
 func setFiltersFromFile(initialFlags *pflag.FlagSet, filterFlag string, filterFileFlag string) ([]string, error) {
 	filters, err := initialFlags.GetStringArray(filterFlag)
 	if err != nil {
@@ -138,6 +170,92 @@ func (o *Options) AddIncludedRelation(relation string) {
 	o.IncludedRelations = append(o.IncludedRelations, relation)
 }
 
+func (o Options) HasFilterPatterns() bool {
+	for _, filterList := range [][]string{o.IncludedRelations, o.ExcludedRelations, o.IncludedSchemas, o.ExcludedSchemas} {
+		for _, filter := range filterList {
+			if utils.IsFilterPattern(filter) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+ * Replaces the patterns in the include and exclude lists, and in the flags
+ * they were read from, with the names they match.  The schemas and relations
+ * maps are keyed by the unquoted names patterns are matched against, and their
+ * values are the names that are put in the lists in place of the patterns.
+ * A pattern in an include list must match at least one name.
+ */
+func (o *Options) ExpandFilterPatterns(flags *pflag.FlagSet, schemas map[string]string, relations map[string]string) error {
+	var err error
+	o.IncludedRelations, err = expandFilterList(flags, INCLUDE_RELATION, o.IncludedRelations, relations, false)
+	if err != nil {
+		return err
+	}
+	o.originalIncludedRelations = o.IncludedRelations
+	o.ExcludedRelations, err = expandFilterList(flags, EXCLUDE_RELATION, o.ExcludedRelations, relations, true)
+	if err != nil {
+		return err
+	}
+	o.IncludedSchemas, err = expandFilterList(flags, INCLUDE_SCHEMA, o.IncludedSchemas, schemas, false)
+	if err != nil {
+		return err
+	}
+	o.ExcludedSchemas, err = expandFilterList(flags, EXCLUDE_SCHEMA, o.ExcludedSchemas, schemas, true)
+	return err
+}
+
+func expandFilterList(flags *pflag.FlagSet, filterFlag string, filterList []string, candidates map[string]string, excludeSet bool) ([]string, error) {
+	if len(filterList) == 0 {
+		return filterList, nil
+	}
+	candidateNames := make([]string, 0, len(candidates))
+	for name := range candidates {
+		candidateNames = append(candidateNames, name)
+	}
+	sort.Strings(candidateNames)
+
+	expandedList := make([]string, 0, len(filterList))
+	expandedSet := make(map[string]bool, len(filterList))
+	addFilter := func(filter string) {
+		if !expandedSet[filter] {
+			expandedSet[filter] = true
+			expandedList = append(expandedList, filter)
+		}
+	}
+	for _, filter := range filterList {
+		if !utils.IsFilterPattern(filter) {
+			addFilter(filter)
+			continue
+		}
+		numMatches := 0
+		for _, name := range candidateNames {
+			if utils.MatchesFilterPattern(filter, name) {
+				addFilter(candidates[name])
+				numMatches++
+			}
+		}
+		if numMatches == 0 {
+			if !excludeSet {
+				return nil, errors.Errorf("No objects match the --%s pattern %s", filterFlag, filter)
+			}
+			gplog.Warn("No objects match the --%s pattern %s", filterFlag, filter)
+		} else {
+			gplog.Verbose("Pattern %s for --%s matches %d object(s)", filter, filterFlag, numMatches)
+		}
+	}
+
+	if flag := flags.Lookup(filterFlag); flag != nil {
+		err := flag.Value.(pflag.SliceValue).Replace(expandedList)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return expandedList, nil
+}
+
 type FqnStruct struct {
 	SchemaName string
 	TableName  string
@@ -249,12 +367,25 @@ func (o *Options) ExpandIncludesForPartitions(conn *dbconn.DBConn, flags *pflag.
 	return nil
 }
 
+/*
+ * Patterns are left unquoted, as they are matched against unquoted names
+ * when they are expanded.
+ */
 func (o *Options) QuoteIncludeRelations(conn *dbconn.DBConn) error {
-	var err error
-	o.IncludedRelations, err = QuoteTableNames(conn, o.GetIncludedTables())
+	includeRelations := make([]string, 0)
+	includePatterns := make([]string, 0)
+	for _, relation := range o.GetIncludedTables() {
+		if utils.IsFilterPattern(relation) {
+			includePatterns = append(includePatterns, relation)
+		} else {
+			includeRelations = append(includeRelations, relation)
+		}
+	}
+	quotedIncludeRelations, err := QuoteTableNames(conn, includeRelations)
 	if err != nil {
 		return err
 	}
+	o.IncludedRelations = append(quotedIncludeRelations, includePatterns...)
 
 	return nil
 }
@@ -196,6 +196,18 @@ var _ = Describe("options", func() {
 			Expect(subject.GetIncludedSchemas()[0]).To(Equal("my include schema"))
 			Expect(subject.GetExcludedSchemas()[0]).To(Equal("my exclude schema"))
 		})
+		It("returns object types in upper case", func() {
+			err := myflags.Set(options.EXCLUDE_OBJECT_TYPE, "text search parser")
+			Expect(err).ToNot(HaveOccurred())
+			err = myflags.Set(options.EXCLUDE_OBJECT_TYPE, "Cast")
+			Expect(err).ToNot(HaveOccurred())
+
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			Expect(subject.ExcludedObjectTypes).To(Equal([]string{"TEXT SEARCH PARSER", "CAST"}))
+			Expect(subject.IncludedObjectTypes).To(BeEmpty())
+		})
 		It("returns an error upon invalid inclusions", func() {
 			err := myflags.Set(options.INCLUDE_RELATION, "foo")
 			Expect(err).ToNot(HaveOccurred())
@@ -212,6 +224,71 @@ var _ = Describe("options", func() {
 			})
 		})
 	})
+	Describe("ExpandFilterPatterns", func() {
+		var (
+			schemas   map[string]string
+			relations map[string]string
+		)
+		BeforeEach(func() {
+			schemas = map[string]string{"public": "public", "sales": "sales", "stage_a": "stage_a", "stage_b": "stage_b"}
+			relations = map[string]string{
+				"public.foo":         "public.foo",
+				"sales.fact_2019_01": "sales.fact_2019_01",
+				"sales.fact_2019_02": "sales.fact_2019_02",
+				"sales.fact_2020_01": "sales.fact_2020_01",
+				"sales.Mixed Case":   `sales."Mixed Case"`,
+			}
+		})
+		It("replaces patterns in the include lists and flags with the names they match", func() {
+			Expect(myflags.Set(options.INCLUDE_RELATION, "sales.fact_2019_*")).To(Succeed())
+			Expect(myflags.Set(options.INCLUDE_RELATION, "public.foo")).To(Succeed())
+			Expect(myflags.Set(options.INCLUDE_RELATION, "sales.M*")).To(Succeed())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = subject.ExpandFilterPatterns(myflags, schemas, relations)
+			Expect(err).ToNot(HaveOccurred())
+
+			expectedRelations := []string{"sales.fact_2019_01", "sales.fact_2019_02", "public.foo", `sales."Mixed Case"`}
+			Expect(subject.GetIncludedTables()).To(Equal(expectedRelations))
+			Expect(subject.GetOriginalIncludedTables()).To(Equal(expectedRelations))
+			flagValues, err := myflags.GetStringArray(options.INCLUDE_RELATION)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(flagValues).To(Equal(expectedRelations))
+		})
+		It("replaces regular expressions in the schema lists with the names they match", func() {
+			Expect(myflags.Set(options.EXCLUDE_SCHEMA, "^stage_.*")).To(Succeed())
+			Expect(myflags.Set(options.EXCLUDE_SCHEMA, "stage_a")).To(Succeed())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = subject.ExpandFilterPatterns(myflags, schemas, relations)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(subject.GetExcludedSchemas()).To(Equal([]string{"stage_a", "stage_b"}))
+			flagValues, err := myflags.GetStringArray(options.EXCLUDE_SCHEMA)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(flagValues).To(Equal([]string{"stage_a", "stage_b"}))
+		})
+		It("removes an exclude pattern that matches nothing", func() {
+			Expect(myflags.Set(options.EXCLUDE_RELATION, "sales.fact_2018_*")).To(Succeed())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = subject.ExpandFilterPatterns(myflags, schemas, relations)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(subject.GetExcludedTables()).To(BeEmpty())
+		})
+		It("returns an error when an include pattern matches nothing", func() {
+			Expect(myflags.Set(options.INCLUDE_SCHEMA, "^archive_")).To(Succeed())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = subject.ExpandFilterPatterns(myflags, schemas, relations)
+			Expect(err).To(MatchError("No objects match the --include-schema pattern ^archive_"))
+		})
+	})
 	Describe("SeparateSchemaAndTable", func() {
 		It("properly splits the strings", func() {
 			tableList := []string{"foo.Bar", "FOO.Bar", "FO!@#.BAR"}
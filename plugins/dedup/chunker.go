@@ -0,0 +1,137 @@
+package dedup
+
+/*
+ * This file contains the content-defined chunker used to split backup files
+ * into chunks.  Chunk boundaries are chosen with a gear rolling hash over the
+ * last 64 bytes of data, so inserting or removing data in one part of a file
+ * only changes the chunks around the change, and the rest of the file still
+ * deduplicates against earlier backups.
+ */
+
+import (
+	"io"
+	"math/bits"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultAverageChunkSize = 1024 * 1024
+	MinAverageChunkSize     = 4 * 1024
+	MaxAverageChunkSize     = 64 * 1024 * 1024
+)
+
+/*
+ * The gear table maps each byte value to a pseudorandom 64-bit value.  It is
+ * generated with splitmix64 from a fixed seed rather than math/rand, because
+ * chunk boundaries, and with them deduplication against existing backups,
+ * depend on it never changing.
+ */
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x67706261636b7570)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+type Chunker struct {
+	reader  io.Reader
+	buffer  []byte
+	start   int
+	end     int
+	eof     bool
+	minSize int
+	maxSize int
+	mask    uint64
+}
+
+/*
+ * Chunks average averageSize bytes, which must be a power of two, and are
+ * between a quarter of and four times that size.
+ */
+func NewChunker(reader io.Reader, averageSize int) (*Chunker, error) {
+	err := validateAverageChunkSize(averageSize)
+	if err != nil {
+		return nil, err
+	}
+	maskBits := uint(bits.TrailingZeros(uint(averageSize)))
+	return &Chunker{
+		reader:  reader,
+		buffer:  make([]byte, 4*averageSize),
+		minSize: averageSize / 4,
+		maxSize: 4 * averageSize,
+		mask:    ((uint64(1) << maskBits) - 1) << (64 - maskBits),
+	}, nil
+}
+
+func validateAverageChunkSize(averageSize int) error {
+	if averageSize < MinAverageChunkSize || averageSize > MaxAverageChunkSize || averageSize&(averageSize-1) != 0 {
+		return errors.Errorf("Average chunk size must be a power of two between %d and %d", MinAverageChunkSize, MaxAverageChunkSize)
+	}
+	return nil
+}
+
+/*
+ * Returns the next chunk, or io.EOF once all data has been read.  The chunk is
+ * only valid until the next call to Next.
+ */
+func (chunker *Chunker) Next() ([]byte, error) {
+	if chunker.end-chunker.start < chunker.maxSize && !chunker.eof {
+		err := chunker.fill()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if chunker.start == chunker.end {
+		return nil, io.EOF
+	}
+	length := chunker.findBoundary(chunker.buffer[chunker.start:chunker.end])
+	chunk := chunker.buffer[chunker.start : chunker.start+length]
+	chunker.start += length
+	return chunk, nil
+}
+
+func (chunker *Chunker) fill() error {
+	copy(chunker.buffer, chunker.buffer[chunker.start:chunker.end])
+	chunker.end -= chunker.start
+	chunker.start = 0
+	for chunker.end < len(chunker.buffer) {
+		numRead, err := chunker.reader.Read(chunker.buffer[chunker.end:])
+		chunker.end += numRead
+		if err == io.EOF {
+			chunker.eof = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * Returns the length of the chunk at the start of data.  The hash only starts
+ * at the minimum chunk size, since no boundary can be placed before it.
+ */
+func (chunker *Chunker) findBoundary(data []byte) int {
+	if len(data) <= chunker.minSize {
+		return len(data)
+	}
+	limit := len(data)
+	if limit > chunker.maxSize {
+		limit = chunker.maxSize
+	}
+	hash := uint64(0)
+	for i := chunker.minSize; i < limit; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&chunker.mask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
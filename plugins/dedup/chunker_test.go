@@ -0,0 +1,73 @@
+package dedup_test
+
+import (
+	"bytes"
+	"io"
+	"testing/iotest"
+
+	"github.com/greenplum-db/gpbackup/plugins/dedup"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func getChunks(reader io.Reader, averageSize int) [][]byte {
+	chunker, err := dedup.NewChunker(reader, averageSize)
+	Expect(err).ToNot(HaveOccurred())
+	chunks := make([][]byte, 0)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		Expect(err).ToNot(HaveOccurred())
+		chunks = append(chunks, append([]byte{}, chunk...))
+	}
+}
+
+var _ = Describe("dedup/chunker tests", func() {
+	Describe("NewChunker", func() {
+		It("returns an error if the average chunk size is not a power of two", func() {
+			_, err := dedup.NewChunker(nil, 5000)
+			Expect(err).To(MatchError("Average chunk size must be a power of two between 4096 and 67108864"))
+		})
+	})
+	Describe("Next", func() {
+		It("splits data into chunks that reassemble to the original data", func() {
+			data := randomData(1, 200000)
+			chunks := getChunks(bytes.NewReader(data), 4096)
+
+			Expect(len(chunks)).To(BeNumerically(">", 10))
+			Expect(bytes.Join(chunks, nil)).To(Equal(data))
+			for _, chunk := range chunks[:len(chunks)-1] {
+				Expect(len(chunk)).To(BeNumerically(">=", 1024))
+				Expect(len(chunk)).To(BeNumerically("<=", 16384))
+			}
+		})
+		It("returns no chunks for empty data", func() {
+			Expect(getChunks(bytes.NewReader(nil), 4096)).To(BeEmpty())
+		})
+		It("chooses the same boundaries regardless of how the data is read", func() {
+			data := randomData(2, 100000)
+
+			Expect(getChunks(iotest.OneByteReader(bytes.NewReader(data)), 4096)).To(Equal(getChunks(bytes.NewReader(data), 4096)))
+		})
+		It("only changes the chunks around data inserted into the middle of a file", func() {
+			data := randomData(3, 200000)
+			changedData := append(append(append([]byte{}, data[:100000]...), []byte("inserted row")...), data[100000:]...)
+
+			chunks := getChunks(bytes.NewReader(data), 4096)
+			changedChunks := getChunks(bytes.NewReader(changedData), 4096)
+			numShared := 0
+			for _, changedChunk := range changedChunks {
+				for _, chunk := range chunks {
+					if bytes.Equal(chunk, changedChunk) {
+						numShared++
+						break
+					}
+				}
+			}
+			Expect(numShared).To(BeNumerically(">=", len(changedChunks)-2))
+		})
+	})
+})
@@ -0,0 +1,24 @@
+package dedup_test
+
+import (
+	"math/rand"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestDedup(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Dedup Plugin Suite")
+}
+
+/*
+ * Returns the same pseudorandom data for a given seed, so that tests can build
+ * files that share content.
+ */
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
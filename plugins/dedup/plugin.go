@@ -0,0 +1,138 @@
+package dedup
+
+/*
+ * This file contains the implementation of the plugin API commands for
+ * gpbackup_dedup_plugin, which stores backups in a directory on local or
+ * shared (e.g. NFS) storage, deduplicating their contents across backups.
+ */
+
+import (
+	"io"
+	"os"
+	path "path/filepath"
+	"strconv"
+
+	"github.com/greenplum-db/gpbackup/plugins/pluginapi"
+	"github.com/pkg/errors"
+)
+
+const PluginName = "gpbackup_dedup_plugin"
+
+var version string
+
+func GetVersion() string {
+	return version
+}
+
+type DedupPlugin struct {
+	store *Store
+}
+
+func NewPlugin(config *pluginapi.PluginConfig) (pluginapi.Plugin, error) {
+	directory := config.Options["directory"]
+	if directory == "" {
+		return nil, errors.Errorf("The directory option must be set in the plugin config")
+	}
+	if !path.IsAbs(directory) {
+		return nil, errors.Errorf("The directory option must be an absolute path, not %s", directory)
+	}
+	averageChunkSize := DefaultAverageChunkSize
+	if sizeStr, ok := config.Options["average_chunk_size"]; ok {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil {
+			return nil, errors.Errorf("Invalid average_chunk_size %s", sizeStr)
+		}
+		averageChunkSize = size
+	}
+	err := validateAverageChunkSize(averageChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return &DedupPlugin{store: NewStore(directory, averageChunkSize)}, nil
+}
+
+func (plugin *DedupPlugin) SetupPluginForBackup(localBackupDir string, scope string) error {
+	return os.MkdirAll(plugin.store.Directory, 0755)
+}
+
+/*
+ * gprestore reads restored files from the local backup directory, so it must
+ * exist on every host before any files are restored.
+ */
+func (plugin *DedupPlugin) SetupPluginForRestore(localBackupDir string, scope string) error {
+	return os.MkdirAll(localBackupDir, 0755)
+}
+
+func (plugin *DedupPlugin) CleanupPluginForBackup(localBackupDir string, scope string) error {
+	return nil
+}
+
+func (plugin *DedupPlugin) CleanupPluginForRestore(localBackupDir string, scope string) error {
+	return nil
+}
+
+func (plugin *DedupPlugin) BackupFile(filename string) error {
+	key, err := pluginapi.GetBackupKey(filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return plugin.store.Backup(key, file)
+}
+
+func (plugin *DedupPlugin) RestoreFile(filename string) (err error) {
+	key, err := pluginapi.GetBackupKey(filename)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(path.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(filename)
+		}
+	}()
+	return plugin.store.Restore(key, file)
+}
+
+func (plugin *DedupPlugin) BackupData(dataFilekey string, reader io.Reader) error {
+	key, err := pluginapi.GetBackupKey(dataFilekey)
+	if err != nil {
+		return err
+	}
+	return plugin.store.Backup(key, reader)
+}
+
+func (plugin *DedupPlugin) RestoreData(dataFilekey string, writer io.Writer) error {
+	key, err := pluginapi.GetBackupKey(dataFilekey)
+	if err != nil {
+		return err
+	}
+	return plugin.store.Restore(key, writer)
+}
+
+func (plugin *DedupPlugin) RestoreDataSubset(dataFilekey string, byteRanges []pluginapi.ByteRange, writer io.Writer) error {
+	key, err := pluginapi.GetBackupKey(dataFilekey)
+	if err != nil {
+		return err
+	}
+	return plugin.store.RestoreRanges(key, byteRanges, writer)
+}
+
+func (plugin *DedupPlugin) DeleteBackup(timestamp string) error {
+	return plugin.store.DeleteBackup(timestamp)
+}
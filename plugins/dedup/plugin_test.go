@@ -0,0 +1,52 @@
+package dedup_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+
+	"github.com/greenplum-db/gpbackup/plugins/dedup"
+	"github.com/greenplum-db/gpbackup/plugins/pluginapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dedup/plugin tests", func() {
+	Describe("NewPlugin", func() {
+		It("requires the directory option", func() {
+			_, err := dedup.NewPlugin(&pluginapi.PluginConfig{Options: map[string]string{}})
+			Expect(err).To(MatchError("The directory option must be set in the plugin config"))
+		})
+		It("requires an absolute directory", func() {
+			_, err := dedup.NewPlugin(&pluginapi.PluginConfig{Options: map[string]string{"directory": "backups"}})
+			Expect(err).To(MatchError("The directory option must be an absolute path, not backups"))
+		})
+		It("returns an error for an invalid average chunk size", func() {
+			_, err := dedup.NewPlugin(&pluginapi.PluginConfig{Options: map[string]string{"directory": "/backups", "average_chunk_size": "1k"}})
+			Expect(err).To(MatchError("Invalid average_chunk_size 1k"))
+		})
+	})
+	It("backs up and restores files by their backup key", func() {
+		directory, err := ioutil.TempDir("", "dedup_plugin_test_")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(directory)
+		plugin, err := dedup.NewPlugin(&pluginapi.PluginConfig{Options: map[string]string{"directory": path.Join(directory, "dest")}})
+		Expect(err).ToNot(HaveOccurred())
+		filename := path.Join(directory, "backups", "20190101", "20190101010101", "gpbackup_20190101010101_toc.yaml")
+		Expect(os.MkdirAll(path.Dir(filename), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filename, []byte("toc contents"), 0644)).To(Succeed())
+
+		Expect(plugin.BackupFile(filename)).To(Succeed())
+		Expect(os.Remove(filename)).To(Succeed())
+		Expect(plugin.RestoreFile(filename)).To(Succeed())
+
+		Expect(ioutil.ReadFile(filename)).To(Equal([]byte("toc contents")))
+		Expect(path.Join(directory, "dest", "backups", "20190101", "20190101010101", "gpbackup_20190101010101_toc.yaml")).To(BeARegularFile())
+
+		buffer := &bytes.Buffer{}
+		Expect(plugin.RestoreData(filename, buffer)).To(Succeed())
+		Expect(buffer.String()).To(Equal("toc contents"))
+	})
+})
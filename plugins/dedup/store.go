@@ -0,0 +1,374 @@
+package dedup
+
+/*
+ * This file contains the deduplicating store that the plugin keeps in its
+ * target directory, which has the following layout:
+ *
+ *   <directory>/chunks/<first two digits of hash>/<SHA-256 hash of chunk>
+ *   <directory>/backups/YYYYMMDD/<timestamp>/<filename>
+ *
+ * Each file under backups/ is a manifest listing the hash and size of each
+ * chunk of the backed up file, one chunk per line.  A chunk is only written
+ * once no matter how many files contain it, and chunks are removed when
+ * delete_backup leaves them unreferenced by any manifest.
+ */
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/greenplum-db/gpbackup/plugins/pluginapi"
+	"github.com/pkg/errors"
+)
+
+const tempFilePrefix = ".tmp_"
+
+type ChunkRef struct {
+	Hash string
+	Size int64
+}
+
+type Store struct {
+	Directory        string
+	AverageChunkSize int
+}
+
+func NewStore(directory string, averageChunkSize int) *Store {
+	return &Store{Directory: directory, AverageChunkSize: averageChunkSize}
+}
+
+func (store *Store) chunkPath(hash string) string {
+	return path.Join(store.Directory, "chunks", hash[0:2], hash)
+}
+
+func (store *Store) manifestPath(key string) string {
+	return path.Join(store.Directory, "backups", key)
+}
+
+/*
+ * Backups and garbage collection are serialized with a lock file, so that
+ * garbage collection cannot remove a chunk that a concurrent backup found
+ * already present and referenced in its manifest.  Backups hold a shared lock,
+ * so any number of segments may back up at once.
+ */
+func (store *Store) lock(how int) (*os.File, error) {
+	err := os.MkdirAll(store.Directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+	lockFile, err := os.OpenFile(path.Join(store.Directory, ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(lockFile.Fd()), how)
+	if err != nil {
+		_ = lockFile.Close()
+		return nil, err
+	}
+	return lockFile, nil
+}
+
+/*
+ * Splits the data read from reader into chunks, writes the chunks that are not
+ * already in the store, and then writes the manifest of the file under key.
+ */
+func (store *Store) Backup(key string, reader io.Reader) error {
+	lockFile, err := store.lock(syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+
+	chunker, err := NewChunker(reader, store.AverageChunkSize)
+	if err != nil {
+		return err
+	}
+	chunkRefs := make([]ChunkRef, 0)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		hash, err := store.writeChunk(chunk)
+		if err != nil {
+			return err
+		}
+		chunkRefs = append(chunkRefs, ChunkRef{Hash: hash, Size: int64(len(chunk))})
+	}
+	return store.writeManifest(key, chunkRefs)
+}
+
+func (store *Store) writeChunk(chunk []byte) (string, error) {
+	sum := sha256.Sum256(chunk)
+	hash := hex.EncodeToString(sum[:])
+	chunkPath := store.chunkPath(hash)
+	if _, err := os.Stat(chunkPath); err == nil {
+		return hash, nil
+	}
+	err := os.MkdirAll(path.Dir(chunkPath), 0755)
+	if err != nil {
+		return "", err
+	}
+	err = writeFileAtomically(chunkPath, func(writer io.Writer) error {
+		_, err := writer.Write(chunk)
+		return err
+	})
+	return hash, err
+}
+
+func (store *Store) writeManifest(key string, chunkRefs []ChunkRef) error {
+	manifestPath := store.manifestPath(key)
+	err := os.MkdirAll(path.Dir(manifestPath), 0755)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(manifestPath, func(writer io.Writer) error {
+		bufferedWriter := bufio.NewWriter(writer)
+		for _, chunkRef := range chunkRefs {
+			_, err := fmt.Fprintf(bufferedWriter, "%s %d\n", chunkRef.Hash, chunkRef.Size)
+			if err != nil {
+				return err
+			}
+		}
+		return bufferedWriter.Flush()
+	})
+}
+
+/*
+ * Writes the file under a temporary name and renames it into place, so that
+ * an interrupted write never leaves a partial chunk or manifest behind.
+ */
+func writeFileAtomically(filename string, write func(writer io.Writer) error) (err error) {
+	tempFile, err := ioutil.TempFile(path.Dir(filename), tempFilePrefix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tempFile.Close()
+			_ = os.Remove(tempFile.Name())
+		}
+	}()
+	err = write(tempFile)
+	if err != nil {
+		return err
+	}
+	err = tempFile.Sync()
+	if err != nil {
+		return err
+	}
+	err = tempFile.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), filename)
+}
+
+func (store *Store) ReadManifest(key string) ([]ChunkRef, error) {
+	contents, err := ioutil.ReadFile(store.manifestPath(key))
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("File %s does not exist in %s", key, store.Directory)
+	} else if err != nil {
+		return nil, err
+	}
+	chunkRefs := make([]ChunkRef, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("Manifest of file %s is corrupt", key)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || len(fields[0]) != sha256.Size*2 {
+			return nil, errors.Errorf("Manifest of file %s is corrupt", key)
+		}
+		chunkRefs = append(chunkRefs, ChunkRef{Hash: fields[0], Size: size})
+	}
+	return chunkRefs, nil
+}
+
+/*
+ * Reads a chunk and verifies its contents against its hash, so that a damaged
+ * chunk fails the restore instead of silently corrupting every backup that
+ * shares it.
+ */
+func (store *Store) readChunk(chunkRef ChunkRef) ([]byte, error) {
+	chunk, err := ioutil.ReadFile(store.chunkPath(chunkRef.Hash))
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("Chunk %s is missing from %s", chunkRef.Hash, store.Directory)
+	} else if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(chunk)
+	if int64(len(chunk)) != chunkRef.Size || hex.EncodeToString(sum[:]) != chunkRef.Hash {
+		return nil, errors.Errorf("Chunk %s in %s is corrupt", chunkRef.Hash, store.Directory)
+	}
+	return chunk, nil
+}
+
+func (store *Store) Restore(key string, writer io.Writer) error {
+	chunkRefs, err := store.ReadManifest(key)
+	if err != nil {
+		return err
+	}
+	for _, chunkRef := range chunkRefs {
+		chunk, err := store.readChunk(chunkRef)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * Writes the given byte ranges of the file, in order, reading only the chunks
+ * that overlap them.
+ */
+func (store *Store) RestoreRanges(key string, byteRanges []pluginapi.ByteRange, writer io.Writer) error {
+	chunkRefs, err := store.ReadManifest(key)
+	if err != nil {
+		return err
+	}
+	chunkOffsets := make([]int64, len(chunkRefs)+1)
+	for i, chunkRef := range chunkRefs {
+		chunkOffsets[i+1] = chunkOffsets[i] + chunkRef.Size
+	}
+	fileSize := chunkOffsets[len(chunkRefs)]
+
+	cachedIndex := -1
+	var cachedChunk []byte
+	for _, byteRange := range byteRanges {
+		if byteRange.End > fileSize {
+			return errors.Errorf("Byte range %d-%d is beyond the end of file %s, which is %d bytes", byteRange.Start, byteRange.End, key, fileSize)
+		}
+		offset := byteRange.Start
+		for offset < byteRange.End {
+			index := sort.Search(len(chunkRefs), func(i int) bool { return chunkOffsets[i+1] > offset })
+			if index != cachedIndex {
+				cachedChunk, err = store.readChunk(chunkRefs[index])
+				if err != nil {
+					return err
+				}
+				cachedIndex = index
+			}
+			end := byteRange.End
+			if end > chunkOffsets[index+1] {
+				end = chunkOffsets[index+1]
+			}
+			_, err = writer.Write(cachedChunk[offset-chunkOffsets[index] : end-chunkOffsets[index]])
+			if err != nil {
+				return err
+			}
+			offset = end
+		}
+	}
+	return nil
+}
+
+/*
+ * Removes the manifests of every file in the backup and then the chunks that
+ * no other backup references.  Garbage collection is skipped if a backup is in
+ * progress, in which case the unreferenced chunks are removed by the next
+ * delete_backup.
+ */
+func (store *Store) DeleteBackup(timestamp string) error {
+	timestampKey, err := pluginapi.GetTimestampKey(timestamp)
+	if err != nil {
+		return err
+	}
+	backupDir := store.manifestPath(timestampKey)
+	err = os.RemoveAll(backupDir)
+	if err != nil {
+		return err
+	}
+	dayDir := path.Dir(backupDir)
+	if entries, err := ioutil.ReadDir(dayDir); err == nil && len(entries) == 0 {
+		_ = os.Remove(dayDir)
+	}
+
+	lockFile, err := store.lock(syscall.LOCK_EX | syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+	_, err = store.CollectGarbage()
+	return err
+}
+
+/*
+ * Removes every chunk not referenced by a manifest, along with any temporary
+ * files left behind by interrupted writes, and returns the number of chunks
+ * removed.  The caller must hold the exclusive lock.
+ */
+func (store *Store) CollectGarbage() (int, error) {
+	referenced := make(map[string]bool)
+	err := walkFiles(path.Join(store.Directory, "backups"), func(filename string) error {
+		if strings.HasPrefix(path.Base(filename), tempFilePrefix) {
+			return os.Remove(filename)
+		}
+		key, err := path.Rel(path.Join(store.Directory, "backups"), filename)
+		if err != nil {
+			return err
+		}
+		chunkRefs, err := store.ReadManifest(key)
+		if err != nil {
+			return err
+		}
+		for _, chunkRef := range chunkRefs {
+			referenced[chunkRef.Hash] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	numRemoved := 0
+	err = walkFiles(path.Join(store.Directory, "chunks"), func(filename string) error {
+		name := path.Base(filename)
+		if referenced[name] {
+			return nil
+		}
+		if !strings.HasPrefix(name, tempFilePrefix) {
+			numRemoved++
+		}
+		return os.Remove(filename)
+	})
+	return numRemoved, err
+}
+
+func walkFiles(root string, visit func(filename string) error) error {
+	err := path.Walk(root, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			return visit(filename)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
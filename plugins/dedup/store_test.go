@@ -0,0 +1,117 @@
+package dedup_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+
+	"github.com/greenplum-db/gpbackup/plugins/dedup"
+	"github.com/greenplum-db/gpbackup/plugins/pluginapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func countChunks(directory string) int {
+	numChunks := 0
+	_ = path.Walk(path.Join(directory, "chunks"), func(filename string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			numChunks++
+		}
+		return nil
+	})
+	return numChunks
+}
+
+var _ = Describe("dedup/store tests", func() {
+	var (
+		directory string
+		store     *dedup.Store
+		data      []byte
+	)
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "dedup_store_test_")
+		Expect(err).ToNot(HaveOccurred())
+		store = dedup.NewStore(directory, 4096)
+		data = randomData(1, 100000)
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(directory)
+	})
+	restore := func(key string) []byte {
+		buffer := &bytes.Buffer{}
+		Expect(store.Restore(key, buffer)).To(Succeed())
+		return buffer.Bytes()
+	}
+	Describe("Backup and Restore", func() {
+		It("restores the data that was backed up", func() {
+			Expect(store.Backup("20190101/20190101010101/file", bytes.NewReader(data))).To(Succeed())
+
+			Expect(restore("20190101/20190101010101/file")).To(Equal(data))
+		})
+		It("restores an empty file", func() {
+			Expect(store.Backup("20190101/20190101010101/file", bytes.NewReader(nil))).To(Succeed())
+
+			Expect(restore("20190101/20190101010101/file")).To(BeEmpty())
+		})
+		It("does not store the chunks of a file again in a later backup", func() {
+			Expect(store.Backup("20190101/20190101010101/file", bytes.NewReader(data))).To(Succeed())
+			numChunks := countChunks(directory)
+
+			Expect(store.Backup("20190102/20190102010101/file", bytes.NewReader(data))).To(Succeed())
+			Expect(countChunks(directory)).To(Equal(numChunks))
+			Expect(restore("20190102/20190102010101/file")).To(Equal(data))
+		})
+		It("returns an error for a file that was not backed up", func() {
+			err := store.Restore("20190101/20190101010101/file", &bytes.Buffer{})
+			Expect(err).To(MatchError("File 20190101/20190101010101/file does not exist in " + directory))
+		})
+		It("returns an error if a chunk is corrupt", func() {
+			Expect(store.Backup("20190101/20190101010101/file", bytes.NewReader(data))).To(Succeed())
+			chunkRefs, err := store.ReadManifest("20190101/20190101010101/file")
+			Expect(err).ToNot(HaveOccurred())
+			chunkPath := path.Join(directory, "chunks", chunkRefs[1].Hash[0:2], chunkRefs[1].Hash)
+			Expect(ioutil.WriteFile(chunkPath, make([]byte, chunkRefs[1].Size), 0644)).To(Succeed())
+
+			err = store.Restore("20190101/20190101010101/file", &bytes.Buffer{})
+			Expect(err).To(MatchError("Chunk " + chunkRefs[1].Hash + " in " + directory + " is corrupt"))
+		})
+	})
+	Describe("RestoreRanges", func() {
+		BeforeEach(func() {
+			Expect(store.Backup("20190101/20190101010101/file", bytes.NewReader(data))).To(Succeed())
+		})
+		It("writes the given byte ranges, including ranges that span chunks", func() {
+			buffer := &bytes.Buffer{}
+			byteRanges := []pluginapi.ByteRange{{Start: 10, End: 20}, {Start: 5000, End: 60000}, {Start: 60000, End: 60000}, {Start: 99990, End: 100000}}
+			Expect(store.RestoreRanges("20190101/20190101010101/file", byteRanges, buffer)).To(Succeed())
+
+			expected := append(append(append([]byte{}, data[10:20]...), data[5000:60000]...), data[99990:100000]...)
+			Expect(buffer.Bytes()).To(Equal(expected))
+		})
+		It("returns an error for a byte range beyond the end of the file", func() {
+			err := store.RestoreRanges("20190101/20190101010101/file", []pluginapi.ByteRange{{Start: 99990, End: 100001}}, &bytes.Buffer{})
+			Expect(err).To(MatchError("Byte range 99990-100001 is beyond the end of file 20190101/20190101010101/file, which is 100000 bytes"))
+		})
+	})
+	Describe("DeleteBackup", func() {
+		It("removes the backup and only the chunks no other backup references", func() {
+			otherData := append(append([]byte{}, data[:50000]...), randomData(2, 50000)...)
+			Expect(store.Backup("20190101/20190101010101/file", bytes.NewReader(data))).To(Succeed())
+			numChunks := countChunks(directory)
+			Expect(store.Backup("20190102/20190102010101/file", bytes.NewReader(otherData))).To(Succeed())
+			Expect(countChunks(directory)).To(BeNumerically(">", numChunks))
+
+			Expect(store.DeleteBackup("20190102010101")).To(Succeed())
+
+			Expect(countChunks(directory)).To(Equal(numChunks))
+			Expect(path.Join(directory, "backups", "20190102")).ToNot(BeADirectory())
+			Expect(restore("20190101/20190101010101/file")).To(Equal(data))
+		})
+		It("succeeds for a backup that does not exist", func() {
+			Expect(store.DeleteBackup("20190101010101")).To(Succeed())
+		})
+	})
+})
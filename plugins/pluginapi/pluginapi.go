@@ -0,0 +1,224 @@
+package pluginapi
+
+/*
+ * This file contains the command-line side of the gpbackup plugin API, so that
+ * plugins written in Go only need to implement the Plugin interface.  See
+ * plugins/README.md for a description of each command.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	path "path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+ * The version of the plugin API implemented by this package, which is printed
+ * by the plugin_api_version command.
+ */
+const APIVersion = "0.4.0"
+
+var timestampRegex = regexp.MustCompile(`^\d{14}$`)
+
+type PluginConfig struct {
+	ExecutablePath string            `yaml:"executablepath"`
+	Options        map[string]string `yaml:"options"`
+}
+
+/*
+ * A byte range within a data file, including Start and excluding End, as
+ * passed to restore_data_subset.
+ */
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+type Plugin interface {
+	SetupPluginForBackup(localBackupDir string, scope string) error
+	SetupPluginForRestore(localBackupDir string, scope string) error
+	CleanupPluginForBackup(localBackupDir string, scope string) error
+	CleanupPluginForRestore(localBackupDir string, scope string) error
+	BackupFile(filename string) error
+	RestoreFile(filename string) error
+	BackupData(dataFilekey string, reader io.Reader) error
+	RestoreData(dataFilekey string, writer io.Writer) error
+	RestoreDataSubset(dataFilekey string, byteRanges []ByteRange, writer io.Writer) error
+	DeleteBackup(timestamp string) error
+}
+
+/*
+ * Reads the plugin configuration file passed as the first argument of each
+ * command.  Options are always non-nil so plugins can look them up directly.
+ */
+func ReadPluginConfig(configFile string) (*PluginConfig, error) {
+	contents, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &PluginConfig{}
+	err = yaml.Unmarshal(contents, config)
+	if err != nil {
+		return nil, errors.Errorf("Unable to parse plugin config file %s: %v", configFile, err)
+	}
+	if config.Options == nil {
+		config.Options = make(map[string]string)
+	}
+	return config, nil
+}
+
+/*
+ * Reads an offsets file written by gpbackup_helper, which has the format
+ * "<number of ranges> <start1> <end1> <start2> <end2> ...".
+ */
+func ReadOffsetsFile(offsetsFile string) ([]ByteRange, error) {
+	contents, err := ioutil.ReadFile(offsetsFile)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) == 0 {
+		return nil, errors.Errorf("Offsets file %s is empty", offsetsFile)
+	}
+	numRanges, err := strconv.Atoi(fields[0])
+	if err != nil || numRanges < 0 || len(fields) != 2*numRanges+1 {
+		return nil, errors.Errorf("Offsets file %s is malformed", offsetsFile)
+	}
+	byteRanges := make([]ByteRange, numRanges)
+	for i := range byteRanges {
+		start, startErr := strconv.ParseInt(fields[2*i+1], 10, 64)
+		end, endErr := strconv.ParseInt(fields[2*i+2], 10, 64)
+		if startErr != nil || endErr != nil || start < 0 || end < start {
+			return nil, errors.Errorf("Offsets file %s contains an invalid byte range", offsetsFile)
+		}
+		byteRanges[i] = ByteRange{Start: start, End: end}
+	}
+	return byteRanges, nil
+}
+
+/*
+ * Returns the key under which a plugin stores the given file, which has the
+ * form "YYYYMMDD/<timestamp>/<filename>".  The timestamp is the name of the
+ * directory containing the file, as in the default backup directories, so all
+ * files of a backup can be found by delete_backup.
+ */
+func GetBackupKey(filename string) (string, error) {
+	timestamp := path.Base(path.Dir(filename))
+	if !timestampRegex.MatchString(timestamp) {
+		return "", errors.Errorf("Unable to determine the backup timestamp of %s", filename)
+	}
+	return fmt.Sprintf("%s/%s/%s", timestamp[0:8], timestamp, path.Base(filename)), nil
+}
+
+/*
+ * Returns the "YYYYMMDD/<timestamp>" prefix of the keys of every file in the
+ * given backup.
+ */
+func GetTimestampKey(timestamp string) (string, error) {
+	if !timestampRegex.MatchString(timestamp) {
+		return "", errors.Errorf("Invalid timestamp %s", timestamp)
+	}
+	return fmt.Sprintf("%s/%s", timestamp[0:8], timestamp), nil
+}
+
+/*
+ * Runs the plugin command given in args, which are the command-line arguments
+ * without the executable name.  newPlugin is called with the parsed config of
+ * every command that takes one.
+ */
+func Execute(pluginName string, version string, newPlugin func(config *PluginConfig) (Plugin, error),
+	args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.Errorf("Usage: %s <command> <config_path> [arguments]", pluginName)
+	}
+	command := args[0]
+	switch command {
+	case "plugin_api_version":
+		_, err := fmt.Fprintln(stdout, APIVersion)
+		return err
+	case "--version":
+		_, err := fmt.Fprintf(stdout, "%s version %s\n", pluginName, version)
+		return err
+	}
+
+	expectedArgs, ok := map[string]int{
+		"setup_plugin_for_backup":    3,
+		"setup_plugin_for_restore":   3,
+		"cleanup_plugin_for_backup":  3,
+		"cleanup_plugin_for_restore": 3,
+		"backup_file":                2,
+		"restore_file":               2,
+		"backup_data":                2,
+		"restore_data":               2,
+		"restore_data_subset":        3,
+		"delete_backup":              2,
+	}[command]
+	if !ok {
+		return errors.Errorf("Unrecognized command %s", command)
+	}
+	/*
+	 * The setup and cleanup hooks are passed a contentID for the master and
+	 * segment scopes, which these plugins do not need.
+	 */
+	isHook := strings.HasPrefix(command, "setup_") || strings.HasPrefix(command, "cleanup_")
+	numArgs := len(args) - 1
+	if numArgs != expectedArgs && !(isHook && numArgs == expectedArgs+1) {
+		return errors.Errorf("Wrong number of arguments for %s", command)
+	}
+	config, err := ReadPluginConfig(args[1])
+	if err != nil {
+		return err
+	}
+	plugin, err := newPlugin(config)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "setup_plugin_for_backup":
+		return plugin.SetupPluginForBackup(args[2], args[3])
+	case "setup_plugin_for_restore":
+		return plugin.SetupPluginForRestore(args[2], args[3])
+	case "cleanup_plugin_for_backup":
+		return plugin.CleanupPluginForBackup(args[2], args[3])
+	case "cleanup_plugin_for_restore":
+		return plugin.CleanupPluginForRestore(args[2], args[3])
+	case "backup_file":
+		return plugin.BackupFile(args[2])
+	case "restore_file":
+		return plugin.RestoreFile(args[2])
+	case "backup_data":
+		return plugin.BackupData(args[2], stdin)
+	case "restore_data":
+		return restoreToWriter(stdout, func(writer io.Writer) error {
+			return plugin.RestoreData(args[2], writer)
+		})
+	case "restore_data_subset":
+		byteRanges, err := ReadOffsetsFile(args[3])
+		if err != nil {
+			return err
+		}
+		return restoreToWriter(stdout, func(writer io.Writer) error {
+			return plugin.RestoreDataSubset(args[2], byteRanges, writer)
+		})
+	default: // delete_backup
+		return plugin.DeleteBackup(args[2])
+	}
+}
+
+func restoreToWriter(stdout io.Writer, restore func(writer io.Writer) error) error {
+	writer := bufio.NewWriter(stdout)
+	err := restore(writer)
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
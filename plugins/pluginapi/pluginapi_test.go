@@ -0,0 +1,170 @@
+package pluginapi_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/greenplum-db/gpbackup/plugins/pluginapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPluginAPI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Plugin API Suite")
+}
+
+type fakePlugin struct {
+	calls      []string
+	byteRanges []pluginapi.ByteRange
+}
+
+func (plugin *fakePlugin) SetupPluginForBackup(localBackupDir string, scope string) error {
+	plugin.calls = append(plugin.calls, "setup_plugin_for_backup "+localBackupDir+" "+scope)
+	return nil
+}
+func (plugin *fakePlugin) SetupPluginForRestore(localBackupDir string, scope string) error {
+	return nil
+}
+func (plugin *fakePlugin) CleanupPluginForBackup(localBackupDir string, scope string) error {
+	return nil
+}
+func (plugin *fakePlugin) CleanupPluginForRestore(localBackupDir string, scope string) error {
+	return nil
+}
+func (plugin *fakePlugin) BackupFile(filename string) error {
+	return nil
+}
+func (plugin *fakePlugin) RestoreFile(filename string) error {
+	return nil
+}
+func (plugin *fakePlugin) BackupData(dataFilekey string, reader io.Reader) error {
+	data, _ := ioutil.ReadAll(reader)
+	plugin.calls = append(plugin.calls, "backup_data "+dataFilekey+" "+string(data))
+	return nil
+}
+func (plugin *fakePlugin) RestoreData(dataFilekey string, writer io.Writer) error {
+	_, err := writer.Write([]byte("data of " + dataFilekey))
+	return err
+}
+func (plugin *fakePlugin) RestoreDataSubset(dataFilekey string, byteRanges []pluginapi.ByteRange, writer io.Writer) error {
+	plugin.byteRanges = byteRanges
+	return nil
+}
+func (plugin *fakePlugin) DeleteBackup(timestamp string) error {
+	plugin.calls = append(plugin.calls, "delete_backup "+timestamp)
+	return nil
+}
+
+var _ = Describe("pluginapi tests", func() {
+	var (
+		plugin     *fakePlugin
+		configFile string
+		stdout     *bytes.Buffer
+	)
+	newPlugin := func(config *pluginapi.PluginConfig) (pluginapi.Plugin, error) {
+		return plugin, nil
+	}
+	writeTempFile := func(contents string) string {
+		file, err := ioutil.TempFile("", "pluginapi_test_")
+		Expect(err).ToNot(HaveOccurred())
+		_, _ = file.WriteString(contents)
+		_ = file.Close()
+		return file.Name()
+	}
+	BeforeEach(func() {
+		plugin = &fakePlugin{}
+		configFile = writeTempFile("executablepath: /tmp/test_plugin\noptions:\n  directory: /tmp/dest\n")
+		stdout = &bytes.Buffer{}
+	})
+	AfterEach(func() {
+		_ = os.Remove(configFile)
+	})
+	Describe("Execute", func() {
+		It("prints the plugin API version", func() {
+			err := pluginapi.Execute("test_plugin", "1.2.3", newPlugin, []string{"plugin_api_version"}, nil, stdout)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stdout.String()).To(Equal("0.4.0\n"))
+		})
+		It("prints the plugin version", func() {
+			err := pluginapi.Execute("test_plugin", "1.2.3", newPlugin, []string{"--version"}, nil, stdout)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stdout.String()).To(Equal("test_plugin version 1.2.3\n"))
+		})
+		It("passes the setup hook arguments with or without a contentID", func() {
+			err := pluginapi.Execute("test_plugin", "1.2.3", newPlugin, []string{"setup_plugin_for_backup", configFile, "/data/backups", "master", "-1"}, nil, stdout)
+			Expect(err).ToNot(HaveOccurred())
+			err = pluginapi.Execute("test_plugin", "1.2.3", newPlugin, []string{"setup_plugin_for_backup", configFile, "/data/backups", "segment_host"}, nil, stdout)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(plugin.calls).To(Equal([]string{
+				"setup_plugin_for_backup /data/backups master",
+				"setup_plugin_for_backup /data/backups segment_host",
+			}))
+		})
+		It("streams data to and from the plugin", func() {
+			err := pluginapi.Execute("test_plugin", "1.2.3", newPlugin, []string{"backup_data", configFile, "/data/file"}, strings.NewReader("abc"), stdout)
+			Expect(err).ToNot(HaveOccurred())
+			err = pluginapi.Execute("test_plugin", "1.2.3", newPlugin, []string{"restore_data", configFile, "/data/file"}, nil, stdout)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(plugin.calls).To(Equal([]string{"backup_data /data/file abc"}))
+			Expect(stdout.String()).To(Equal("data of /data/file"))
+		})
+		It("reads the byte ranges to restore from the offsets file", func() {
+			offsetsFile := writeTempFile("2 0 10 25 40")
+			defer os.Remove(offsetsFile)
+
+			err := pluginapi.Execute("test_plugin", "1.2.3", newPlugin, []string{"restore_data_subset", configFile, "/data/file", offsetsFile}, nil, stdout)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plugin.byteRanges).To(Equal([]pluginapi.ByteRange{{Start: 0, End: 10}, {Start: 25, End: 40}}))
+		})
+		It("returns an error for an unrecognized command", func() {
+			err := pluginapi.Execute("test_plugin", "1.2.3", newPlugin, []string{"unknown_command", configFile}, nil, stdout)
+			Expect(err).To(MatchError("Unrecognized command unknown_command"))
+		})
+		It("returns an error for the wrong number of arguments", func() {
+			err := pluginapi.Execute("test_plugin", "1.2.3", newPlugin, []string{"delete_backup", configFile, "20190101010101", "extra"}, nil, stdout)
+			Expect(err).To(MatchError("Wrong number of arguments for delete_backup"))
+			Expect(plugin.calls).To(BeEmpty())
+		})
+	})
+	Describe("ReadOffsetsFile", func() {
+		It("returns an error for a malformed offsets file", func() {
+			offsetsFile := writeTempFile("2 0 10 25")
+			defer os.Remove(offsetsFile)
+
+			_, err := pluginapi.ReadOffsetsFile(offsetsFile)
+			Expect(err).To(MatchError("Offsets file " + offsetsFile + " is malformed"))
+		})
+		It("returns an error for a byte range that ends before it starts", func() {
+			offsetsFile := writeTempFile("1 10 0")
+			defer os.Remove(offsetsFile)
+
+			_, err := pluginapi.ReadOffsetsFile(offsetsFile)
+			Expect(err).To(MatchError("Offsets file " + offsetsFile + " contains an invalid byte range"))
+		})
+	})
+	Describe("GetBackupKey", func() {
+		It("returns the day, timestamp, and name of a file in a backup directory", func() {
+			key, err := pluginapi.GetBackupKey("/data/gpseg0/backups/20190101/20190101010101/gpbackup_0_20190101010101_3")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key).To(Equal("20190101/20190101010101/gpbackup_0_20190101010101_3"))
+		})
+		It("returns an error for a file outside a backup directory", func() {
+			_, err := pluginapi.GetBackupKey("/tmp/some_file")
+			Expect(err).To(MatchError("Unable to determine the backup timestamp of /tmp/some_file"))
+		})
+	})
+	Describe("GetTimestampKey", func() {
+		It("returns an error for an invalid timestamp", func() {
+			_, err := pluginapi.GetTimestampKey("../20190101")
+			Expect(err).To(MatchError("Invalid timestamp ../20190101"))
+		})
+	})
+})
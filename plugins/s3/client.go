@@ -0,0 +1,325 @@
+package s3
+
+/*
+ * This file contains a minimal client for the subset of the S3 REST API used
+ * by the plugin.  Every request is retried on network errors and on responses
+ * indicating that the object store is overloaded or unavailable.
+ */
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var retryDelay = 500 * time.Millisecond
+
+/*
+ * Sets the delay before the first retry of a failed request, which doubles
+ * with each further retry.
+ */
+func SetRetryDelay(delay time.Duration) {
+	retryDelay = delay
+}
+
+func sleepBeforeRetry(attempt int) {
+	delay := retryDelay << uint(attempt)
+	if delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+	time.Sleep(delay)
+}
+
+type Client struct {
+	Endpoint        *url.URL
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PathStyle       bool
+	MaxRetries      int
+	HTTPClient      *http.Client
+}
+
+type requestError struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (err *requestError) Error() string {
+	if err.Code == "" {
+		return fmt.Sprintf("status %d", err.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s", err.Code, err.Message)
+}
+
+func isRetryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+/*
+ * Returns the URL of the given object, or of the bucket if key is empty, using
+ * either path-style ("endpoint/bucket/key") or virtual-hosted-style
+ * ("bucket.endpoint/key") addressing.
+ */
+func (client *Client) objectURL(key string, query url.Values) *url.URL {
+	objectURL := *client.Endpoint
+	basePath := strings.TrimSuffix(objectURL.Path, "/")
+	if client.PathStyle {
+		objectURL.Path = basePath + "/" + client.Bucket
+		if key != "" {
+			objectURL.Path += "/" + key
+		}
+	} else {
+		objectURL.Host = client.Bucket + "." + objectURL.Host
+		objectURL.Path = basePath + "/" + key
+	}
+	objectURL.RawPath = uriEncode(objectURL.Path, false)
+	objectURL.RawQuery = query.Encode()
+	return &objectURL
+}
+
+/*
+ * Sends a signed request and returns the response if it succeeded, retrying up
+ * to MaxRetries times.  The caller must close the body of the response.
+ */
+func (client *Client) doRequest(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	payloadHash := hashPayload(body)
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequest(method, client.objectURL(key, query).String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		request.ContentLength = int64(len(body))
+		client.SignRequest(request, payloadHash, time.Now())
+
+		response, err := client.HTTPClient.Do(request)
+		if err == nil && response.StatusCode < http.StatusMultipleChoices {
+			return response, nil
+		}
+		if err == nil {
+			err = readRequestError(response)
+			if !isRetryable(response.StatusCode) {
+				return nil, errors.Errorf("%s of %s failed: %v", method, client.describe(key), err)
+			}
+		}
+		if attempt >= client.MaxRetries {
+			return nil, errors.Errorf("%s of %s failed after %d attempts: %v", method, client.describe(key), attempt+1, err)
+		}
+		sleepBeforeRetry(attempt)
+	}
+}
+
+func (client *Client) describe(key string) string {
+	if key == "" {
+		return fmt.Sprintf("bucket %s", client.Bucket)
+	}
+	return fmt.Sprintf("object %s in bucket %s", key, client.Bucket)
+}
+
+func readRequestError(response *http.Response) error {
+	defer response.Body.Close()
+	requestErr := &requestError{StatusCode: response.StatusCode}
+	contents, _ := ioutil.ReadAll(response.Body)
+	_ = xml.Unmarshal(contents, requestErr)
+	return requestErr
+}
+
+func (client *Client) doRequestAndClose(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, []byte, error) {
+	response, err := client.doRequest(method, key, query, header, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	contents, err := ioutil.ReadAll(response.Body)
+	return response, contents, err
+}
+
+func (client *Client) HeadBucket() error {
+	_, _, err := client.doRequestAndClose(http.MethodHead, "", nil, nil, nil)
+	return err
+}
+
+func (client *Client) PutObject(key string, data []byte) error {
+	_, _, err := client.doRequestAndClose(http.MethodPut, key, nil, nil, data)
+	return err
+}
+
+func (client *Client) DeleteObject(key string) error {
+	_, _, err := client.doRequestAndClose(http.MethodDelete, key, nil, nil, nil)
+	return err
+}
+
+func (client *Client) CreateMultipartUpload(key string) (string, error) {
+	_, contents, err := client.doRequestAndClose(http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	result := struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+	err = xml.Unmarshal(contents, &result)
+	if err != nil || result.UploadID == "" {
+		return "", errors.Errorf("Unable to parse the response to creating a multipart upload of %s", client.describe(key))
+	}
+	return result.UploadID, nil
+}
+
+/*
+ * Uploads one part of a multipart upload and returns its ETag, which must be
+ * passed to CompleteMultipartUpload.
+ */
+func (client *Client) UploadPart(key string, uploadID string, partNumber int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+	response, _, err := client.doRequestAndClose(http.MethodPut, key, query, nil, data)
+	if err != nil {
+		return "", err
+	}
+	return response.Header.Get("ETag"), nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+/*
+ * Completes a multipart upload of the parts with the given ETags, in order.  S3
+ * may report an error in the body of a successful response to this request,
+ * so the body is checked as well.
+ */
+func (client *Client) CompleteMultipartUpload(key string, uploadID string, etags []string) error {
+	request := struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{}
+	for i, etag := range etags {
+		request.Parts = append(request.Parts, completedPart{PartNumber: i + 1, ETag: etag})
+	}
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return err
+	}
+	_, contents, err := client.doRequestAndClose(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	result := struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}{}
+	if xml.Unmarshal(contents, &result) == nil && result.XMLName.Local == "Error" {
+		requestErr := &requestError{StatusCode: http.StatusOK, Code: result.Code, Message: result.Message}
+		return errors.Errorf("%s of %s failed: %v", http.MethodPost, client.describe(key), requestErr)
+	}
+	return nil
+}
+
+func (client *Client) AbortMultipartUpload(key string, uploadID string) error {
+	_, _, err := client.doRequestAndClose(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	return err
+}
+
+/*
+ * Returns the keys of every object whose key starts with prefix.
+ */
+func (client *Client) ListObjects(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	continuationToken := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		_, contents, err := client.doRequestAndClose(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		result := struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}{}
+		err = xml.Unmarshal(contents, &result)
+		if err != nil {
+			return nil, errors.Errorf("Unable to parse the list of objects in bucket %s: %v", client.Bucket, err)
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+type trackingWriter struct {
+	writer  io.Writer
+	written int64
+	err     error
+}
+
+func (writer *trackingWriter) Write(p []byte) (int, error) {
+	numWritten, err := writer.writer.Write(p)
+	writer.written += int64(numWritten)
+	writer.err = err
+	return numWritten, err
+}
+
+/*
+ * Writes the bytes of the object from start up to but excluding end, or the
+ * whole object from start if end is negative.  If the connection fails while
+ * the object is being read, the rest of the object is requested again with a
+ * ranged GET, so a restore does not have to start over.
+ */
+func (client *Client) GetObject(key string, start int64, end int64, writer io.Writer) error {
+	if end >= 0 && start >= end {
+		return nil
+	}
+	tracker := &trackingWriter{writer: writer}
+	for attempt := 0; ; attempt++ {
+		offset := start + tracker.written
+		header := http.Header{}
+		if end >= 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, end-1))
+		} else if offset > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		response, err := client.doRequest(http.MethodGet, key, nil, header, nil)
+		if err != nil {
+			return err
+		}
+		if header.Get("Range") != "" && response.StatusCode != http.StatusPartialContent {
+			response.Body.Close()
+			return errors.Errorf("GET of %s returned the whole object instead of the requested byte range", client.describe(key))
+		}
+		_, err = io.Copy(tracker, response.Body)
+		response.Body.Close()
+		if err == nil {
+			return nil
+		} else if tracker.err != nil {
+			return tracker.err
+		}
+		if attempt >= client.MaxRetries {
+			return errors.Errorf("GET of %s failed after %d attempts: %v", client.describe(key), attempt+1, err)
+		}
+		sleepBeforeRetry(attempt)
+	}
+}
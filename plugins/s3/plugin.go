@@ -0,0 +1,433 @@
+package s3
+
+/*
+ * This file contains the implementation of the plugin API commands for
+ * gpbackup_s3_compatible_plugin, which stores backups in Amazon S3 or any
+ * object store implementing the S3 API, such as MinIO.
+ */
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	path "path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/plugins/pluginapi"
+	"github.com/pkg/errors"
+)
+
+const (
+	PluginName = "gpbackup_s3_compatible_plugin"
+
+	DefaultPartSize              = 64 * 1024 * 1024
+	MinPartSize                  = 5 * 1024 * 1024
+	MaxPartSize                  = 5 * 1024 * 1024 * 1024
+	MaxPartCount                 = 10000
+	PartSizeGrowthInterval       = 1000
+	DefaultMaxConcurrentRequests = 2
+	DefaultMaxRetries            = 5
+)
+
+var version string
+
+func GetVersion() string {
+	return version
+}
+
+type S3Plugin struct {
+	client                *Client
+	folder                string
+	partSize              int
+	maxConcurrentRequests int
+}
+
+func NewPlugin(config *pluginapi.PluginConfig) (pluginapi.Plugin, error) {
+	options := config.Options
+	if options["bucket"] == "" {
+		return nil, errors.Errorf("The bucket option must be set in the plugin config")
+	}
+	region := options["region"]
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpointStr := options["endpoint"]
+	if endpointStr == "" {
+		endpointStr = "https://s3." + region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(endpointStr)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, errors.Errorf("Invalid endpoint %s; the endpoint must be an http or https URL", endpointStr)
+	}
+	accessKeyID := options["aws_access_key_id"]
+	secretAccessKey := options["aws_secret_access_key"]
+	if accessKeyID == "" && secretAccessKey == "" {
+		accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, errors.Errorf("The aws_access_key_id and aws_secret_access_key options must be set in the plugin config")
+	}
+	pathStyle, err := parseOnOff(options, "path_style", true)
+	if err != nil {
+		return nil, err
+	}
+	partSize, err := parseSize(options, "backup_multipart_chunksize", DefaultPartSize)
+	if err != nil {
+		return nil, err
+	}
+	if partSize < MinPartSize {
+		return nil, errors.Errorf("backup_multipart_chunksize must be at least %d bytes", MinPartSize)
+	}
+	maxConcurrentRequests, err := parseNumber(options, "backup_max_concurrent_requests", DefaultMaxConcurrentRequests, 1)
+	if err != nil {
+		return nil, err
+	}
+	maxRetries, err := parseNumber(options, "max_retries", DefaultMaxRetries, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		Endpoint:        endpoint,
+		Region:          region,
+		Bucket:          options["bucket"],
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		PathStyle:       pathStyle,
+		MaxRetries:      maxRetries,
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 5 * time.Minute,
+		}},
+	}
+	return &S3Plugin{
+		client:                client,
+		folder:                strings.Trim(options["folder"], "/"),
+		partSize:              partSize,
+		maxConcurrentRequests: maxConcurrentRequests,
+	}, nil
+}
+
+func parseOnOff(options map[string]string, name string, defaultValue bool) (bool, error) {
+	switch options[name] {
+	case "":
+		return defaultValue, nil
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, errors.Errorf("%s must be on or off, not %s", name, options[name])
+}
+
+func parseNumber(options map[string]string, name string, defaultValue int, minValue int) (int, error) {
+	if options[name] == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(options[name])
+	if err != nil || value < minValue {
+		return 0, errors.Errorf("%s must be a number no less than %d, not %s", name, minValue, options[name])
+	}
+	return value, nil
+}
+
+/*
+ * Parses a size in bytes, which may have a KB, MB, or GB suffix.
+ */
+func parseSize(options map[string]string, name string, defaultValue int) (int, error) {
+	sizeStr := strings.ToUpper(options[name])
+	if sizeStr == "" {
+		return defaultValue, nil
+	}
+	multiplier := 1
+	for suffix, suffixMultiplier := range map[string]int{"KB": 1024, "MB": 1024 * 1024, "GB": 1024 * 1024 * 1024} {
+		if strings.HasSuffix(sizeStr, suffix) {
+			sizeStr = strings.TrimSuffix(sizeStr, suffix)
+			multiplier = suffixMultiplier
+			break
+		}
+	}
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil || size <= 0 {
+		return 0, errors.Errorf("Invalid %s %s", name, options[name])
+	}
+	return size * multiplier, nil
+}
+
+/*
+ * Returns the object key of a backup file, which is
+ * "<folder>/backups/YYYYMMDD/<timestamp>/<filename>".
+ */
+func (plugin *S3Plugin) objectKey(filename string) (string, error) {
+	key, err := pluginapi.GetBackupKey(filename)
+	if err != nil {
+		return "", err
+	}
+	return plugin.prefix() + key, nil
+}
+
+func (plugin *S3Plugin) prefix() string {
+	if plugin.folder == "" {
+		return "backups/"
+	}
+	return plugin.folder + "/backups/"
+}
+
+/*
+ * The master checks that the bucket can be reached at the start of a backup or
+ * restore, so that bad credentials or endpoints fail before any data is read.
+ */
+func (plugin *S3Plugin) SetupPluginForBackup(localBackupDir string, scope string) error {
+	if scope == "master" {
+		return plugin.client.HeadBucket()
+	}
+	return nil
+}
+
+func (plugin *S3Plugin) SetupPluginForRestore(localBackupDir string, scope string) error {
+	err := os.MkdirAll(localBackupDir, 0755)
+	if err != nil {
+		return err
+	}
+	if scope == "master" {
+		return plugin.client.HeadBucket()
+	}
+	return nil
+}
+
+func (plugin *S3Plugin) CleanupPluginForBackup(localBackupDir string, scope string) error {
+	return nil
+}
+
+func (plugin *S3Plugin) CleanupPluginForRestore(localBackupDir string, scope string) error {
+	return nil
+}
+
+func (plugin *S3Plugin) BackupFile(filename string) error {
+	key, err := plugin.objectKey(filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return plugin.upload(key, file)
+}
+
+func (plugin *S3Plugin) RestoreFile(filename string) (err error) {
+	key, err := plugin.objectKey(filename)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(path.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(filename)
+		}
+	}()
+	return plugin.client.GetObject(key, 0, -1, file)
+}
+
+func (plugin *S3Plugin) BackupData(dataFilekey string, reader io.Reader) error {
+	key, err := plugin.objectKey(dataFilekey)
+	if err != nil {
+		return err
+	}
+	return plugin.upload(key, reader)
+}
+
+func (plugin *S3Plugin) RestoreData(dataFilekey string, writer io.Writer) error {
+	key, err := plugin.objectKey(dataFilekey)
+	if err != nil {
+		return err
+	}
+	return plugin.client.GetObject(key, 0, -1, writer)
+}
+
+/*
+ * Requests each byte range with a ranged GET, merging ranges that are adjacent
+ * in the data file into a single request.
+ */
+func (plugin *S3Plugin) RestoreDataSubset(dataFilekey string, byteRanges []pluginapi.ByteRange, writer io.Writer) error {
+	key, err := plugin.objectKey(dataFilekey)
+	if err != nil {
+		return err
+	}
+	merged := make([]pluginapi.ByteRange, 0)
+	for _, byteRange := range byteRanges {
+		if byteRange.Start == byteRange.End {
+			continue
+		}
+		if len(merged) > 0 && merged[len(merged)-1].End == byteRange.Start {
+			merged[len(merged)-1].End = byteRange.End
+		} else {
+			merged = append(merged, byteRange)
+		}
+	}
+	for _, byteRange := range merged {
+		err = plugin.client.GetObject(key, byteRange.Start, byteRange.End, writer)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (plugin *S3Plugin) DeleteBackup(timestamp string) error {
+	timestampKey, err := pluginapi.GetTimestampKey(timestamp)
+	if err != nil {
+		return err
+	}
+	keys, err := plugin.client.ListObjects(plugin.prefix() + timestampKey + "/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = plugin.client.DeleteObject(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readPart(reader io.Reader, partSize int) ([]byte, error) {
+	part := make([]byte, partSize)
+	numRead, err := io.ReadFull(reader, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return part[:numRead], err
+}
+
+/*
+ * Streams the data read from reader to the object.  Data smaller than a single
+ * part is uploaded with one request, and anything larger with a multipart
+ * upload, so that an arbitrarily large stream never has to be held in memory
+ * or written to local disk.
+ */
+func (plugin *S3Plugin) upload(key string, reader io.Reader) error {
+	part, err := readPart(reader, plugin.partSize)
+	if err != nil {
+		return err
+	}
+	if len(part) < plugin.partSize {
+		return plugin.client.PutObject(key, part)
+	}
+	uploadID, err := plugin.client.CreateMultipartUpload(key)
+	if err != nil {
+		return err
+	}
+	etags, err := plugin.uploadParts(key, uploadID, part, reader)
+	if err == nil {
+		err = plugin.client.CompleteMultipartUpload(key, uploadID, etags)
+	}
+	if err != nil {
+		_ = plugin.client.AbortMultipartUpload(key, uploadID)
+		return err
+	}
+	return nil
+}
+
+/*
+ * S3 allows at most MaxPartCount parts in a multipart upload, so the size of
+ * the parts grows by the configured part size every PartSizeGrowthInterval
+ * parts, up to MaxPartSize.  Small files are still uploaded in small parts,
+ * while a file can grow to 55000 times the configured part size, rather than
+ * 10000 times, before it runs out of parts.
+ */
+func GetPartSize(basePartSize int, partNumber int) int {
+	partSize := basePartSize * (1 + (partNumber-1)/PartSizeGrowthInterval)
+	if partSize > MaxPartSize {
+		return MaxPartSize
+	}
+	return partSize
+}
+
+/*
+ * Uploads firstPart and the rest of the data read from reader as the parts of
+ * a multipart upload, and returns their ETags.  Up to maxConcurrentRequests
+ * parts are uploaded at once while the next part is read.  If the data does
+ * not fit in MaxPartCount parts, the upload fails as soon as that is known.
+ */
+func (plugin *S3Plugin) uploadParts(key string, uploadID string, firstPart []byte, reader io.Reader) ([]string, error) {
+	var (
+		waitGroup sync.WaitGroup
+		mutex     sync.Mutex
+		uploadErr error
+		etags     []string
+	)
+	setErr := func(err error) {
+		mutex.Lock()
+		if uploadErr == nil {
+			uploadErr = err
+		}
+		mutex.Unlock()
+	}
+	semaphore := make(chan bool, plugin.maxConcurrentRequests)
+	part := firstPart
+	for partNumber := 1; ; partNumber++ {
+		semaphore <- true
+		mutex.Lock()
+		failed := uploadErr != nil
+		etags = append(etags, "")
+		mutex.Unlock()
+		if failed {
+			<-semaphore
+			break
+		}
+		waitGroup.Add(1)
+		go func(partNumber int, part []byte) {
+			defer func() {
+				<-semaphore
+				waitGroup.Done()
+			}()
+			etag, err := plugin.client.UploadPart(key, uploadID, partNumber, part)
+			if err != nil {
+				setErr(err)
+				return
+			}
+			mutex.Lock()
+			etags[partNumber-1] = etag
+			mutex.Unlock()
+		}(partNumber, part)
+
+		if len(part) < GetPartSize(plugin.partSize, partNumber) {
+			break
+		}
+		var err error
+		part, err = readPart(reader, GetPartSize(plugin.partSize, partNumber+1))
+		if err != nil {
+			setErr(err)
+			break
+		}
+		if len(part) == 0 {
+			break
+		}
+		if partNumber == MaxPartCount {
+			setErr(errors.Errorf("Unable to upload %s, as it is larger than the %d parts S3 allows in a multipart upload.  "+
+				"Increase backup_multipart_chunksize to upload larger files.", key, MaxPartCount))
+			break
+		}
+	}
+	waitGroup.Wait()
+	return etags, uploadErr
+}
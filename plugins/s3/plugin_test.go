@@ -0,0 +1,179 @@
+package s3_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	path "path/filepath"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/plugins/pluginapi"
+	"github.com/greenplum-db/gpbackup/plugins/s3"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("s3/plugin tests", func() {
+	var (
+		fake    *fakeS3
+		server  *httptest.Server
+		options map[string]string
+	)
+	BeforeEach(func() {
+		fake = newFakeS3()
+		server = httptest.NewServer(fake)
+		options = map[string]string{
+			"endpoint":                   server.URL,
+			"bucket":                     "testbucket",
+			"folder":                     "gpdb",
+			"aws_access_key_id":          "testkey",
+			"aws_secret_access_key":      "testsecret",
+			"backup_multipart_chunksize": "5MB",
+		}
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+	newPlugin := func() pluginapi.Plugin {
+		plugin, err := s3.NewPlugin(&pluginapi.PluginConfig{Options: options})
+		Expect(err).ToNot(HaveOccurred())
+		return plugin
+	}
+	dataFile := "/data/gpseg0/backups/20190101/20190101010101/gpbackup_0_20190101010101"
+	dataKey := "gpdb/backups/20190101/20190101010101/gpbackup_0_20190101010101"
+
+	Describe("NewPlugin", func() {
+		It("requires the bucket option", func() {
+			delete(options, "bucket")
+			_, err := s3.NewPlugin(&pluginapi.PluginConfig{Options: options})
+			Expect(err).To(MatchError("The bucket option must be set in the plugin config"))
+		})
+		It("requires credentials", func() {
+			delete(options, "aws_secret_access_key")
+			_, err := s3.NewPlugin(&pluginapi.PluginConfig{Options: options})
+			Expect(err).To(MatchError("The aws_access_key_id and aws_secret_access_key options must be set in the plugin config"))
+		})
+		It("requires an http or https endpoint", func() {
+			options["endpoint"] = "minio:9000"
+			_, err := s3.NewPlugin(&pluginapi.PluginConfig{Options: options})
+			Expect(err).To(MatchError("Invalid endpoint minio:9000; the endpoint must be an http or https URL"))
+		})
+		It("requires parts of at least 5MB", func() {
+			options["backup_multipart_chunksize"] = "1MB"
+			_, err := s3.NewPlugin(&pluginapi.PluginConfig{Options: options})
+			Expect(err).To(MatchError("backup_multipart_chunksize must be at least 5242880 bytes"))
+		})
+	})
+	Describe("BackupData and RestoreData", func() {
+		It("uploads data smaller than a part with a single request", func() {
+			plugin := newPlugin()
+			Expect(plugin.BackupData(dataFile, strings.NewReader("small data"))).To(Succeed())
+
+			Expect(fake.objects[dataKey]).To(Equal([]byte("small data")))
+			Expect(fake.requests).To(Equal([]string{"PUT /testbucket/" + dataKey}))
+		})
+		It("streams larger data with a multipart upload", func() {
+			data := make([]byte, 12*1024*1024)
+			_, _ = rand.New(rand.NewSource(1)).Read(data)
+			plugin := newPlugin()
+
+			Expect(plugin.BackupData(dataFile, bytes.NewReader(data))).To(Succeed())
+			Expect(fake.requests).To(HaveLen(5))
+			Expect(fake.uploads).To(BeEmpty())
+
+			buffer := &bytes.Buffer{}
+			Expect(plugin.RestoreData(dataFile, buffer)).To(Succeed())
+			Expect(buffer.Bytes()).To(Equal(data))
+		})
+		It("aborts the multipart upload if reading the data fails", func() {
+			plugin := newPlugin()
+			reader := &failAfterReader{data: make([]byte, 6*1024*1024)}
+
+			err := plugin.BackupData(dataFile, reader)
+			Expect(err).To(MatchError("read failed"))
+			Expect(fake.requests[len(fake.requests)-1]).To(Equal("DELETE /testbucket/" + dataKey))
+			Expect(fake.uploads).To(BeEmpty())
+			Expect(fake.objects).ToNot(HaveKey(dataKey))
+		})
+	})
+	Describe("GetPartSize", func() {
+		It("grows the part size by the configured part size every 1000 parts", func() {
+			Expect(s3.GetPartSize(s3.DefaultPartSize, 1)).To(Equal(s3.DefaultPartSize))
+			Expect(s3.GetPartSize(s3.DefaultPartSize, 1000)).To(Equal(s3.DefaultPartSize))
+			Expect(s3.GetPartSize(s3.DefaultPartSize, 1001)).To(Equal(2 * s3.DefaultPartSize))
+			Expect(s3.GetPartSize(s3.DefaultPartSize, s3.MaxPartCount)).To(Equal(10 * s3.DefaultPartSize))
+		})
+		It("does not grow the part size beyond the largest part S3 allows", func() {
+			Expect(s3.GetPartSize(1024*1024*1024, s3.MaxPartCount)).To(Equal(s3.MaxPartSize))
+		})
+	})
+	Describe("RestoreDataSubset", func() {
+		It("writes the byte ranges, merging adjacent ranges into one request", func() {
+			fake.objects[dataKey] = []byte("0123456789")
+			plugin := newPlugin()
+			buffer := &bytes.Buffer{}
+
+			Expect(plugin.RestoreDataSubset(dataFile, []pluginapi.ByteRange{{Start: 1, End: 3}, {Start: 3, End: 4}, {Start: 5, End: 5}, {Start: 7, End: 10}}, buffer)).To(Succeed())
+			Expect(buffer.String()).To(Equal("123789"))
+			Expect(fake.requests).To(HaveLen(2))
+		})
+	})
+	Describe("BackupFile and RestoreFile", func() {
+		It("restores a file that was backed up", func() {
+			directory, err := ioutil.TempDir("", "s3_plugin_test_")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(directory)
+			filename := path.Join(directory, "20190101010101", "gpbackup_20190101010101_config.yaml")
+			Expect(os.MkdirAll(path.Dir(filename), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filename, []byte("config"), 0644)).To(Succeed())
+			plugin := newPlugin()
+
+			Expect(plugin.BackupFile(filename)).To(Succeed())
+			Expect(fake.objects).To(HaveKey("gpdb/backups/20190101/20190101010101/gpbackup_20190101010101_config.yaml"))
+			Expect(os.Remove(filename)).To(Succeed())
+			Expect(plugin.RestoreFile(filename)).To(Succeed())
+			Expect(ioutil.ReadFile(filename)).To(Equal([]byte("config")))
+		})
+		It("does not leave a file behind if it cannot be restored", func() {
+			directory, err := ioutil.TempDir("", "s3_plugin_test_")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(directory)
+			filename := path.Join(directory, "20190101010101", "gpbackup_20190101010101_config.yaml")
+
+			Expect(newPlugin().RestoreFile(filename)).ToNot(Succeed())
+			Expect(filename).ToNot(BeAnExistingFile())
+		})
+	})
+	Describe("DeleteBackup", func() {
+		It("deletes every object of the backup and no others", func() {
+			fake.objects[dataKey] = []byte("data")
+			fake.objects["gpdb/backups/20190101/20190101010101/gpbackup_20190101010101_toc.yaml"] = []byte("toc")
+			fake.objects["gpdb/backups/20190101/20190101020202/gpbackup_20190101020202_toc.yaml"] = []byte("toc")
+
+			Expect(newPlugin().DeleteBackup("20190101010101")).To(Succeed())
+			Expect(fake.objects).To(HaveLen(1))
+			Expect(fake.objects).To(HaveKey("gpdb/backups/20190101/20190101020202/gpbackup_20190101020202_toc.yaml"))
+		})
+	})
+})
+
+/*
+ * failAfterReader returns its data and then an error, like a backup whose
+ * COPY fails partway through.
+ */
+type failAfterReader struct {
+	data []byte
+}
+
+func (reader *failAfterReader) Read(p []byte) (int, error) {
+	if len(reader.data) == 0 {
+		return 0, errors.New("read failed")
+	}
+	numRead := copy(p, reader.data)
+	reader.data = reader.data[numRead:]
+	return numRead, nil
+}
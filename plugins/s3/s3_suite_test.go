@@ -0,0 +1,190 @@
+package s3_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/plugins/s3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestS3(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "S3 Plugin Suite")
+}
+
+var _ = BeforeSuite(func() {
+	s3.SetRetryDelay(time.Millisecond)
+})
+
+/*
+ * fakeS3 is an in-memory stand-in for an S3-compatible object store such as
+ * MinIO, serving a single bucket named "testbucket" with path-style requests.
+ */
+type fakeS3 struct {
+	mutex        sync.Mutex
+	objects      map[string][]byte
+	uploads      map[string]map[int][]byte
+	nextUploadID int
+	requests     []string
+	failRequests int
+	truncateGets int
+	listPageSize int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}, listPageSize: 1000}
+}
+
+func (fake *fakeS3) writeError(writer http.ResponseWriter, status int, code string) {
+	writer.WriteHeader(status)
+	fmt.Fprintf(writer, "<Error><Code>%s</Code><Message>%s message</Message></Error>", code, code)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (fake *fakeS3) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	query := request.URL.Query()
+	fake.requests = append(fake.requests, request.Method+" "+request.URL.Path)
+	if !strings.HasPrefix(request.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=testkey/") {
+		fake.writeError(writer, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	if fake.failRequests > 0 {
+		fake.failRequests--
+		fake.writeError(writer, http.StatusServiceUnavailable, "SlowDown")
+		return
+	}
+	pathParts := strings.SplitN(strings.TrimPrefix(request.URL.Path, "/"), "/", 2)
+	if pathParts[0] != "testbucket" {
+		fake.writeError(writer, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := ""
+	if len(pathParts) == 2 {
+		key = pathParts[1]
+	}
+	body, _ := ioutil.ReadAll(request.Body)
+
+	switch {
+	case key == "" && request.Method == http.MethodHead:
+		return
+	case key == "" && request.Method == http.MethodGet:
+		fake.listObjects(writer, query.Get("prefix"), query.Get("continuation-token"))
+	case request.Method == http.MethodPost && query["uploads"] != nil:
+		fake.nextUploadID++
+		uploadID := strconv.Itoa(fake.nextUploadID)
+		fake.uploads[uploadID] = map[int][]byte{}
+		fmt.Fprintf(writer, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+	case request.Method == http.MethodPut && query.Get("uploadId") != "":
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		fake.uploads[query.Get("uploadId")][partNumber] = body
+		writer.Header().Set("ETag", etag(body))
+	case request.Method == http.MethodPost && query.Get("uploadId") != "":
+		fake.completeUpload(writer, key, query.Get("uploadId"), body)
+	case request.Method == http.MethodDelete && query.Get("uploadId") != "":
+		delete(fake.uploads, query.Get("uploadId"))
+		writer.WriteHeader(http.StatusNoContent)
+	case request.Method == http.MethodPut:
+		fake.objects[key] = body
+	case request.Method == http.MethodGet:
+		fake.getObject(writer, key, request.Header.Get("Range"))
+	case request.Method == http.MethodDelete:
+		delete(fake.objects, key)
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		fake.writeError(writer, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (fake *fakeS3) listObjects(writer http.ResponseWriter, prefix string, continuationToken string) {
+	keys := make([]string, 0)
+	for key := range fake.objects {
+		if strings.HasPrefix(key, prefix) && key > continuationToken {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	isTruncated := len(keys) > fake.listPageSize
+	if isTruncated {
+		keys = keys[:fake.listPageSize]
+	}
+	fmt.Fprint(writer, "<ListBucketResult>")
+	for _, key := range keys {
+		fmt.Fprintf(writer, "<Contents><Key>%s</Key></Contents>", key)
+	}
+	if isTruncated {
+		fmt.Fprintf(writer, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[len(keys)-1])
+	}
+	fmt.Fprint(writer, "</ListBucketResult>")
+}
+
+func (fake *fakeS3) completeUpload(writer http.ResponseWriter, key string, uploadID string, body []byte) {
+	request := struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}{}
+	_ = xml.Unmarshal(body, &request)
+	object := make([]byte, 0)
+	for _, part := range request.Parts {
+		data, ok := fake.uploads[uploadID][part.PartNumber]
+		if !ok || etag(data) != part.ETag {
+			fake.writeError(writer, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		object = append(object, data...)
+	}
+	delete(fake.uploads, uploadID)
+	fake.objects[key] = object
+	fmt.Fprint(writer, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+}
+
+func (fake *fakeS3) getObject(writer http.ResponseWriter, key string, byteRange string) {
+	object, ok := fake.objects[key]
+	if !ok {
+		fake.writeError(writer, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	status := http.StatusOK
+	if byteRange != "" {
+		bounds := strings.SplitN(strings.TrimPrefix(byteRange, "bytes="), "-", 2)
+		start, _ := strconv.Atoi(bounds[0])
+		end := len(object)
+		if bounds[1] != "" {
+			end, _ = strconv.Atoi(bounds[1])
+			end++
+		}
+		if start >= len(object) || end > len(object) {
+			fake.writeError(writer, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		object = object[start:end]
+		status = http.StatusPartialContent
+	}
+	writer.Header().Set("Content-Length", strconv.Itoa(len(object)))
+	writer.WriteHeader(status)
+	if fake.truncateGets > 0 {
+		fake.truncateGets--
+		object = object[:len(object)/2]
+	}
+	_, _ = writer.Write(object)
+}
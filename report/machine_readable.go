@@ -0,0 +1,180 @@
+package report
+
+/*
+ * This file contains structs and functions related to writing JSON or YAML
+ * versions of the backup and restore reports next to the text reports.  They
+ * contain the same information as the text reports, along with per-table and
+ * per-section details, for ingestion by monitoring tools.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ReportFormatJSON = "json"
+	ReportFormatYAML = "yaml"
+)
+
+type TableReport struct {
+	Schema       string
+	Name         string
+	Oid          uint32 `json:",omitempty" yaml:",omitempty"`
+	Rows         int64
+	BytesWritten int64  `json:",omitempty" yaml:",omitempty"`
+	Error        string `json:",omitempty" yaml:",omitempty"`
+	RowFilter    string `json:",omitempty" yaml:",omitempty"`
+}
+
+type SectionReport struct {
+	Name            string
+	StartTime       string
+	EndTime         string
+	DurationSeconds float64
+}
+
+type MachineReadableBackupReport struct {
+	Timestamp       string
+	GpdbVersion     string
+	GpbackupVersion string
+	DatabaseName    string
+	CommandLine     string
+	StartTime       string
+	EndTime         string
+	DurationSeconds float64
+	Status          string
+	Error           string `json:",omitempty" yaml:",omitempty"`
+	DatabaseSize    string `json:",omitempty" yaml:",omitempty"`
+	BytesWritten    int64
+	BackupConfig    history.BackupConfig
+	ObjectCounts    map[string]int
+	Sections        []SectionReport
+	Tables          []TableReport
+}
+
+type MachineReadableRestoreReport struct {
+	Timestamp           string
+	GpdbVersion         string
+	GprestoreVersion    string
+	DatabaseName        string
+	CommandLine         string
+	StartTime           string
+	EndTime             string
+	DurationSeconds     float64
+	Status              string
+	Error               string `json:",omitempty" yaml:",omitempty"`
+	ErrorTablesMetadata []string
+	ErrorTablesData     []string
+	Sections            []SectionReport
+	Tables              []TableReport
+}
+
+func ValidateReportFormat(format string) error {
+	if format != ReportFormatJSON && format != ReportFormatYAML {
+		return errors.Errorf("Invalid report format %s.  Valid formats are %s and %s.", format, ReportFormatJSON, ReportFormatYAML)
+	}
+	return nil
+}
+
+func GetMachineReadableReportFilePath(reportFilename string, format string) string {
+	return fmt.Sprintf("%s.%s", reportFilename, format)
+}
+
+func NewSectionReport(name string, startTime time.Time, endTime time.Time) SectionReport {
+	return SectionReport{
+		Name:            name,
+		StartTime:       startTime.Format(time.RFC3339),
+		EndTime:         endTime.Format(time.RFC3339),
+		DurationSeconds: endTime.Sub(startTime).Seconds(),
+	}
+}
+
+func (report *Report) NewMachineReadableBackupReport(timestamp string, endtime time.Time, objectCounts map[string]int, errMsg string) *MachineReadableBackupReport {
+	startTime, _ := time.ParseInLocation("20060102150405", timestamp, operating.System.Local)
+	status := history.BackupStatusSucceed
+	if errMsg != "" {
+		status = history.BackupStatusFailed
+	}
+	var bytesWritten int64
+	for _, table := range report.Tables {
+		bytesWritten += table.BytesWritten
+	}
+	return &MachineReadableBackupReport{
+		Timestamp:       timestamp,
+		GpdbVersion:     report.DatabaseVersion,
+		GpbackupVersion: report.BackupVersion,
+		DatabaseName:    report.DatabaseName,
+		CommandLine:     strings.Join(os.Args, " "),
+		StartTime:       startTime.Format(time.RFC3339),
+		EndTime:         endtime.Format(time.RFC3339),
+		DurationSeconds: endtime.Sub(startTime).Seconds(),
+		Status:          status,
+		Error:           errMsg,
+		DatabaseSize:    strings.ToUpper(report.DatabaseSize),
+		BytesWritten:    bytesWritten,
+		BackupConfig:    report.BackupConfig,
+		ObjectCounts:    objectCounts,
+		Sections:        report.Sections,
+		Tables:          report.Tables,
+	}
+}
+
+func NewMachineReadableRestoreReport(backupTimestamp string, startTimestamp string, connectionPool *dbconn.DBConn, restoreVersion string, errMsg string) *MachineReadableRestoreReport {
+	startTime, _ := time.ParseInLocation("20060102150405", startTimestamp, operating.System.Local)
+	endTime := operating.System.Now()
+	status := "Success"
+	if gplog.GetErrorCode() == 1 {
+		status = "Success with errors"
+	} else if errMsg != "" {
+		status = "Failure"
+	}
+	return &MachineReadableRestoreReport{
+		Timestamp:           backupTimestamp,
+		GpdbVersion:         connectionPool.Version.VersionString,
+		GprestoreVersion:    restoreVersion,
+		DatabaseName:        connectionPool.DBName,
+		CommandLine:         strings.Join(os.Args, " "),
+		StartTime:           startTime.Format(time.RFC3339),
+		EndTime:             endTime.Format(time.RFC3339),
+		DurationSeconds:     endTime.Sub(startTime).Seconds(),
+		Status:              status,
+		Error:               errMsg,
+		ErrorTablesMetadata: []string{},
+		ErrorTablesData:     []string{},
+		Sections:            []SectionReport{},
+		Tables:              []TableReport{},
+	}
+}
+
+/*
+ * Like the text reports, errors writing the file are only logged so that
+ * they do not mask the outcome of the backup or restore.
+ */
+func WriteMachineReadableReportFile(reportFilename string, format string, contents interface{}) {
+	var reportBytes []byte
+	var err error
+	if format == ReportFormatYAML {
+		reportBytes, err = yaml.Marshal(contents)
+	} else {
+		reportBytes, err = json.MarshalIndent(contents, "", "  ")
+		reportBytes = append(reportBytes, '\n')
+	}
+	if err == nil {
+		err = utils.WriteToFileAndMakeReadOnly(reportFilename, reportBytes)
+	}
+	if err != nil {
+		gplog.Error("Unable to write report file %s: %v", reportFilename, err)
+	}
+}
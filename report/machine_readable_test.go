@@ -0,0 +1,103 @@
+package report_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/report"
+	"gopkg.in/yaml.v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("report/machine_readable tests", func() {
+	timestamp := "20170101010101"
+	endtime := time.Date(2017, 1, 1, 1, 2, 1, 0, time.Local)
+	backupReport := &report.Report{
+		DatabaseSize: "42 MB",
+		Tables: []report.TableReport{
+			{Schema: "public", Name: "foo", Oid: 1, Rows: 10, BytesWritten: 100},
+			{Schema: "public", Name: "bar", Oid: 2, Rows: 20, BytesWritten: 200},
+		},
+		Sections: []report.SectionReport{
+			report.NewSectionReport("data", time.Date(2017, 1, 1, 1, 1, 1, 0, time.Local), endtime),
+		},
+		BackupConfig: history.BackupConfig{DatabaseName: "testdb", BackupVersion: "0.1.0", Timestamp: timestamp},
+	}
+	objectCounts := map[string]int{"Tables": 2}
+
+	Describe("ValidateReportFormat", func() {
+		It("accepts json and yaml", func() {
+			Expect(report.ValidateReportFormat("json")).To(Succeed())
+			Expect(report.ValidateReportFormat("yaml")).To(Succeed())
+		})
+		It("rejects any other format", func() {
+			Expect(report.ValidateReportFormat("xml")).To(MatchError("Invalid report format xml.  Valid formats are json and yaml."))
+		})
+	})
+	Describe("NewMachineReadableBackupReport", func() {
+		It("includes the tables, sections, and object counts of a successful backup", func() {
+			machineReport := backupReport.NewMachineReadableBackupReport(timestamp, endtime, objectCounts, "")
+
+			Expect(machineReport.Status).To(Equal(history.BackupStatusSucceed))
+			Expect(machineReport.DurationSeconds).To(Equal(float64(60)))
+			Expect(machineReport.BytesWritten).To(Equal(int64(300)))
+			Expect(machineReport.DatabaseSize).To(Equal("42 MB"))
+			Expect(machineReport.ObjectCounts).To(Equal(objectCounts))
+			Expect(machineReport.Tables).To(Equal(backupReport.Tables))
+			Expect(machineReport.Sections[0].Name).To(Equal("data"))
+			Expect(machineReport.Sections[0].DurationSeconds).To(Equal(float64(60)))
+		})
+		It("includes the error of a failed backup", func() {
+			machineReport := backupReport.NewMachineReadableBackupReport(timestamp, endtime, objectCounts, "Cannot access /tmp/backups: Permission denied")
+
+			Expect(machineReport.Status).To(Equal(history.BackupStatusFailed))
+			Expect(machineReport.Error).To(Equal("Cannot access /tmp/backups: Permission denied"))
+		})
+	})
+	Describe("WriteMachineReadableReportFile", func() {
+		var tempDir string
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "machine_readable_report")
+			Expect(err).ToNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(tempDir)
+		})
+		It("writes the report as JSON", func() {
+			reportFilename := filepath.Join(tempDir, "gpbackup_20170101010101_report.json")
+			machineReport := backupReport.NewMachineReadableBackupReport(timestamp, endtime, objectCounts, "")
+
+			report.WriteMachineReadableReportFile(reportFilename, report.ReportFormatJSON, machineReport)
+
+			contents, err := ioutil.ReadFile(reportFilename)
+			Expect(err).ToNot(HaveOccurred())
+			var readReport report.MachineReadableBackupReport
+			Expect(json.Unmarshal(contents, &readReport)).To(Succeed())
+			Expect(readReport).To(Equal(*machineReport))
+			Expect(string(contents)).ToNot(ContainSubstring(`"Error"`))
+		})
+		It("writes the report as YAML", func() {
+			reportFilename := filepath.Join(tempDir, "gpbackup_20170101010101_report.yaml")
+			machineReport := backupReport.NewMachineReadableBackupReport(timestamp, endtime, objectCounts, "")
+
+			report.WriteMachineReadableReportFile(reportFilename, report.ReportFormatYAML, machineReport)
+
+			contents, err := ioutil.ReadFile(reportFilename)
+			Expect(err).ToNot(HaveOccurred())
+			var readReport report.MachineReadableBackupReport
+			Expect(yaml.Unmarshal(contents, &readReport)).To(Succeed())
+			Expect(readReport.Status).To(Equal(history.BackupStatusSucceed))
+			Expect(readReport.BackupConfig.DatabaseName).To(Equal("testdb"))
+			Expect(readReport.ObjectCounts).To(Equal(objectCounts))
+			Expect(readReport.Sections).To(Equal(machineReport.Sections))
+			Expect(readReport.Tables).To(Equal(machineReport.Tables))
+		})
+	})
+})
@@ -0,0 +1,262 @@
+package report
+
+/*
+ * This file contains structs and functions related to sending notifications
+ * when a backup or restore finishes.  Notifiers are configured per utility in
+ * a YAML file, in the same way as the contacts in gp_email_contacts.yaml:
+ *
+ * notifiers:
+ *   gpbackup:
+ *   - type: webhook
+ *     url: https://chat.example.com/hooks/backups
+ *     headers:
+ *       Authorization: Bearer <token>
+ *     status:
+ *       failure: true
+ *   - type: command
+ *     command: /usr/local/bin/on_backup_finished.sh
+ *   - type: email
+ *     address: dba@example.com
+ *     status:
+ *       success: true
+ *       success_with_errors: true
+ *       failure: true
+ *
+ * A notifier with no status map is sent every notification.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	NOTIFIER_COMMAND = "command"
+	NOTIFIER_EMAIL   = "email"
+	NOTIFIER_WEBHOOK = "webhook"
+
+	defaultNotifierTimeout = 30
+)
+
+type NotificationFile struct {
+	Notifiers map[string][]NotifierConfig
+}
+
+type NotifierConfig struct {
+	Type    string
+	URL     string
+	Headers map[string]string
+	Command string
+	Address string
+	Timeout int
+	Status  map[string]bool
+}
+
+/*
+ * The Report of a notification is the machine-readable report of the backup
+ * or restore, which is sent as JSON to webhooks and to hook commands.
+ */
+type Notification struct {
+	Utility        string
+	Timestamp      string
+	Status         string
+	ReportFilePath string
+	Report         interface{}
+}
+
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string
+	Timeout time.Duration
+}
+
+type CommandNotifier struct {
+	Command string
+	Timeout time.Duration
+}
+
+type EmailNotifier struct {
+	Address string
+	Cluster *cluster.Cluster
+}
+
+func (notifier WebhookNotifier) Notify(notification Notification) error {
+	body, err := json.Marshal(notification.Report)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", notifier.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range notifier.Headers {
+		request.Header.Set(key, value)
+	}
+	client := &http.Client{Timeout: notifier.Timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		responseBody, _ := ioutil.ReadAll(response.Body)
+		return errors.Errorf("Webhook %s returned %s: %s", notifier.URL, response.Status, bytes.TrimSpace(responseBody))
+	}
+	return nil
+}
+
+/*
+ * The command is run with bash on the master host, with the JSON report on its
+ * standard input and the details of the notification in its environment.
+ */
+func (notifier CommandNotifier) Notify(notification Notification) error {
+	body, err := json.Marshal(notification.Report)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("bash", "-c", notifier.Command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("GPBACKUP_UTILITY=%s", notification.Utility),
+		fmt.Sprintf("GPBACKUP_TIMESTAMP=%s", notification.Timestamp),
+		fmt.Sprintf("GPBACKUP_STATUS=%s", notification.Status),
+		fmt.Sprintf("GPBACKUP_REPORT_FILE=%s", notification.ReportFilePath),
+	)
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(notifier.Timeout):
+		_ = cmd.Process.Kill()
+		err = errors.Errorf("Command timed out after %v", notifier.Timeout)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Command %s failed", notifier.Command)
+	}
+	return nil
+}
+
+func (notifier EmailNotifier) Notify(notification Notification) error {
+	message := ConstructEmailMessage(notification.Timestamp, notifier.Address, notification.ReportFilePath, notification.Utility)
+	return sendEmailMessage(notifier.Cluster, message)
+}
+
+func ReadNotificationFile(filename string) (*NotificationFile, error) {
+	contents, err := operating.System.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	notificationFile := &NotificationFile{}
+	err = yaml.UnmarshalStrict(contents, notificationFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse notification file %s", filename)
+	}
+	for utility, configs := range notificationFile.Notifiers {
+		for _, config := range configs {
+			_, err = config.NewNotifier(nil)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid %s notifier in %s", utility, filename)
+			}
+		}
+	}
+	return notificationFile, nil
+}
+
+func (config NotifierConfig) NewNotifier(c *cluster.Cluster) (Notifier, error) {
+	timeout := time.Duration(config.Timeout) * time.Second
+	if config.Timeout <= 0 {
+		timeout = defaultNotifierTimeout * time.Second
+	}
+	switch config.Type {
+	case NOTIFIER_WEBHOOK:
+		if config.URL == "" {
+			return nil, errors.New("A webhook notifier requires a url")
+		}
+		return WebhookNotifier{URL: config.URL, Headers: config.Headers, Timeout: timeout}, nil
+	case NOTIFIER_COMMAND:
+		if config.Command == "" {
+			return nil, errors.New("A command notifier requires a command")
+		}
+		return CommandNotifier{Command: config.Command, Timeout: timeout}, nil
+	case NOTIFIER_EMAIL:
+		if config.Address == "" {
+			return nil, errors.New("An email notifier requires an address")
+		}
+		return EmailNotifier{Address: config.Address, Cluster: c}, nil
+	default:
+		return nil, errors.Errorf("Unknown notifier type %q.  Valid types are %s, %s, and %s.", config.Type, NOTIFIER_WEBHOOK, NOTIFIER_COMMAND, NOTIFIER_EMAIL)
+	}
+}
+
+func (config NotifierConfig) MatchesStatus(status string) bool {
+	return len(config.Status) == 0 || config.Status[status]
+}
+
+/*
+ * Returns the notification file given with --notification-config, or else
+ * gp_notifications.yaml in $HOME or $GPHOME/bin if either exists.
+ */
+func FindNotificationFile(userSpecifiedFile string) string {
+	if userSpecifiedFile != "" {
+		return userSpecifiedFile
+	}
+	notificationFilename := "gp_notifications.yaml"
+	homeFile := fmt.Sprintf("%s/%s", operating.System.Getenv("HOME"), notificationFilename)
+	gphomeFile := fmt.Sprintf("%s/bin/%s", operating.System.Getenv("GPHOME"), notificationFilename)
+	if utils.FileExists(homeFile) {
+		return homeFile
+	} else if utils.FileExists(gphomeFile) {
+		return gphomeFile
+	}
+	return ""
+}
+
+/*
+ * Sends the notification to every notifier for the utility whose statuses
+ * match.  Failures are only logged, so that a notifier that is unavailable
+ * does not change the outcome of the backup or restore.
+ */
+func SendNotifications(c *cluster.Cluster, notificationFilename string, notification Notification) {
+	if notificationFilename == "" {
+		return
+	}
+	notificationFile, err := ReadNotificationFile(notificationFilename)
+	if err != nil {
+		gplog.Warn("Unable to send notifications: %v", err)
+		return
+	}
+	for _, config := range notificationFile.Notifiers[notification.Utility] {
+		if !config.MatchesStatus(notification.Status) {
+			continue
+		}
+		notifier, _ := config.NewNotifier(c)
+		gplog.Verbose("Sending %s notification for %s %s", config.Type, notification.Utility, notification.Timestamp)
+		err = notifier.Notify(notification)
+		if err != nil {
+			gplog.Warn("Unable to send %s notification: %v", config.Type, err)
+		}
+	}
+}
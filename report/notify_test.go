@@ -0,0 +1,155 @@
+package report_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("report/notify tests", func() {
+	var (
+		tempDir          string
+		notificationFile string
+		server           *httptest.Server
+		requests         []map[string]interface{}
+		responseCode     int
+		notification     report.Notification
+	)
+	writeNotificationFile := func(contents string) {
+		err := ioutil.WriteFile(notificationFile, []byte(contents), 0644)
+		Expect(err).ToNot(HaveOccurred())
+	}
+	BeforeEach(func() {
+		// Other report tests replace operating.System functions such as ReadFile
+		operating.System = operating.InitializeSystemFunctions()
+		var err error
+		tempDir, err = ioutil.TempDir("", "notify")
+		Expect(err).ToNot(HaveOccurred())
+		notificationFile = filepath.Join(tempDir, "gp_notifications.yaml")
+		requests = make([]map[string]interface{}, 0)
+		responseCode = http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			defer GinkgoRecover()
+			Expect(request.Method).To(Equal("POST"))
+			Expect(request.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(request.Header.Get("Authorization")).To(Equal("Bearer token"))
+			body := make(map[string]interface{})
+			Expect(json.NewDecoder(request.Body).Decode(&body)).To(Succeed())
+			requests = append(requests, body)
+			writer.WriteHeader(responseCode)
+		}))
+		notification = report.Notification{
+			Utility:        "gpbackup",
+			Timestamp:      "20170101010101",
+			Status:         "failure",
+			ReportFilePath: "/tmp/gpbackup_20170101010101_report",
+			Report:         report.MachineReadableBackupReport{Timestamp: "20170101010101", Status: "Failure"},
+		}
+	})
+	AfterEach(func() {
+		server.Close()
+		_ = os.RemoveAll(tempDir)
+	})
+	Describe("SendNotifications", func() {
+		It("posts the report to webhooks whose statuses match", func() {
+			writeNotificationFile(fmt.Sprintf(`notifiers:
+  gpbackup:
+  - type: webhook
+    url: %[1]s/failures
+    headers:
+      Authorization: Bearer token
+    status:
+      failure: true
+  - type: webhook
+    url: %[1]s/successes
+    headers:
+      Authorization: Bearer token
+    status:
+      success: true
+  gprestore:
+  - type: webhook
+    url: %[1]s/restores
+`, server.URL))
+
+			report.SendNotifications(nil, notificationFile, notification)
+
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0]["Timestamp"]).To(Equal("20170101010101"))
+			Expect(requests[0]["Status"]).To(Equal("Failure"))
+		})
+		It("logs a warning if a webhook returns an error", func() {
+			responseCode = http.StatusInternalServerError
+			writeNotificationFile(fmt.Sprintf(`notifiers:
+  gpbackup:
+  - type: webhook
+    url: %s
+    headers:
+      Authorization: Bearer token
+`, server.URL))
+
+			report.SendNotifications(nil, notificationFile, notification)
+
+			Expect(requests).To(HaveLen(1))
+			Expect(logfile).To(Say("Unable to send webhook notification: Webhook .* returned 500 Internal Server Error"))
+		})
+		It("runs hook commands with the report on standard input", func() {
+			outputFile := filepath.Join(tempDir, "output")
+			writeNotificationFile(fmt.Sprintf(`notifiers:
+  gpbackup:
+  - type: command
+    command: echo "$GPBACKUP_UTILITY $GPBACKUP_TIMESTAMP $GPBACKUP_STATUS $GPBACKUP_REPORT_FILE" > %[1]s; cat >> %[1]s
+`, outputFile))
+
+			report.SendNotifications(nil, notificationFile, notification)
+
+			contents, err := ioutil.ReadFile(outputFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(HavePrefix("gpbackup 20170101010101 failure /tmp/gpbackup_20170101010101_report\n{"))
+			Expect(string(contents)).To(ContainSubstring(`"Status":"Failure"`))
+		})
+		It("logs a warning if a hook command fails", func() {
+			writeNotificationFile(`notifiers:
+  gpbackup:
+  - type: command
+    command: exit 3
+`)
+
+			report.SendNotifications(nil, notificationFile, notification)
+
+			Expect(logfile).To(Say("Unable to send command notification: Command exit 3 failed: exit status 3"))
+		})
+	})
+	Describe("ReadNotificationFile", func() {
+		It("returns an error for an unknown notifier type", func() {
+			writeNotificationFile(`notifiers:
+  gpbackup:
+  - type: pager
+`)
+
+			_, err := report.ReadNotificationFile(notificationFile)
+
+			Expect(err).To(MatchError(ContainSubstring(`Unknown notifier type "pager"`)))
+		})
+		It("returns an error for a webhook without a url", func() {
+			writeNotificationFile(`notifiers:
+  gprestore:
+  - type: webhook
+`)
+
+			_, err := report.ReadNotificationFile(notificationFile)
+
+			Expect(err).To(MatchError(ContainSubstring("A webhook notifier requires a url")))
+		})
+	})
+})
@@ -29,6 +29,8 @@ import (
 type Report struct {
 	BackupParamsString string
 	DatabaseSize       string
+	Tables             []TableReport
+	Sections           []SectionReport
 	history.BackupConfig
 }
 
@@ -99,6 +101,38 @@ data file format: %s
 %s`
 	report.BackupParamsString = fmt.Sprintf(backupParamsTemplate, compressStr, pluginStr, sectionStr, filterStr,
 		statsStr, filesStr, report.constructIncrementalSection())
+	if report.ResumedFrom != "" {
+		report.BackupParamsString += fmt.Sprintf("\nresumed from: %s", report.ResumedFrom)
+	}
+	if len(report.RowFilters) > 0 {
+		report.BackupParamsString += fmt.Sprintf("\nrow filtered tables: %d", len(report.RowFilters))
+	}
+	maskedColumns := make([]string, 0, len(report.MaskingRules))
+	for column := range report.MaskingRules {
+		maskedColumns = append(maskedColumns, column)
+	}
+	sort.Strings(maskedColumns)
+	for _, column := range maskedColumns {
+		report.BackupParamsString += fmt.Sprintf("\nmasked column: %s (%s)", column, getMaskingRuleType(report.MaskingRules[column]))
+	}
+}
+
+/*
+ * The report lists the type of each masking rule rather than the rule itself,
+ * as expressions may span lines or contain colons.  The config file has the
+ * rules themselves.
+ */
+func getMaskingRuleType(rule string) string {
+	switch {
+	case rule == "hash" || rule == "null":
+		return rule
+	case strings.HasPrefix(rule, "fixed:"):
+		return "fixed"
+	case strings.HasPrefix(rule, "partial:"):
+		return "partial"
+	default:
+		return "expression"
+	}
 }
 
 func (report *Report) constructIncrementalSection() string {
@@ -318,6 +352,21 @@ type EmailContact struct {
 	Status  map[string]bool
 }
 
+/*
+ * Returns the status of the utility, as used to choose which contacts and
+ * notifiers to send the report to.
+ */
+func GetExitStatus() string {
+	switch gplog.GetErrorCode() {
+	case 1:
+		return "success_with_errors"
+	case 2:
+		return "failure"
+	default:
+		return "success"
+	}
+}
+
 func GetContacts(filename string, utility string) string {
 	contactFile := &ContactFile{}
 	contents, err := operating.System.ReadFile(filename)
@@ -329,14 +378,7 @@ func GetContacts(filename string, utility string) string {
 		return ""
 	}
 
-	errorCode := gplog.GetErrorCode()
-	exitStatus := "success"
-	if errorCode == 1 {
-		exitStatus = "success_with_errors"
-	} else if errorCode == 2 {
-		exitStatus = "failure"
-	}
-
+	exitStatus := GetExitStatus()
 	contactList := make([]string, 0)
 	for _, contact := range contactFile.Contacts[utility] {
 		if contact.Status[exitStatus] {
@@ -387,10 +429,18 @@ func EmailReport(c *cluster.Cluster, timestamp string, reportFilePath string, ut
 	}
 	message := ConstructEmailMessage(timestamp, contactList, reportFilePath, utility)
 	gplog.Verbose("Sending email report to the following addresses: %s", contactList)
-	output, sendErr := c.ExecuteLocalCommand(fmt.Sprintf(`echo "%s" | sendmail -t`, message))
+	sendErr := sendEmailMessage(c, message)
 	if sendErr != nil {
-		gplog.Warn("Unable to send email report: %s", output)
+		gplog.Warn("Unable to send email report: %v", sendErr)
+	}
+}
+
+func sendEmailMessage(c *cluster.Cluster, message string) error {
+	output, err := c.ExecuteLocalCommand(fmt.Sprintf(`echo "%s" | sendmail -t`, message))
+	if err != nil {
+		return errors.New(output)
 	}
+	return nil
 }
 
 func AppendBackupParams(infoArr *[]LineInfo, paramsStr string) {
@@ -295,10 +295,10 @@ restore status:      Success but non-fatal errors occurred. See log file .+ for
 	})
 	Describe("SetBackupParamFromFlags", func() {
 		AfterEach(func() {
-			utils.InitializePipeThroughParameters(false, 0)
+			utils.InitializePipeThroughParameters(false, "", 0)
 		})
 		It("configures the Report struct correctly", func() {
-			utils.InitializePipeThroughParameters(true, 0)
+			utils.InitializePipeThroughParameters(true, "gzip", 0)
 			backupCmdFlags := pflag.NewFlagSet("gpbackup", pflag.ExitOnError)
 			backup.SetCmdFlags(backupCmdFlags)
 			err := backupCmdFlags.Set(options.INCLUDE_RELATION, "public.foobar")
@@ -315,18 +315,44 @@ restore status:      Success but non-fatal errors occurred. See log file .+ for
 			structmatcher.ExpectStructsToMatch(history.BackupConfig{
 				BackupVersion:        "0.1.0",
 				Compressed:           true,
+				CompressionType:      "gzip",
 				DatabaseName:         "testdb",
 				DatabaseVersion:      "5.0.0 build test",
 				IncludeSchemas:       []string{},
 				IncludeRelations:     []string{"public.foobar"},
 				ExcludeSchemas:       []string{},
 				ExcludeRelations:     []string{},
+				ExcludeObjectTypes:   []string{},
 				Plugin:               "/tmp/plugin.sh",
 				Timestamp:            "timestamp1",
 				IncludeTableFiltered: true,
 				Status:               history.BackupStatusFailed,
 			}, backupConfig)
 		})
+		It("records the compression type in the backup config", func() {
+			backupCmdFlags := pflag.NewFlagSet("gpbackup", pflag.ExitOnError)
+			backup.SetCmdFlags(backupCmdFlags)
+			err := backupCmdFlags.Set(options.COMPRESSION_TYPE, "zstd")
+			Expect(err).ToNot(HaveOccurred())
+			opts, err := options.NewOptions(backupCmdFlags)
+			Expect(err).ToNot(HaveOccurred())
+
+			backupConfig := backup.NewBackupConfig("testdb", "5.0.0 build test", "0.1.0", "", "timestamp1", *opts)
+			Expect(backupConfig.Compressed).To(BeTrue())
+			Expect(backupConfig.CompressionType).To(Equal("zstd"))
+		})
+		It("does not record a compression type for an uncompressed backup", func() {
+			backupCmdFlags := pflag.NewFlagSet("gpbackup", pflag.ExitOnError)
+			backup.SetCmdFlags(backupCmdFlags)
+			err := backupCmdFlags.Set(options.NO_COMPRESSION, "true")
+			Expect(err).ToNot(HaveOccurred())
+			opts, err := options.NewOptions(backupCmdFlags)
+			Expect(err).ToNot(HaveOccurred())
+
+			backupConfig := backup.NewBackupConfig("testdb", "5.0.0 build test", "0.1.0", "", "timestamp1", *opts)
+			Expect(backupConfig.Compressed).To(BeFalse())
+			Expect(backupConfig.CompressionType).To(Equal(""))
+		})
 	})
 	Describe("GetDurationInfo", func() {
 		timestamp := "20170101010101"
@@ -13,6 +13,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/jackc/pgx"
@@ -37,10 +38,14 @@ func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableAttribute
 		readFromDestinationCommand = fmt.Sprintf("%s restore_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
 	}
 
-	copyCommand = fmt.Sprintf("PROGRAM '%s %s | %s'", readFromDestinationCommand, destinationToRead, customPipeThroughCommand)
+	pipeline := fmt.Sprintf("%s %s | %s", readFromDestinationCommand, destinationToRead, customPipeThroughCommand)
+	copyCommand = fmt.Sprintf("PROGRAM '%s'", utils.EscapeSingleQuotes(utils.WrapWithPipefail(pipeline)))
 
 	query := fmt.Sprintf("COPY %s%s FROM %s WITH CSV DELIMITER '%s' ON SEGMENT;", tableName, tableAttributes, copyCommand, tableDelim)
 	gplog.Verbose(query)
+	if restoreSQLFile.WriteStatement(query) {
+		return 0, nil
+	}
 	result, err := connectionPool.Exec(query, whichConn)
 	if err != nil {
 		errStr := fmt.Sprintf("Error loading data into table %s", tableName)
@@ -56,7 +61,7 @@ func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableAttribute
 	return numRows, err
 }
 
-func restoreSingleTableData(fpInfo *filepath.FilePathInfo, entry toc.MasterDataEntry, tableName string, whichConn int) error {
+func restoreSingleTableData(fpInfo *filepath.FilePathInfo, entry toc.MasterDataEntry, tableName string, whichConn int) (int64, error) {
 	destinationToRead := ""
 	if backupConfig.SingleDataFile {
 		destinationToRead = fmt.Sprintf("%s_%d", fpInfo.GetSegmentPipePathForCopyCommand(), entry.Oid)
@@ -65,14 +70,53 @@ func restoreSingleTableData(fpInfo *filepath.FilePathInfo, entry toc.MasterDataE
 	}
 	numRowsRestored, err := CopyTableIn(connectionPool, tableName, entry.AttributeString, destinationToRead, backupConfig.SingleDataFile, whichConn)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if restoreSQLFile != nil {
+		// The rows are only restored when the SQL file is run
+		return 0, nil
 	}
 	numRowsBackedUp := entry.RowsCopied
 	err = CheckRowsRestored(numRowsRestored, numRowsBackedUp, tableName)
 	if err != nil {
-		return err
+		return numRowsRestored, err
+	}
+	return numRowsRestored, nil
+}
+
+/*
+ * Records the rows restored to a table, or the error restoring it, for the
+ * machine-readable restore report.
+ */
+func recordRestoredTable(entry toc.MasterDataEntry, rowsRestored int64, err error) {
+	tableReport := report.TableReport{Schema: entry.Schema, Name: entry.Name, Oid: entry.Oid, Rows: rowsRestored, RowFilter: entry.RowFilter}
+	tableReport.Schema, tableReport.Name = redirectMap.RedirectRelation(entry.Schema, entry.Name)
+	if err != nil {
+		tableReport.Error = err.Error()
+	}
+	restoredTablesLock.Lock()
+	restoredTables = append(restoredTables, tableReport)
+	restoredTablesLock.Unlock()
+}
+
+func getDataRestoreTableName(entry toc.MasterDataEntry) string {
+	return utils.MakeFQN(redirectMap.RedirectRelation(entry.Schema, entry.Name))
+}
+
+/*
+ * The bytes read on each segment are counted from the sizes of each table's
+ * data recorded in the TOC of its backup, as its data is restored.  Backups
+ * taken before these sizes were recorded do not update the count.
+ */
+func recordSegmentBytesRead(timestamp string, oid uint32) {
+	segmentBytesLock.Lock()
+	defer segmentBytesLock.Unlock()
+	for contentID, tableSizes := range backupDataSizes[timestamp] {
+		if size, ok := tableSizes[oid]; ok {
+			segmentBytesRead[contentID] += size
+			metricsRegistry.SetSegmentBytesRead(contentID, segmentBytesRead[contentID])
+		}
 	}
-	return nil
 }
 
 func CheckRowsRestored(rowsRestored int64, rowsBackedUp int64, tableName string) error {
@@ -99,8 +143,11 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Ma
 			filteredOids[i] = fmt.Sprintf("%d", entry.Oid)
 		}
 		utils.WriteOidListToSegments(filteredOids, globalCluster, fpInfo)
-		firstOid := fmt.Sprintf("%d", dataEntries[0].Oid)
-		utils.CreateFirstSegmentPipeOnAllHosts(firstOid, globalCluster, fpInfo)
+		jobs := connectionPool.NumConns
+		if jobs > totalTables {
+			jobs = totalTables
+		}
+		utils.CreateFirstSegmentPipesOnAllHosts(filteredOids[:jobs], globalCluster, fpInfo)
 		if wasTerminated {
 			return
 		}
@@ -108,7 +155,7 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Ma
 		if len(opts.IncludedRelations) > 0 || len(opts.ExcludedRelations) > 0 || len(opts.IncludedSchemas) > 0 || len(opts.ExcludedSchemas) > 0 {
 			isFilter = true
 		}
-		utils.StartGpbackupHelpers(globalCluster, fpInfo, "--restore-agent", MustGetFlagString(options.PLUGIN_CONFIG), "", MustGetFlagBool(options.ON_ERROR_CONTINUE), isFilter)
+		utils.StartGpbackupHelpers(globalCluster, fpInfo, "--restore-agent", MustGetFlagString(options.PLUGIN_CONFIG), "", MustGetFlagBool(options.ON_ERROR_CONTINUE), isFilter, jobs)
 	}
 	/*
 	 * We break when an interrupt is received and rely on
@@ -141,17 +188,22 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Ma
 					dataProgressBar.(*pb.ProgressBar).NotPrint = true
 					return
 				}
-				tableName := utils.MakeFQN(entry.Schema, entry.Name)
-				if opts.RedirectSchema != "" {
-					tableName = utils.MakeFQN(opts.RedirectSchema, entry.Name)
-				}
+				tableName := getDataRestoreTableName(entry)
+				restoreState.MarkTableStarted(fpInfo.Timestamp, tableName)
 				// Truncate table before restore, if needed
 				var err error
 				if MustGetFlagBool(options.INCREMENTAL) || MustGetFlagBool(options.TRUNCATE_TABLE) {
 					err = TruncateTable(tableName)
 				}
 				if err == nil {
-					err = restoreSingleTableData(&fpInfo, entry, tableName, whichConn)
+					var rowsRestored int64
+					rowsRestored, err = restoreSingleTableData(&fpInfo, entry, tableName, whichConn)
+					recordRestoredTable(entry, rowsRestored, err)
+					if err == nil {
+						restoreState.MarkTableRestored(fpInfo.Timestamp, tableName)
+						metricsRegistry.TableCompleted(rowsRestored)
+						recordSegmentBytesRead(fpInfo.Timestamp, entry.Oid)
+					}
 
 					atomic.AddInt64(&tableNum, 1)
 					if gplog.GetVerbosity() > gplog.LOGINFO {
@@ -165,6 +217,7 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Ma
 				if err != nil {
 					gplog.Error(err.Error())
 					atomic.AddInt32(&numErrors, 1)
+					metricsRegistry.AddErrors(1)
 					if !MustGetFlagBool(options.ON_ERROR_CONTINUE) {
 						dataProgressBar.(*pb.ProgressBar).NotPrint = true
 						return
@@ -7,7 +7,9 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/metrics"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/spf13/pflag"
@@ -40,6 +42,19 @@ var (
 	errorTablesMetadata map[string]Empty
 	errorTablesData     map[string]Empty
 	opts                *options.Options
+	restoreState        *RestoreState
+	restoreSections     []report.SectionReport
+	restoredTables      []report.TableReport
+	restoredTablesLock  sync.Mutex
+	metricsRegistry     *metrics.Registry
+	backupDataSizes     map[string]map[int]map[uint32]int64
+	segmentBytesRead    map[int]int64
+	segmentBytesLock    sync.Mutex
+	useListTables       map[string]bool
+	restoreSQLFile      *SQLFile
+	redirectMap         *RedirectMap
+	roleMap             NameMap
+	tablespaceMap       NameMap
 	/*
 	 * Used for synchronizing DoCleanup.  In DoInit() we increment the group
 	 * and then wait for at least one DoCleanup to finish, either in DoTeardown
@@ -92,6 +107,10 @@ func SetTOC(toc *toc.TOC) {
 	globalTOC = toc
 }
 
+func SetSQLFile(sqlFile *SQLFile) {
+	restoreSQLFile = sqlFile
+}
+
 // Util functions to enable ease of access to global flag values
 
 func MustGetFlagString(flagName string) string {
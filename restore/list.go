@@ -0,0 +1,76 @@
+package restore
+
+/*
+ * This file contains functions related to listing the entries of a backup set
+ * with --list and restoring only the entries of a list with --use-list.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+)
+
+func DoList() {
+	header := []string{
+		fmt.Sprintf("Backup timestamp: %s", globalFPInfo.Timestamp),
+		fmt.Sprintf("Database: %s", backupConfig.DatabaseName),
+		fmt.Sprintf("Backup version: %s", backupConfig.BackupVersion),
+	}
+	err := toc.WriteList(os.Stdout, header, globalTOC.GetListEntries(getListDataEntries()))
+	gplog.FatalOnError(err)
+}
+
+/*
+ * The data entries of a backup set are those of each backup in its restore
+ * plan for the tables whose data is restored from that backup.
+ */
+func getListDataEntries() []toc.MasterDataEntry {
+	dataEntries := make([]toc.MasterDataEntry, 0)
+	if backupConfig.MetadataOnly {
+		return dataEntries
+	}
+	for _, entry := range backupConfig.RestorePlan {
+		tocfile := globalTOC
+		if entry.Timestamp != globalFPInfo.Timestamp {
+			fpInfo := GetBackupFPInfoForTimestamp(entry.Timestamp)
+			tocfile = toc.NewTOC(fpInfo.GetTOCFilePath())
+		}
+		dataEntries = append(dataEntries, tocfile.GetDataEntriesMatching(nil, nil, nil, nil, entry.TableFQNs)...)
+	}
+	return dataEntries
+}
+
+func applyUseList(listFilename string) {
+	contents, err := operating.System.ReadFile(listFilename)
+	gplog.FatalOnError(err)
+	ids, err := toc.ReadList(bytes.NewReader(contents))
+	gplog.FatalOnError(err, fmt.Sprintf("Unable to read list file %s", listFilename))
+	tableFQNs, err := globalTOC.ApplyList(ids, getListDataEntries())
+	gplog.FatalOnError(err, fmt.Sprintf("Unable to use list file %s", listFilename))
+	// A list with no data entries restores no table data, so the map is never nil here
+	useListTables = make(map[string]bool, len(tableFQNs))
+	for _, tableFQN := range tableFQNs {
+		useListTables[tableFQN] = true
+	}
+	gplog.Verbose("Restoring %d entries listed in %s", len(ids), listFilename)
+}
+
+/*
+ * Table data is restored in the order of the backup rather than that of the
+ * list, as the data of a single-data-file backup can only be read in order.
+ */
+func filterUseListDataEntries(dataEntries []toc.MasterDataEntry) []toc.MasterDataEntry {
+	filteredEntries := make([]toc.MasterDataEntry, 0, len(dataEntries))
+	for _, entry := range dataEntries {
+		if useListTables[utils.MakeFQN(entry.Schema, entry.Name)] {
+			filteredEntries = append(filteredEntries, entry)
+		}
+	}
+	return filteredEntries
+}
@@ -25,6 +25,10 @@ func executeStatementsForConn(statements chan toc.StatementWithType, fatalErr *e
 		if wasTerminated || *fatalErr != nil {
 			return
 		}
+		if restoreSQLFile.WriteStatement(statement.Statement) {
+			progressBar.Increment()
+			continue
+		}
 		_, err := connectionPool.Exec(statement.Statement, whichConn)
 		if err != nil {
 			gplog.Verbose("Error encountered when executing statement: %s Error was: %s", strings.TrimSpace(statement.Statement), err.Error())
@@ -41,6 +45,8 @@ func executeStatementsForConn(statements chan toc.StatementWithType, fatalErr *e
 			} else {
 				*fatalErr = err
 			}
+		} else {
+			restoreState.MarkStatementRestored(statement)
 		}
 		progressBar.Increment()
 	}
@@ -76,9 +82,11 @@ func ExecuteStatements(statements []toc.StatementWithType, progressBar utils.Pro
 	}
 	if fatalErr != nil {
 		fmt.Println("")
+		metricsRegistry.AddErrors(1)
 		gplog.Fatal(fatalErr, "")
 	} else if numErrors > 0 {
 		fmt.Println("")
+		metricsRegistry.AddErrors(int64(numErrors))
 		gplog.Error("Encountered %d errors during metadata restore; see log file %s for a list of failed statements.", numErrors, gplog.GetLogFilePath())
 	}
 }
@@ -0,0 +1,522 @@
+package restore
+
+/*
+ * This file contains structs and functions related to restoring objects to
+ * other schemas or under other names than they were backed up with, using
+ * --redirect-schema or --redirect-mapping-file.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Old names are unquoted, as they are compared with the names in statements
+ * after those are unquoted, and new names are quoted, as they are written to
+ * statements as they are.
+ */
+type RedirectMap struct {
+	schemas   map[string]string
+	relations map[options.FqnStruct]options.FqnStruct
+}
+
+func NewRedirectMap() *RedirectMap {
+	return &RedirectMap{
+		schemas:   make(map[string]string),
+		relations: make(map[options.FqnStruct]options.FqnStruct),
+	}
+}
+
+func (redirectMap *RedirectMap) AddSchema(oldSchema string, newQuotedSchema string) {
+	redirectMap.schemas[oldSchema] = newQuotedSchema
+}
+
+func (redirectMap *RedirectMap) AddRelation(oldSchema string, oldName string, newQuotedSchema string, newQuotedName string) {
+	redirectMap.relations[options.FqnStruct{SchemaName: oldSchema, TableName: oldName}] =
+		options.FqnStruct{SchemaName: newQuotedSchema, TableName: newQuotedName}
+}
+
+/*
+ * Each line of a mapping file maps a schema to another schema, or a table to
+ * a table in the same or another schema, as in
+ *
+ *   sales -> sales_2019
+ *   public.orders -> archive.orders_2019
+ *
+ * Names are given unquoted, as in filter files.  Blank lines and lines
+ * starting with # are ignored.  quoteIdent is used to quote the new names.
+ */
+func ReadRedirectMapFile(filename string, quoteIdent func(string) string) (*RedirectMap, error) {
+	mappings, err := readMappingFile(filename, "redirect mapping file")
+	if err != nil {
+		return nil, err
+	}
+	redirectMap := NewRedirectMap()
+	for _, mapping := range mappings {
+		oldName, newName := mapping.oldName, mapping.newName
+		if !strings.Contains(oldName, ".") && !strings.Contains(newName, ".") {
+			if _, exists := redirectMap.schemas[oldName]; exists {
+				return nil, errors.Errorf("Schema %s is mapped more than once in redirect mapping file %s", oldName, filename)
+			}
+			redirectMap.AddSchema(oldName, quoteIdent(newName))
+			continue
+		}
+		fqns, err := options.SeparateSchemaAndTable([]string{oldName, newName})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Line %d of redirect mapping file %s must map a schema to a schema or a table to a table", mapping.lineNumber, filename)
+		}
+		if _, exists := redirectMap.relations[fqns[0]]; exists {
+			return nil, errors.Errorf("Table %s is mapped more than once in redirect mapping file %s", oldName, filename)
+		}
+		redirectMap.AddRelation(fqns[0].SchemaName, fqns[0].TableName, quoteIdent(fqns[1].SchemaName), quoteIdent(fqns[1].TableName))
+	}
+	return redirectMap, nil
+}
+
+type nameMapping struct {
+	lineNumber int
+	oldName    string
+	newName    string
+}
+
+/*
+ * Reads the "old_name -> new_name" lines of a mapping file, skipping blank
+ * lines and lines starting with #.  fileDescription names the kind of file
+ * in error messages.
+ */
+func readMappingFile(filename string, fileDescription string) ([]nameMapping, error) {
+	lines, err := iohelper.ReadLinesFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	mappings := make([]nameMapping, 0)
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names := strings.Split(line, "->")
+		if len(names) != 2 || strings.TrimSpace(names[0]) == "" || strings.TrimSpace(names[1]) == "" {
+			return nil, errors.Errorf(`Line %d of %s %s is not in the format "old_name -> new_name": %s`, i+1, fileDescription, filename, line)
+		}
+		mappings = append(mappings, nameMapping{lineNumber: i + 1, oldName: strings.TrimSpace(names[0]), newName: strings.TrimSpace(names[1])})
+	}
+	return mappings, nil
+}
+
+/*
+ * --redirect-schema moves the restored relations, rather than their schemas,
+ * so that references to objects that are not restored are left as they are.
+ */
+func NewRedirectSchemaMap(redirectSchema string, quotedRelations []string) (*RedirectMap, error) {
+	fqns, err := options.SeparateSchemaAndTable(quotedRelations)
+	if err != nil {
+		return nil, err
+	}
+	redirectMap := NewRedirectMap()
+	for _, fqn := range fqns {
+		redirectMap.AddRelation(utils.UnquoteIdent(fqn.SchemaName), utils.UnquoteIdent(fqn.TableName), redirectSchema, fqn.TableName)
+	}
+	return redirectMap, nil
+}
+
+/*
+ * Takes and returns the quoted schema and name of a relation, as they are in
+ * the TOC.  Relations in a mapped schema keep their names.
+ */
+func (redirectMap *RedirectMap) RedirectRelation(schema string, name string) (string, string) {
+	if redirectMap == nil {
+		return schema, name
+	}
+	if fqn, ok := redirectMap.lookupRelation(schema, name); ok {
+		return fqn.SchemaName, fqn.TableName
+	}
+	return redirectMap.RedirectSchema(schema), name
+}
+
+func (redirectMap *RedirectMap) lookupRelation(schema string, name string) (options.FqnStruct, bool) {
+	fqn, ok := redirectMap.relations[options.FqnStruct{SchemaName: utils.UnquoteIdent(schema), TableName: utils.UnquoteIdent(name)}]
+	return fqn, ok
+}
+
+func (redirectMap *RedirectMap) RedirectSchema(schema string) string {
+	if redirectMap == nil {
+		return schema
+	}
+	if newSchema, ok := redirectMap.schemas[utils.UnquoteIdent(schema)]; ok {
+		return newSchema
+	}
+	return schema
+}
+
+var dollarQuoteTagRegex = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+/*
+ * Rewrites the mapped names in a statement.  The statement is tokenized, so
+ * that only qualified names are rewritten, along with schema names following
+ * the SCHEMA keyword and names in string literals cast to a reg* type, as in
+ * 'public.foo_seq'::regclass.  Comments, other string literals, and
+ * dollar-quoted function bodies are left as they are.
+ *
+ * The queries of views and rules refer to the columns of a table in their
+ * FROM clause by the table name, as in SELECT orders.id FROM public.orders,
+ * so a renamed table in a FROM item without an alias is given its old name
+ * as its alias, and those references still resolve.
+ */
+func (redirectMap *RedirectMap) RedirectStatement(statement string) string {
+	if redirectMap == nil {
+		return statement
+	}
+	var result strings.Builder
+	afterSchemaKeyword := false
+	inFromClause := false
+	expectFromItem := false
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		start := i
+		switch {
+		case isSpace(c):
+			result.WriteByte(c)
+			i++
+			continue
+		case strings.HasPrefix(statement[i:], "--"):
+			i = indexFrom(statement, i, "\n", 0)
+			result.WriteString(statement[start:i])
+		case strings.HasPrefix(statement[i:], "/*"):
+			i = indexFrom(statement, i+2, "*/", 2)
+			result.WriteString(statement[start:i])
+		case c == '\'':
+			i = scanStringLiteral(statement, i, false)
+			if strings.HasPrefix(statement[i:], "::reg") {
+				literal := strings.Replace(statement[start+1:i-1], "''", "'", -1)
+				result.WriteString(fmt.Sprintf("'%s'", utils.EscapeSingleQuotes(redirectMap.RedirectStatement(literal))))
+			} else {
+				result.WriteString(statement[start:i])
+			}
+		case (c == 'E' || c == 'e') && strings.HasPrefix(statement[i+1:], "'"):
+			i = scanStringLiteral(statement, i+1, true)
+			result.WriteString(statement[start:i])
+		case c == '$' && dollarQuoteTagRegex.MatchString(statement[i:]):
+			tag := dollarQuoteTagRegex.FindString(statement[i:])
+			i = indexFrom(statement, i+len(tag), tag, len(tag))
+			result.WriteString(statement[start:i])
+		case c == '"' || isIdentifierStart(c):
+			var names []string
+			names, i = scanQualifiedName(statement, i)
+			redirected, ok := redirectMap.redirectQualifiedName(names, afterSchemaKeyword)
+			if ok {
+				result.WriteString(redirected)
+				if expectFromItem && redirectMap.isRenamedRelation(names) && !hasAlias(statement, i) {
+					result.WriteString(" " + names[1])
+				}
+			} else {
+				result.WriteString(statement[start:i])
+			}
+			keyword := ""
+			if len(names) == 1 && names[0][0] != '"' {
+				keyword = strings.ToUpper(names[0])
+			}
+			afterSchemaKeyword = keyword == "SCHEMA"
+			switch {
+			case keyword == "FROM" || keyword == "JOIN":
+				inFromClause = true
+				expectFromItem = true
+			case keyword == "UPDATE":
+				expectFromItem = true
+			case keyword == "ONLY":
+			case fromClauseEndKeywords[keyword]:
+				inFromClause = false
+				expectFromItem = false
+			default:
+				expectFromItem = false
+			}
+			continue
+		case c == ',':
+			result.WriteByte(c)
+			i++
+			afterSchemaKeyword = false
+			expectFromItem = inFromClause
+			continue
+		case c == '(':
+			// Joined tables may be parenthesized, as in FROM (public.a JOIN public.b ON ...)
+			result.WriteByte(c)
+			i++
+			afterSchemaKeyword = false
+			continue
+		case isDigit(c):
+			for i < len(statement) && isIdentifierChar(statement[i]) {
+				i++
+			}
+			result.WriteString(statement[start:i])
+		default:
+			result.WriteByte(c)
+			i++
+		}
+		afterSchemaKeyword = false
+		expectFromItem = false
+	}
+	return result.String()
+}
+
+// Keywords that end a FROM clause, after which commas no longer separate FROM items
+var fromClauseEndKeywords = map[string]bool{
+	"WHERE": true, "GROUP": true, "HAVING": true, "ORDER": true, "LIMIT": true, "OFFSET": true, "WINDOW": true,
+	"UNION": true, "INTERSECT": true, "EXCEPT": true, "SELECT": true, "SET": true, "VALUES": true, "RETURNING": true,
+}
+
+// Keywords that may follow a FROM item that has no alias
+var fromItemFollowingKeywords = map[string]bool{
+	"WHERE": true, "JOIN": true, "LEFT": true, "RIGHT": true, "FULL": true, "INNER": true, "CROSS": true, "NATURAL": true,
+	"ON": true, "USING": true, "GROUP": true, "HAVING": true, "ORDER": true, "LIMIT": true, "OFFSET": true, "WINDOW": true,
+	"UNION": true, "INTERSECT": true, "EXCEPT": true, "FOR": true, "FETCH": true, "SET": true, "RETURNING": true, "WITH": true,
+}
+
+// Returns whether the FROM item ending at i is followed by an alias
+func hasAlias(statement string, i int) bool {
+	for i < len(statement) && isSpace(statement[i]) {
+		i++
+	}
+	if i == len(statement) {
+		return false
+	}
+	if statement[i] == '"' {
+		return true
+	}
+	start := i
+	for i < len(statement) && isIdentifierChar(statement[i]) {
+		i++
+	}
+	return i > start && !fromItemFollowingKeywords[strings.ToUpper(statement[start:i])]
+}
+
+// Returns whether the names are of the form schema.table and the table is mapped to another name
+func (redirectMap *RedirectMap) isRenamedRelation(names []string) bool {
+	if len(names) != 2 {
+		return false
+	}
+	fqn, ok := redirectMap.relations[options.FqnStruct{SchemaName: unquoteName(names[0]), TableName: unquoteName(names[1])}]
+	return ok && utils.UnquoteIdent(fqn.TableName) != unquoteName(names[1])
+}
+
+/*
+ * Names of the form schema.table, or schema.table.column, are redirected if
+ * their relation is mapped, and names of the form schema.object if their
+ * schema is mapped.  A single name is redirected only if it follows SCHEMA.
+ */
+func (redirectMap *RedirectMap) redirectQualifiedName(names []string, afterSchemaKeyword bool) (string, bool) {
+	unquotedNames := make([]string, len(names))
+	for i, name := range names {
+		unquotedNames[i] = unquoteName(name)
+	}
+	if len(names) == 1 {
+		newSchema, ok := redirectMap.schemas[unquotedNames[0]]
+		return newSchema, ok && afterSchemaKeyword
+	}
+	if fqn, ok := redirectMap.relations[options.FqnStruct{SchemaName: unquotedNames[0], TableName: unquotedNames[1]}]; ok {
+		return strings.Join(append([]string{fqn.SchemaName, fqn.TableName}, names[2:]...), "."), true
+	}
+	if newSchema, ok := redirectMap.schemas[unquotedNames[0]]; ok {
+		return strings.Join(append([]string{newSchema}, names[1:]...), "."), true
+	}
+	return "", false
+}
+
+func initializeRedirectMap() {
+	var err error
+	if opts.RedirectSchema != "" {
+		redirectMap, err = NewRedirectSchemaMap(opts.RedirectSchema, opts.IncludedRelations)
+		gplog.FatalOnError(err)
+	} else if filename := MustGetFlagString(options.REDIRECT_MAPPING_FILE); filename != "" {
+		redirectMap, err = ReadRedirectMapFile(filename, func(name string) string {
+			return utils.QuoteIdent(connectionPool, name)
+		})
+		gplog.FatalOnError(err)
+		gplog.Verbose("Redirecting %d schema(s) and %d table(s) using %s", len(redirectMap.schemas), len(redirectMap.relations), filename)
+	}
+	redirectLeafPartitions()
+}
+
+/*
+ * Leaf partitions are created in the schema of their partition table and are
+ * named after it, so they are redirected along with their partition table,
+ * and the data of the leaf partitions of a renamed table cannot be restored.
+ */
+func redirectLeafPartitions() {
+	if redirectMap == nil {
+		return
+	}
+	isMetadataOnly := backupConfig.MetadataOnly || MustGetFlagBool(options.METADATA_ONLY)
+	for _, entry := range globalTOC.DataEntries {
+		if entry.PartitionRoot == "" {
+			continue
+		}
+		rootFQN, ok := redirectMap.lookupRelation(entry.Schema, entry.PartitionRoot)
+		if !ok {
+			continue
+		}
+		if utils.UnquoteIdent(rootFQN.TableName) != utils.UnquoteIdent(entry.PartitionRoot) {
+			if !isMetadataOnly {
+				gplog.Fatal(errors.Errorf("Cannot rename partition table %s, as the backup contains the data of its leaf partitions",
+					utils.MakeFQN(entry.Schema, entry.PartitionRoot)), "")
+			}
+			continue
+		}
+		if _, ok := redirectMap.lookupRelation(entry.Schema, entry.Name); !ok {
+			redirectMap.AddRelation(utils.UnquoteIdent(entry.Schema), utils.UnquoteIdent(entry.Name), rootFQN.SchemaName, entry.Name)
+		}
+	}
+}
+
+func editStatementsRedirect(statements []toc.StatementWithType, redirectMap *RedirectMap) {
+	if redirectMap == nil {
+		return
+	}
+	for i, statement := range statements {
+		switch statement.ObjectType {
+		case "TABLE", "SEQUENCE", "SEQUENCE OWNER", "VIEW", "MATERIALIZED VIEW", "STATISTICS":
+			statements[i].Schema, statements[i].Name = redirectMap.RedirectRelation(statement.Schema, statement.Name)
+		case "SCHEMA":
+			statements[i].Schema = redirectMap.RedirectSchema(statement.Schema)
+			statements[i].Name = redirectMap.RedirectSchema(statement.Name)
+		default:
+			statements[i].Schema = redirectMap.RedirectSchema(statement.Schema)
+		}
+		// Objects such as indexes and triggers are in the schema of the relation they belong to
+		if statement.ReferenceObject != "" && statement.ObjectType != "SEQUENCE OWNER" {
+			if fqns, err := options.SeparateSchemaAndTable([]string{statement.ReferenceObject}); err == nil {
+				if fqn, ok := redirectMap.lookupRelation(fqns[0].SchemaName, fqns[0].TableName); ok {
+					statements[i].Schema = fqn.SchemaName
+				}
+			}
+		}
+		statements[i].ReferenceObject = redirectMap.RedirectStatement(statement.ReferenceObject)
+		statements[i].Statement = redirectMap.RedirectStatement(statement.Statement)
+	}
+}
+
+/*
+ * When several schemas are redirected to the same schema, only the CREATE
+ * SCHEMA statement of the first of them is kept, so that the schema is created
+ * once.  The other statements of each schema, such as its comment, owner, and
+ * privileges, are all kept, as are the statements of schemas that are the only
+ * one redirected to their target.  This is called before the statements are
+ * redirected, as it compares the names of the schemas in the backup.
+ */
+func filterRedirectedSchemaStatements(schemaStatements []toc.StatementWithType, redirectMap *RedirectMap) []toc.StatementWithType {
+	if redirectMap == nil {
+		return schemaStatements
+	}
+	sourceSchemas := make(map[string]map[string]bool)
+	for _, statement := range schemaStatements {
+		target := utils.UnquoteIdent(redirectMap.RedirectSchema(statement.Name))
+		if sourceSchemas[target] == nil {
+			sourceSchemas[target] = make(map[string]bool)
+		}
+		sourceSchemas[target][utils.UnquoteIdent(statement.Name)] = true
+	}
+
+	filteredStatements := make([]toc.StatementWithType, 0, len(schemaStatements))
+	createdSchemas := make(map[string]bool)
+	for _, statement := range schemaStatements {
+		target := utils.UnquoteIdent(redirectMap.RedirectSchema(statement.Name))
+		if len(sourceSchemas[target]) > 1 && strings.HasPrefix(strings.TrimSpace(statement.Statement), "CREATE SCHEMA") {
+			if createdSchemas[target] {
+				continue
+			}
+			createdSchemas[target] = true
+		}
+		filteredStatements = append(filteredStatements, statement)
+	}
+	return filteredStatements
+}
+
+func scanQualifiedName(statement string, i int) ([]string, int) {
+	names := make([]string, 0)
+	for {
+		start := i
+		if statement[i] == '"' {
+			i++
+			for i < len(statement) {
+				if statement[i] == '"' {
+					if i+1 < len(statement) && statement[i+1] == '"' {
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				i++
+			}
+		} else {
+			for i < len(statement) && isIdentifierChar(statement[i]) {
+				i++
+			}
+		}
+		names = append(names, statement[start:i])
+		if i+1 < len(statement) && statement[i] == '.' && (statement[i+1] == '"' || isIdentifierStart(statement[i+1])) {
+			i++
+			continue
+		}
+		return names, i
+	}
+}
+
+func scanStringLiteral(statement string, i int, backslashEscapes bool) int {
+	for i++; i < len(statement); i++ {
+		if backslashEscapes && statement[i] == '\\' {
+			i++
+		} else if statement[i] == '\'' {
+			if i+1 < len(statement) && statement[i+1] == '\'' {
+				i++
+			} else {
+				return i + 1
+			}
+		}
+	}
+	return len(statement)
+}
+
+// Returns the index after the end of the first occurrence of substr at or after i, or the end of the statement
+func indexFrom(statement string, i int, substr string, substrLen int) int {
+	if i > len(statement) {
+		return len(statement)
+	}
+	index := strings.Index(statement[i:], substr)
+	if index == -1 {
+		return len(statement)
+	}
+	return i + index + substrLen
+}
+
+// Unquoted names are folded to lower case, as Postgres does
+func unquoteName(name string) string {
+	if strings.HasPrefix(name, `"`) {
+		return utils.UnquoteIdent(name)
+	}
+	return strings.ToLower(name)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentifierStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c >= 0x80
+}
+
+func isIdentifierChar(c byte) bool {
+	return isIdentifierStart(c) || isDigit(c) || c == '$'
+}
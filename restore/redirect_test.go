@@ -0,0 +1,92 @@
+package restore_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gpbackup/restore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/redirect tests", func() {
+	var (
+		tempDir    string
+		mapFile    string
+		quoteIdent = func(name string) string { return fmt.Sprintf(`"%s"`, name) }
+	)
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "redirect")
+		Expect(err).ToNot(HaveOccurred())
+		mapFile = filepath.Join(tempDir, "redirect_map")
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	Describe("ReadRedirectMapFile", func() {
+		It("reads schema and table mappings, skipping blank lines and comments", func() {
+			Expect(ioutil.WriteFile(mapFile, []byte("# schemas\nsales -> sales_2019\n\npublic.Orders  ->  archive.orders_2019\n"), 0644)).To(Succeed())
+
+			redirectMap, err := restore.ReadRedirectMapFile(mapFile, quoteIdent)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(redirectMap.RedirectSchema("sales")).To(Equal(`"sales_2019"`))
+			Expect(redirectMap.RedirectSchema("public")).To(Equal("public"))
+			schema, name := redirectMap.RedirectRelation("sales", "items")
+			Expect([]string{schema, name}).To(Equal([]string{`"sales_2019"`, "items"}))
+			schema, name = redirectMap.RedirectRelation("public", `"Orders"`)
+			Expect([]string{schema, name}).To(Equal([]string{`"archive"`, `"orders_2019"`}))
+			schema, name = redirectMap.RedirectRelation("public", "orders")
+			Expect([]string{schema, name}).To(Equal([]string{"public", "orders"}))
+		})
+		It("returns an error for a line that is not a mapping", func() {
+			Expect(ioutil.WriteFile(mapFile, []byte("sales sales_2019\n"), 0644)).To(Succeed())
+
+			_, err := restore.ReadRedirectMapFile(mapFile, quoteIdent)
+			Expect(err).To(MatchError(fmt.Sprintf(`Line 1 of redirect mapping file %s is not in the format "old_name -> new_name": sales sales_2019`, mapFile)))
+		})
+		It("returns an error for a line mapping a schema to a table", func() {
+			Expect(ioutil.WriteFile(mapFile, []byte("sales -> archive.sales\n"), 0644)).To(Succeed())
+
+			_, err := restore.ReadRedirectMapFile(mapFile, quoteIdent)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("must map a schema to a schema or a table to a table"))
+		})
+		It("returns an error for a table that is mapped twice", func() {
+			Expect(ioutil.WriteFile(mapFile, []byte("public.foo -> public.bar\npublic.foo -> public.baz\n"), 0644)).To(Succeed())
+
+			_, err := restore.ReadRedirectMapFile(mapFile, quoteIdent)
+			Expect(err).To(MatchError(fmt.Sprintf("Table public.foo is mapped more than once in redirect mapping file %s", mapFile)))
+		})
+	})
+	Describe("RedirectStatement", func() {
+		It("does not change names in comments, string literals, or dollar-quoted strings", func() {
+			redirectMap := restore.NewRedirectMap()
+			redirectMap.AddSchema("sales", "sales_2019")
+			statement := `-- sales.items
+/* sales.items */ SELECT 'sales.items', E'sales.items\'', $body$sales.items$body$, sales.items.id FROM sales.items;`
+
+			Expect(redirectMap.RedirectStatement(statement)).To(Equal(`-- sales.items
+/* sales.items */ SELECT 'sales.items', E'sales.items\'', $body$sales.items$body$, sales_2019.items.id FROM sales_2019.items;`))
+		})
+		It("gives a renamed table in a FROM item without an alias its old name as its alias", func() {
+			redirectMap := restore.NewRedirectMap()
+			redirectMap.AddRelation("public", "orders", "archive", "orders_2019")
+			redirectMap.AddRelation("public", "items", "archive", "items")
+			statement := `SELECT orders.id, o2.id FROM (public.orders JOIN public.items ON ((orders.id = items.order_id))), ONLY public.orders o2, public.orders AS o3 WHERE (orders.id = ANY (SELECT orders.id FROM public.orders));`
+
+			Expect(redirectMap.RedirectStatement(statement)).To(Equal(`SELECT orders.id, o2.id FROM (archive.orders_2019 orders JOIN archive.items ON ((orders.id = items.order_id))), ONLY archive.orders_2019 o2, archive.orders_2019 AS o3 WHERE (orders.id = ANY (SELECT orders.id FROM archive.orders_2019 orders));`))
+		})
+		It("does not give a renamed table an alias outside of FROM items", func() {
+			redirectMap := restore.NewRedirectMap()
+			redirectMap.AddRelation("public", "orders", "archive", "orders_2019")
+			statement := `ALTER TABLE ONLY public.orders ADD CONSTRAINT orders_fkey FOREIGN KEY (id) REFERENCES public.orders(id);`
+
+			Expect(redirectMap.RedirectStatement(statement)).To(Equal(`ALTER TABLE ONLY archive.orders_2019 ADD CONSTRAINT orders_fkey FOREIGN KEY (id) REFERENCES archive.orders_2019(id);`))
+		})
+	})
+})
@@ -0,0 +1,193 @@
+package restore
+
+/*
+ * This file contains structs and functions related to restoring objects with
+ * other owners, privileges, or tablespaces than they were backed up with,
+ * using --role-mapping, --tablespace-mapping, --no-owner, or --no-privileges.
+ */
+
+import (
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * As in a RedirectMap, old names are unquoted and new names are quoted.
+ */
+type NameMap map[string]string
+
+/*
+ * Each line of a role or tablespace mapping file maps one name to another,
+ * as in
+ *
+ *   prod_owner -> qa_owner
+ *
+ * in the format of a redirect mapping file.
+ */
+func ReadNameMapFile(filename string, fileDescription string, quoteIdent func(string) string) (NameMap, error) {
+	mappings, err := readMappingFile(filename, fileDescription)
+	if err != nil {
+		return nil, err
+	}
+	nameMap := make(NameMap)
+	for _, mapping := range mappings {
+		if _, exists := nameMap[mapping.oldName]; exists {
+			return nil, errors.Errorf("%s is mapped more than once in %s %s", mapping.oldName, fileDescription, filename)
+		}
+		nameMap[mapping.oldName] = quoteIdent(mapping.newName)
+	}
+	return nameMap, nil
+}
+
+func (nameMap NameMap) lookup(quotedName string) (string, bool) {
+	newName, ok := nameMap[utils.UnquoteIdent(quotedName)]
+	return newName, ok
+}
+
+/*
+ * Statements naming a role only do so directly after one of these keywords,
+ * as in OWNER TO, GRANT ... TO, REVOKE ... FROM, and FOR ROLE.  Role
+ * membership statements also name roles after GRANT and GRANTED BY.
+ */
+var (
+	roleKeywords           = map[string]bool{"TO": true, "FROM": true, "ROLE": true}
+	roleMembershipKeywords = map[string]bool{"GRANT": true, "TO": true, "BY": true}
+	tablespaceKeywords     = map[string]bool{"TABLESPACE": true}
+)
+
+/*
+ * Rewrites the mapped names that directly follow one of the keywords in a
+ * statement.  The statement is tokenized as in RedirectStatement, so names in
+ * comments, string literals, and dollar-quoted strings are left as they are.
+ */
+func (nameMap NameMap) MapNamesAfterKeywords(statement string, keywords map[string]bool) string {
+	if len(nameMap) == 0 {
+		return statement
+	}
+	var result strings.Builder
+	afterKeyword := false
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		start := i
+		switch {
+		case isSpace(c):
+			result.WriteByte(c)
+			i++
+			continue
+		case strings.HasPrefix(statement[i:], "--"):
+			i = indexFrom(statement, i, "\n", 0)
+		case strings.HasPrefix(statement[i:], "/*"):
+			i = indexFrom(statement, i+2, "*/", 2)
+		case c == '\'':
+			i = scanStringLiteral(statement, i, false)
+		case (c == 'E' || c == 'e') && strings.HasPrefix(statement[i+1:], "'"):
+			i = scanStringLiteral(statement, i+1, true)
+		case c == '$' && dollarQuoteTagRegex.MatchString(statement[i:]):
+			tag := dollarQuoteTagRegex.FindString(statement[i:])
+			i = indexFrom(statement, i+len(tag), tag, len(tag))
+		case c == '"' || isIdentifierStart(c):
+			var names []string
+			names, i = scanQualifiedName(statement, i)
+			if newName, ok := nameMap.lookup(names[0]); ok && afterKeyword && len(names) == 1 {
+				result.WriteString(newName)
+			} else {
+				result.WriteString(statement[start:i])
+			}
+			afterKeyword = len(names) == 1 && keywords[strings.ToUpper(names[0])]
+			continue
+		case isDigit(c):
+			for i < len(statement) && isIdentifierChar(statement[i]) {
+				i++
+			}
+		default:
+			i++
+		}
+		result.WriteString(statement[start:i])
+		afterKeyword = false
+	}
+	return result.String()
+}
+
+func initializeRoleAndTablespaceMaps() {
+	quoteIdent := func(name string) string {
+		return utils.QuoteIdent(connectionPool, name)
+	}
+	var err error
+	if filename := MustGetFlagString(options.ROLE_MAPPING); filename != "" {
+		roleMap, err = ReadNameMapFile(filename, "role mapping file", quoteIdent)
+		gplog.FatalOnError(err)
+		gplog.Verbose("Mapping %d role(s) using %s", len(roleMap), filename)
+	}
+	if filename := MustGetFlagString(options.TABLESPACE_MAPPING); filename != "" {
+		tablespaceMap, err = ReadNameMapFile(filename, "tablespace mapping file", quoteIdent)
+		gplog.FatalOnError(err)
+		gplog.Verbose("Mapping %d tablespace(s) using %s", len(tablespaceMap), filename)
+	}
+	if len(roleMap) > 0 || len(tablespaceMap) > 0 || MustGetFlagBool(options.NO_OWNER) || MustGetFlagBool(options.NO_PRIVILEGES) {
+		if !tocMarksClauses(globalTOC) {
+			gplog.Warn("The TOC of backup %s does not mark owner, privileges, or tablespace statements, as it was taken by an older version of gpbackup. "+
+				"--role-mapping, --tablespace-mapping, --no-owner, and --no-privileges do not apply to its objects.", backupConfig.Timestamp)
+		}
+	}
+}
+
+func tocMarksClauses(tocfile *toc.TOC) bool {
+	for _, entries := range [][]toc.MetadataEntry{tocfile.GlobalEntries, tocfile.PredataEntries, tocfile.PostdataEntries} {
+		for _, entry := range entries {
+			if entry.Clause != "" || entry.Tablespace != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func applyRoleAndTablespaceOptions(statements []toc.StatementWithType) []toc.StatementWithType {
+	return editStatementsRoleAndTablespace(statements, roleMap, tablespaceMap,
+		MustGetFlagBool(options.NO_OWNER), MustGetFlagBool(options.NO_PRIVILEGES))
+}
+
+/*
+ * Leaves out the owner and privileges statements that --no-owner and
+ * --no-privileges skip, and the statements creating the roles and tablespaces
+ * that are mapped to others, as those already exist in the restore cluster.
+ * The mapped roles and tablespaces are then rewritten in the statements the
+ * TOC marks as naming them.
+ */
+func editStatementsRoleAndTablespace(statements []toc.StatementWithType, roleMap NameMap, tablespaceMap NameMap,
+	noOwner bool, noPrivileges bool) []toc.StatementWithType {
+	if len(roleMap) == 0 && len(tablespaceMap) == 0 && !noOwner && !noPrivileges {
+		return statements
+	}
+	editedStatements := make([]toc.StatementWithType, 0, len(statements))
+	for _, statement := range statements {
+		if (noOwner && statement.Clause == toc.CLAUSE_OWNER) || (noPrivileges && statement.Clause == toc.CLAUSE_PRIVILEGES) {
+			continue
+		}
+		switch statement.ObjectType {
+		case "ROLE", "ROLE GUCS":
+			if _, ok := roleMap.lookup(statement.Name); ok {
+				continue
+			}
+		case "TABLESPACE":
+			if _, ok := tablespaceMap.lookup(statement.Name); ok {
+				continue
+			}
+		}
+		if statement.Clause != "" {
+			statement.Statement = roleMap.MapNamesAfterKeywords(statement.Statement, roleKeywords)
+		} else if statement.ObjectType == "ROLE GRANT" {
+			statement.Statement = roleMap.MapNamesAfterKeywords(statement.Statement, roleMembershipKeywords)
+		}
+		if statement.Tablespace != "" {
+			statement.Statement = tablespaceMap.MapNamesAfterKeywords(statement.Statement, tablespaceKeywords)
+		}
+		editedStatements = append(editedStatements, statement)
+	}
+	return editedStatements
+}
@@ -0,0 +1,70 @@
+package restore_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gpbackup/restore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/remap tests", func() {
+	var (
+		tempDir    string
+		mapFile    string
+		quoteIdent = func(name string) string { return fmt.Sprintf(`"%s"`, name) }
+	)
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "remap")
+		Expect(err).ToNot(HaveOccurred())
+		mapFile = filepath.Join(tempDir, "role_map")
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	Describe("ReadNameMapFile", func() {
+		It("reads name mappings, skipping blank lines and comments", func() {
+			Expect(ioutil.WriteFile(mapFile, []byte("# owners\nprod_owner -> qa_owner\n\nProdReader->qa_reader\n"), 0644)).To(Succeed())
+
+			roleMap, err := restore.ReadNameMapFile(mapFile, "role mapping file", quoteIdent)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(roleMap).To(Equal(restore.NameMap{"prod_owner": `"qa_owner"`, "ProdReader": `"qa_reader"`}))
+		})
+		It("returns an error for a name that is mapped twice", func() {
+			Expect(ioutil.WriteFile(mapFile, []byte("prod_owner -> qa_owner\nprod_owner -> dev_owner\n"), 0644)).To(Succeed())
+
+			_, err := restore.ReadNameMapFile(mapFile, "role mapping file", quoteIdent)
+			Expect(err).To(MatchError(fmt.Sprintf("prod_owner is mapped more than once in role mapping file %s", mapFile)))
+		})
+		It("returns an error for a line that is not a mapping", func() {
+			Expect(ioutil.WriteFile(mapFile, []byte("prod_owner ->\n"), 0644)).To(Succeed())
+
+			_, err := restore.ReadNameMapFile(mapFile, "tablespace mapping file", quoteIdent)
+			Expect(err).To(MatchError(fmt.Sprintf(`Line 1 of tablespace mapping file %s is not in the format "old_name -> new_name": prod_owner ->`, mapFile)))
+		})
+	})
+	Describe("MapNamesAfterKeywords", func() {
+		roleMap := restore.NameMap{"prod_owner": "qa_owner", "ProdReader": "qa_reader"}
+		keywords := map[string]bool{"TO": true, "FROM": true, "ROLE": true}
+		It("maps names that follow a keyword", func() {
+			statement := `ALTER DEFAULT PRIVILEGES FOR ROLE prod_owner REVOKE ALL ON TABLES FROM prod_owner;
+ALTER DEFAULT PRIVILEGES FOR ROLE prod_owner GRANT SELECT ON TABLES TO "ProdReader";`
+
+			Expect(roleMap.MapNamesAfterKeywords(statement, keywords)).To(Equal(`ALTER DEFAULT PRIVILEGES FOR ROLE qa_owner REVOKE ALL ON TABLES FROM qa_owner;
+ALTER DEFAULT PRIVILEGES FOR ROLE qa_owner GRANT SELECT ON TABLES TO qa_reader;`))
+		})
+		It("does not map object names, qualified names, or names in comments and string literals", func() {
+			statement := `ALTER TABLE public.prod_owner OWNER TO prod_owner; -- TO prod_owner
+COMMENT ON TABLE prod_owner IS 'TO prod_owner'; ALTER SCHEMA "TO" OWNER TO public.prod_owner;`
+
+			Expect(roleMap.MapNamesAfterKeywords(statement, keywords)).To(Equal(`ALTER TABLE public.prod_owner OWNER TO qa_owner; -- TO prod_owner
+COMMENT ON TABLE prod_owner IS 'TO prod_owner'; ALTER SCHEMA "TO" OWNER TO public.prod_owner;`))
+		})
+	})
+})
@@ -5,13 +5,17 @@ import (
 	"fmt"
 	"os"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/metrics"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
@@ -41,6 +45,23 @@ func DoValidation(cmd *cobra.Command) {
 	gplog.FatalOnError(err)
 	err = utils.ValidateFullPath(MustGetFlagString(options.PLUGIN_CONFIG))
 	gplog.FatalOnError(err)
+	err = report.ValidateReportFormat(MustGetFlagString(options.REPORT_FORMAT))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.METRICS_TEXTFILE))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.NOTIFICATION_CONFIG))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.USE_LIST))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.TO_SQL_FILE))
+	gplog.FatalOnError(err)
+	if MustGetFlagString(options.NOTIFICATION_CONFIG) != "" {
+		_, err = report.ReadNotificationFile(MustGetFlagString(options.NOTIFICATION_CONFIG))
+		gplog.FatalOnError(err)
+	}
+	if port := MustGetFlagInt(options.METRICS_PORT); port < 0 || port > 65535 {
+		gplog.Fatal(errors.Errorf("Metrics port %d is invalid.  Valid ports are between 1 and 65535.", port), "")
+	}
 	if !filepath.IsValidTimestamp(MustGetFlagString(options.TIMESTAMP)) {
 		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", MustGetFlagString(options.TIMESTAMP)), "")
 	}
@@ -77,6 +98,16 @@ func DoSetup() {
 	}
 
 	BackupConfigurationValidation()
+	initializeMetrics()
+	if MustGetFlagBool(options.VERIFY) || MustGetFlagBool(options.LIST) {
+		// Verification and listing only read the backup files, so there is no restore database to set up
+		return
+	}
+	if MustGetFlagString(options.USE_LIST) != "" {
+		applyUseList(MustGetFlagString(options.USE_LIST))
+	}
+	initializeRedirectMap()
+	initializeRoleAndTablespaceMaps()
 	metadataFilename := globalFPInfo.GetMetadataFilePath()
 	if !backupConfig.DataOnly {
 		gplog.Verbose("Metadata will be restored from %s", metadataFilename)
@@ -85,12 +116,26 @@ func DoSetup() {
 	if MustGetFlagString(options.REDIRECT_DB) != "" {
 		unquotedRestoreDatabase = MustGetFlagString(options.REDIRECT_DB)
 	}
-	ValidateDatabaseExistence(unquotedRestoreDatabase, MustGetFlagBool(options.CREATE_DB), backupConfig.IncludeTableFiltered || backupConfig.DataOnly)
+	writeSQLFile := MustGetFlagString(options.TO_SQL_FILE) != ""
+	if writeSQLFile {
+		// The SQL file may be run against another cluster, so the restore database is not checked here
+		initializeSQLFile(MustGetFlagString(options.TO_SQL_FILE))
+	} else {
+		ValidateDatabaseExistence(unquotedRestoreDatabase, MustGetFlagBool(options.CREATE_DB), backupConfig.IncludeTableFiltered || backupConfig.DataOnly)
+	}
 	if MustGetFlagBool(options.WITH_GLOBALS) {
+		sectionStart := operating.System.Now()
 		restoreGlobal(metadataFilename)
+		recordSection("globals", sectionStart)
 	} else if MustGetFlagBool(options.CREATE_DB) {
 		createDatabase(metadataFilename)
 	}
+	if writeSQLFile {
+		// The connection to postgres is kept, and the restore database is only connected to in the SQL file
+		restoreSQLFile.WriteConnect(utils.QuoteIdent(connectionPool, unquotedRestoreDatabase))
+		restoreSQLFile.WriteStatement(getSetupQuery())
+		return
+	}
 	if connectionPool != nil {
 		connectionPool.Close()
 	}
@@ -102,14 +147,13 @@ func DoSetup() {
 	 * For on-error-continue, we will see the same errors later when we try to run SQL,
 	 * but since they will not stop the restore, it is not necessary to log them twice.
 	 */
-	if !MustGetFlagBool(options.CREATE_DB) && !MustGetFlagBool(options.ON_ERROR_CONTINUE) && !MustGetFlagBool(options.INCREMENTAL) {
+	if !MustGetFlagBool(options.CREATE_DB) && !MustGetFlagBool(options.ON_ERROR_CONTINUE) && !MustGetFlagBool(options.INCREMENTAL) &&
+		!MustGetFlagBool(options.RESUME) {
 		relationsToRestore := GenerateRestoreRelationList(*opts)
-		if opts.RedirectSchema != "" {
-			fqns, err := options.SeparateSchemaAndTable(relationsToRestore)
-			gplog.FatalOnError(err)
-			redirectRelationsToRestore := make([]string, 0)
-			for _, fqn := range fqns {
-				redirectRelationsToRestore = append(redirectRelationsToRestore, utils.MakeFQN(opts.RedirectSchema, fqn.TableName))
+		if redirectMap != nil {
+			redirectRelationsToRestore := make([]string, 0, len(relationsToRestore))
+			for _, fqn := range relationsToRestore {
+				redirectRelationsToRestore = append(redirectRelationsToRestore, redirectMap.RedirectStatement(fqn))
 			}
 			relationsToRestore = redirectRelationsToRestore
 		}
@@ -119,6 +163,7 @@ func DoSetup() {
 	if opts.RedirectSchema != "" {
 		ValidateRedirectSchema(connectionPool, opts.RedirectSchema)
 	}
+	initializeRestoreState(unquotedRestoreDatabase)
 }
 
 func DoRestore() {
@@ -127,15 +172,18 @@ func DoRestore() {
 	isMetadataOnly := backupConfig.MetadataOnly || MustGetFlagBool(options.METADATA_ONLY)
 	isIncremental := MustGetFlagBool(options.INCREMENTAL)
 
-	if isIncremental {
+	if isIncremental && restoreSQLFile == nil {
 		verifyIncrementalState()
 	}
 
 	if !isDataOnly && !isIncremental {
+		sectionStart := operating.System.Now()
 		restorePredata(metadataFilename)
+		recordSection("predata", sectionStart)
 	}
 
-	if !isMetadataOnly {
+	// Table data is restored along with the tables themselves
+	if !isMetadataOnly && restoresObjectType("TABLE") {
 		if MustGetFlagString(options.PLUGIN_CONFIG) == "" {
 			backupFileCount := 2 // 1 for the actual data file, 1 for the segment TOC file
 			if !backupConfig.SingleDataFile {
@@ -143,16 +191,53 @@ func DoRestore() {
 			}
 			VerifyBackupFileCountOnSegments(backupFileCount)
 		}
+		sectionStart := operating.System.Now()
 		restoreData()
+		recordSection("data", sectionStart)
 	}
 
 	if !isDataOnly && !isIncremental {
+		sectionStart := operating.System.Now()
 		restorePostdata(metadataFilename)
+		recordSection("postdata", sectionStart)
 	}
 
 	if MustGetFlagBool(options.WITH_STATS) && backupConfig.WithStatistics {
+		sectionStart := operating.System.Now()
 		restoreStatistics()
+		recordSection("statistics", sectionStart)
+	}
+}
+
+func recordSection(name string, startTime time.Time) {
+	endTime := operating.System.Now()
+	restoreSections = append(restoreSections, report.NewSectionReport(name, startTime, endTime))
+	metricsRegistry.SetSectionDuration(name, endTime.Sub(startTime))
+}
+
+func initializeMetrics() {
+	port := MustGetFlagInt(options.METRICS_PORT)
+	if port == 0 && MustGetFlagString(options.METRICS_TEXTFILE) == "" {
+		return
+	}
+	restoreDatabase := utils.UnquoteIdent(backupConfig.DatabaseName)
+	if MustGetFlagString(options.REDIRECT_DB) != "" {
+		restoreDatabase = MustGetFlagString(options.REDIRECT_DB)
+	}
+	metricsRegistry = metrics.NewRegistry("gprestore", map[string]string{"database": restoreDatabase})
+	if port != 0 {
+		err := metricsRegistry.StartServer(port)
+		gplog.FatalOnError(err)
+	}
+}
+
+func finishMetrics(restoreFailed bool) {
+	endTime := operating.System.Now()
+	startTime, err := time.ParseInLocation("20060102150405", restoreStartTime, operating.System.Local)
+	if err != nil {
+		startTime = endTime
 	}
+	metricsRegistry.Finish(!restoreFailed && gplog.GetErrorCode() == 0, startTime, endTime, MustGetFlagString(options.METRICS_TEXTFILE))
 }
 
 func createDatabase(metadataFilename string) {
@@ -165,6 +250,7 @@ func createDatabase(metadataFilename string) {
 		dbName = quotedDBName
 		statements = toc.SubstituteRedirectDatabaseInStatements(statements, backupConfig.DatabaseName, quotedDBName)
 	}
+	statements = applyRoleAndTablespaceOptions(statements)
 	ExecuteRestoreMetadataStatements(statements, "", nil, utils.PB_NONE, false)
 	gplog.Info("Database creation complete for: %s", dbName)
 }
@@ -181,6 +267,8 @@ func restoreGlobal(metadataFilename string) {
 		statements = toc.SubstituteRedirectDatabaseInStatements(statements, backupConfig.DatabaseName, quotedDBName)
 	}
 	statements = toc.RemoveActiveRole(connectionPool.User, statements)
+	statements = applyRoleAndTablespaceOptions(statements)
+	statements = filterStatementsByObjectType(statements)
 	ExecuteRestoreMetadataStatements(statements, "Global objects", nil, utils.PB_VERBOSE, false)
 	gplog.Info("Global database metadata restore complete")
 }
@@ -257,7 +345,15 @@ func restorePredata(metadataFilename string) {
 	}
 	statements := GetRestoreMetadataStatementsFiltered("predata", metadataFilename, []string{}, []string{"SCHEMA"}, filters)
 
-	editStatementsRedirectSchema(statements, opts.RedirectSchema)
+	schemaStatements = filterRedirectedSchemaStatements(schemaStatements, redirectMap)
+	editStatementsRedirect(schemaStatements, redirectMap)
+	editStatementsRedirect(statements, redirectMap)
+	schemaStatements = applyRoleAndTablespaceOptions(schemaStatements)
+	statements = applyRoleAndTablespaceOptions(statements)
+	schemaStatements = filterStatementsByObjectType(schemaStatements)
+	statements = filterStatementsByObjectType(statements)
+	schemaStatements = restoreState.FilterRestoredStatements(schemaStatements)
+	statements = restoreState.FilterRestoredStatements(statements)
 	progressBar := utils.NewProgressBar(len(schemaStatements)+len(statements), "Pre-data objects restored: ", utils.PB_VERBOSE)
 	progressBar.Start()
 
@@ -272,23 +368,6 @@ func restorePredata(metadataFilename string) {
 	}
 }
 
-func editStatementsRedirectSchema(statements []toc.StatementWithType, redirectSchema string) {
-	if redirectSchema == "" {
-		return
-	}
-
-	for i, statement := range statements {
-		oldSchema := fmt.Sprintf("%s.", statement.Schema)
-		newSchema := fmt.Sprintf("%s.", redirectSchema)
-		statements[i].Schema = redirectSchema
-		statements[i].Statement = strings.Replace(statement.Statement, oldSchema, newSchema, 1)
-		// only postdata will have a reference object
-		if statement.ReferenceObject != "" {
-			statements[i].ReferenceObject = strings.Replace(statement.ReferenceObject, oldSchema, newSchema, 1)
-		}
-	}
-}
-
 func restoreData() {
 	if wasTerminated {
 		return
@@ -304,18 +383,45 @@ func restoreData() {
 		}
 	}
 
+	if backupConfig.ResumedFrom != "" {
+		for _, entry := range restorePlanEntries {
+			if entry.Timestamp == backupConfig.ResumedFrom {
+				gplog.Info("Backup was resumed from failed backup with timestamp %s, from which data for %d tables will be restored",
+					backupConfig.ResumedFrom, len(entry.TableFQNs))
+			}
+		}
+	}
+
+	if len(backupConfig.RowFilters) > 0 {
+		gplog.Warn("Backup was taken with row filters for %d table(s), so only the rows of those tables matching their filters will be restored",
+			len(backupConfig.RowFilters))
+	}
+	if len(backupConfig.MaskingRules) > 0 {
+		gplog.Info("Backup was taken with masking rules for %d column(s), whose masked values will be restored", len(backupConfig.MaskingRules))
+	}
+
 	totalTables := 0
+	backupDataSizes = make(map[string]map[int]map[uint32]int64)
+	segmentBytesRead = make(map[int]int64)
 	filteredDataEntries := make(map[string][]toc.MasterDataEntry)
 	for _, entry := range restorePlanEntries {
 		fpInfo := GetBackupFPInfoForTimestamp(entry.Timestamp)
 		tocfile := toc.NewTOC(fpInfo.GetTOCFilePath())
+		backupDataSizes[entry.Timestamp] = tocfile.DataSizes
 		restorePlanTableFQNs := entry.TableFQNs
 		filteredDataEntriesForTimestamp := tocfile.GetDataEntriesMatching(opts.IncludedSchemas,
 			opts.ExcludedSchemas, opts.IncludedRelations, opts.ExcludedRelations, restorePlanTableFQNs)
+		if useListTables != nil {
+			filteredDataEntriesForTimestamp = filterUseListDataEntries(filteredDataEntriesForTimestamp)
+		}
+		if MustGetFlagBool(options.RESUME) {
+			filteredDataEntriesForTimestamp = filterRestoredDataEntries(entry.Timestamp, filteredDataEntriesForTimestamp)
+		}
 		filteredDataEntries[entry.Timestamp] = filteredDataEntriesForTimestamp
 		totalTables += len(filteredDataEntriesForTimestamp)
 	}
 	dataProgressBar := utils.NewProgressBar(totalTables, "Tables restored: ", utils.PB_INFO)
+	metricsRegistry.SetTables(int64(totalTables))
 	dataProgressBar.Start()
 
 	gucStatements := setGUCsForConnection(nil, 0)
@@ -341,7 +447,10 @@ func restorePostdata(metadataFilename string) {
 	filters := NewFilters(opts.IncludedSchemas, opts.ExcludedSchemas, opts.IncludedRelations, opts.ExcludedRelations)
 
 	statements := GetRestoreMetadataStatementsFiltered("postdata", metadataFilename, []string{}, []string{}, filters)
-	editStatementsRedirectSchema(statements, opts.RedirectSchema)
+	editStatementsRedirect(statements, redirectMap)
+	statements = applyRoleAndTablespaceOptions(statements)
+	statements = filterStatementsByObjectType(statements)
+	statements = restoreState.FilterRestoredStatements(statements)
 	firstBatch, secondBatch := BatchPostdataStatements(statements)
 	progressBar := utils.NewProgressBar(len(statements), "Post-data objects restored: ", utils.PB_VERBOSE)
 	progressBar.Start()
@@ -365,7 +474,9 @@ func restoreStatistics() {
 	filters := NewFilters(opts.IncludedSchemas, opts.ExcludedSchemas, opts.IncludedRelations, opts.ExcludedRelations)
 
 	statements := GetRestoreMetadataStatementsFiltered("statistics", statisticsFilename, []string{}, []string{}, filters)
-	editStatementsRedirectSchema(statements, opts.RedirectSchema)
+	editStatementsRedirect(statements, redirectMap)
+	statements = filterStatementsByObjectType(statements)
+	statements = restoreState.FilterRestoredStatements(statements)
 	ExecuteRestoreMetadataStatements(statements, "Table statistics", nil, utils.PB_VERBOSE, false)
 	gplog.Info("Query planner statistics restore complete")
 }
@@ -377,7 +488,13 @@ func DoTeardown() {
 
 		errorCode := gplog.GetErrorCode()
 		if errorCode == 0 {
-			gplog.Info("Restore completed successfully")
+			if MustGetFlagBool(options.VERIFY) {
+				gplog.Info("Verification completed successfully")
+			} else if MustGetFlagString(options.TO_SQL_FILE) != "" {
+				gplog.Info("Restore statements written to %s", MustGetFlagString(options.TO_SQL_FILE))
+			} else if !MustGetFlagBool(options.LIST) {
+				gplog.Info("Restore completed successfully")
+			}
 		}
 		os.Exit(errorCode)
 
@@ -408,6 +525,10 @@ func DoTeardown() {
 	if errStr != "" {
 		fmt.Println(errStr)
 	}
+	if MustGetFlagBool(options.LIST) {
+		// Nothing is restored or verified, so there is nothing to report
+		return
+	}
 	errMsg := report.ParseErrorMessage(errStr)
 
 	if globalFPInfo.Timestamp != "" {
@@ -415,9 +536,20 @@ func DoTeardown() {
 		if statErr != nil { // Even if this isn't os.IsNotExist, don't try to write a report file in case of further errors
 			return
 		}
-		reportFilename := globalFPInfo.GetRestoreReportFilePath(restoreStartTime)
-		report.WriteRestoreReportFile(reportFilename, globalFPInfo.Timestamp, restoreStartTime, connectionPool, version, errMsg)
-		report.EmailReport(globalCluster, globalFPInfo.Timestamp, reportFilename, "gprestore")
+		// Nothing is restored when writing a SQL file, so there is nothing to report
+		if MustGetFlagString(options.TO_SQL_FILE) == "" {
+			reportFilename := globalFPInfo.GetRestoreReportFilePath(restoreStartTime)
+			report.WriteRestoreReportFile(reportFilename, globalFPInfo.Timestamp, restoreStartTime, connectionPool, version, errMsg)
+			machineReport := writeMachineReadableRestoreReport(reportFilename, errMsg)
+			report.EmailReport(globalCluster, globalFPInfo.Timestamp, reportFilename, "gprestore")
+			report.SendNotifications(globalCluster, report.FindNotificationFile(MustGetFlagString(options.NOTIFICATION_CONFIG)), report.Notification{
+				Utility:        "gprestore",
+				Timestamp:      globalFPInfo.Timestamp,
+				Status:         report.GetExitStatus(),
+				ReportFilePath: reportFilename,
+				Report:         machineReport,
+			})
+		}
 		if pluginConfig != nil {
 			pluginConfig.CleanupPluginForRestore(globalCluster, globalFPInfo)
 			pluginConfig.DeletePluginConfigWhenEncrypting(globalCluster)
@@ -433,6 +565,25 @@ func DoTeardown() {
 	}
 }
 
+func writeMachineReadableRestoreReport(reportFilename string, errMsg string) *report.MachineReadableRestoreReport {
+	reportFormat := MustGetFlagString(options.REPORT_FORMAT)
+	machineReport := report.NewMachineReadableRestoreReport(globalFPInfo.Timestamp, restoreStartTime, connectionPool, version, errMsg)
+	for table := range errorTablesMetadata {
+		machineReport.ErrorTablesMetadata = append(machineReport.ErrorTablesMetadata, table)
+	}
+	sort.Strings(machineReport.ErrorTablesMetadata)
+	for table := range errorTablesData {
+		machineReport.ErrorTablesData = append(machineReport.ErrorTablesData, table)
+	}
+	sort.Strings(machineReport.ErrorTablesData)
+	machineReport.Sections = append(machineReport.Sections, restoreSections...)
+	restoredTablesLock.Lock()
+	machineReport.Tables = append(machineReport.Tables, restoredTables...)
+	restoredTablesLock.Unlock()
+	report.WriteMachineReadableReportFile(report.GetMachineReadableReportFilePath(reportFilename, reportFormat), reportFormat, machineReport)
+	return machineReport
+}
+
 func writeErrorTables(isMetadata bool) {
 	var errorTables *map[string]Empty
 	var errorFilename string
@@ -476,6 +627,16 @@ func DoCleanup(restoreFailed bool) {
 	}()
 
 	gplog.Verbose("Beginning cleanup")
+	finishMetrics(restoreFailed)
+	closeSQLFile(restoreFailed)
+	if restoreState != nil {
+		if restoreFailed || gplog.GetErrorCode() != 0 {
+			restoreState.Close()
+			gplog.Info("Run gprestore with --resume to continue this restore without restoring objects and tables that were already restored")
+		} else {
+			restoreState.Remove()
+		}
+	}
 	if backupConfig != nil && backupConfig.SingleDataFile {
 		fpInfoList := GetBackupFPInfoListFromRestorePlan()
 		for _, fpInfo := range fpInfoList {
@@ -489,6 +650,10 @@ func DoCleanup(restoreFailed bool) {
 		}
 	}
 
+	if utils.IsEncryptionEnabled() && !backupConfig.MetadataOnly {
+		utils.RemoveEncryptionKeyFromAllHosts(globalCluster, utils.GetEncryptionKeyFilePath())
+	}
+
 	if connectionPool != nil {
 		connectionPool.Close()
 	}
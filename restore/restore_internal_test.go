@@ -1,6 +1,10 @@
 package restore
 
 import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/toc"
 
 	. "github.com/onsi/ginkgo"
@@ -8,7 +12,7 @@ import (
 )
 
 var _ = Describe("restore internal tests", func() {
-	Describe("editStatementsRedirectStatements", func() {
+	Describe("editStatementsRedirect", func() {
 		It("does not alter schemas if no redirect was specified", func() {
 			statements := []toc.StatementWithType{
 				{ // simple table
@@ -25,7 +29,7 @@ var _ = Describe("restore internal tests", func() {
 				},
 			}
 
-			editStatementsRedirectSchema(statements, "")
+			editStatementsRedirect(statements, nil)
 			Expect(statements).To(Equal(statements))
 		})
 		It("changes schema in the sql statement", func() {
@@ -39,8 +43,10 @@ var _ = Describe("restore internal tests", func() {
 					Statement: "\n\nCREATE TABLE foo.foo (\n\ti integer\n) DISTRIBUTED BY (i);\n",
 				},
 			}
+			redirectSchemaMap, err := NewRedirectSchemaMap("foo2", []string{"foo.bar", "foo.foo"})
+			Expect(err).ToNot(HaveOccurred())
 
-			editStatementsRedirectSchema(statements, "foo2")
+			editStatementsRedirect(statements, redirectSchemaMap)
 
 			expectedStatements := []toc.StatementWithType{
 				{
@@ -54,5 +60,244 @@ var _ = Describe("restore internal tests", func() {
 			}
 			Expect(statements).To(Equal(expectedStatements))
 		})
+		It("changes every reference to a redirected relation but not references to other relations", func() {
+			statements := []toc.StatementWithType{
+				{
+					Schema: "foo", Name: "bar", ObjectType: "TABLE",
+					Statement: "\n\nCREATE TABLE foo.bar (\n\ti integer DEFAULT nextval('foo.bar_seq'::regclass),\n\tj foo.mytype\n) DISTRIBUTED BY (i);\n",
+				},
+				{
+					Schema: "foo", Name: "bar_fkey", ObjectType: "CONSTRAINT", ReferenceObject: "foo.bar",
+					Statement: "\n\nALTER TABLE ONLY foo.bar ADD CONSTRAINT bar_fkey FOREIGN KEY (i) REFERENCES foo.baz(i);\n",
+				},
+			}
+			redirectSchemaMap, err := NewRedirectSchemaMap("foo2", []string{"foo.bar"})
+			Expect(err).ToNot(HaveOccurred())
+
+			editStatementsRedirect(statements, redirectSchemaMap)
+
+			Expect(statements[0].Statement).To(Equal("\n\nCREATE TABLE foo2.bar (\n\ti integer DEFAULT nextval('foo.bar_seq'::regclass),\n\tj foo.mytype\n) DISTRIBUTED BY (i);\n"))
+			Expect(statements[1].Schema).To(Equal("foo2"))
+			Expect(statements[1].ReferenceObject).To(Equal("foo2.bar"))
+			Expect(statements[1].Statement).To(Equal("\n\nALTER TABLE ONLY foo2.bar ADD CONSTRAINT bar_fkey FOREIGN KEY (i) REFERENCES foo.baz(i);\n"))
+		})
+		It("changes mapped schemas and tables in every section", func() {
+			redirectMap := NewRedirectMap()
+			redirectMap.AddSchema("sales", "sales_2019")
+			redirectMap.AddRelation("public", "Orders", "archive", `"Orders_2019"`)
+			statements := []toc.StatementWithType{
+				{
+					Schema: "sales", Name: "sales", ObjectType: "SCHEMA",
+					Statement: "\n\nCREATE SCHEMA sales;\n\nCOMMENT ON SCHEMA sales IS 'sales.data';\n",
+				},
+				{
+					Schema: "public", Name: `"Orders"`, ObjectType: "TABLE",
+					Statement: "\n\nCREATE TABLE public.\"Orders\" (\n\tid integer,\n\tamount sales.money_type\n) DISTRIBUTED BY (id);\n",
+				},
+				{
+					Schema: "public", Name: "orders_idx", ObjectType: "INDEX", ReferenceObject: `public."Orders"`,
+					Statement: "\n\nCREATE INDEX orders_idx ON public.\"Orders\" USING btree (id);\n",
+				},
+				{
+					Schema: "sales", Name: "total", ObjectType: "FUNCTION",
+					Statement: "\n\nCREATE FUNCTION sales.total() RETURNS bigint AS $$SELECT count(*) FROM sales.items$$ LANGUAGE sql;\n",
+				},
+				{
+					Schema: "public", Name: `"Orders"`, ObjectType: "STATISTICS",
+					Statement: "\n\nUPDATE pg_class\nSET\n\trelpages = 1::int,\n\treltuples = 10::real\nWHERE oid = 'public.\"Orders\"'::regclass::oid;\n",
+				},
+			}
+
+			editStatementsRedirect(statements, redirectMap)
+
+			expectedStatements := []toc.StatementWithType{
+				{
+					Schema: "sales_2019", Name: "sales_2019", ObjectType: "SCHEMA",
+					Statement: "\n\nCREATE SCHEMA sales_2019;\n\nCOMMENT ON SCHEMA sales_2019 IS 'sales.data';\n",
+				},
+				{
+					Schema: "archive", Name: `"Orders_2019"`, ObjectType: "TABLE",
+					Statement: "\n\nCREATE TABLE archive.\"Orders_2019\" (\n\tid integer,\n\tamount sales_2019.money_type\n) DISTRIBUTED BY (id);\n",
+				},
+				{
+					Schema: "archive", Name: "orders_idx", ObjectType: "INDEX", ReferenceObject: `archive."Orders_2019"`,
+					Statement: "\n\nCREATE INDEX orders_idx ON archive.\"Orders_2019\" USING btree (id);\n",
+				},
+				{
+					Schema: "sales_2019", Name: "total", ObjectType: "FUNCTION",
+					Statement: "\n\nCREATE FUNCTION sales_2019.total() RETURNS bigint AS $$SELECT count(*) FROM sales.items$$ LANGUAGE sql;\n",
+				},
+				{
+					Schema: "archive", Name: `"Orders_2019"`, ObjectType: "STATISTICS",
+					Statement: "\n\nUPDATE pg_class\nSET\n\trelpages = 1::int,\n\treltuples = 10::real\nWHERE oid = 'archive.\"Orders_2019\"'::regclass::oid;\n",
+				},
+			}
+			Expect(statements).To(Equal(expectedStatements))
+		})
+		It("keeps references to the columns of a renamed table in views and rules valid", func() {
+			redirectMap := NewRedirectMap()
+			redirectMap.AddRelation("public", "orders", "archive", "orders_2019")
+			statements := []toc.StatementWithType{
+				{
+					Schema: "public", Name: "orders_view", ObjectType: "VIEW",
+					Statement: "\n\nCREATE VIEW public.orders_view AS  SELECT orders.id,\n    orders.amount\n   FROM public.orders\n  WHERE (orders.amount > 0);\n",
+				},
+				{
+					Schema: "public", Name: "orders_rule", ObjectType: "RULE", ReferenceObject: "public.orders",
+					Statement: "\n\nCREATE RULE orders_rule AS\n    ON DELETE TO public.orders DO INSTEAD  UPDATE public.orders SET amount = 0\n  WHERE (orders.id = old.id);\n",
+				},
+			}
+
+			editStatementsRedirect(statements, redirectMap)
+
+			Expect(statements[0].Statement).To(Equal("\n\nCREATE VIEW public.orders_view AS  SELECT orders.id,\n    orders.amount\n   FROM archive.orders_2019 orders\n  WHERE (orders.amount > 0);\n"))
+			Expect(statements[1].Schema).To(Equal("archive"))
+			Expect(statements[1].Statement).To(Equal("\n\nCREATE RULE orders_rule AS\n    ON DELETE TO archive.orders_2019 DO INSTEAD  UPDATE archive.orders_2019 orders SET amount = 0\n  WHERE (orders.id = old.id);\n"))
+		})
+	})
+	Describe("filterRedirectedSchemaStatements", func() {
+		schemaStatements := []toc.StatementWithType{
+			{Schema: "sales_east", Name: "sales_east", ObjectType: "SCHEMA", Statement: "\n\nCREATE SCHEMA sales_east;\n"},
+			{Schema: "sales_east", Name: "sales_east", ObjectType: "SCHEMA", Statement: "\n\nCOMMENT ON SCHEMA sales_east IS 'East';\n"},
+			{Schema: "sales_east", Name: "sales_east", ObjectType: "SCHEMA", Statement: "\n\nALTER SCHEMA sales_east OWNER TO east_owner;\n"},
+			{Schema: "sales_west", Name: "sales_west", ObjectType: "SCHEMA", Statement: "\n\nCREATE SCHEMA sales_west;\n"},
+			{Schema: "sales_west", Name: "sales_west", ObjectType: "SCHEMA", Statement: "\n\nGRANT ALL ON SCHEMA sales_west TO west_user;\n"},
+			{Schema: "public", Name: "public", ObjectType: "SCHEMA", Statement: "\n"},
+			{Schema: "public", Name: "public", ObjectType: "SCHEMA", Statement: "\n\nCOMMENT ON SCHEMA public IS 'Public';\n"},
+			{Schema: "public", Name: "public", ObjectType: "SCHEMA", Statement: "\n\nALTER SCHEMA public OWNER TO public_owner;\n"},
+			{Schema: "public", Name: "public", ObjectType: "SCHEMA", Statement: "\n\nGRANT ALL ON SCHEMA public TO public_user;\n"},
+		}
+		It("keeps every statement if no schemas are redirected", func() {
+			Expect(filterRedirectedSchemaStatements(schemaStatements, nil)).To(Equal(schemaStatements))
+		})
+		It("keeps every statement of a schema that is the only one redirected to its target", func() {
+			redirectMap := NewRedirectMap()
+			redirectMap.AddSchema("sales_east", "sales")
+
+			Expect(filterRedirectedSchemaStatements(schemaStatements, redirectMap)).To(Equal(schemaStatements))
+		})
+		It("creates a schema that several schemas are redirected to once, keeping their other statements", func() {
+			redirectMap := NewRedirectMap()
+			redirectMap.AddSchema("sales_east", "sales")
+			redirectMap.AddSchema("sales_west", "sales")
+			statements := make([]toc.StatementWithType, len(schemaStatements))
+			copy(statements, schemaStatements)
+
+			statements = filterRedirectedSchemaStatements(statements, redirectMap)
+			editStatementsRedirect(statements, redirectMap)
+
+			Expect(statements).To(Equal([]toc.StatementWithType{
+				{Schema: "sales", Name: "sales", ObjectType: "SCHEMA", Statement: "\n\nCREATE SCHEMA sales;\n"},
+				{Schema: "sales", Name: "sales", ObjectType: "SCHEMA", Statement: "\n\nCOMMENT ON SCHEMA sales IS 'East';\n"},
+				{Schema: "sales", Name: "sales", ObjectType: "SCHEMA", Statement: "\n\nALTER SCHEMA sales OWNER TO east_owner;\n"},
+				{Schema: "sales", Name: "sales", ObjectType: "SCHEMA", Statement: "\n\nGRANT ALL ON SCHEMA sales TO west_user;\n"},
+				{Schema: "public", Name: "public", ObjectType: "SCHEMA", Statement: "\n"},
+				{Schema: "public", Name: "public", ObjectType: "SCHEMA", Statement: "\n\nCOMMENT ON SCHEMA public IS 'Public';\n"},
+				{Schema: "public", Name: "public", ObjectType: "SCHEMA", Statement: "\n\nALTER SCHEMA public OWNER TO public_owner;\n"},
+				{Schema: "public", Name: "public", ObjectType: "SCHEMA", Statement: "\n\nGRANT ALL ON SCHEMA public TO public_user;\n"},
+			}))
+		})
+	})
+	Describe("filterRestoredDataEntries", func() {
+		var (
+			mockConn *dbconn.DBConn
+			mockDB   sqlmock.Sqlmock
+			oldConn  *dbconn.DBConn
+		)
+		dataEntries := []toc.MasterDataEntry{
+			{Schema: "public", Name: "restored", RowsCopied: 5},
+			{Schema: "public", Name: "copied", RowsCopied: 5},
+			{Schema: "public", Name: "partial", RowsCopied: 5},
+			{Schema: "public", Name: "unstarted", RowsCopied: 5},
+		}
+		BeforeEach(func() {
+			oldConn = connectionPool
+			mockConn, mockDB = testhelper.CreateAndConnectMockDB(1)
+			connectionPool = mockConn
+			restoreState = NewRestoreState("testdb")
+			restoreState.MarkTableRestored("20190101010101", "public.restored")
+			for _, tableName := range []string{"public.restored", "public.copied", "public.partial"} {
+				restoreState.MarkTableStarted("20190101010101", tableName)
+			}
+		})
+		AfterEach(func() {
+			connectionPool = oldConn
+			restoreState = nil
+		})
+		It("skips restored tables and started tables with all their rows, and truncates only other started tables", func() {
+			mockDB.ExpectQuery(`SELECT count\(\*\) AS string FROM public.copied`).WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("5"))
+			mockDB.ExpectQuery(`SELECT count\(\*\) AS string FROM public.partial`).WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("3"))
+			mockDB.ExpectExec(`TRUNCATE public.partial`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			filteredEntries := filterRestoredDataEntries("20190101010101", dataEntries)
+
+			Expect(filteredEntries).To(Equal(dataEntries[2:]))
+			Expect(restoreState.IsTableRestored("20190101010101", "public.copied")).To(BeTrue())
+			Expect(mockDB.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("filterStatementsByObjectType", func() {
+		gucs := toc.StatementWithType{ObjectType: "SESSION GUCS", Statement: "SET client_encoding = 'UTF8';"}
+		function := toc.StatementWithType{Schema: "public", Name: "add", ObjectType: "FUNCTION", Statement: "CREATE FUNCTION public.add ..."}
+		view := toc.StatementWithType{Schema: "public", Name: "myview", ObjectType: "VIEW", Statement: "CREATE VIEW public.myview ..."}
+		trigger := toc.StatementWithType{Schema: "public", Name: "mytrigger", ObjectType: "TRIGGER", Statement: "CREATE TRIGGER mytrigger ..."}
+		statements := []toc.StatementWithType{gucs, function, view, trigger}
+		var oldOpts *options.Options
+		BeforeEach(func() {
+			oldOpts = opts
+		})
+		AfterEach(func() {
+			opts = oldOpts
+		})
+		It("returns all statements if no object types are given", func() {
+			opts = &options.Options{}
+			Expect(filterStatementsByObjectType(statements)).To(Equal(statements))
+			Expect(restoresObjectType("TABLE")).To(BeTrue())
+		})
+		It("returns only statements of included object types and session GUCs", func() {
+			opts = &options.Options{IncludedObjectTypes: []string{"FUNCTION", "VIEW"}}
+			Expect(filterStatementsByObjectType(statements)).To(Equal([]toc.StatementWithType{gucs, function, view}))
+			Expect(restoresObjectType("TABLE")).To(BeFalse())
+		})
+		It("returns all statements except those of excluded object types", func() {
+			opts = &options.Options{ExcludedObjectTypes: []string{"TRIGGER"}}
+			Expect(filterStatementsByObjectType(statements)).To(Equal([]toc.StatementWithType{gucs, function, view}))
+			Expect(restoresObjectType("TABLE")).To(BeTrue())
+		})
+	})
+	Describe("editStatementsRoleAndTablespace", func() {
+		table := toc.StatementWithType{Schema: "public", Name: "foo", ObjectType: "TABLE", Tablespace: "prod_space",
+			Statement: "\n\nCREATE TABLE public.foo (\n\ti integer\n) TABLESPACE prod_space DISTRIBUTED BY (i);\n"}
+		owner := toc.StatementWithType{Schema: "public", Name: "foo", ObjectType: "TABLE", Clause: toc.CLAUSE_OWNER,
+			Statement: "\n\nALTER TABLE public.foo OWNER TO prod_owner;\n"}
+		privileges := toc.StatementWithType{Schema: "public", Name: "foo", ObjectType: "TABLE", Clause: toc.CLAUSE_PRIVILEGES,
+			Statement: "\n\nREVOKE ALL ON TABLE public.foo FROM prod_owner;\nGRANT ALL ON TABLE public.foo TO prod_owner;\n"}
+		comment := toc.StatementWithType{Schema: "public", Name: "foo", ObjectType: "TABLE",
+			Statement: "\n\nCOMMENT ON TABLE public.foo IS 'OWNER TO prod_owner';\n"}
+		role := toc.StatementWithType{Name: "prod_owner", ObjectType: "ROLE", Statement: "\n\nCREATE ROLE prod_owner;\n"}
+		membership := toc.StatementWithType{Name: "prod_owner", ObjectType: "ROLE GRANT", Statement: "\nGRANT readers TO prod_owner GRANTED BY prod_owner;"}
+		tablespace := toc.StatementWithType{Name: "prod_space", ObjectType: "TABLESPACE", Statement: "\n\nCREATE TABLESPACE prod_space LOCATION '/data';"}
+		statements := []toc.StatementWithType{tablespace, role, membership, table, owner, privileges, comment}
+
+		It("returns the statements unchanged if no option is set", func() {
+			Expect(editStatementsRoleAndTablespace(statements, nil, nil, false, false)).To(Equal(statements))
+		})
+		It("leaves out owner and privileges statements", func() {
+			Expect(editStatementsRoleAndTablespace(statements, nil, nil, true, false)).To(Equal([]toc.StatementWithType{tablespace, role, membership, table, privileges, comment}))
+			Expect(editStatementsRoleAndTablespace(statements, nil, nil, false, true)).To(Equal([]toc.StatementWithType{tablespace, role, membership, table, owner, comment}))
+		})
+		It("maps roles and tablespaces in the statements marked as naming them, leaving out the mapped roles and tablespaces", func() {
+			roleMap := NameMap{"prod_owner": "qa_owner"}
+			tablespaceMap := NameMap{"prod_space": "qa_space"}
+
+			edited := editStatementsRoleAndTablespace(statements, roleMap, tablespaceMap, false, false)
+
+			Expect(edited).To(HaveLen(5))
+			Expect(edited[0].Statement).To(Equal("\nGRANT readers TO qa_owner GRANTED BY qa_owner;"))
+			Expect(edited[1].Statement).To(Equal("\n\nCREATE TABLE public.foo (\n\ti integer\n) TABLESPACE qa_space DISTRIBUTED BY (i);\n"))
+			Expect(edited[2].Statement).To(Equal("\n\nALTER TABLE public.foo OWNER TO qa_owner;\n"))
+			Expect(edited[3].Statement).To(Equal("\n\nREVOKE ALL ON TABLE public.foo FROM qa_owner;\nGRANT ALL ON TABLE public.foo TO qa_owner;\n"))
+			Expect(edited[4]).To(Equal(comment))
+		})
 	})
 })
@@ -0,0 +1,117 @@
+package restore
+
+/*
+ * This file contains structs and functions related to writing the statements
+ * of a restore to a SQL file with --to-sql-file instead of executing them.
+ *
+ * The file is a psql script.  Statements that a restore would run on its
+ * connection to the postgres database, such as those creating globals or the
+ * restore database, come first, followed by a \connect to the restore
+ * database and the statements that would be run there.  Table data is
+ * restored by COPY commands that read the backup's data files on the segments.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+type SQLFile struct {
+	Filename string
+	file     *os.File
+	writer   *bufio.Writer
+	lock     sync.Mutex
+}
+
+func NewSQLFile(filename string) (*SQLFile, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLFile{Filename: filename, file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+/*
+ * Writes the statement to the file and returns true, or returns false if no
+ * SQL file is being written, in which case the caller executes the statement.
+ */
+func (sqlFile *SQLFile) WriteStatement(statement string) bool {
+	if sqlFile == nil {
+		return false
+	}
+	sqlFile.lock.Lock()
+	defer sqlFile.lock.Unlock()
+	_, err := fmt.Fprintf(sqlFile.writer, "%s\n\n", strings.TrimSpace(statement))
+	gplog.FatalOnError(err, fmt.Sprintf("Unable to write to SQL file %s", sqlFile.Filename))
+	return true
+}
+
+func (sqlFile *SQLFile) WriteConnect(quotedDBName string) {
+	sqlFile.WriteStatement(fmt.Sprintf(`\connect %s`, quotedDBName))
+}
+
+func (sqlFile *SQLFile) Close() error {
+	if sqlFile == nil {
+		return nil
+	}
+	sqlFile.lock.Lock()
+	defer sqlFile.lock.Unlock()
+	err := sqlFile.writer.Flush()
+	closeErr := sqlFile.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func initializeSQLFile(filename string) {
+	var err error
+	restoreSQLFile, err = NewSQLFile(filename)
+	gplog.FatalOnError(err, fmt.Sprintf("Unable to create SQL file %s", filename))
+	gplog.Info("Writing restore statements to %s instead of executing them", filename)
+	restoreSQLFile.WriteStatement(fmt.Sprintf("-- Restore of backup %s of database %s, written by gprestore %s",
+		globalFPInfo.Timestamp, utils.UnquoteIdent(backupConfig.DatabaseName), version))
+	if !MustGetFlagBool(options.ON_ERROR_CONTINUE) {
+		restoreSQLFile.WriteStatement(`\set ON_ERROR_STOP on`)
+	}
+}
+
+/*
+ * An incomplete SQL file is removed so that it is not run by mistake.
+ */
+func closeSQLFile(restoreFailed bool) {
+	if restoreSQLFile == nil {
+		return
+	}
+	err := restoreSQLFile.Close()
+	if err != nil {
+		gplog.Warn("Unable to close SQL file %s: %v", restoreSQLFile.Filename, err)
+	}
+	if restoreFailed || gplog.GetErrorCode() != 0 {
+		_ = os.Remove(restoreSQLFile.Filename)
+		gplog.Warn("Removed incomplete SQL file %s", restoreSQLFile.Filename)
+	}
+}
+
+func validateSQLFileBackup() {
+	if MustGetFlagString(options.TO_SQL_FILE) == "" || backupConfig.MetadataOnly || MustGetFlagBool(options.METADATA_ONLY) {
+		return
+	}
+	/*
+	 * The COPY commands in the file must be able to read the data files after
+	 * gprestore exits, so the helper processes of single-data-file restores,
+	 * the temporary key of encrypted restores, and plugin restores are ruled out.
+	 */
+	if backupConfig.SingleDataFile || backupConfig.Encrypted || backupConfig.Plugin != "" {
+		gplog.Fatal(errors.Errorf("Cannot write the data of a single-data-file, encrypted, or plugin backup to a SQL file.  Use --%s to write only its metadata.",
+			options.METADATA_ONLY), "")
+	}
+}
@@ -0,0 +1,44 @@
+package restore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gpbackup/restore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/sql_file tests", func() {
+	var tempDir string
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "sql_file")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	It("writes statements separated by blank lines", func() {
+		sqlFilename := filepath.Join(tempDir, "restore.sql")
+		sqlFile, err := restore.NewSQLFile(sqlFilename)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(sqlFile.WriteStatement("\n\nCREATE DATABASE testdb;\n")).To(BeTrue())
+		sqlFile.WriteConnect(`"test db"`)
+		Expect(sqlFile.WriteStatement("\n\nCREATE TABLE public.foo (i int);")).To(BeTrue())
+		Expect(sqlFile.Close()).To(Succeed())
+
+		contents, err := ioutil.ReadFile(sqlFilename)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(contents)).To(Equal("CREATE DATABASE testdb;\n\n\\connect \"test db\"\n\nCREATE TABLE public.foo (i int);\n\n"))
+	})
+	It("does not write statements when no SQL file is being written", func() {
+		var sqlFile *restore.SQLFile
+
+		Expect(sqlFile.WriteStatement("CREATE TABLE public.foo (i int);")).To(BeFalse())
+		Expect(sqlFile.Close()).To(Succeed())
+	})
+})
@@ -0,0 +1,338 @@
+package restore
+
+/*
+ * This file contains structs and functions related to recording the progress
+ * of a restore in a state file, so that a failed restore can be resumed with
+ * --resume without restoring the same objects and tables again.
+ *
+ * The state file is written next to the restore report.  Its first line names
+ * the database being restored to, and a line is appended for each metadata
+ * statement and each table whose data is restored, as well as for each table
+ * before its COPY starts, so the progress recorded survives the restore being
+ * killed.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	path "path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	stateDatabasePrefix  = "database "
+	stateStatementPrefix = "statement "
+	stateTablePrefix     = "table "
+	stateStartedPrefix   = "started "
+)
+
+type RestoreState struct {
+	Database           string
+	RestoredStatements map[string]bool
+	RestoredTables     map[string]bool
+	StartedTables      map[string]bool
+	file               *os.File
+	lock               sync.Mutex
+}
+
+func NewRestoreState(database string) *RestoreState {
+	return &RestoreState{
+		Database:           database,
+		RestoredStatements: make(map[string]bool),
+		RestoredTables:     make(map[string]bool),
+		StartedTables:      make(map[string]bool),
+	}
+}
+
+func ReadRestoreStateFile(filename string) (*RestoreState, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	state := NewRestoreState("")
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, stateDatabasePrefix):
+			state.Database = strings.TrimPrefix(line, stateDatabasePrefix)
+		case strings.HasPrefix(line, stateStatementPrefix):
+			state.RestoredStatements[strings.TrimPrefix(line, stateStatementPrefix)] = true
+		case strings.HasPrefix(line, stateTablePrefix):
+			state.RestoredTables[strings.TrimPrefix(line, stateTablePrefix)] = true
+		case strings.HasPrefix(line, stateStartedPrefix):
+			state.StartedTables[strings.TrimPrefix(line, stateStartedPrefix)] = true
+		}
+	}
+	return state, scanner.Err()
+}
+
+/*
+ * Writes the progress recorded so far to a new state file, which is kept open
+ * so that further progress can be appended to it.
+ */
+func (state *RestoreState) Open(filename string) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	_, _ = writer.WriteString(stateDatabasePrefix + state.Database + "\n")
+	for _, key := range sortedKeys(state.RestoredStatements) {
+		_, _ = writer.WriteString(stateStatementPrefix + key + "\n")
+	}
+	for _, key := range sortedKeys(state.RestoredTables) {
+		_, _ = writer.WriteString(stateTablePrefix + key + "\n")
+	}
+	for _, key := range sortedKeys(state.StartedTables) {
+		_, _ = writer.WriteString(stateStartedPrefix + key + "\n")
+	}
+	err = writer.Flush()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	state.file = file
+	return nil
+}
+
+func (state *RestoreState) Close() {
+	if state == nil {
+		return
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.file != nil {
+		_ = state.file.Close()
+		state.file = nil
+	}
+}
+
+/*
+ * Once a restore completes, there is nothing left to resume, so the state
+ * file is removed.
+ */
+func (state *RestoreState) Remove() {
+	if state == nil {
+		return
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.file != nil {
+		filename := state.file.Name()
+		_ = state.file.Close()
+		state.file = nil
+		err := os.Remove(filename)
+		if err != nil {
+			gplog.Warn("Unable to remove restore state file %s: %v", filename, err)
+		}
+	}
+}
+
+func (state *RestoreState) appendLine(line string) {
+	if state.file == nil {
+		return
+	}
+	_, err := state.file.WriteString(line + "\n")
+	if err != nil {
+		gplog.Warn("Unable to record restore progress in state file %s: %v", state.file.Name(), err)
+	}
+}
+
+/*
+ * Different objects can have identical statements, such as the same comment or
+ * privileges on two objects, so the key identifies the object as well.
+ */
+func getStatementKey(statement toc.StatementWithType) string {
+	keyFields := []string{statement.ObjectType, statement.Schema, statement.Name, statement.ReferenceObject, statement.Statement}
+	checksum, _ := utils.GetChecksum(strings.NewReader(strings.Join(keyFields, "\x00")))
+	return checksum
+}
+
+func getTableKey(timestamp string, tableName string) string {
+	return fmt.Sprintf("%s %s", timestamp, tableName)
+}
+
+func (state *RestoreState) MarkStatementRestored(statement toc.StatementWithType) {
+	if state == nil {
+		return
+	}
+	key := getStatementKey(statement)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if !state.RestoredStatements[key] {
+		state.RestoredStatements[key] = true
+		state.appendLine(stateStatementPrefix + key)
+	}
+}
+
+func (state *RestoreState) MarkTableRestored(timestamp string, tableName string) {
+	if state == nil {
+		return
+	}
+	key := getTableKey(timestamp, tableName)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if !state.RestoredTables[key] {
+		state.RestoredTables[key] = true
+		state.appendLine(stateTablePrefix + key)
+	}
+}
+
+/*
+ * A table is recorded as started before it is truncated or its COPY starts, so
+ * that a resumed restore only truncates tables that this restore wrote to.
+ */
+func (state *RestoreState) MarkTableStarted(timestamp string, tableName string) {
+	if state == nil {
+		return
+	}
+	key := getTableKey(timestamp, tableName)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if !state.StartedTables[key] {
+		state.StartedTables[key] = true
+		state.appendLine(stateStartedPrefix + key)
+	}
+}
+
+func (state *RestoreState) IsTableStarted(timestamp string, tableName string) bool {
+	if state == nil {
+		return false
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.StartedTables[getTableKey(timestamp, tableName)]
+}
+
+func (state *RestoreState) IsTableRestored(timestamp string, tableName string) bool {
+	if state == nil {
+		return false
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.RestoredTables[getTableKey(timestamp, tableName)]
+}
+
+func (state *RestoreState) FilterRestoredStatements(statements []toc.StatementWithType) []toc.StatementWithType {
+	if state == nil {
+		return statements
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	filteredStatements := make([]toc.StatementWithType, 0, len(statements))
+	for _, statement := range statements {
+		if !state.RestoredStatements[getStatementKey(statement)] {
+			filteredStatements = append(filteredStatements, statement)
+		}
+	}
+	if numSkipped := len(statements) - len(filteredStatements); numSkipped > 0 {
+		gplog.Verbose("Skipping %d statements that were already restored", numSkipped)
+	}
+	return filteredStatements
+}
+
+func sortedKeys(keys map[string]bool) []string {
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+	return sortedKeys
+}
+
+/*
+ * With --resume, the progress of the most recent restore of this backup into
+ * the same database is read from its state file and carried over to the state
+ * file of this restore.
+ */
+func initializeRestoreState(database string) {
+	state := NewRestoreState(database)
+	resumeStateFilename := ""
+	if MustGetFlagBool(options.RESUME) {
+		resumeStateFilename = findRestoreStateFile(database)
+		if resumeStateFilename == "" {
+			gplog.Fatal(errors.Errorf("No failed restore of backup with timestamp %s into database %s was found to resume",
+				globalFPInfo.Timestamp, database), "")
+		}
+		gplog.Info("Resuming restore using state file %s", resumeStateFilename)
+		var err error
+		state, err = ReadRestoreStateFile(resumeStateFilename)
+		gplog.FatalOnError(err)
+	}
+	err := state.Open(globalFPInfo.GetRestoreStateFilePath(restoreStartTime))
+	gplog.FatalOnError(err)
+	if resumeStateFilename != "" {
+		// The progress of the failed restore is now in the state file of this restore
+		err = os.Remove(resumeStateFilename)
+		if err != nil {
+			gplog.Warn("Unable to remove restore state file %s: %v", resumeStateFilename, err)
+		}
+	}
+	restoreState = state
+}
+
+func findRestoreStateFile(database string) string {
+	stateFilenames, err := path.Glob(globalFPInfo.GetRestoreStateFilePath("*"))
+	gplog.FatalOnError(err)
+	// Restore timestamps sort chronologically, so check the most recent restore first
+	sort.Sort(sort.Reverse(sort.StringSlice(stateFilenames)))
+	for _, stateFilename := range stateFilenames {
+		state, err := ReadRestoreStateFile(stateFilename)
+		if err != nil {
+			gplog.Warn("Unable to read restore state file %s: %v", stateFilename, err)
+			continue
+		}
+		if state.Database == database {
+			return stateFilename
+		}
+	}
+	return ""
+}
+
+/*
+ * Tables whose data was restored by the failed restore are skipped, as are
+ * tables whose COPY was started and that already contain the number of rows
+ * that were backed up, in case the restore was killed before their progress
+ * was recorded.  Any other started table that contains rows is truncated
+ * before its data is restored again.  Tables whose COPY never started are
+ * left as they are, so rows that were already in them are kept.
+ */
+func filterRestoredDataEntries(timestamp string, dataEntries []toc.MasterDataEntry) []toc.MasterDataEntry {
+	filteredEntries := make([]toc.MasterDataEntry, 0, len(dataEntries))
+	for _, entry := range dataEntries {
+		tableName := getDataRestoreTableName(entry)
+		if restoreState.IsTableRestored(timestamp, tableName) {
+			continue
+		}
+		if restoreState.IsTableStarted(timestamp, tableName) {
+			rowCount := dbconn.MustSelectString(connectionPool, fmt.Sprintf("SELECT count(*) AS string FROM %s", tableName))
+			if rowCount == strconv.FormatInt(entry.RowsCopied, 10) {
+				restoreState.MarkTableRestored(timestamp, tableName)
+				continue
+			}
+			if rowCount != "0" {
+				err := TruncateTable(tableName)
+				gplog.FatalOnError(err)
+			}
+		}
+		filteredEntries = append(filteredEntries, entry)
+	}
+	if numSkipped := len(dataEntries) - len(filteredEntries); numSkipped > 0 {
+		gplog.Info("Skipping data restore of %d tables from backup with timestamp %s that were already restored", numSkipped, timestamp)
+	}
+	return filteredEntries
+}
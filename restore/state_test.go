@@ -0,0 +1,96 @@
+package restore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/toc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/state tests", func() {
+	var (
+		tempDir   string
+		statement = toc.StatementWithType{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: "CREATE TABLE public.foo (i int);"}
+		other     = toc.StatementWithType{Schema: "public", Name: "bar", ObjectType: "TABLE", Statement: "CREATE TABLE public.bar (i int);"}
+	)
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "restore_state")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	It("records restored statements and tables in the state file as they are restored", func() {
+		stateFilename := filepath.Join(tempDir, "state")
+		state := restore.NewRestoreState("testdb")
+		Expect(state.Open(stateFilename)).To(Succeed())
+		state.MarkStatementRestored(statement)
+		state.MarkTableRestored("20190101010101", "public.foo")
+
+		readState, err := restore.ReadRestoreStateFile(stateFilename)
+		state.Close()
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readState.Database).To(Equal("testdb"))
+		Expect(readState.IsTableRestored("20190101010101", "public.foo")).To(BeTrue())
+		Expect(readState.IsTableRestored("20190102010101", "public.foo")).To(BeFalse())
+		Expect(readState.FilterRestoredStatements([]toc.StatementWithType{statement, other})).To(Equal([]toc.StatementWithType{other}))
+	})
+	It("records tables whose data restore was started in the state file", func() {
+		stateFilename := filepath.Join(tempDir, "state")
+		state := restore.NewRestoreState("testdb")
+		Expect(state.Open(stateFilename)).To(Succeed())
+		state.MarkTableStarted("20190101010101", "public.foo")
+		state.Close()
+
+		readState, err := restore.ReadRestoreStateFile(stateFilename)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readState.IsTableStarted("20190101010101", "public.foo")).To(BeTrue())
+		Expect(readState.IsTableRestored("20190101010101", "public.foo")).To(BeFalse())
+		Expect(readState.IsTableStarted("20190101010101", "public.bar")).To(BeFalse())
+	})
+	It("distinguishes identical statements for different objects", func() {
+		state := restore.NewRestoreState("testdb")
+		fooComment := toc.StatementWithType{Schema: "public", Name: "foo", ObjectType: "COMMENT", Statement: "COMMENT ON TABLE foo IS 'comment';"}
+		otherComment := toc.StatementWithType{Schema: "other", Name: "foo", ObjectType: "COMMENT", Statement: "COMMENT ON TABLE foo IS 'comment';"}
+		state.MarkStatementRestored(fooComment)
+
+		Expect(state.FilterRestoredStatements([]toc.StatementWithType{fooComment, otherComment})).To(Equal([]toc.StatementWithType{otherComment}))
+	})
+	It("carries the progress of a previous restore over to a new state file", func() {
+		oldStateFilename := filepath.Join(tempDir, "old_state")
+		newStateFilename := filepath.Join(tempDir, "new_state")
+		state := restore.NewRestoreState("testdb")
+		Expect(state.Open(oldStateFilename)).To(Succeed())
+		state.MarkTableRestored("20190101010101", "public.foo")
+		state.Close()
+
+		resumedState, err := restore.ReadRestoreStateFile(oldStateFilename)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resumedState.Open(newStateFilename)).To(Succeed())
+		resumedState.MarkTableRestored("20190101010101", "public.bar")
+		resumedState.Close()
+
+		readState, err := restore.ReadRestoreStateFile(newStateFilename)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readState.IsTableRestored("20190101010101", "public.foo")).To(BeTrue())
+		Expect(readState.IsTableRestored("20190101010101", "public.bar")).To(BeTrue())
+	})
+	It("removes the state file", func() {
+		stateFilename := filepath.Join(tempDir, "state")
+		state := restore.NewRestoreState("testdb")
+		Expect(state.Open(stateFilename)).To(Succeed())
+
+		state.Remove()
+
+		_, err := os.Stat(stateFilename)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
@@ -8,6 +8,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
@@ -22,6 +23,71 @@ func validateFilterListsInBackupSet() {
 	ValidateExcludeSchemasInBackupSet(opts.ExcludedSchemas)
 	ValidateIncludeRelationsInBackupSet(opts.IncludedRelations)
 	ValidateExcludeRelationsInBackupSet(opts.ExcludedRelations)
+	ValidateObjectTypesInBackupSet(append(opts.IncludedObjectTypes, opts.ExcludedObjectTypes...))
+}
+
+/*
+ * An object type may be missing because the backup excluded it, or because the
+ * backed-up database had no objects of that type, so this only warns.
+ */
+func ValidateObjectTypesInBackupSet(objectTypes []string) {
+	if len(objectTypes) == 0 || backupConfig.DataOnly {
+		return
+	}
+	objectTypeMap := make(map[string]bool, len(objectTypes))
+	for _, objectType := range objectTypes {
+		objectTypeMap[objectType] = true
+	}
+	for _, entries := range [][]toc.MetadataEntry{globalTOC.GlobalEntries, globalTOC.PredataEntries, globalTOC.PostdataEntries, globalTOC.StatisticsEntries} {
+		for _, entry := range entries {
+			delete(objectTypeMap, entry.ObjectType)
+		}
+	}
+	missingTypes := make([]string, 0)
+	for _, objectType := range objectTypes {
+		if objectTypeMap[objectType] {
+			missingTypes = append(missingTypes, objectType)
+			delete(objectTypeMap, objectType)
+		}
+	}
+	if len(missingTypes) > 0 {
+		gplog.Warn("Could not find objects of the following type(s) in the backup set: %s", strings.Join(missingTypes, ", "))
+	}
+	if len(backupConfig.ExcludeObjectTypes) > 0 {
+		gplog.Verbose("Objects of the following type(s) were excluded from the backup: %s", strings.Join(backupConfig.ExcludeObjectTypes, ", "))
+	}
+}
+
+/*
+ * Patterns are matched against the schemas and relations in the TOC, so they
+ * select the same objects whatever is in the restore database, and the names
+ * they match are taken from the TOC in the quoted form the filters compare.
+ */
+func expandFilterPatternsInBackupSet() {
+	if !opts.HasFilterPatterns() {
+		return
+	}
+	gplog.Verbose("Expanding filter patterns against the backup set")
+	schemas := make(map[string]string)
+	relations := make(map[string]string)
+	addRelation := func(schema string, name string) {
+		relations[utils.UnquoteIdent(schema)+"."+utils.UnquoteIdent(name)] = utils.MakeFQN(schema, name)
+	}
+	for _, entry := range globalTOC.PredataEntries {
+		if entry.Schema != "" {
+			schemas[utils.UnquoteIdent(entry.Schema)] = entry.Schema
+		}
+		if entry.ObjectType == "TABLE" || entry.ObjectType == "SEQUENCE" || entry.ObjectType == "VIEW" || entry.ObjectType == "MATERIALIZED VIEW" {
+			addRelation(entry.Schema, entry.Name)
+		}
+	}
+	for _, entry := range globalTOC.DataEntries {
+		schemas[utils.UnquoteIdent(entry.Schema)] = entry.Schema
+		addRelation(entry.Schema, entry.Name)
+	}
+
+	err := opts.ExpandFilterPatterns(cmdFlags, schemas, relations)
+	gplog.FatalOnError(err)
 }
 
 func ValidateIncludeSchemasInBackupSet(schemaList []string) {
@@ -225,9 +291,6 @@ END AS string;`, utils.EscapeSingleQuotes(unquotedDBName))
 }
 
 func ValidateBackupFlagCombinations() {
-	if backupConfig.SingleDataFile && MustGetFlagInt(options.JOBS) != 1 {
-		gplog.Fatal(errors.Errorf("Cannot use jobs flag when restoring backups with a single data file per segment."), "")
-	}
 	if (backupConfig.IncludeTableFiltered || backupConfig.DataOnly) && MustGetFlagBool(options.WITH_GLOBALS) {
 		gplog.Fatal(errors.Errorf("Global metadata is not backed up in table-filtered or data-only backups."), "")
 	}
@@ -238,6 +301,7 @@ func ValidateBackupFlagCombinations() {
 		gplog.Fatal(errors.Errorf("Cannot use metadata-only flag when restoring data-only backup"), "")
 	}
 	validateBackupFlagPluginCombinations()
+	validateSQLFileBackup()
 }
 
 func validateBackupFlagPluginCombinations() {
@@ -256,7 +320,9 @@ func ValidateFlagCombinations(flags *pflag.FlagSet) {
 		options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE, options.EXCLUDE_RELATION, options.EXCLUDE_RELATION_FILE,
 		options.INCLUDE_SCHEMA, options.INCLUDE_SCHEMA_FILE, options.INCLUDE_RELATION, options.INCLUDE_RELATION_FILE)
 	options.CheckExclusiveFlags(flags, options.METADATA_ONLY, options.DATA_ONLY)
+	options.CheckExclusiveFlags(flags, options.INCLUDE_OBJECT_TYPE, options.EXCLUDE_OBJECT_TYPE)
 	options.CheckExclusiveFlags(flags, options.PLUGIN_CONFIG, options.BACKUP_DIR)
+	options.CheckExclusiveFlags(flags, options.ENCRYPTION_KEY_FILE, options.ENCRYPTION_PASSPHRASE_FILE)
 	options.CheckExclusiveFlags(flags,
 		options.TRUNCATE_TABLE, options.REDIRECT_SCHEMA, options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE,
 		options.EXCLUDE_RELATION, options.EXCLUDE_RELATION_FILE, options.INCLUDE_SCHEMA, options.INCLUDE_SCHEMA_FILE)
@@ -266,6 +332,7 @@ func ValidateFlagCombinations(flags *pflag.FlagSet) {
 	}
 	options.CheckExclusiveFlags(flags,
 		options.TRUNCATE_TABLE, options.METADATA_ONLY, options.INCREMENTAL, options.REDIRECT_SCHEMA)
+	options.CheckExclusiveFlags(flags, options.REDIRECT_SCHEMA, options.REDIRECT_MAPPING_FILE)
 	if flags.Changed(options.TRUNCATE_TABLE) &&
 		!(flags.Changed(options.INCLUDE_RELATION) || flags.Changed(options.INCLUDE_RELATION_FILE)) &&
 		!flags.Changed(options.DATA_ONLY) {
@@ -274,4 +341,24 @@ func ValidateFlagCombinations(flags *pflag.FlagSet) {
 	if flags.Changed(options.INCREMENTAL) && !flags.Changed(options.DATA_ONLY) {
 		gplog.Fatal(errors.Errorf("Cannot use --incremental without --data-only"), "")
 	}
+	if flags.Changed(options.VERIFY) {
+		// --verify only reads the backup set, so no option that affects what is restored applies
+		for _, flagName := range []string{options.CREATE_DB, options.DATA_ONLY, options.METADATA_ONLY, options.INCREMENTAL,
+			options.REDIRECT_DB, options.REDIRECT_SCHEMA, options.REDIRECT_MAPPING_FILE, options.TRUNCATE_TABLE, options.WITH_GLOBALS, options.WITH_STATS,
+			options.ON_ERROR_CONTINUE, options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE, options.EXCLUDE_RELATION,
+			options.EXCLUDE_RELATION_FILE, options.INCLUDE_SCHEMA, options.INCLUDE_SCHEMA_FILE, options.INCLUDE_RELATION,
+			options.INCLUDE_RELATION_FILE, options.RESUME, options.TO_SQL_FILE, options.INCLUDE_OBJECT_TYPE, options.EXCLUDE_OBJECT_TYPE,
+			options.ROLE_MAPPING, options.TABLESPACE_MAPPING, options.NO_OWNER, options.NO_PRIVILEGES} {
+			options.CheckExclusiveFlags(flags, options.VERIFY, flagName)
+		}
+	}
+	options.CheckExclusiveFlags(flags, options.LIST, options.USE_LIST, options.VERIFY)
+	// A resumed restore continues into the database that the failed restore created
+	options.CheckExclusiveFlags(flags, options.RESUME, options.CREATE_DB)
+	options.CheckExclusiveFlags(flags, options.RESUME, options.WITH_GLOBALS)
+	options.CheckExclusiveFlags(flags, options.RESUME, options.INCREMENTAL)
+	// Statements are written to a SQL file in order, as if executed on a single connection
+	options.CheckExclusiveFlags(flags, options.TO_SQL_FILE, options.JOBS)
+	options.CheckExclusiveFlags(flags, options.TO_SQL_FILE, options.LIST)
+	options.CheckExclusiveFlags(flags, options.TO_SQL_FILE, options.RESUME)
 }
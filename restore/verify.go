@@ -0,0 +1,171 @@
+package restore
+
+/*
+ * This file contains functions related to verifying the checksums of the
+ * files in a backup set without restoring it.
+ */
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Every mismatch is reported before failing, so that a single run lists all
+ * of the files in the backup set that are missing or corrupt.
+ */
+func DoVerify() {
+	gplog.Info("Verifying checksums of backup set with timestamp %s", globalFPInfo.Timestamp)
+	failures := verifyMetadataChecksums()
+	if !backupConfig.MetadataOnly {
+		for _, entry := range backupConfig.RestorePlan {
+			fpInfo := GetBackupFPInfoForTimestamp(entry.Timestamp)
+			backupTOC := globalTOC
+			if entry.Timestamp != globalFPInfo.Timestamp {
+				backupTOC = toc.NewTOC(fpInfo.GetTOCFilePath())
+			}
+			if len(backupTOC.DataEntries) == 0 {
+				continue
+			}
+			gplog.Info("Verifying data files of backup with timestamp %s", entry.Timestamp)
+			if len(backupTOC.DataChecksums) == 0 {
+				failures = append(failures, fmt.Sprintf("No data file checksums were recorded for backup with timestamp %s", entry.Timestamp))
+			} else if backupConfig.SingleDataFile {
+				failures = append(failures, verifySingleDataFileChecksums(fpInfo, backupTOC)...)
+			} else {
+				failures = append(failures, verifyTableDataFileChecksums(fpInfo, backupTOC)...)
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		for _, failure := range failures {
+			gplog.Error(failure)
+		}
+		gplog.Fatal(errors.Errorf("Backup verification failed with %d error(s)", len(failures)), "")
+	}
+	gplog.Info("All checksums in backup set with timestamp %s are valid", globalFPInfo.Timestamp)
+}
+
+func verifyChecksum(filename string, expectedChecksum string) string {
+	checksum, err := utils.GetFileChecksum(filename)
+	if err != nil {
+		return fmt.Sprintf("Unable to read %s: %v", filename, err)
+	}
+	if checksum != expectedChecksum {
+		return fmt.Sprintf("Checksum mismatch for %s", filename)
+	}
+	return ""
+}
+
+func verifyMetadataChecksums() []string {
+	failures := make([]string, 0)
+	if backupConfig.TOCChecksum == "" {
+		return append(failures, fmt.Sprintf("No checksums were recorded for backup with timestamp %s", globalFPInfo.Timestamp))
+	}
+	if failure := verifyChecksum(globalFPInfo.GetTOCFilePath(), backupConfig.TOCChecksum); failure != "" {
+		failures = append(failures, failure)
+	}
+
+	filenames := make([]string, 0, len(globalTOC.MetadataChecksums))
+	for filename := range globalTOC.MetadataChecksums {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		filePath := path.Join(globalFPInfo.GetDirForContent(-1), filename)
+		if pluginConfig != nil && !utils.FileExists(filePath) {
+			pluginConfig.MustRestoreFile(filePath)
+		}
+		if failure := verifyChecksum(filePath, globalTOC.MetadataChecksums[filename]); failure != "" {
+			failures = append(failures, failure)
+		}
+	}
+	return failures
+}
+
+/*
+ * Each data file is read back on its segment, from disk or using the plugin,
+ * and its checksum is compared against the one recorded in the TOC.
+ */
+func verifyTableDataFileChecksums(fpInfo filepath.FilePathInfo, backupTOC *toc.TOC) []string {
+	oidList := make([]string, 0, len(backupTOC.DataEntries))
+	for _, entry := range backupTOC.DataEntries {
+		oidList = append(oidList, fmt.Sprintf("%d", entry.Oid))
+	}
+	checksums := utils.ComputeDataFileChecksumsOnSegments(globalCluster, fpInfo, oidList, pluginConfig)
+
+	extension := utils.GetPipeThroughProgram().Extension
+	contentIDs := make([]int, 0, len(checksums))
+	for contentID := range checksums {
+		contentIDs = append(contentIDs, contentID)
+	}
+	sort.Ints(contentIDs)
+	failures := make([]string, 0)
+	for _, contentID := range contentIDs {
+		for _, entry := range backupTOC.DataEntries {
+			filename := fpInfo.GetTableBackupFilePath(contentID, entry.Oid, extension, false)
+			expectedChecksum, ok := backupTOC.DataChecksums[contentID][entry.Oid]
+			if !ok {
+				failures = append(failures, fmt.Sprintf("No checksum was recorded for %s", filename))
+			} else if checksums[contentID][entry.Oid] != expectedChecksum {
+				failures = append(failures, fmt.Sprintf("Checksum mismatch for %s on segment %d", filename, contentID))
+			}
+		}
+	}
+	return failures
+}
+
+/*
+ * The single data file on each segment is checked by gpbackup_helper, which
+ * verifies each table's data against the segment TOC and outputs the checksum
+ * of the whole file to compare against the one recorded in the TOC.
+ */
+func verifySingleDataFileChecksums(fpInfo filepath.FilePathInfo, backupTOC *toc.TOC) []string {
+	gphome := operating.System.Getenv("GPHOME")
+	optionalArgs := ""
+	if pluginConfig != nil {
+		optionalArgs += fmt.Sprintf(" --plugin-config %s", pluginConfig.ConfigPath)
+	}
+	if utils.IsEncryptionEnabled() {
+		optionalArgs += fmt.Sprintf(" --encryption-key-file %s", utils.GetEncryptionKeyFilePath())
+	}
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Verifying single data files", func(contentID int) string {
+		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
+		dataFile := fpInfo.GetTableBackupFilePath(contentID, 0, utils.GetPipeThroughProgram().Extension, true)
+		return fmt.Sprintf("source %[1]s/greenplum_path.sh && %[1]s/bin/gpbackup_helper --verify-agent --toc-file %s --data-file %s --content %d%s",
+			gphome, tocFile, dataFile, contentID, optionalArgs)
+	}, cluster.ON_SEGMENTS)
+
+	failures := make([]string, 0)
+	for _, contentID := range sortedContentIDs(remoteOutput.Stdouts) {
+		dataFile := fpInfo.GetTableBackupFilePath(contentID, 0, utils.GetPipeThroughProgram().Extension, true)
+		if remoteOutput.Errors[contentID] != nil {
+			failures = append(failures, fmt.Sprintf("Unable to verify %s on segment %d: %s", dataFile, contentID, strings.TrimSpace(remoteOutput.Stderrs[contentID])))
+			continue
+		}
+		if strings.TrimSpace(remoteOutput.Stdouts[contentID]) != backupTOC.DataChecksums[contentID][0] {
+			failures = append(failures, fmt.Sprintf("Checksum mismatch for %s on segment %d", dataFile, contentID))
+		}
+	}
+	return failures
+}
+
+func sortedContentIDs(outputs map[int]string) []int {
+	contentIDs := make([]int, 0, len(outputs))
+	for contentID := range outputs {
+		contentIDs = append(contentIDs, contentID)
+	}
+	sort.Ints(contentIDs)
+	return contentIDs
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 )
 
 /*
@@ -52,7 +53,8 @@ func filtersEmpty(filters Filters) bool {
 }
 
 func SetLoggerVerbosity() {
-	if MustGetFlagBool(options.QUIET) {
+	// --list prints the list to stdout, where it should not be mixed with log messages
+	if MustGetFlagBool(options.QUIET) || MustGetFlagBool(options.LIST) {
 		gplog.SetVerbosity(gplog.LOGERROR)
 	} else if MustGetFlagBool(options.DEBUG) {
 		gplog.SetVerbosity(gplog.LOGDEBUG)
@@ -69,6 +71,13 @@ func CreateConnectionPool(unquotedDBName string) {
 
 func InitializeConnectionPool(unquotedDBName string) {
 	CreateConnectionPool(unquotedDBName)
+	setupQuery := getSetupQuery()
+	for i := 0; i < connectionPool.NumConns; i++ {
+		connectionPool.MustExec(setupQuery, i)
+	}
+}
+
+func getSetupQuery() string {
 	setupQuery := `
 SET application_name TO 'gprestore';
 SET search_path TO pg_catalog;
@@ -105,10 +114,7 @@ SET default_with_oids = off;
 		}
 	}
 	setupQuery += SetMaxCsvLineLengthQuery(connectionPool)
-
-	for i := 0; i < connectionPool.NumConns; i++ {
-		connectionPool.MustExec(setupQuery, i)
-	}
+	return setupQuery
 }
 
 func SetMaxCsvLineLengthQuery(connectionPool *dbconn.DBConn) string {
@@ -130,15 +136,46 @@ func SetMaxCsvLineLengthQuery(connectionPool *dbconn.DBConn) string {
 
 func InitializeBackupConfig() {
 	backupConfig = history.ReadConfigFile(globalFPInfo.GetConfigFilePath())
-	utils.InitializePipeThroughParameters(backupConfig.Compressed, 0)
+	utils.InitializePipeThroughParameters(backupConfig.Compressed, backupConfig.CompressionType, 0)
+	initializeEncryption()
 	report.EnsureBackupVersionCompatibility(backupConfig.BackupVersion, version)
 	report.EnsureDatabaseVersionCompatibility(backupConfig.DatabaseVersion, connectionPool.Version)
 }
 
+/*
+ * The key fingerprint is checked before any backup files are read, so that
+ * the wrong key fails fast instead of partway through the restore.
+ */
+func initializeEncryption() {
+	keySource, err := utils.ReadEncryptionKeySource(MustGetFlagString(options.ENCRYPTION_KEY_FILE), MustGetFlagString(options.ENCRYPTION_PASSPHRASE_FILE))
+	gplog.FatalOnError(err)
+	if !backupConfig.Encrypted {
+		if keySource != nil {
+			gplog.Warn("Backup with timestamp %s is not encrypted; the encryption key will be ignored", backupConfig.Timestamp)
+		}
+		return
+	}
+	if keySource == nil {
+		gplog.Fatal(errors.Errorf("Backup with timestamp %s is encrypted.  Please specify --%s or --%s.",
+			backupConfig.Timestamp, options.ENCRYPTION_KEY_FILE, options.ENCRYPTION_PASSPHRASE_FILE), "")
+	}
+	key, err := keySource.DeriveKey(backupConfig.EncryptionSalt)
+	gplog.FatalOnError(err)
+	err = utils.ValidateEncryptionKeyFingerprint(key, backupConfig.EncryptionKeyFingerprint)
+	gplog.FatalOnError(err)
+
+	keyFilePath := utils.MakeEncryptionKeyFilePath(restoreStartTime)
+	utils.InitializeEncryption(key, keyFilePath)
+	if !backupConfig.MetadataOnly {
+		utils.CopyEncryptionKeyToAllHosts(globalCluster, keyFilePath)
+	}
+}
+
 func BackupConfigurationValidation() {
 	if !backupConfig.MetadataOnly {
 		gplog.Verbose("Gathering information on backup directories")
 		VerifyBackupDirectoriesExistOnAllHosts()
+		utils.CheckCompressionProgramExistsOnAllHosts(globalCluster, utils.GetPipeThroughProgram())
 	}
 
 	VerifyMetadataFilePaths(MustGetFlagBool(options.WITH_STATS))
@@ -154,6 +191,7 @@ func BackupConfigurationValidation() {
 
 	ValidateBackupFlagCombinations()
 
+	expandFilterPatternsInBackupSet()
 	validateFilterListsInBackupSet()
 }
 
@@ -225,7 +263,14 @@ func FindHistoricalPluginVersion(timestamp string) string {
 		gplog.FatalOnError(err)
 		foundBackupConfig := hist.FindBackupConfig(timestamp)
 		if foundBackupConfig != nil {
-			historicalPluginVersion = foundBackupConfig.PluginVersion
+			// The backup may be a replica made using a different plugin than the one it was taken with
+			pluginBinaryName := ""
+			destination := ""
+			if pluginConfig != nil {
+				pluginBinaryName = path.Base(pluginConfig.ExecutablePath)
+				destination = pluginConfig.GetDestination()
+			}
+			historicalPluginVersion = foundBackupConfig.GetPluginVersion(pluginBinaryName, destination)
 		}
 	}
 	return historicalPluginVersion
@@ -242,7 +287,7 @@ func GetRestoreMetadataStatements(section string, filename string, includeObject
 }
 
 func GetRestoreMetadataStatementsFiltered(section string, filename string, includeObjectTypes []string, excludeObjectTypes []string, filters Filters) []toc.StatementWithType {
-	metadataFile := iohelper.MustOpenFileForReading(filename)
+	metadataFile := utils.MustOpenFileForReadingAt(filename)
 	var statements []toc.StatementWithType
 	var inSchemas, exSchemas, inRelations, exRelations []string
 	if !filtersEmpty(filters) {
@@ -273,6 +318,30 @@ func GetRestoreMetadataStatementsFiltered(section string, filename string, inclu
 	return statements
 }
 
+/*
+ * Session GUCs are restored regardless of --include-object-type and
+ * --exclude-object-type, as the other statements of a section rely on them.
+ */
+func filterStatementsByObjectType(statements []toc.StatementWithType) []toc.StatementWithType {
+	if len(opts.IncludedObjectTypes) == 0 && len(opts.ExcludedObjectTypes) == 0 {
+		return statements
+	}
+	filteredStatements := make([]toc.StatementWithType, 0, len(statements))
+	for _, statement := range statements {
+		if statement.ObjectType == "SESSION GUCS" || restoresObjectType(statement.ObjectType) {
+			filteredStatements = append(filteredStatements, statement)
+		}
+	}
+	return filteredStatements
+}
+
+func restoresObjectType(objectType string) bool {
+	if len(opts.IncludedObjectTypes) > 0 {
+		return utils.NewIncludeSet(opts.IncludedObjectTypes).MatchesFilter(objectType)
+	}
+	return utils.NewExcludeSet(opts.ExcludedObjectTypes).MatchesFilter(objectType)
+}
+
 func ExecuteRestoreMetadataStatements(statements []toc.StatementWithType, objectsTitle string, progressBar utils.ProgressBar, showProgressBar int, executeInParallel bool) {
 	if progressBar == nil {
 		ExecuteStatementsAndCreateProgressBar(statements, objectsTitle, showProgressBar, executeInParallel)
@@ -316,6 +385,10 @@ func setGUCsForConnection(gucStatements []toc.StatementWithType, whichConn int)
 func RestoreSchemas(schemaStatements []toc.StatementWithType, progressBar utils.ProgressBar) {
 	numErrors := 0
 	for _, schema := range schemaStatements {
+		if restoreSQLFile.WriteStatement(schema.Statement) {
+			progressBar.Increment()
+			continue
+		}
 		_, err := connectionPool.Exec(schema.Statement, 0)
 		if err != nil {
 			if strings.Contains(err.Error(), "already exists") {
@@ -329,6 +402,8 @@ func RestoreSchemas(schemaStatements []toc.StatementWithType, progressBar utils.
 					gplog.Fatal(err, errMsg)
 				}
 			}
+		} else {
+			restoreState.MarkStatementRestored(schema)
 		}
 		progressBar.Increment()
 	}
@@ -370,6 +445,9 @@ func GetExistingSchemas() ([]string, error) {
 
 func TruncateTable(tableFQN string) error {
 	gplog.Verbose("Truncating table %s prior to restoring data", tableFQN)
+	if restoreSQLFile.WriteStatement(fmt.Sprintf("TRUNCATE %s;", tableFQN)) {
+		return nil
+	}
 	_, err := connectionPool.Exec(`TRUNCATE ` + tableFQN)
 	return err
 }
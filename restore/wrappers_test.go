@@ -1,6 +1,8 @@
 package restore_test
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -8,6 +10,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	backupfilepath "github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/restore"
@@ -126,6 +129,31 @@ var _ = Describe("wrapper tests", func() {
 		})
 
 	})
+	Describe("BackupConfigurationValidation", func() {
+		var tempDir string
+		var fpInfo backupfilepath.FilePathInfo
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "encrypted_backup")
+			Expect(err).ToNot(HaveOccurred())
+			fpInfo = backupfilepath.NewFilePathInfo(testutils.SetDefaultSegmentConfiguration(), tempDir, "20170101010101", "gpseg")
+			restore.SetFPInfo(fpInfo)
+			restore.SetBackupConfig(&history.BackupConfig{Timestamp: "20170101010101", MetadataOnly: true, Encrypted: true})
+			Expect(os.MkdirAll(fpInfo.GetDirForContent(-1), 0700)).To(Succeed())
+			for _, filename := range []string{fpInfo.GetConfigFilePath(), fpInfo.GetMetadataFilePath(), fpInfo.GetTOCFilePath()} {
+				Expect(ioutil.WriteFile(filename, []byte("dataentries: []\n"), 0600)).To(Succeed())
+			}
+			utils.SetEncryptionKey(bytes.Repeat([]byte{0x42}, utils.EncryptionKeyLength))
+		})
+		AfterEach(func() {
+			utils.SetEncryptionKey(nil)
+			_ = os.RemoveAll(tempDir)
+		})
+		It("does not read a TOC that is not encrypted from an encrypted backup", func() {
+			defer testhelper.ShouldPanicWithMessage(fmt.Sprintf("File %s is not encrypted, but the backup is encrypted", fpInfo.GetTOCFilePath()))
+			restore.BackupConfigurationValidation()
+		})
+	})
 	Describe("restore history tests", func() {
 		sampleConfigContents := `
 executablepath: /bin/echo
@@ -376,7 +376,7 @@ func AssertBufferContents(entries []toc.MetadataEntry, buffer *Buffer, expected
 
 func ExpectEntry(entries []toc.MetadataEntry, index int, schema, referenceObject, name, objectType string) {
 	Expect(len(entries)).To(BeNumerically(">", index))
-	structmatcher.ExpectStructsToMatchExcluding(entries[index], toc.MetadataEntry{Schema: schema, Name: name, ObjectType: objectType, ReferenceObject: referenceObject, StartByte: 0, EndByte: 0}, "StartByte", "EndByte")
+	structmatcher.ExpectStructsToMatchExcluding(entries[index], toc.MetadataEntry{Schema: schema, Name: name, ObjectType: objectType, ReferenceObject: referenceObject, StartByte: 0, EndByte: 0}, "StartByte", "EndByte", "Clause", "Tablespace")
 }
 
 func ExecuteSQLFile(connectionPool *dbconn.DBConn, filename string) {
@@ -0,0 +1,169 @@
+package toc
+
+/*
+ * This file contains structs and functions related to listing the entries of
+ * a TOC for gprestore --list and selecting entries with gprestore --use-list.
+ *
+ * Each line of a list is an entry of the form
+ *
+ *   <id>; <section>; <object type>; <schema>; <name>; <reference object>
+ *
+ * and lines that are empty or start with a semicolon are comments.  Only the
+ * id is read from a list given to --use-list, so the other fields may be left
+ * as they are when lines are commented out or reordered.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+const TABLE_DATA = "TABLE DATA"
+
+// Sections are listed, and restored, in this order
+var listSections = []string{"global", "predata", "data", "postdata", "statistics"}
+
+type ListEntry struct {
+	ID              int
+	Section         string
+	ObjectType      string
+	Schema          string
+	Name            string
+	ReferenceObject string
+}
+
+func (entry ListEntry) String() string {
+	return fmt.Sprintf("%d; %s; %s; %s; %s; %s", entry.ID, entry.Section, entry.ObjectType, entry.Schema, entry.Name, entry.ReferenceObject)
+}
+
+/*
+ * The data entries of a restore may come from the TOCs of several backups in
+ * a restore plan, so they are passed in instead of taken from this TOC.
+ * Entries are numbered from 1 in the order of listSections.
+ */
+func (toc *TOC) GetListEntries(dataEntries []MasterDataEntry) []ListEntry {
+	listEntries := make([]ListEntry, 0)
+	for _, section := range listSections {
+		if section == "data" {
+			for _, entry := range dataEntries {
+				listEntries = append(listEntries, ListEntry{ID: len(listEntries) + 1, Section: section, ObjectType: TABLE_DATA, Schema: entry.Schema, Name: entry.Name})
+			}
+			continue
+		}
+		for _, entry := range toc.getSectionEntries(section) {
+			listEntries = append(listEntries, ListEntry{ID: len(listEntries) + 1, Section: section, ObjectType: entry.ObjectType,
+				Schema: entry.Schema, Name: entry.Name, ReferenceObject: entry.ReferenceObject})
+		}
+	}
+	return listEntries
+}
+
+func (toc *TOC) getSectionEntries(section string) []MetadataEntry {
+	switch section {
+	case "global":
+		return toc.GlobalEntries
+	case "predata":
+		return toc.PredataEntries
+	case "postdata":
+		return toc.PostdataEntries
+	case "statistics":
+		return toc.StatisticsEntries
+	}
+	return nil
+}
+
+func (toc *TOC) setSectionEntries(section string, entries []MetadataEntry) {
+	switch section {
+	case "global":
+		toc.GlobalEntries = entries
+	case "predata":
+		toc.PredataEntries = entries
+	case "postdata":
+		toc.PostdataEntries = entries
+	case "statistics":
+		toc.StatisticsEntries = entries
+	}
+}
+
+func WriteList(writer io.Writer, header []string, listEntries []ListEntry) error {
+	bufWriter := bufio.NewWriter(writer)
+	for _, line := range header {
+		_, _ = fmt.Fprintf(bufWriter, "; %s\n", line)
+	}
+	_, _ = fmt.Fprintln(bufWriter, ";")
+	_, _ = fmt.Fprintln(bufWriter, "; ID; Section; Object type; Schema; Name; Reference object")
+	_, _ = fmt.Fprintln(bufWriter, ";")
+	for _, entry := range listEntries {
+		_, _ = fmt.Fprintln(bufWriter, entry.String())
+	}
+	return bufWriter.Flush()
+}
+
+// Returns the ids of the entries in a list, in the order in which they appear
+func ReadList(reader io.Reader) ([]int, error) {
+	ids := make([]int, 0)
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		idStr := strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+		id, err := strconv.Atoi(idStr)
+		if err != nil || id < 1 {
+			return nil, errors.Errorf("Invalid entry id %q on line %d of list", idStr, lineNum)
+		}
+		ids = append(ids, id)
+	}
+	return ids, scanner.Err()
+}
+
+/*
+ * Keeps only the metadata entries of this TOC whose ids are given, in the
+ * order of the ids within each section, and returns the FQNs of the tables
+ * whose data entries are given.  The ids are those of GetListEntries for the
+ * same data entries.
+ */
+func (toc *TOC) ApplyList(ids []int, dataEntries []MasterDataEntry) ([]string, error) {
+	listEntries := toc.GetListEntries(dataEntries)
+	sectionOffsets := make(map[string]int)
+	for _, entry := range listEntries {
+		if _, ok := sectionOffsets[entry.Section]; !ok {
+			sectionOffsets[entry.Section] = entry.ID
+		}
+	}
+
+	selectedEntries := make(map[string][]MetadataEntry)
+	selectedTables := make([]string, 0)
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		if id > len(listEntries) {
+			return nil, errors.Errorf("Entry %d is not in the list of entries of this backup, which has %d entries", id, len(listEntries))
+		}
+		if seen[id] {
+			return nil, errors.Errorf("Entry %d is listed more than once", id)
+		}
+		seen[id] = true
+		listEntry := listEntries[id-1]
+		if listEntry.Section == "data" {
+			selectedTables = append(selectedTables, utils.MakeFQN(listEntry.Schema, listEntry.Name))
+			continue
+		}
+		sectionIndex := id - sectionOffsets[listEntry.Section]
+		selectedEntries[listEntry.Section] = append(selectedEntries[listEntry.Section], toc.getSectionEntries(listEntry.Section)[sectionIndex])
+	}
+	for _, section := range listSections {
+		if section != "data" {
+			toc.setSectionEntries(section, selectedEntries[section])
+		}
+	}
+	return selectedTables, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"path"
 	"regexp"
 
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
@@ -11,6 +12,13 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+/*
+ * DataChecksums holds the checksum of each data file, keyed by content ID and
+ * then by table oid; the single data file of a segment is recorded with oid 0.
+ * DataSizes holds the number of bytes of each table's data on each segment,
+ * keyed the same way but always by table oid.  MetadataChecksums is keyed by
+ * the base name of each master metadata file.
+ */
 type TOC struct {
 	metadataEntryMap    map[string]*[]MetadataEntry
 	GlobalEntries       []MetadataEntry
@@ -19,12 +27,30 @@ type TOC struct {
 	StatisticsEntries   []MetadataEntry
 	DataEntries         []MasterDataEntry
 	IncrementalMetadata IncrementalEntries
+	DataChecksums       map[int]map[uint32]string `yaml:",omitempty"`
+	DataSizes           map[int]map[uint32]int64  `yaml:",omitempty"`
+	MetadataChecksums   map[string]string         `yaml:",omitempty"`
 }
 
+/*
+ * When SeekableDataFile is set, the data of each table was compressed
+ * separately and FileStartByte and FileEndByte of its entry give the offsets
+ * of that data in the data file, so that the data of any table can be read
+ * without reading the data before it.  StartByte and EndByte are always the
+ * offsets of the table's data in the uncompressed data.
+ */
 type SegmentTOC struct {
-	DataEntries map[uint]SegmentDataEntry
+	DataEntries      map[uint]SegmentDataEntry
+	DataFileChecksum string `yaml:",omitempty"`
+	SeekableDataFile bool   `yaml:",omitempty"`
 }
 
+/*
+ * Clause is set for the owner and privileges statements of an object, and
+ * Tablespace for statements that create an object in a tablespace other than
+ * the default one, so that gprestore can leave out those statements or map
+ * the roles and tablespaces they name to others.
+ */
 type MetadataEntry struct {
 	Schema          string
 	Name            string
@@ -32,8 +58,15 @@ type MetadataEntry struct {
 	ReferenceObject string
 	StartByte       uint64
 	EndByte         uint64
+	Clause          string `yaml:",omitempty"`
+	Tablespace      string `yaml:",omitempty"`
 }
 
+const (
+	CLAUSE_OWNER      = "OWNER"
+	CLAUSE_PRIVILEGES = "PRIVILEGES"
+)
+
 type MasterDataEntry struct {
 	Schema          string
 	Name            string
@@ -41,15 +74,26 @@ type MasterDataEntry struct {
 	AttributeString string
 	RowsCopied      int64
 	PartitionRoot   string
+	RowFilter       string `yaml:",omitempty"`
 }
 
 type SegmentDataEntry struct {
-	StartByte uint64
-	EndByte   uint64
+	StartByte     uint64
+	EndByte       uint64
+	Checksum      string `yaml:",omitempty"`
+	FileStartByte uint64 `yaml:",omitempty"`
+	FileEndByte   uint64 `yaml:",omitempty"`
 }
 
 type IncrementalEntries struct {
-	AO map[string]AOEntry
+	AO   map[string]AOEntry
+	Heap map[string]HeapEntry `yaml:",omitempty"`
+	/*
+	 * The times at which the statistics of the database were last reset on
+	 * each segment.  Heap entries can only be compared between backups with
+	 * the same reset times, as resetting statistics resets the tuple counters.
+	 */
+	StatsResetTimes string `yaml:",omitempty"`
 }
 
 type AOEntry struct {
@@ -57,9 +101,18 @@ type AOEntry struct {
 	LastDDLTimestamp string
 }
 
+type HeapEntry struct {
+	Relfilenode      uint32
+	TuplesInserted   int64
+	TuplesUpdated    int64
+	TuplesDeleted    int64
+	Size             int64
+	LastDDLTimestamp string
+}
+
 func NewTOC(filename string) *TOC {
 	toc := &TOC{}
-	contents, err := ioutil.ReadFile(filename)
+	contents, err := utils.ReadFileWithDecryption(filename)
 	gplog.FatalOnError(err)
 	err = yaml.Unmarshal(contents, toc)
 	gplog.FatalOnError(err)
@@ -78,7 +131,7 @@ func NewSegmentTOC(filename string) *SegmentTOC {
 func (toc *TOC) WriteToFileAndMakeReadOnly(filename string) {
 	contents, err := yaml.Marshal(toc)
 	gplog.FatalOnError(err)
-	err = utils.WriteToFileAndMakeReadOnly(filename, contents)
+	err = utils.WriteToFileAndMakeReadOnlyWithEncryption(filename, contents)
 	gplog.FatalOnError(err)
 }
 
@@ -96,6 +149,8 @@ type StatementWithType struct {
 	ObjectType      string
 	ReferenceObject string
 	Statement       string
+	Clause          string
+	Tablespace      string
 }
 
 func GetIncludedPartitionRoots(tocDataEntries []MasterDataEntry, includeRelations []string) []string {
@@ -130,7 +185,8 @@ func (toc *TOC) GetSQLStatementForObjectTypes(section string, metadataFile io.Re
 			contents := make([]byte, entry.EndByte-entry.StartByte)
 			_, err := metadataFile.ReadAt(contents, int64(entry.StartByte))
 			gplog.FatalOnError(err)
-			statements = append(statements, StatementWithType{Schema: entry.Schema, Name: entry.Name, ObjectType: entry.ObjectType, ReferenceObject: entry.ReferenceObject, Statement: string(contents)})
+			statements = append(statements, StatementWithType{Schema: entry.Schema, Name: entry.Name, ObjectType: entry.ObjectType, ReferenceObject: entry.ReferenceObject, Statement: string(contents),
+				Clause: entry.Clause, Tablespace: entry.Tablespace})
 		}
 	}
 	return statements
@@ -242,6 +298,33 @@ func RemoveActiveRole(activeUser string, statements []StatementWithType) []State
 	return newStatements
 }
 
+func (toc *TOC) AddDataChecksum(contentID int, oid uint32, checksum string) {
+	if toc.DataChecksums == nil {
+		toc.DataChecksums = make(map[int]map[uint32]string)
+	}
+	if toc.DataChecksums[contentID] == nil {
+		toc.DataChecksums[contentID] = make(map[uint32]string)
+	}
+	toc.DataChecksums[contentID][oid] = checksum
+}
+
+func (toc *TOC) AddDataSize(contentID int, oid uint32, size int64) {
+	if toc.DataSizes == nil {
+		toc.DataSizes = make(map[int]map[uint32]int64)
+	}
+	if toc.DataSizes[contentID] == nil {
+		toc.DataSizes[contentID] = make(map[uint32]int64)
+	}
+	toc.DataSizes[contentID][oid] = size
+}
+
+func (toc *TOC) AddMetadataChecksum(filename string, checksum string) {
+	if toc.MetadataChecksums == nil {
+		toc.MetadataChecksums = make(map[string]string)
+	}
+	toc.MetadataChecksums[path.Base(filename)] = checksum
+}
+
 func (toc *TOC) InitializeMetadataEntryMap() {
 	toc.metadataEntryMap = make(map[string]*[]MetadataEntry, 4)
 	toc.metadataEntryMap["global"] = &toc.GlobalEntries
@@ -265,11 +348,33 @@ func (toc *TOC) AddMetadataEntry(section string, entry MetadataEntry, start, end
 	*toc.metadataEntryMap[section] = append(*toc.metadataEntryMap[section], entry)
 }
 
-func (toc *TOC) AddMasterDataEntry(schema string, name string, oid uint32, attributeString string, rowsCopied int64, PartitionRoot string) {
-	toc.DataEntries = append(toc.DataEntries, MasterDataEntry{schema, name, oid, attributeString, rowsCopied, PartitionRoot})
+// Returns the new entry, which is only valid until the next entry is added
+func (toc *TOC) AddMasterDataEntry(schema string, name string, oid uint32, attributeString string, rowsCopied int64, PartitionRoot string) *MasterDataEntry {
+	toc.DataEntries = append(toc.DataEntries, MasterDataEntry{Schema: schema, Name: name, Oid: oid,
+		AttributeString: attributeString, RowsCopied: rowsCopied, PartitionRoot: PartitionRoot})
+	return &toc.DataEntries[len(toc.DataEntries)-1]
 }
 
-func (toc *SegmentTOC) AddSegmentDataEntry(oid uint, startByte uint64, endByte uint64) {
+func (toc *SegmentTOC) AddSegmentDataEntry(oid uint, startByte uint64, endByte uint64, checksum string) {
 	// We use uint for oid since the flags package does not have a uint32 flag
-	toc.DataEntries[oid] = SegmentDataEntry{startByte, endByte}
+	toc.DataEntries[oid] = SegmentDataEntry{StartByte: startByte, EndByte: endByte, Checksum: checksum}
+}
+
+func (toc *SegmentTOC) SetSegmentDataFileOffsets(oid uint, fileStartByte uint64, fileEndByte uint64) {
+	entry := toc.DataEntries[oid]
+	entry.FileStartByte = fileStartByte
+	entry.FileEndByte = fileEndByte
+	toc.DataEntries[oid] = entry
+}
+
+/*
+ * Returns the offsets of the data of a table in the data file, which are the
+ * offsets in the uncompressed data unless SeekableDataFile is set.
+ */
+func (toc *SegmentTOC) GetDataFileOffsets(oid uint) (uint64, uint64) {
+	entry := toc.DataEntries[oid]
+	if toc.SeekableDataFile {
+		return entry.FileStartByte, entry.FileEndByte
+	}
+	return entry.StartByte, entry.EndByte
 }
@@ -2,6 +2,7 @@ package toc_test
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/greenplum-db/gpbackup/testutils"
@@ -440,4 +441,111 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 			Expect(roots).To(BeEmpty())
 		})
 	})
+	Describe("AddDataChecksum", func() {
+		It("records checksums per segment and table", func() {
+			tocfile.AddDataChecksum(0, 1234, "checksum0")
+			tocfile.AddDataChecksum(1, 1234, "checksum1")
+			tocfile.AddDataChecksum(1, 5678, "checksum2")
+			Expect(tocfile.DataChecksums).To(Equal(map[int]map[uint32]string{
+				0: {1234: "checksum0"},
+				1: {1234: "checksum1", 5678: "checksum2"},
+			}))
+		})
+	})
+	Describe("AddDataSize", func() {
+		It("records sizes per segment and table", func() {
+			tocfile.AddDataSize(0, 1234, 100)
+			tocfile.AddDataSize(1, 1234, 200)
+			tocfile.AddDataSize(1, 5678, 300)
+			Expect(tocfile.DataSizes).To(Equal(map[int]map[uint32]int64{
+				0: {1234: 100},
+				1: {1234: 200, 5678: 300},
+			}))
+		})
+	})
+	Describe("AddMetadataChecksum", func() {
+		It("records the checksum under the base name of the file", func() {
+			tocfile.AddMetadataChecksum("/data/backups/20170101/20170101010101/gpbackup_20170101010101_metadata.sql", "checksum")
+			Expect(tocfile.MetadataChecksums).To(Equal(map[string]string{"gpbackup_20170101010101_metadata.sql": "checksum"}))
+		})
+	})
+	Describe("GetDataFileOffsets", func() {
+		var segmentTOC *toc.SegmentTOC
+		BeforeEach(func() {
+			segmentTOC = &toc.SegmentTOC{DataEntries: make(map[uint]toc.SegmentDataEntry)}
+			segmentTOC.AddSegmentDataEntry(1234, 0, 100, "checksum")
+			segmentTOC.SetSegmentDataFileOffsets(1234, 0, 40)
+			segmentTOC.AddSegmentDataEntry(5678, 100, 300, "checksum")
+			segmentTOC.SetSegmentDataFileOffsets(5678, 40, 90)
+		})
+		It("returns the offsets in the data file if the data file is seekable", func() {
+			segmentTOC.SeekableDataFile = true
+			start, end := segmentTOC.GetDataFileOffsets(5678)
+			Expect(start).To(Equal(uint64(40)))
+			Expect(end).To(Equal(uint64(90)))
+		})
+		It("returns the offsets in the uncompressed data otherwise", func() {
+			start, end := segmentTOC.GetDataFileOffsets(5678)
+			Expect(start).To(Equal(uint64(100)))
+			Expect(end).To(Equal(uint64(300)))
+		})
+	})
+	Describe("lists", func() {
+		dataEntries := []toc.MasterDataEntry{{Schema: "schema", Name: "table1", Oid: 1}, {Schema: "schema2", Name: "table2", Oid: 2}}
+		BeforeEach(func() {
+			tocfile.GlobalEntries = []toc.MetadataEntry{{Name: "role1", ObjectType: "ROLE"}}
+			tocfile.PredataEntries = []toc.MetadataEntry{
+				{Schema: "schema", Name: "table1", ObjectType: "TABLE"},
+				{Schema: "schema2", Name: "table2", ObjectType: "TABLE"},
+			}
+			tocfile.PostdataEntries = []toc.MetadataEntry{{Schema: "schema2", Name: "someindex", ObjectType: "INDEX", ReferenceObject: "schema2.table2"}}
+		})
+		Describe("GetListEntries", func() {
+			It("numbers the entries of each section in order", func() {
+				entries := tocfile.GetListEntries(dataEntries)
+
+				Expect(entries).To(HaveLen(6))
+				Expect(entries[0].String()).To(Equal("1; global; ROLE; ; role1; "))
+				Expect(entries[3].String()).To(Equal("4; data; TABLE DATA; schema; table1; "))
+				Expect(entries[5].String()).To(Equal("6; postdata; INDEX; schema2; someindex; schema2.table2"))
+			})
+		})
+		Describe("ReadList", func() {
+			It("reads the ids of entries in order and skips comments", func() {
+				ids, err := toc.ReadList(strings.NewReader("; header\n\n3; predata; TABLE; schema2; table2; \n;2; predata; TABLE; schema; table1; \n 1; global; ROLE; ; role1; \n"))
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ids).To(Equal([]int{3, 1}))
+			})
+			It("returns an error for a line without an id", func() {
+				_, err := toc.ReadList(strings.NewReader("predata; TABLE; schema2; table2; \n"))
+
+				Expect(err).To(MatchError(`Invalid entry id "predata" on line 1 of list`))
+			})
+		})
+		Describe("ApplyList", func() {
+			It("keeps only the listed entries, in the listed order", func() {
+				tables, err := tocfile.ApplyList([]int{6, 3, 5, 2}, dataEntries)
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tables).To(Equal([]string{"schema2.table2"}))
+				Expect(tocfile.GlobalEntries).To(BeEmpty())
+				Expect(tocfile.PredataEntries).To(Equal([]toc.MetadataEntry{
+					{Schema: "schema2", Name: "table2", ObjectType: "TABLE"},
+					{Schema: "schema", Name: "table1", ObjectType: "TABLE"},
+				}))
+				Expect(tocfile.PostdataEntries).To(HaveLen(1))
+			})
+			It("returns an error for an id that is not in the list", func() {
+				_, err := tocfile.ApplyList([]int{7}, dataEntries)
+
+				Expect(err).To(MatchError("Entry 7 is not in the list of entries of this backup, which has 6 entries"))
+			})
+			It("returns an error for an id that is listed twice", func() {
+				_, err := tocfile.ApplyList([]int{2, 2}, dataEntries)
+
+				Expect(err).To(MatchError("Entry 2 is listed more than once"))
+			})
+		})
+	})
 })
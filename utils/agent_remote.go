@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	path "path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
@@ -23,13 +24,18 @@ import (
  * that the first pipe is created before the first COPY FROM is issued.  If
  * gpbackup_helper was in charge of creating the first pipe, there is a
  * possibility that the COPY FROM commands start before gpbackup_helper is done
- * starting up and setting up the first pipe.
+ * starting up and setting up the first pipe.  With --jobs, the pipes of the
+ * first tables are created, one per job, as that many COPY commands are
+ * issued at once.
  */
-func CreateFirstSegmentPipeOnAllHosts(oid string, c *cluster.Cluster, fpInfo filepath.FilePathInfo) {
+func CreateFirstSegmentPipesOnAllHosts(oids []string, c *cluster.Cluster, fpInfo filepath.FilePathInfo) {
 	remoteOutput := c.GenerateAndExecuteCommand("Creating segment data pipes", func(contentID int) string {
-		pipeName := fpInfo.GetSegmentPipeFilePath(contentID)
-		pipeName = fmt.Sprintf("%s_%s", pipeName, oid)
-		return fmt.Sprintf("mkfifo %s", pipeName)
+		pipePrefix := fpInfo.GetSegmentPipeFilePath(contentID)
+		pipeNames := make([]string, len(oids))
+		for i, oid := range oids {
+			pipeNames[i] = fmt.Sprintf("%s_%s", pipePrefix, oid)
+		}
+		return fmt.Sprintf("mkfifo %s", strings.Join(pipeNames, " "))
 	}, cluster.ON_SEGMENTS)
 	c.CheckClusterError(remoteOutput, "Unable to create segment data pipes", func(contentID int) string {
 		return "Unable to create segment data pipe"
@@ -111,7 +117,7 @@ func VerifyHelperVersionOnSegments(version string, c *cluster.Cluster) {
 	}
 }
 
-func StartGpbackupHelpers(c *cluster.Cluster, fpInfo filepath.FilePathInfo, operation string, pluginConfigFile string, compressStr string, onErrorContinue bool, isFilter bool) {
+func StartGpbackupHelpers(c *cluster.Cluster, fpInfo filepath.FilePathInfo, operation string, pluginConfigFile string, compressStr string, onErrorContinue bool, isFilter bool, jobs int) {
 	gphomePath := operating.System.Getenv("GPHOME")
 	pluginStr := ""
 	if pluginConfigFile != "" {
@@ -126,13 +132,21 @@ func StartGpbackupHelpers(c *cluster.Cluster, fpInfo filepath.FilePathInfo, oper
 	if isFilter {
 		filterStr = " --with-filters"
 	}
+	jobsStr := ""
+	if jobs > 1 {
+		jobsStr = fmt.Sprintf(" --jobs %d", jobs)
+	}
+	encryptionStr := ""
+	if IsEncryptionEnabled() {
+		encryptionStr = fmt.Sprintf(" --encryption-key-file %s", GetEncryptionKeyFilePath())
+	}
 	remoteOutput := c.GenerateAndExecuteCommand("Starting gpbackup_helper agent", func(contentID int) string {
 		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
 		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
 		scriptFile := fpInfo.GetSegmentHelperFilePath(contentID, "script")
 		pipeFile := fpInfo.GetSegmentPipeFilePath(contentID)
 		backupFile := fpInfo.GetTableBackupFilePath(contentID, 0, GetPipeThroughProgram().Extension, true)
-		helperCmdStr := fmt.Sprintf("gpbackup_helper %s --toc-file %s --oid-file %s --pipe-file %s --data-file %s --content %d%s%s%s%s", operation, tocFile, oidFile, pipeFile, backupFile, contentID, pluginStr, compressStr, onErrorContinueStr, filterStr)
+		helperCmdStr := fmt.Sprintf("gpbackup_helper %s --toc-file %s --oid-file %s --pipe-file %s --data-file %s --content %d%s%s%s%s%s%s", operation, tocFile, oidFile, pipeFile, backupFile, contentID, pluginStr, compressStr, encryptionStr, onErrorContinueStr, filterStr, jobsStr)
 		// we run these commands in sequence to ensure that any failure is critical; the last command ensures the agent process was successfully started
 		return fmt.Sprintf(`cat << HEREDOC > %[1]s && chmod +x %[1]s && ( nohup %[1]s &> /dev/null &)
 #!/bin/bash
@@ -151,9 +165,10 @@ HEREDOC
 func CleanUpHelperFilesOnAllHosts(c *cluster.Cluster, fpInfo filepath.FilePathInfo) {
 	remoteOutput := c.GenerateAndExecuteCommand("Removing oid list and helper script files from segment data directories", func(contentID int) string {
 		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
+		progressFile := fmt.Sprintf("%s_progress", fpInfo.GetSegmentPipeFilePath(contentID))
 		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
 		scriptFile := fpInfo.GetSegmentHelperFilePath(contentID, "script")
-		return fmt.Sprintf("rm -f %s && rm -f %s && rm -f %s", errorFile, oidFile, scriptFile)
+		return fmt.Sprintf("rm -f %s && rm -f %s && rm -f %s && rm -f %s", errorFile, progressFile, oidFile, scriptFile)
 	}, cluster.ON_SEGMENTS)
 	errMsg := fmt.Sprintf("Unable to remove segment helper file(s). See %s for a complete list of segments with errors and remove manually.",
 		gplog.GetLogFilePath())
@@ -203,3 +218,33 @@ func CheckAgentErrorsOnSegments(c *cluster.Cluster, fpInfo filepath.FilePathInfo
 	}
 	return nil
 }
+
+/*
+ * Returns the number of bytes of table data written so far on each segment.
+ * With one data file per table, the sizes recorded in the checksum file of
+ * each completed data file are summed, and for single-data-file backups the
+ * progress file that gpbackup_helper updates after each table is read.  As
+ * this is only used to report progress, segments whose progress cannot be
+ * read are left out instead of causing an error.
+ */
+func GetSegmentBytesWritten(c *cluster.Cluster, fpInfo filepath.FilePathInfo, singleDataFile bool) map[int]int64 {
+	remoteOutput := c.GenerateAndExecuteCommand("Reading bytes written on segments", func(contentID int) string {
+		if singleDataFile {
+			return fmt.Sprintf("cat %s_progress 2>/dev/null || echo 0", fpInfo.GetSegmentPipeFilePath(contentID))
+		}
+		pattern := fmt.Sprintf("gpbackup_%d_%s_*%s", contentID, fpInfo.Timestamp, ChecksumExtension)
+		return fmt.Sprintf(`cd %s && cat %s 2>/dev/null | awk '{total += $2} END {print total + 0}'`, fpInfo.GetDirForContent(contentID), pattern)
+	}, cluster.ON_SEGMENTS)
+
+	segmentBytes := make(map[int]int64, len(remoteOutput.Stdouts))
+	for contentID, stdout := range remoteOutput.Stdouts {
+		if remoteOutput.Errors[contentID] != nil {
+			continue
+		}
+		numBytes, err := strconv.ParseInt(strings.TrimSpace(stdout), 10, 64)
+		if err == nil {
+			segmentBytes[contentID] = numBytes
+		}
+	}
+	return segmentBytes
+}
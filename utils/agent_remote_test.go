@@ -123,10 +123,26 @@ var _ = Describe("agent remote", func() {
 	})
 	Describe("StartGpbackupHelpers()", func() {
 		It("Correctly propagates --on-error-continue flag to gpbackup_helper", func() {
-			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "/tmp/pluginConfigFile.yml", " compressStr", true, false)
+			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "/tmp/pluginConfigFile.yml", " compressStr", true, false, 1)
 
 			cc := testExecutor.ClusterCommands[0]
 			Expect(cc[0][4]).To(ContainSubstring(" --on-error-continue"))
+			Expect(cc[0][4]).ToNot(ContainSubstring(" --jobs"))
+		})
+		It("passes --jobs to gpbackup_helper when using more than one connection", func() {
+			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "", "", false, false, 4)
+
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc[0][4]).To(ContainSubstring(" --jobs 4"))
+		})
+	})
+	Describe("CreateFirstSegmentPipesOnAllHosts()", func() {
+		It("creates a pipe for each of the given oids on each segment", func() {
+			utils.CreateFirstSegmentPipesOnAllHosts([]string{"1", "2"}, testCluster, fpInfo)
+
+			cc := testExecutor.ClusterCommands[0]
+			pipe0 := fmt.Sprintf("/data/gpseg0/gpbackup_0_11112233445566_pipe_%d", fpInfo.PID)
+			Expect(cc[0][4]).To(Equal(fmt.Sprintf("mkfifo %[1]s_1 %[1]s_2", pipe0)))
 		})
 	})
 	Describe("CheckAgentErrorsOnSegments", func() {
@@ -145,6 +161,34 @@ var _ = Describe("agent remote", func() {
 		})
 
 	})
+	Describe("GetSegmentBytesWritten", func() {
+		It("sums the data file sizes in the checksum files on each segment", func() {
+			remoteOutput.Stdouts = map[int]string{0: "1024\n", 1: "2048\n"}
+
+			segmentBytes := utils.GetSegmentBytesWritten(testCluster, fpInfo, false)
+
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc[0][4]).To(Equal(`cd /data/gpseg0/backups/11112233/11112233445566 && cat gpbackup_0_11112233445566_*.sha256 2>/dev/null | awk '{total += $2} END {print total + 0}'`))
+			Expect(segmentBytes).To(Equal(map[int]int64{0: 1024, 1: 2048}))
+		})
+		It("reads the progress file of gpbackup_helper for single-data-file backups", func() {
+			remoteOutput.Stdouts = map[int]string{0: "1024\n", 1: "2048\n"}
+
+			segmentBytes := utils.GetSegmentBytesWritten(testCluster, fpInfo, true)
+
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc[0][4]).To(Equal(fmt.Sprintf("cat /data/gpseg0/gpbackup_0_11112233445566_pipe_%d_progress 2>/dev/null || echo 0", fpInfo.PID)))
+			Expect(segmentBytes).To(Equal(map[int]int64{0: 1024, 1: 2048}))
+		})
+		It("leaves out segments whose progress cannot be read", func() {
+			remoteOutput.Stdouts = map[int]string{0: "1024\n", 1: ""}
+			remoteOutput.Errors = map[int]error{1: errors.New("exit status 255")}
+
+			segmentBytes := utils.GetSegmentBytesWritten(testCluster, fpInfo, true)
+
+			Expect(segmentBytes).To(Equal(map[int]int64{0: 1024}))
+		})
+	})
 })
 
 type testWriter struct {
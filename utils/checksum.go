@@ -0,0 +1,150 @@
+package utils
+
+/*
+ * This file contains functions relating to checksums of backup files.
+ *
+ * Checksums are hex-encoded SHA-256 hashes of files as they are stored, after
+ * any compression or encryption, so a backup set can be verified without
+ * needing to decompress or decrypt it.
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/filepath"
+)
+
+const ChecksumExtension = ".sha256"
+
+func NewChecksum() hash.Hash {
+	return sha256.New()
+}
+
+func FormatChecksum(checksum hash.Hash) string {
+	return hex.EncodeToString(checksum.Sum(nil))
+}
+
+func GetChecksum(reader io.Reader) (string, error) {
+	checksum := NewChecksum()
+	_, err := io.Copy(checksum, reader)
+	if err != nil {
+		return "", err
+	}
+	return FormatChecksum(checksum), nil
+}
+
+func GetFileChecksum(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return GetChecksum(file)
+}
+
+/*
+ * In a backup with one data file per table, the COPY command for each table
+ * pipes its output through gpbackup_helper, which writes the checksum of the
+ * data file to a file of the same name plus ChecksumExtension.
+ */
+func GetChecksumCommand(destinationFile string) string {
+	gphome := operating.System.Getenv("GPHOME")
+	return fmt.Sprintf("%s/bin/gpbackup_helper --checksum-file %s%s", gphome, destinationFile, ChecksumExtension)
+}
+
+/*
+ * Reads the checksum files written by the COPY commands of a backup with one
+ * data file per table and removes them, so that only data files remain in the
+ * segment backup directories.  The checksums and sizes of the data files are
+ * returned by content ID and table oid.
+ */
+func CollectDataFileChecksumsOnSegments(c *cluster.Cluster, fpInfo filepath.FilePathInfo) (map[int]map[uint32]string, map[int]map[uint32]int64) {
+	remoteOutput := c.GenerateAndExecuteCommand("Gathering data file checksums", func(contentID int) string {
+		pattern := fmt.Sprintf("gpbackup_%d_%s_*%s", contentID, fpInfo.Timestamp, ChecksumExtension)
+		return fmt.Sprintf(`cd %s && for f in %[2]s; do if [[ -f "$f" ]]; then echo "$f $(cat "$f")"; fi; done; rm -f %[2]s`,
+			fpInfo.GetDirForContent(contentID), pattern)
+	}, cluster.ON_SEGMENTS)
+	c.CheckClusterError(remoteOutput, "Unable to gather data file checksums", func(contentID int) string {
+		return fmt.Sprintf("Unable to gather data file checksums on segment %d", contentID)
+	})
+
+	checksums := make(map[int]map[uint32]string, len(remoteOutput.Stdouts))
+	sizes := make(map[int]map[uint32]int64, len(remoteOutput.Stdouts))
+	for contentID, stdout := range remoteOutput.Stdouts {
+		checksums[contentID] = make(map[uint32]string)
+		sizes[contentID] = make(map[uint32]int64)
+		prefix := fmt.Sprintf("gpbackup_%d_%s_", contentID, fpInfo.Timestamp)
+		for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+			// Each line is the file name, the checksum, and the size of the data file
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			// Checksum files are named gpbackup_<content>_<timestamp>_<oid><extension>.sha256
+			oidStr := strings.TrimPrefix(fields[0], prefix)
+			if idx := strings.Index(oidStr, "."); idx != -1 {
+				oidStr = oidStr[:idx]
+			}
+			oid, err := strconv.ParseUint(oidStr, 10, 32)
+			gplog.FatalOnError(err, fmt.Sprintf("Invalid checksum file name %s on segment %d", fields[0], contentID))
+			checksums[contentID][uint32(oid)] = fields[1]
+			if len(fields) > 2 {
+				sizes[contentID][uint32(oid)], _ = strconv.ParseInt(fields[2], 10, 64)
+			}
+		}
+	}
+	return checksums, sizes
+}
+
+/*
+ * Reads back the data files of the given tables in a backup with one data file
+ * per table, from disk or using the plugin, and returns their checksums by
+ * content ID and table oid.  A file that cannot be read has the checksum of
+ * whatever could be read, so it will not match the recorded checksum.
+ */
+func ComputeDataFileChecksumsOnSegments(c *cluster.Cluster, fpInfo filepath.FilePathInfo, oidList []string, pluginConfig *PluginConfig) map[int]map[uint32]string {
+	WriteOidListToSegments(oidList, c, fpInfo)
+	defer CleanUpHelperFilesOnAllHosts(c, fpInfo)
+
+	readCommand := "cat"
+	if pluginConfig != nil {
+		readCommand = fmt.Sprintf("%s restore_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+	}
+	remoteOutput := c.GenerateAndExecuteCommand("Computing data file checksums", func(contentID int) string {
+		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
+		filePrefix := path.Join(fpInfo.GetDirForContent(contentID), fmt.Sprintf("gpbackup_%d_%s_", contentID, fpInfo.Timestamp))
+		return fmt.Sprintf(`source %s/greenplum_path.sh && for oid in $(cat %s); do echo "$oid $(%s %s${oid}%s | sha256sum)"; done`,
+			operating.System.Getenv("GPHOME"), oidFile, readCommand, filePrefix, GetPipeThroughProgram().Extension)
+	}, cluster.ON_SEGMENTS)
+	c.CheckClusterError(remoteOutput, "Unable to compute data file checksums", func(contentID int) string {
+		return fmt.Sprintf("Unable to compute data file checksums on segment %d", contentID)
+	})
+
+	checksums := make(map[int]map[uint32]string, len(remoteOutput.Stdouts))
+	for contentID, stdout := range remoteOutput.Stdouts {
+		checksums[contentID] = make(map[uint32]string)
+		for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			oid, err := strconv.ParseUint(fields[0], 10, 32)
+			if err != nil {
+				continue
+			}
+			checksums[contentID][uint32(oid)] = fields[1]
+		}
+	}
+	return checksums
+}
@@ -0,0 +1,93 @@
+package utils_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/checksum tests", func() {
+	// SHA-256 of "hello world"
+	helloWorldChecksum := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+	Describe("GetChecksum", func() {
+		It("returns the hex-encoded SHA-256 of the input", func() {
+			checksum, err := utils.GetChecksum(strings.NewReader("hello world"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(checksum).To(Equal(helloWorldChecksum))
+		})
+	})
+	Describe("GetFileChecksum", func() {
+		It("returns the checksum of the file contents", func() {
+			file, err := ioutil.TempFile("", "gpbackup_checksum_test_")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.Remove(file.Name())
+			_, _ = file.WriteString("hello world")
+			Expect(file.Close()).To(Succeed())
+
+			checksum, err := utils.GetFileChecksum(file.Name())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(checksum).To(Equal(helloWorldChecksum))
+		})
+		It("returns an error if the file does not exist", func() {
+			_, err := utils.GetFileChecksum("/tmp/this_file_does_not_exist")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("GetChecksumCommand", func() {
+		It("writes the checksum next to the destination file", func() {
+			operating.System.Getenv = func(key string) string { return "/usr/local/gpdb" }
+			defer func() { operating.System.Getenv = os.Getenv }()
+			Expect(utils.GetChecksumCommand("/data/gpbackup_0_20170101010101_1234.gz")).To(Equal("/usr/local/gpdb/bin/gpbackup_helper --checksum-file /data/gpbackup_0_20170101010101_1234.gz.sha256"))
+		})
+		Context("when used in a COPY pipeline", func() {
+			var gphome string
+			writeHelper := func(script string) {
+				Expect(ioutil.WriteFile(path.Join(gphome, "bin", "gpbackup_helper"), []byte("#!/bin/sh\n"+script+"\n"), 0755)).To(Succeed())
+			}
+			runPipeline := func(pipeline string) error {
+				command := exec.Command("/bin/sh", "-c", pipeline)
+				command.Stdin = strings.NewReader("1,2\n")
+				return command.Run()
+			}
+
+			BeforeEach(func() {
+				var err error
+				gphome, err = ioutil.TempDir("", "gpbackup_checksum_test_")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(os.Mkdir(path.Join(gphome, "bin"), 0755)).To(Succeed())
+				operating.System.Getenv = func(key string) string { return gphome }
+			})
+			AfterEach(func() {
+				operating.System.Getenv = os.Getenv
+				_ = os.RemoveAll(gphome)
+			})
+
+			It("fails if the compression program before it fails", func() {
+				writeHelper("cat -")
+				dataFile := path.Join(gphome, "data.gz")
+				pipeline := fmt.Sprintf("gzip -c -1 --no-such-option | %s > %s", utils.GetChecksumCommand(dataFile), dataFile)
+
+				Expect(runPipeline(pipeline)).To(Succeed())
+				Expect(runPipeline(utils.WrapWithPipefail(pipeline))).To(HaveOccurred())
+			})
+			It("fails if it fails before a plugin", func() {
+				writeHelper("cat > /dev/null; exit 1")
+				dataFile := path.Join(gphome, "data.gz")
+				pipeline := fmt.Sprintf("gzip -c -1 | %s | cat > %s", utils.GetChecksumCommand(dataFile), dataFile)
+
+				Expect(runPipeline(pipeline)).To(Succeed())
+				Expect(runPipeline(utils.WrapWithPipefail(pipeline))).To(HaveOccurred())
+			})
+		})
+	})
+})
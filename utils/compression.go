@@ -1,6 +1,12 @@
 package utils
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/pkg/errors"
+)
 
 var (
 	pipeThroughProgram PipeThroughProgram
@@ -13,9 +19,35 @@ type PipeThroughProgram struct {
 	Extension     string
 }
 
-func InitializePipeThroughParameters(compress bool, compressionLevel int) {
+/*
+ * The maximum compression level accepted by each supported compression
+ * program; the minimum level is always 1.  zstd levels above 19 require
+ * --ultra and are not supported.
+ */
+var compressionLevelLimits = map[string]int{
+	"gzip": 9,
+	"zstd": 19,
+	"lz4":  12,
+}
+
+func NewPipeThroughProgram(compressionType string, compressionLevel int) PipeThroughProgram {
+	switch compressionType {
+	case "zstd":
+		return PipeThroughProgram{Name: "zstd", OutputCommand: fmt.Sprintf("zstd --compress -%d -c", compressionLevel), InputCommand: "zstd --decompress -c", Extension: ".zst"}
+	case "lz4":
+		return PipeThroughProgram{Name: "lz4", OutputCommand: fmt.Sprintf("lz4 --compress -%d -c", compressionLevel), InputCommand: "lz4 --decompress -c", Extension: ".lz4"}
+	default:
+		return PipeThroughProgram{Name: "gzip", OutputCommand: fmt.Sprintf("gzip -c -%d", compressionLevel), InputCommand: "gzip -d -c", Extension: ".gz"}
+	}
+}
+
+/*
+ * An empty compression type means gzip, as backups taken before the compression
+ * type was recorded were always compressed with gzip.
+ */
+func InitializePipeThroughParameters(compress bool, compressionType string, compressionLevel int) {
 	if compress {
-		pipeThroughProgram = PipeThroughProgram{Name: "gzip", OutputCommand: fmt.Sprintf("gzip -c -%d", compressionLevel), InputCommand: "gzip -d -c", Extension: ".gz"}
+		pipeThroughProgram = NewPipeThroughProgram(compressionType, compressionLevel)
 	} else {
 		pipeThroughProgram = PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""}
 	}
@@ -28,3 +60,52 @@ func GetPipeThroughProgram() PipeThroughProgram {
 func SetPipeThroughProgram(compression PipeThroughProgram) {
 	pipeThroughProgram = compression
 }
+
+/*
+ * COPY ... PROGRAM runs its command with /bin/sh, which only reports the exit
+ * status of the last program in a pipeline, so a failing compression,
+ * encryption, or checksum stage would otherwise go unnoticed.  The returned
+ * command still needs its single quotes escaped to be used in a SQL literal.
+ */
+func WrapWithPipefail(command string) string {
+	return fmt.Sprintf("bash -c '%s'", strings.Replace("set -o pipefail; "+command, "'", `'\''`, -1))
+}
+
+// Returns the compression type used for a data file, or "" if it is not compressed
+func GetCompressionTypeForFile(filename string) string {
+	filename = strings.TrimSuffix(filename, EncryptionExtension)
+	for compressionType := range compressionLevelLimits {
+		if strings.HasSuffix(filename, NewPipeThroughProgram(compressionType, 0).Extension) {
+			return compressionType
+		}
+	}
+	return ""
+}
+
+func ValidateCompressionTypeAndLevel(compressionType string, compressionLevel int) error {
+	maxLevel, ok := compressionLevelLimits[compressionType]
+	if !ok {
+		return errors.Errorf("Unknown compression type '%s'.  Valid compression types are gzip, zstd, and lz4.", compressionType)
+	}
+	if compressionLevel < 1 || compressionLevel > maxLevel {
+		return errors.Errorf("Compression level must be between 1 and %d", maxLevel)
+	}
+	return nil
+}
+
+/*
+ * gzip is assumed to be present on every host, but zstd and lz4 are not part of
+ * a standard installation, so we verify they exist before any data is written.
+ */
+func CheckCompressionProgramExistsOnAllHosts(c *cluster.Cluster, program PipeThroughProgram) {
+	if program.Name == "gzip" || program.Name == "cat" {
+		return
+	}
+	remoteOutput := c.GenerateAndExecuteCommand(fmt.Sprintf("Checking that %s is installed on all hosts", program.Name),
+		func(contentID int) string {
+			return fmt.Sprintf("command -v %s", program.Name)
+		}, cluster.ON_HOSTS_AND_MASTER)
+	c.CheckClusterError(remoteOutput, fmt.Sprintf("Compression program %s is not installed on all hosts", program.Name), func(contentID int) string {
+		return fmt.Sprintf("Compression program %s is not installed on host %s", program.Name, c.GetHostForContent(contentID))
+	})
+}
@@ -1,6 +1,8 @@
 package utils_test
 
 import (
+	"errors"
+	"os/exec"
 	"os/user"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
@@ -10,6 +12,7 @@ import (
 	"github.com/greenplum-db/gpbackup/utils"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("utils/compression tests", func() {
@@ -39,7 +42,7 @@ var _ = Describe("utils/compression tests", func() {
 				InputCommand:  "cat -",
 				Extension:     "",
 			}
-			utils.InitializePipeThroughParameters(false, 3)
+			utils.InitializePipeThroughParameters(false, "gzip", 3)
 			resultProgram := utils.GetPipeThroughProgram()
 			structmatcher.ExpectStructsToMatch(&expectedProgram, &resultProgram)
 		})
@@ -52,9 +55,118 @@ var _ = Describe("utils/compression tests", func() {
 				InputCommand:  "gzip -d -c",
 				Extension:     ".gz",
 			}
-			utils.InitializePipeThroughParameters(true, 7)
+			utils.InitializePipeThroughParameters(true, "gzip", 7)
 			resultProgram := utils.GetPipeThroughProgram()
 			structmatcher.ExpectStructsToMatch(&expectedProgram, &resultProgram)
 		})
+		It("initializes to use gzip when passed compression and no compression type", func() {
+			originalProgram := utils.GetPipeThroughProgram()
+			defer utils.SetPipeThroughProgram(originalProgram)
+			utils.InitializePipeThroughParameters(true, "", 0)
+			Expect(utils.GetPipeThroughProgram().Name).To(Equal("gzip"))
+		})
+		It("initializes to use zstd when passed compression type zstd and a level", func() {
+			originalProgram := utils.GetPipeThroughProgram()
+			defer utils.SetPipeThroughProgram(originalProgram)
+			expectedProgram := utils.PipeThroughProgram{
+				Name:          "zstd",
+				OutputCommand: "zstd --compress -15 -c",
+				InputCommand:  "zstd --decompress -c",
+				Extension:     ".zst",
+			}
+			utils.InitializePipeThroughParameters(true, "zstd", 15)
+			resultProgram := utils.GetPipeThroughProgram()
+			structmatcher.ExpectStructsToMatch(&expectedProgram, &resultProgram)
+		})
+		It("initializes to use lz4 when passed compression type lz4 and a level", func() {
+			originalProgram := utils.GetPipeThroughProgram()
+			defer utils.SetPipeThroughProgram(originalProgram)
+			expectedProgram := utils.PipeThroughProgram{
+				Name:          "lz4",
+				OutputCommand: "lz4 --compress -3 -c",
+				InputCommand:  "lz4 --decompress -c",
+				Extension:     ".lz4",
+			}
+			utils.InitializePipeThroughParameters(true, "lz4", 3)
+			resultProgram := utils.GetPipeThroughProgram()
+			structmatcher.ExpectStructsToMatch(&expectedProgram, &resultProgram)
+		})
+	})
+	Describe("WrapWithPipefail", func() {
+		It("runs the command in bash with pipefail set", func() {
+			Expect(utils.WrapWithPipefail("gzip -c -1 > /tmp/data.gz")).To(Equal("bash -c 'set -o pipefail; gzip -c -1 > /tmp/data.gz'"))
+		})
+		It("escapes single quotes in the command", func() {
+			Expect(utils.WrapWithPipefail("echo 'foo'")).To(Equal(`bash -c 'set -o pipefail; echo '\''foo'\'''`))
+		})
+		It("fails if any stage of the pipeline fails", func() {
+			Expect(exec.Command("/bin/sh", "-c", "false | cat -").Run()).To(Succeed())
+			Expect(exec.Command("/bin/sh", "-c", utils.WrapWithPipefail("false | cat -")).Run()).To(HaveOccurred())
+			Expect(exec.Command("/bin/sh", "-c", utils.WrapWithPipefail("true | cat -")).Run()).To(Succeed())
+		})
+	})
+	Describe("GetCompressionTypeForFile", func() {
+		It("returns the compression type matching the file extension", func() {
+			Expect(utils.GetCompressionTypeForFile("/data/gpbackup_0_20190101010101.gz")).To(Equal("gzip"))
+			Expect(utils.GetCompressionTypeForFile("/data/gpbackup_0_20190101010101.zst")).To(Equal("zstd"))
+			Expect(utils.GetCompressionTypeForFile("/data/gpbackup_0_20190101010101.lz4")).To(Equal("lz4"))
+		})
+		It("returns an empty string for an uncompressed file", func() {
+			Expect(utils.GetCompressionTypeForFile("/data/gpbackup_0_20190101010101")).To(Equal(""))
+		})
+	})
+	Describe("ValidateCompressionTypeAndLevel", func() {
+		It("validates a compression level between 1 and 9", func() {
+			compressLevel := 5
+			err := utils.ValidateCompressionTypeAndLevel("gzip", compressLevel)
+			Expect(err).To(Not(HaveOccurred()))
+		})
+		It("panics if given a compression level < 1", func() {
+			compressLevel := 0
+			err := utils.ValidateCompressionTypeAndLevel("gzip", compressLevel)
+			Expect(err).To(MatchError("Compression level must be between 1 and 9"))
+		})
+		It("panics if given a compression level > 9", func() {
+			compressLevel := 11
+			err := utils.ValidateCompressionTypeAndLevel("gzip", compressLevel)
+			Expect(err).To(MatchError("Compression level must be between 1 and 9"))
+		})
+		It("accepts zstd compression levels up to 19", func() {
+			Expect(utils.ValidateCompressionTypeAndLevel("zstd", 19)).To(Succeed())
+			Expect(utils.ValidateCompressionTypeAndLevel("zstd", 20)).To(MatchError("Compression level must be between 1 and 19"))
+		})
+		It("accepts lz4 compression levels up to 12", func() {
+			Expect(utils.ValidateCompressionTypeAndLevel("lz4", 12)).To(Succeed())
+			Expect(utils.ValidateCompressionTypeAndLevel("lz4", 13)).To(MatchError("Compression level must be between 1 and 12"))
+		})
+		It("returns an error for an unknown compression type", func() {
+			err := utils.ValidateCompressionTypeAndLevel("bzip2", 1)
+			Expect(err).To(MatchError("Unknown compression type 'bzip2'.  Valid compression types are gzip, zstd, and lz4."))
+		})
+	})
+	Describe("CheckCompressionProgramExistsOnAllHosts", func() {
+		It("does not check for gzip", func() {
+			utils.CheckCompressionProgramExistsOnAllHosts(testCluster, utils.NewPipeThroughProgram("gzip", 1))
+			Expect(testExecutor.NumExecutions).To(Equal(0))
+		})
+		It("checks that zstd is installed on every host", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{}
+			utils.CheckCompressionProgramExistsOnAllHosts(testCluster, utils.NewPipeThroughProgram("zstd", 1))
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			Expect(testExecutor.ClusterCommands[0]).To(HaveLen(2))
+			for _, command := range testExecutor.ClusterCommands[0] {
+				Expect(command).To(ContainElement("command -v zstd"))
+			}
+		})
+		It("panics if the program is missing on a host", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{
+				NumErrors: 1,
+				Errors:    map[int]error{1: errors.New("exit status 1")},
+				Stderrs:   map[int]string{1: ""},
+				CmdStrs:   map[int]string{1: "command -v zstd"},
+			}
+			defer testhelper.ShouldPanicWithMessage("Compression program zstd is not installed on all hosts")
+			utils.CheckCompressionProgramExistsOnAllHosts(testCluster, utils.NewPipeThroughProgram("zstd", 1))
+		})
 	})
 })
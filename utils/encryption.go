@@ -0,0 +1,480 @@
+package utils
+
+/*
+ * This file contains functions and structs relating to native encryption of
+ * backup files.
+ */
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	EncryptionExtension = ".enc"
+	EncryptionKeyLength = 32
+
+	encryptionMagic       = "GPBKENC1"
+	encryptionNonceLength = 12
+	encryptionChunkSize   = 64 * 1024
+	encryptionFinalChunk  = uint32(1 << 31)
+	encryptionSaltLength  = 16
+)
+
+var (
+	encryptionKey         []byte
+	encryptionKeyFilePath string
+)
+
+/*
+ * An EncryptionKeySource holds the key material supplied by the user.  A raw
+ * key is used as-is, while a passphrase is stretched with scrypt using a salt
+ * that is stored in the backup config so the same key can be derived again at
+ * restore time.
+ */
+type EncryptionKeySource struct {
+	key        []byte
+	passphrase []byte
+}
+
+func ReadEncryptionKeySource(keyFile string, passphraseFile string) (*EncryptionKeySource, error) {
+	if keyFile != "" {
+		contents, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to read encryption key file %s", keyFile)
+		}
+		key, err := parseEncryptionKey(contents)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid encryption key file %s", keyFile)
+		}
+		return &EncryptionKeySource{key: key}, nil
+	}
+	if passphraseFile != "" {
+		contents, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to read encryption passphrase file %s", passphraseFile)
+		}
+		passphrase := bytes.TrimRight(contents, "\r\n")
+		if len(passphrase) == 0 {
+			return nil, errors.Errorf("Encryption passphrase file %s is empty", passphraseFile)
+		}
+		return &EncryptionKeySource{passphrase: passphrase}, nil
+	}
+	return nil, nil
+}
+
+// Key files may contain either the raw key bytes or the key encoded as hex
+func parseEncryptionKey(contents []byte) ([]byte, error) {
+	trimmed := strings.TrimSpace(string(contents))
+	if len(trimmed) == 2*EncryptionKeyLength {
+		if key, err := hex.DecodeString(trimmed); err == nil {
+			return key, nil
+		}
+	}
+	if len(contents) == EncryptionKeyLength {
+		return contents, nil
+	}
+	return nil, errors.Errorf("Encryption key must be %d bytes, either raw or hex-encoded", EncryptionKeyLength)
+}
+
+func (source *EncryptionKeySource) UsesPassphrase() bool {
+	return source.passphrase != nil
+}
+
+func (source *EncryptionKeySource) DeriveKey(salt string) ([]byte, error) {
+	if !source.UsesPassphrase() {
+		return source.key, nil
+	}
+	if salt == "" {
+		return nil, errors.New("The backup was encrypted with a key file, not a passphrase")
+	}
+	saltBytes, err := hex.DecodeString(salt)
+	if err != nil {
+		return nil, errors.Errorf("Invalid encryption salt '%s'", salt)
+	}
+	return scrypt.Key(source.passphrase, saltBytes, 1<<15, 8, 1, EncryptionKeyLength)
+}
+
+func NewEncryptionSalt() (string, error) {
+	salt := make([]byte, encryptionSaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt), nil
+}
+
+/*
+ * The fingerprint is stored in the backup config so that gprestore can detect
+ * a wrong key before reading any files.  It is an HMAC of a fixed string, so
+ * it does not reveal anything about the key itself.
+ */
+func GetEncryptionKeyFingerprint(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte("gpbackup encryption key fingerprint"))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func ValidateEncryptionKeyFingerprint(key []byte, expectedFingerprint string) error {
+	if GetEncryptionKeyFingerprint(key) != expectedFingerprint {
+		return errors.New("The encryption key does not match the key used to encrypt the backup")
+	}
+	return nil
+}
+
+/*
+ * Enables encryption for all subsequent backup file operations.  keyFilePath
+ * is the location of the key on every host, which is passed to gpbackup_helper
+ * by the COPY commands and the segment agents.
+ */
+func InitializeEncryption(key []byte, keyFilePath string) {
+	encryptionKey = key
+	encryptionKeyFilePath = keyFilePath
+	gphome := operating.System.Getenv("GPHOME")
+	helperStr := fmt.Sprintf("%s/bin/gpbackup_helper", gphome)
+	pipeThroughProgram.OutputCommand = fmt.Sprintf("%s | %s --encrypt --encryption-key-file %s", pipeThroughProgram.OutputCommand, helperStr, keyFilePath)
+	pipeThroughProgram.InputCommand = fmt.Sprintf("%s --decrypt --encryption-key-file %s | %s", helperStr, keyFilePath, pipeThroughProgram.InputCommand)
+	pipeThroughProgram.Extension += EncryptionExtension
+}
+
+func IsEncryptionEnabled() bool {
+	return encryptionKey != nil
+}
+
+func GetEncryptionKeyFilePath() string {
+	return encryptionKeyFilePath
+}
+
+func SetEncryptionKey(key []byte) {
+	encryptionKey = key
+}
+
+func IsEncryptedFile(filename string) bool {
+	return strings.HasSuffix(filename, EncryptionExtension)
+}
+
+/*
+ * The key is written to a file on every host so that the COPY commands and
+ * gpbackup_helper agents on the segments can read it.  The file is placed in a
+ * directory with a random name, which is created on each host with mode 0700
+ * before the key is copied, so no other user can create or read the file.
+ * The directory is removed during cleanup.
+ */
+func MakeEncryptionKeyFilePath(timestamp string) string {
+	suffix := make([]byte, 8)
+	_, err := rand.Read(suffix)
+	gplog.FatalOnError(err)
+	return fmt.Sprintf("/tmp/gpbackup_%s_%s/encryption_key", timestamp, hex.EncodeToString(suffix))
+}
+
+func CopyEncryptionKeyToAllHosts(c *cluster.Cluster, keyFilePath string) {
+	// mkdir fails if the directory already exists, so it cannot have been created by another user
+	keyDir := path.Dir(keyFilePath)
+	remoteOutput := c.GenerateAndExecuteCommand("Creating encryption key directory on all hosts", func(contentID int) string {
+		return fmt.Sprintf("mkdir -m 0700 %s", keyDir)
+	}, cluster.ON_HOSTS_AND_MASTER)
+	c.CheckClusterError(remoteOutput, "Unable to create encryption key directory", func(contentID int) string {
+		return fmt.Sprintf("Unable to create encryption key directory %s on host %s", keyDir, c.GetHostForContent(contentID))
+	})
+
+	sourceFile, err := ioutil.TempFile("/tmp", "gpbackup_encryption_key_")
+	gplog.FatalOnError(err)
+	defer func() {
+		_ = os.Remove(sourceFile.Name())
+	}()
+	_, err = sourceFile.WriteString(hex.EncodeToString(encryptionKey))
+	gplog.FatalOnError(err)
+	err = sourceFile.Close()
+	gplog.FatalOnError(err)
+
+	remoteOutput = c.GenerateAndExecuteCommand("Copying encryption key to all hosts", func(contentID int) string {
+		return fmt.Sprintf("scp %s %s:%s", sourceFile.Name(), c.GetHostForContent(contentID), keyFilePath)
+	}, cluster.ON_MASTER_TO_HOSTS_AND_MASTER)
+	c.CheckClusterError(remoteOutput, "Unable to copy encryption key", func(contentID int) string {
+		return fmt.Sprintf("Unable to copy encryption key to host %s", c.GetHostForContent(contentID))
+	})
+}
+
+func RemoveEncryptionKeyFromAllHosts(c *cluster.Cluster, keyFilePath string) {
+	if keyFilePath == "" {
+		return
+	}
+	remoteOutput := c.GenerateAndExecuteCommand("Removing encryption key from all hosts", func(contentID int) string {
+		return fmt.Sprintf("rm -rf %s", path.Dir(keyFilePath))
+	}, cluster.ON_HOSTS_AND_MASTER)
+	c.CheckClusterError(remoteOutput, "Unable to remove encryption key", func(contentID int) string {
+		return fmt.Sprintf("Unable to remove encryption key %s from host %s", keyFilePath, c.GetHostForContent(contentID))
+	}, true)
+}
+
+/*
+ * Encrypted files consist of a header containing a magic string and a random
+ * nonce prefix, followed by a sequence of AES-256-GCM sealed chunks.  Each
+ * chunk is preceded by its sealed length, with the high bit set on the last
+ * chunk; the nonce of each chunk is the prefix plus a chunk counter and the
+ * length is authenticated as additional data, so reordered, truncated, or
+ * modified files fail to decrypt.
+ */
+type encryptWriter struct {
+	aead    cipher.AEAD
+	output  io.Writer
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != EncryptionKeyLength {
+		return nil, errors.Errorf("Encryption key must be %d bytes", EncryptionKeyLength)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, counter uint32) []byte {
+	nonce := make([]byte, encryptionNonceLength)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], counter)
+	return nonce
+}
+
+func NewEncryptWriter(output io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptionNonceLength-4)
+	_, err = rand.Read(prefix)
+	if err != nil {
+		return nil, err
+	}
+	_, err = output.Write(append([]byte(encryptionMagic), prefix...))
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{aead: aead, output: output, prefix: prefix, buf: make([]byte, 0, encryptionChunkSize)}, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(w.buf) == encryptionChunkSize {
+			err := w.writeChunk(false)
+			if err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):encryptionChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *encryptWriter) writeChunk(final bool) error {
+	length := uint32(len(w.buf) + w.aead.Overhead())
+	if final {
+		length |= encryptionFinalChunk
+	}
+	lengthBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(lengthBytes, length)
+	sealed := w.aead.Seal(lengthBytes, chunkNonce(w.prefix, w.counter), w.buf, lengthBytes)
+	_, err := w.output.Write(sealed)
+	if err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	w.counter++
+	if w.counter == 0 {
+		return errors.New("Too much data to encrypt with a single nonce prefix")
+	}
+	return nil
+}
+
+// Close writes the final chunk; it does not close the underlying writer
+func (w *encryptWriter) Close() error {
+	return w.writeChunk(true)
+}
+
+type decryptReader struct {
+	aead    cipher.AEAD
+	input   io.Reader
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	done    bool
+}
+
+func NewDecryptReader(input io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(encryptionMagic)+encryptionNonceLength-4)
+	_, err = io.ReadFull(input, header)
+	if err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, errors.New("Input is not an encrypted backup file")
+	}
+	return &decryptReader{aead: aead, input: input, prefix: header[len(encryptionMagic):]}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		err := r.readChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *decryptReader) readChunk() error {
+	lengthBytes := make([]byte, 4)
+	_, err := io.ReadFull(r.input, lengthBytes)
+	if err != nil {
+		return errors.New("Encrypted backup file is truncated")
+	}
+	length := binary.BigEndian.Uint32(lengthBytes)
+	final := length&encryptionFinalChunk != 0
+	length &^= encryptionFinalChunk
+	if length < uint32(r.aead.Overhead()) || length > uint32(encryptionChunkSize+r.aead.Overhead()) {
+		return errors.New("Encrypted backup file is corrupt")
+	}
+	sealed := make([]byte, length)
+	_, err = io.ReadFull(r.input, sealed)
+	if err != nil {
+		return errors.New("Encrypted backup file is truncated")
+	}
+	r.buf, err = r.aead.Open(sealed[:0], chunkNonce(r.prefix, r.counter), sealed, lengthBytes)
+	if err != nil {
+		return errors.New("Unable to decrypt backup file; the file is corrupt or the encryption key is incorrect")
+	}
+	r.counter++
+	if final {
+		r.done = true
+		if n, _ := r.input.Read(make([]byte, 1)); n > 0 {
+			return errors.New("Encrypted backup file has unexpected data after the final chunk")
+		}
+	}
+	return nil
+}
+
+func IsEncryptedContents(contents []byte) bool {
+	return bytes.HasPrefix(contents, []byte(encryptionMagic))
+}
+
+func EncryptBytes(contents []byte, key []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := NewEncryptWriter(&buf, key)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(contents)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func DecryptBytes(contents []byte, key []byte) ([]byte, error) {
+	reader, err := NewDecryptReader(bytes.NewReader(contents), key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+/*
+ * Master backup files (metadata, statistics, and TOC) are encrypted whenever
+ * encryption is enabled, so they are written and read through these functions
+ * rather than directly.  When encryption is enabled, a file that is not
+ * encrypted is rejected, as it cannot be authenticated and may have been
+ * swapped into the backup.
+ */
+func WriteToFileAndMakeReadOnlyWithEncryption(filename string, contents []byte) error {
+	if IsEncryptionEnabled() {
+		var err error
+		contents, err = EncryptBytes(contents, encryptionKey)
+		if err != nil {
+			return err
+		}
+	}
+	return WriteToFileAndMakeReadOnly(filename, contents)
+}
+
+func ReadFileWithDecryption(filename string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncryptedContents(contents) {
+		if IsEncryptionEnabled() {
+			return nil, errors.Errorf("File %s is not encrypted, but the backup is encrypted", filename)
+		}
+		return contents, nil
+	}
+	if !IsEncryptionEnabled() {
+		return nil, errors.Errorf("File %s is encrypted, but no encryption key was provided", filename)
+	}
+	contents, err = DecryptBytes(contents, encryptionKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to decrypt file %s", filename)
+	}
+	return contents, nil
+}
+
+/*
+ * Statements are read from metadata files at arbitrary offsets, so encrypted
+ * files are decrypted into memory instead of being read in place.
+ */
+func MustOpenFileForReadingAt(filename string) io.ReaderAt {
+	if !IsEncryptionEnabled() {
+		return iohelper.MustOpenFileForReading(filename)
+	}
+	contents, err := ReadFileWithDecryption(filename)
+	gplog.FatalOnError(err)
+	return bytes.NewReader(contents)
+}
+
+func NewEncryptedFileWithByteCountFromFile(filename string) *FileWithByteCount {
+	file := NewFileWithByteCountFromFile(filename)
+	if IsEncryptionEnabled() {
+		var err error
+		file.encryptWriter, err = NewEncryptWriter(file.File, encryptionKey)
+		gplog.FatalOnError(err)
+		file.Writer = file.encryptWriter
+	}
+	return file
+}
@@ -0,0 +1,281 @@
+package utils_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/encryption tests", func() {
+	key := bytes.Repeat([]byte{0x42}, utils.EncryptionKeyLength)
+	otherKey := bytes.Repeat([]byte{0x24}, utils.EncryptionKeyLength)
+
+	writeTempFile := func(contents []byte) string {
+		file, err := ioutil.TempFile("", "gpbackup_encryption_test_")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = file.Write(contents)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(file.Close()).To(Succeed())
+		return file.Name()
+	}
+
+	AfterEach(func() {
+		utils.SetEncryptionKey(nil)
+	})
+
+	Describe("NewEncryptWriter and NewDecryptReader", func() {
+		DescribeTable("decrypt what was encrypted", func(size int) {
+			plaintext := []byte(strings.Repeat("0123456789", size/10+1)[:size])
+			ciphertext, err := utils.EncryptBytes(plaintext, key)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(utils.IsEncryptedContents(ciphertext)).To(BeTrue())
+			if size > 0 {
+				Expect(ciphertext).ToNot(ContainSubstring("0123456789"))
+			}
+
+			decrypted, err := utils.DecryptBytes(ciphertext, key)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decrypted).To(Equal(plaintext))
+		},
+			Entry("empty input", 0),
+			Entry("input smaller than a chunk", 100),
+			Entry("input of exactly one chunk", 64*1024),
+			Entry("input spanning several chunks", 200*1024+7),
+		)
+		It("uses a different nonce each time", func() {
+			first, _ := utils.EncryptBytes([]byte("data"), key)
+			second, _ := utils.EncryptBytes([]byte("data"), key)
+			Expect(first).ToNot(Equal(second))
+		})
+		It("fails with the wrong key", func() {
+			ciphertext, _ := utils.EncryptBytes([]byte("data"), key)
+			_, err := utils.DecryptBytes(ciphertext, otherKey)
+			Expect(err).To(MatchError("Unable to decrypt backup file; the file is corrupt or the encryption key is incorrect"))
+		})
+		It("fails if the data has been modified", func() {
+			ciphertext, _ := utils.EncryptBytes([]byte("some data"), key)
+			ciphertext[len(ciphertext)-1] ^= 0x01
+			_, err := utils.DecryptBytes(ciphertext, key)
+			Expect(err).To(HaveOccurred())
+		})
+		It("fails if the final chunk is missing", func() {
+			ciphertext, _ := utils.EncryptBytes(bytes.Repeat([]byte("a"), 100*1024), key)
+			headerLength, chunkLength := 16, 4+64*1024+16
+			_, err := utils.DecryptBytes(ciphertext[:headerLength+chunkLength], key)
+			Expect(err).To(MatchError("Encrypted backup file is truncated"))
+		})
+		It("fails if data follows the final chunk", func() {
+			ciphertext, _ := utils.EncryptBytes([]byte("data"), key)
+			_, err := utils.DecryptBytes(append(ciphertext, 'x'), key)
+			Expect(err).To(MatchError("Encrypted backup file has unexpected data after the final chunk"))
+		})
+		It("fails if the input is not encrypted", func() {
+			_, err := utils.DecryptBytes([]byte("SET statement_timeout = 0;\n"), key)
+			Expect(err).To(MatchError("Input is not an encrypted backup file"))
+		})
+	})
+	Describe("ReadEncryptionKeySource", func() {
+		It("returns nil if no key or passphrase is provided", func() {
+			source, err := utils.ReadEncryptionKeySource("", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(source).To(BeNil())
+		})
+		It("reads a raw key", func() {
+			filename := writeTempFile(key)
+			defer os.Remove(filename)
+			source, err := utils.ReadEncryptionKeySource(filename, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(source.UsesPassphrase()).To(BeFalse())
+			derivedKey, _ := source.DeriveKey("")
+			Expect(derivedKey).To(Equal(key))
+		})
+		It("reads a hex-encoded key with a trailing newline", func() {
+			filename := writeTempFile([]byte(hex.EncodeToString(key) + "\n"))
+			defer os.Remove(filename)
+			source, err := utils.ReadEncryptionKeySource(filename, "")
+			Expect(err).ToNot(HaveOccurred())
+			derivedKey, _ := source.DeriveKey("")
+			Expect(derivedKey).To(Equal(key))
+		})
+		It("returns an error if the key is the wrong length", func() {
+			filename := writeTempFile([]byte("tooshort"))
+			defer os.Remove(filename)
+			_, err := utils.ReadEncryptionKeySource(filename, "")
+			Expect(err).To(MatchError(ContainSubstring("Encryption key must be 32 bytes, either raw or hex-encoded")))
+		})
+		It("returns an error if the passphrase file is empty", func() {
+			filename := writeTempFile([]byte("\n"))
+			defer os.Remove(filename)
+			_, err := utils.ReadEncryptionKeySource("", filename)
+			Expect(err).To(MatchError(ContainSubstring("is empty")))
+		})
+		It("derives the same key from a passphrase only when given the same salt", func() {
+			filename := writeTempFile([]byte("correct horse battery staple\n"))
+			defer os.Remove(filename)
+			source, err := utils.ReadEncryptionKeySource("", filename)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(source.UsesPassphrase()).To(BeTrue())
+
+			salt, _ := utils.NewEncryptionSalt()
+			otherSalt, _ := utils.NewEncryptionSalt()
+			firstKey, err := source.DeriveKey(salt)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(firstKey).To(HaveLen(utils.EncryptionKeyLength))
+			secondKey, _ := source.DeriveKey(salt)
+			Expect(secondKey).To(Equal(firstKey))
+			otherSaltKey, _ := source.DeriveKey(otherSalt)
+			Expect(otherSaltKey).ToNot(Equal(firstKey))
+
+			_, err = source.DeriveKey("")
+			Expect(err).To(MatchError("The backup was encrypted with a key file, not a passphrase"))
+		})
+	})
+	Describe("ValidateEncryptionKeyFingerprint", func() {
+		It("accepts the key that produced the fingerprint", func() {
+			Expect(utils.ValidateEncryptionKeyFingerprint(key, utils.GetEncryptionKeyFingerprint(key))).To(Succeed())
+		})
+		It("rejects a different key", func() {
+			err := utils.ValidateEncryptionKeyFingerprint(otherKey, utils.GetEncryptionKeyFingerprint(key))
+			Expect(err).To(MatchError("The encryption key does not match the key used to encrypt the backup"))
+		})
+	})
+	Describe("InitializeEncryption", func() {
+		It("adds an encryption stage to the pipe through program", func() {
+			originalProgram := utils.GetPipeThroughProgram()
+			defer utils.SetPipeThroughProgram(originalProgram)
+			operating.System.Getenv = func(key string) string { return "/usr/local/gpdb" }
+			defer func() { operating.System.Getenv = os.Getenv }()
+
+			utils.InitializePipeThroughParameters(true, "gzip", 1)
+			utils.InitializeEncryption(key, "/tmp/gpbackup_20170101010101_encryption_key")
+
+			Expect(utils.IsEncryptionEnabled()).To(BeTrue())
+			Expect(utils.GetPipeThroughProgram().OutputCommand).To(Equal("gzip -c -1 | /usr/local/gpdb/bin/gpbackup_helper --encrypt --encryption-key-file /tmp/gpbackup_20170101010101_encryption_key"))
+			Expect(utils.GetPipeThroughProgram().InputCommand).To(Equal("/usr/local/gpdb/bin/gpbackup_helper --decrypt --encryption-key-file /tmp/gpbackup_20170101010101_encryption_key | gzip -d -c"))
+			Expect(utils.GetPipeThroughProgram().Extension).To(Equal(".gz.enc"))
+			Expect(utils.GetCompressionTypeForFile("gpbackup_0_20170101010101_1234.gz.enc")).To(Equal("gzip"))
+		})
+		It("fails the COPY pipeline if the encryption stage fails", func() {
+			originalProgram := utils.GetPipeThroughProgram()
+			defer utils.SetPipeThroughProgram(originalProgram)
+			gphome, err := ioutil.TempDir("", "gpbackup_encryption_test_")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(gphome)
+			Expect(os.Mkdir(path.Join(gphome, "bin"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path.Join(gphome, "bin", "gpbackup_helper"), []byte("#!/bin/sh\ncase \"$*\" in *--encrypt*) cat > /dev/null; exit 1;; *) cat -;; esac\n"), 0755)).To(Succeed())
+			operating.System.Getenv = func(key string) string { return gphome }
+			defer func() { operating.System.Getenv = os.Getenv }()
+
+			utils.InitializePipeThroughParameters(true, "gzip", 1)
+			utils.InitializeEncryption(key, "/tmp/gpbackup_20170101010101_encryption_key")
+			dataFile := path.Join(gphome, "data.gz.enc")
+			pipeline := fmt.Sprintf("%s | %s > %s", utils.GetPipeThroughProgram().OutputCommand, utils.GetChecksumCommand(dataFile), dataFile)
+
+			unwrapped := exec.Command("/bin/sh", "-c", pipeline)
+			unwrapped.Stdin = strings.NewReader("1,2\n")
+			Expect(unwrapped.Run()).To(Succeed())
+			wrapped := exec.Command("/bin/sh", "-c", utils.WrapWithPipefail(pipeline))
+			wrapped.Stdin = strings.NewReader("1,2\n")
+			Expect(wrapped.Run()).To(HaveOccurred())
+		})
+	})
+	Describe("MakeEncryptionKeyFilePath", func() {
+		It("places the key in a directory with an unpredictable name", func() {
+			keyFilePath := utils.MakeEncryptionKeyFilePath("20170101010101")
+
+			Expect(keyFilePath).To(MatchRegexp("^/tmp/gpbackup_20170101010101_[0-9a-f]{16}/encryption_key$"))
+			Expect(utils.MakeEncryptionKeyFilePath("20170101010101")).ToNot(Equal(keyFilePath))
+		})
+	})
+	Describe("CopyEncryptionKeyToAllHosts and RemoveEncryptionKeyFromAllHosts", func() {
+		var testCluster *cluster.Cluster
+		var executor testutils.TestExecutorMultiple
+		keyFilePath := "/tmp/gpbackup_20170101010101_0123456789abcdef/encryption_key"
+		getCommands := func(execution int) []string {
+			commands := make([]string, 0)
+			for _, command := range executor.ClusterCommands[execution] {
+				commands = append(commands, command[len(command)-1])
+			}
+			return commands
+		}
+		BeforeEach(func() {
+			testCluster = testutils.SetDefaultSegmentConfiguration()
+			executor = testutils.TestExecutorMultiple{ClusterOutputs: []*cluster.RemoteOutput{{}}}
+			testCluster.Executor = &executor
+			utils.SetEncryptionKey(key)
+		})
+		It("creates a private key directory on every host before copying the key into it", func() {
+			utils.CopyEncryptionKeyToAllHosts(testCluster, keyFilePath)
+
+			Expect(executor.NumRemoteExecutions).To(Equal(2))
+			Expect(getCommands(0)).To(ConsistOf("mkdir -m 0700 /tmp/gpbackup_20170101010101_0123456789abcdef"))
+			Expect(getCommands(1)).To(ConsistOf(MatchRegexp("^scp /tmp/gpbackup_encryption_key_\\S+ localhost:" + keyFilePath + "$")))
+		})
+		It("removes the key directory from every host", func() {
+			utils.RemoveEncryptionKeyFromAllHosts(testCluster, keyFilePath)
+
+			Expect(getCommands(0)).To(ConsistOf("rm -rf /tmp/gpbackup_20170101010101_0123456789abcdef"))
+		})
+		It("does not remove anything if no key was copied", func() {
+			utils.RemoveEncryptionKeyFromAllHosts(testCluster, "")
+
+			Expect(executor.NumRemoteExecutions).To(Equal(0))
+		})
+	})
+	Describe("ReadFileWithDecryption", func() {
+		It("reads an unencrypted file as-is", func() {
+			filename := writeTempFile([]byte("plaintext"))
+			defer os.Remove(filename)
+			contents, err := utils.ReadFileWithDecryption(filename)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("plaintext"))
+		})
+		It("decrypts an encrypted file", func() {
+			ciphertext, _ := utils.EncryptBytes([]byte("plaintext"), key)
+			filename := writeTempFile(ciphertext)
+			defer os.Remove(filename)
+			utils.SetEncryptionKey(key)
+			contents, err := utils.ReadFileWithDecryption(filename)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("plaintext"))
+		})
+		It("returns an error if the file is encrypted and no key was provided", func() {
+			ciphertext, _ := utils.EncryptBytes([]byte("plaintext"), key)
+			filename := writeTempFile(ciphertext)
+			defer os.Remove(filename)
+			_, err := utils.ReadFileWithDecryption(filename)
+			Expect(err).To(MatchError(ContainSubstring("is encrypted, but no encryption key was provided")))
+		})
+		It("returns an error if the file is not encrypted and a key was provided", func() {
+			filename := writeTempFile([]byte("plaintext"))
+			defer os.Remove(filename)
+			utils.SetEncryptionKey(key)
+			_, err := utils.ReadFileWithDecryption(filename)
+			Expect(err).To(MatchError(fmt.Sprintf("File %s is not encrypted, but the backup is encrypted", filename)))
+		})
+	})
+	Describe("MustOpenFileForReadingAt", func() {
+		It("panics if the file is not encrypted and a key was provided", func() {
+			filename := writeTempFile([]byte("plaintext"))
+			defer os.Remove(filename)
+			utils.SetEncryptionKey(key)
+			defer testhelper.ShouldPanicWithMessage(fmt.Sprintf("File %s is not encrypted, but the backup is encrypted", filename))
+			utils.MustOpenFileForReadingAt(filename)
+		})
+	})
+})
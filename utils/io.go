@@ -43,19 +43,25 @@ type FileWithByteCount struct {
 	Writer    io.Writer
 	File      *os.File
 	ByteCount uint64
+
+	encryptWriter io.WriteCloser
 }
 
 func NewFileWithByteCount(writer io.Writer) *FileWithByteCount {
-	return &FileWithByteCount{"", writer, nil, 0}
+	return &FileWithByteCount{"", writer, nil, 0, nil}
 }
 
 func NewFileWithByteCountFromFile(filename string) *FileWithByteCount {
 	file, err := OpenFileForWrite(filename)
 	gplog.FatalOnError(err)
-	return &FileWithByteCount{filename, file, file, 0}
+	return &FileWithByteCount{filename, file, file, 0, nil}
 }
 
 func (file *FileWithByteCount) Close() {
+	if file.encryptWriter != nil {
+		err := file.encryptWriter.Close()
+		gplog.FatalOnError(err)
+	}
 	if file.File != nil {
 		err := file.File.Sync()
 		gplog.FatalOnError(err)
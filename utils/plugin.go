@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	path "path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -77,14 +78,34 @@ func (plugin *PluginConfig) MustBackupFile(filenamePath string) {
 	gplog.FatalOnError(err)
 }
 
-func (plugin *PluginConfig) MustRestoreFile(filenamePath string) {
+func (plugin *PluginConfig) DeleteBackup(timestamp string) error {
+	command := fmt.Sprintf("%s delete_backup %s %s", plugin.ExecutablePath, plugin.ConfigPath, timestamp)
+	gplog.Debug("%s", command)
+	output, err := exec.Command("bash", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ERROR: Plugin failed to delete backup %s. %s", timestamp, string(output))
+	}
+	return nil
+}
+
+func (plugin *PluginConfig) RestoreFile(filenamePath string) error {
 	directory, _ := path.Split(filenamePath)
 	err := operating.System.MkdirAll(directory, 0755)
-	gplog.FatalOnError(err)
+	if err != nil {
+		return err
+	}
 	command := fmt.Sprintf("%s restore_file %s %s", plugin.ExecutablePath, plugin.ConfigPath, filenamePath)
 	gplog.Debug("%s", command)
 	output, err := exec.Command("bash", "-c", command).CombinedOutput()
-	gplog.FatalOnError(err, string(output))
+	if err != nil {
+		return fmt.Errorf("ERROR: Plugin failed to restore %s. %s", filenamePath, string(output))
+	}
+	return nil
+}
+
+func (plugin *PluginConfig) MustRestoreFile(filenamePath string) {
+	err := plugin.RestoreFile(filenamePath)
+	gplog.FatalOnError(err)
 }
 
 func (plugin *PluginConfig) CheckPluginExistsOnAllHosts(c *cluster.Cluster) string {
@@ -437,6 +458,41 @@ func (plugin *PluginConfig) RestoreSegmentTOCs(c *cluster.Cluster, fpInfo filepa
 	})
 }
 
+/*
+ * The plugin options that name where a plugin stores backups, rather than how
+ * it connects or how fast it transfers them, so that rotating credentials or
+ * tuning a plugin does not change where its backups are considered to be.
+ */
+var pluginDestinationOptions = []string{"endpoint", "region", "bucket", "folder", "directory", "hostname", "storage_unit",
+	"remote_hostname", "remote_storage_unit", "remote_directory"}
+
+/*
+ * Returns an identifier for the storage location of the plugin, a checksum of
+ * the plugin's name and its destination options, so that backups written with
+ * the same plugin to different locations can be told apart.  If none of the
+ * destination options are set, every option is used instead.
+ */
+func (plugin *PluginConfig) GetDestination() string {
+	keys := make([]string, 0)
+	for _, key := range pluginDestinationOptions {
+		if _, ok := plugin.Options[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		for key := range plugin.Options {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+	fields := []string{path.Base(plugin.ExecutablePath)}
+	for _, key := range keys {
+		fields = append(fields, key, plugin.Options[key])
+	}
+	checksum, _ := GetChecksum(strings.NewReader(strings.Join(fields, "\x00")))
+	return checksum
+}
+
 func (plugin *PluginConfig) UsesEncryption() bool {
 	return plugin.Options["password_encryption"] == "on" ||
 		(plugin.Options["replication"] == "on" && plugin.Options["remote_password_encryption"] == "on")
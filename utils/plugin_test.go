@@ -371,6 +371,28 @@ options:
 			Expect(err.Error()).To(Equal("Unexpected plugin version format: \"bad output\"\nExpected: \"[plugin_name] version [git_version]\""))
 		})
 	})
+	Describe("GetDestination", func() {
+		It("identifies a destination by the plugin and its destination options", func() {
+			plugin := utils.PluginConfig{ExecutablePath: "/usr/local/bin/gpbackup_s3_plugin",
+				Options: map[string]string{"bucket": "bucket1", "folder": "gpdb", "aws_secret_access_key": "secret1"}}
+			rotatedPlugin := utils.PluginConfig{ExecutablePath: "/other/bin/gpbackup_s3_plugin",
+				Options: map[string]string{"bucket": "bucket1", "folder": "gpdb", "aws_secret_access_key": "secret2"}}
+			otherBucketPlugin := utils.PluginConfig{ExecutablePath: "/usr/local/bin/gpbackup_s3_plugin",
+				Options: map[string]string{"bucket": "bucket2", "folder": "gpdb", "aws_secret_access_key": "secret1"}}
+			otherPlugin := utils.PluginConfig{ExecutablePath: "/usr/local/bin/gpbackup_other_plugin",
+				Options: map[string]string{"bucket": "bucket1", "folder": "gpdb"}}
+
+			Expect(plugin.GetDestination()).To(Equal(rotatedPlugin.GetDestination()))
+			Expect(plugin.GetDestination()).ToNot(Equal(otherBucketPlugin.GetDestination()))
+			Expect(plugin.GetDestination()).ToNot(Equal(otherPlugin.GetDestination()))
+		})
+		It("uses every option if no destination options are set", func() {
+			plugin := utils.PluginConfig{ExecutablePath: "/usr/local/bin/my_plugin", Options: map[string]string{"share": "a"}}
+			otherPlugin := utils.PluginConfig{ExecutablePath: "/usr/local/bin/my_plugin", Options: map[string]string{"share": "b"}}
+
+			Expect(plugin.GetDestination()).ToNot(Equal(otherPlugin.GetDestination()))
+		})
+	})
 	Describe("ReadPluginConfig", func() {
 		It("returns an error if executablepath is not specified", func() {
 			operating.System.ReadFile = func(string) ([]byte, error) { return []byte{}, nil }
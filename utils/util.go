@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path"
 	"regexp"
 	"strings"
 	"syscall"
@@ -94,6 +95,9 @@ func MakeFQN(schema string, object string) string {
 func ValidateFQNs(tableList []string) error {
 	validFormat := regexp.MustCompile(`^[^.]+\.[^.]+$`)
 	for _, fqn := range tableList {
+		if IsFilterPattern(fqn) {
+			continue
+		}
 		if !validFormat.Match([]byte(fqn)) {
 			return errors.Errorf(`Table "%s" is not correctly fully-qualified.  Please ensure table is in the format "schema.table" and both the schema and table does not contain a dot (.).`, fqn)
 		}
@@ -102,16 +106,35 @@ func ValidateFQNs(tableList []string) error {
 	return nil
 }
 
-func ValidateFullPath(path string) error {
-	if len(path) > 0 && !(strings.HasPrefix(path, "/") || strings.HasPrefix(path, "~")) {
-		return errors.Errorf("%s is not an absolute path.", path)
+/*
+ * Filters beginning with ^ are regular expressions, and filters containing
+ * any of *, ?, or [ are glob patterns.  Both are matched against unquoted
+ * schema names or schema.table names.  Any other filter, or one that does not
+ * parse as a pattern, is a literal name.
+ */
+func IsFilterPattern(filter string) bool {
+	if strings.HasPrefix(filter, "^") {
+		_, err := regexp.Compile(filter)
+		return err == nil
 	}
-	return nil
+	if strings.ContainsAny(filter, "*?[") {
+		_, err := path.Match(filter, "")
+		return err == nil
+	}
+	return false
+}
+
+func MatchesFilterPattern(pattern string, name string) bool {
+	if strings.HasPrefix(pattern, "^") {
+		return regexp.MustCompile(pattern).MatchString(name)
+	}
+	matches, _ := path.Match(pattern, name)
+	return matches
 }
 
-func ValidateCompressionLevel(compressionLevel int) error {
-	if compressionLevel < 1 || compressionLevel > 9 {
-		return errors.Errorf("Compression level must be between 1 and 9")
+func ValidateFullPath(path string) error {
+	if len(path) > 0 && !(strings.HasPrefix(path, "/") || strings.HasPrefix(path, "~")) {
+		return errors.Errorf("%s is not an absolute path.", path)
 	}
 	return nil
 }
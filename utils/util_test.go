@@ -63,6 +63,32 @@ var _ = Describe("utils/util tests", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+	Describe("IsFilterPattern", func() {
+		It("recognizes glob patterns and regular expressions", func() {
+			Expect(utils.IsFilterPattern("sales.fact_2019_*")).To(BeTrue())
+			Expect(utils.IsFilterPattern("public.foo?")).To(BeTrue())
+			Expect(utils.IsFilterPattern("public.foo[12]")).To(BeTrue())
+			Expect(utils.IsFilterPattern(`^stage_.*`)).To(BeTrue())
+		})
+		It("treats names and filters that do not parse as patterns as literal names", func() {
+			Expect(utils.IsFilterPattern("public.foo")).To(BeFalse())
+			Expect(utils.IsFilterPattern("public.foo[")).To(BeFalse())
+			Expect(utils.IsFilterPattern(`^stage_(`)).To(BeFalse())
+		})
+	})
+	Describe("MatchesFilterPattern", func() {
+		It("matches glob patterns against the whole name", func() {
+			Expect(utils.MatchesFilterPattern("sales.fact_2019_*", "sales.fact_2019_01")).To(BeTrue())
+			Expect(utils.MatchesFilterPattern("sales.fact_2019_*", "sales.fact_2020_01")).To(BeFalse())
+			Expect(utils.MatchesFilterPattern("sales.fact_2019_*", "other.sales.fact_2019_01")).To(BeFalse())
+		})
+		It("matches regular expressions anchored at the start of the name", func() {
+			Expect(utils.MatchesFilterPattern(`^stage_.*`, "stage_orders")).To(BeTrue())
+			Expect(utils.MatchesFilterPattern(`^stage_.*`, "prod_stage_orders")).To(BeFalse())
+			Expect(utils.MatchesFilterPattern(`^public\.t[0-9]+$`, "public.t12")).To(BeTrue())
+			Expect(utils.MatchesFilterPattern(`^public\.t[0-9]+$`, "public.t12a")).To(BeFalse())
+		})
+	})
 	Context("ValidateFullPath", func() {
 		It("does not return error when the flag is not set", func() {
 			path := ""
@@ -103,23 +129,6 @@ var _ = Describe("utils/util tests", func() {
 			utils.ValidateGPDBVersionCompatibility(connectionPool)
 		})
 	})
-	Describe("ValidateCompressionLevel", func() {
-		It("validates a compression level between 1 and 9", func() {
-			compressLevel := 5
-			err := utils.ValidateCompressionLevel(compressLevel)
-			Expect(err).To(Not(HaveOccurred()))
-		})
-		It("panics if given a compression level < 1", func() {
-			compressLevel := 0
-			err := utils.ValidateCompressionLevel(compressLevel)
-			Expect(err).To(MatchError("Compression level must be between 1 and 9"))
-		})
-		It("panics if given a compression level > 9", func() {
-			compressLevel := 11
-			err := utils.ValidateCompressionLevel(compressLevel)
-			Expect(err).To(MatchError("Compression level must be between 1 and 9"))
-		})
-	})
 	Describe("UnquoteIdent", func() {
 		It("returns unchanged ident when passed a single char", func() {
 			dbname := `a`